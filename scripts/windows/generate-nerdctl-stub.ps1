@@ -6,6 +6,8 @@ $ENV:GOOS = "linux"
 
 Set-Location src/go/nerdctl-stub/generate
 go build .
-wsl.exe -d rancher-desktop --exec ./generate
+# Keep the help output, so the generated file can be checked against it.
+Remove-Item -Recurse -Force ./help -ErrorAction SilentlyContinue
+wsl.exe -d rancher-desktop --exec ./generate -capture-dir ./help
 Remove-Item ./generate
 gofmt -w ../nerdctl_commands_generated.go
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// commandLine is a list of arguments in the configuration file; it may be
+// given as a list, or as a string that is split like a shell would (without
+// any expansion).
+type commandLine []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *commandLine) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		words, err := splitCommandLine(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*c = words
+		return nil
+	}
+	var words []string
+	if err := node.Decode(&words); err != nil {
+		return err
+	}
+	*c = words
+	return nil
+}
+
+// splitCommandLine splits a string into words, honouring single and double
+// quotes and backslash escapes.
+func splitCommandLine(input string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, ch := range input {
+		switch {
+		case escaped:
+			word.WriteRune(ch)
+			escaped = false
+		case ch == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				word.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inWord = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(ch)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", input)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// expandVariables expands environment variables in the given words; `$PWD` is
+// always the current directory, even on Windows.
+func expandVariables(words []string) []string {
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		result = append(result, os.Expand(word, func(name string) string {
+			if name == "PWD" {
+				if dir, err := os.Getwd(); err == nil {
+					return dir
+				}
+			}
+			return os.Getenv(name)
+		}))
+	}
+	return result
+}
+
+// commandIndex returns the index of the first argument that is not an option
+// (or the value of an option) of the given command, or -1 if there is none.
+func commandIndex(command *cliparse.Command, args []string) int {
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return index
+		}
+		owner, option, found := command.LookupOption(arg)
+		if found && owner.Options[option] != nil && !strings.Contains(arg, "=") {
+			index++
+		}
+	}
+	return -1
+}
+
+// expandArgs expands user-defined aliases and adds default options to the
+// given arguments.  Built-in commands take precedence over aliases.  It returns
+// the expanded arguments, and a description of each step taken.
+func expandArgs(registry *cliparse.Registry, config *stubConfig, args []string) ([]string, []string, error) {
+	root, ok := registry.Command("")
+	if !ok {
+		return args, nil, nil
+	}
+	var steps []string
+
+	// Expand aliases, detecting loops.
+	var chain []string
+	for {
+		index := commandIndex(root, args)
+		if index < 0 {
+			break
+		}
+		name := args[index]
+		if _, ok := root.Subcommand(name); ok {
+			break
+		}
+		alias, ok := config.Aliases[name]
+		if !ok {
+			break
+		}
+		for _, previous := range chain {
+			if previous == name {
+				return nil, steps, fmt.Errorf("alias loop: %s -> %s", strings.Join(chain, " -> "), name)
+			}
+		}
+		chain = append(chain, name)
+		expansion := expandVariables(alias)
+		steps = append(steps, fmt.Sprintf("expanded alias %q to %q", name, strings.Join(expansion, " ")))
+		expanded := append([]string{}, args[:index]...)
+		expanded = append(expanded, expansion...)
+		args = append(expanded, args[index+1:]...)
+	}
+
+	// Find the command, and where its options start.
+	command := root
+	insertAt := 0
+	for index := commandIndex(root, args); index >= 0 && command.Handler == nil; {
+		subcommand, ok := command.Subcommand(args[index])
+		if !ok {
+			break
+		}
+		command = subcommand
+		insertAt = index + 1
+		next := commandIndex(command, args[insertAt:])
+		if next < 0 {
+			break
+		}
+		index = insertAt + next
+	}
+
+	// Add default options; the ones for the command are added first, as the
+	// root options are inserted before them.
+	defaults := make(map[string][]string)
+	var paths, unknown []string
+	for commandPath := range config.DefaultOptions {
+		paths = append(paths, commandPath)
+	}
+	sort.Strings(paths)
+	for _, commandPath := range paths {
+		options := config.DefaultOptions[commandPath]
+		target, ok := registry.Command(commandPath)
+		if !ok {
+			unknown = append(unknown, commandPath)
+			continue
+		}
+		defaults[target.Path] = append(defaults[target.Path], expandVariables(options)...)
+	}
+	if len(unknown) > 0 {
+		steps = append(steps, fmt.Sprintf("ignored default options for unknown commands: %s", strings.Join(unknown, ", ")))
+	}
+	insert := func(path string, index int) {
+		options := defaults[path]
+		if len(options) == 0 {
+			return
+		}
+		steps = append(steps, fmt.Sprintf("added default options for %q: %q", path, strings.Join(options, " ")))
+		expanded := append([]string{}, args[:index]...)
+		expanded = append(expanded, options...)
+		args = append(expanded, args[index:]...)
+	}
+	if command.Path != "" {
+		insert(command.Path, insertAt)
+	}
+	insert("", 0)
+	return args, steps, nil
+}
+
+// explainOption is a hidden option that, given as the first argument, prints
+// how the arguments would be expanded instead of running nerdctl.
+const explainOption = "--rd-explain"
+
+// explainArgs describes how the arguments were expanded, and how nerdctl would
+// be run.  No paths are translated.
+func explainArgs(output io.Writer, opts spawnOptions, steps []string, args []string) {
+	for _, step := range steps {
+		fmt.Fprintf(output, "%s\n", step)
+	}
+	fmt.Fprintf(output, "context: %s\n", opts.context)
+	transport := opts.transport
+	if transport == "" {
+		transport = transportWSL
+	}
+	fmt.Fprintf(output, "transport: %s\n", transport)
+	if transport == transportWSL {
+		fmt.Fprintf(output, "distro: %s\n", opts.distro)
+	}
+	fmt.Fprintf(output, "socket: %s\n", opts.containerdSocket)
+	fmt.Fprintf(output, "nerdctl: %s\n", opts.nerdctl)
+	fmt.Fprintf(output, "arguments: %q\n", args)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandLineUnmarshal(t *testing.T) {
+	t.Parallel()
+	configPath := filepath.Join(t.TempDir(), configFileName)
+	contents := strings.Join([]string{
+		"aliases:",
+		`  dev: run --rm -it -e "GREETING=hello world" -v '$PWD:/src'`,
+		"  up: [compose, up, --detach]",
+		"defaultOptions:",
+		"  run: --pull=always",
+		`  "": [--namespace, k8s.io]`,
+		"",
+	}, "\n")
+	require.NoError(t, os.WriteFile(configPath, []byte(contents), 0o644))
+	config, err := loadConfigFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]commandLine{
+		"dev": {"run", "--rm", "-it", "-e", "GREETING=hello world", "-v", "$PWD:/src"},
+		"up":  {"compose", "up", "--detach"},
+	}, config.Aliases)
+	assert.Equal(t, map[string]commandLine{
+		"run": {"--pull=always"},
+		"":    {"--namespace", "k8s.io"},
+	}, config.DefaultOptions)
+
+	require.NoError(t, os.WriteFile(configPath, []byte("aliases:\n  bad: run 'oops\n"), 0o644))
+	_, err = loadConfigFile(configPath)
+	assert.Error(t, err)
+}
+
+func TestExpandArgs(t *testing.T) {
+	t.Parallel()
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	require.NoError(t, err)
+	workdir, err := os.Getwd()
+	require.NoError(t, err)
+	config := &stubConfig{
+		Aliases: map[string]commandLine{
+			"dev":   {"run", "--rm", "-v", "$PWD:/src"},
+			"shell": {"dev", "-it"},
+			"ps":    {"images"},
+			"loop1": {"loop2"},
+			"loop2": {"--debug", "loop1"},
+		},
+		DefaultOptions: map[string]commandLine{
+			"container run": {"--pull=always"},
+			"":              {"--namespace", "k8s.io"},
+			"compose up":    {"--detach"},
+			"nonexistent":   {"--foo"},
+		},
+	}
+
+	testCases := map[string][]string{
+		"dev alpine":           {"--namespace", "k8s.io", "run", "--pull=always", "--rm", "-v", workdir + ":/src", "alpine"},
+		"--debug shell alpine": {"--namespace", "k8s.io", "--debug", "run", "--pull=always", "--rm", "-v", workdir + ":/src", "-it", "alpine"},
+		"ps -a":                {"--namespace", "k8s.io", "ps", "-a"},
+		"-n default run x":     {"--namespace", "k8s.io", "-n", "default", "run", "--pull=always", "x"},
+		"compose -f x.yaml up": {"--namespace", "k8s.io", "compose", "-f", "x.yaml", "up", "--detach"},
+		"unknown command":      {"--namespace", "k8s.io", "unknown", "command"},
+	}
+	for input, expected := range testCases {
+		actual, steps, err := expandArgs(registry, config, strings.Split(input, " "))
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, expected, actual, input)
+			assert.Contains(t, steps, "ignored default options for unknown commands: nonexistent", input)
+		}
+	}
+
+	_, _, err = expandArgs(registry, config, []string{"loop1"})
+	assert.EqualError(t, err, "alias loop: loop1 -> loop2 -> loop1")
+}
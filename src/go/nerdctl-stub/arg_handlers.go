@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// This file contains argument handlers that are not platform-specific.
+
+// pathTranslator converts paths on the host into paths that nerdctl can use.
+// The implementation depends on the transport used to run nerdctl.
+type pathTranslator interface {
+	// prepare should be called before argument parsing to set up the system
+	// for arg parsing.
+	prepare() error
+	// cleanup should be called after the command finishes (regardless of
+	// whether it succeeded) to clean up any resources.
+	cleanup() error
+	// volumeArgHandler handles the argument for `nerdctl run --volume=...`
+	volumeArgHandler(arg string) (string, []cliparse.CleanupFunc, error)
+	// filePathArgHandler handles arguments that take a file path for input.
+	filePathArgHandler(arg string) (string, []cliparse.CleanupFunc, error)
+	// outputPathArgHandler handles arguments that take a file path to
+	// indicate where some file should be output.
+	outputPathArgHandler(arg string) (string, []cliparse.CleanupFunc, error)
+}
+
+// argHandlers contains the handlers for arguments that contain paths.
+type argHandlers struct {
+	pathTranslator
+}
+
+// mountArgHandler handles arguments in the comma-separated key=value format,
+// such as `nerdctl run --mount type=bind,source=/foo,target=/bar`.  The value
+// of the `source` (or `src`) key is converted as an input path if it refers to
+// a host path.
+func (h *argHandlers) mountArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	fields, err := csv.NewReader(strings.NewReader(arg)).Read()
+	if err != nil {
+		return "", nil, err
+	}
+	mountType := ""
+	for _, field := range fields {
+		if strings.HasPrefix(field, "type=") {
+			mountType = strings.TrimPrefix(field, "type=")
+		}
+	}
+	if mountType != "" && mountType != "bind" {
+		// Only bind mounts refer to host paths.
+		return arg, nil, nil
+	}
+	var cleanups []cliparse.CleanupFunc
+	for i, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) < 2 || (parts[0] != "source" && parts[0] != "src") {
+			continue
+		}
+		if mountType == "" && !looksLikePath(parts[1]) {
+			// Without a type, this may be a named volume.
+			continue
+		}
+		converted, newCleanups, err := h.filePathArgHandler(parts[1])
+		cleanups = append(cleanups, newCleanups...)
+		if err != nil {
+			return "", cleanups, err
+		}
+		fields[i] = parts[0] + "=" + converted
+	}
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	if err = writer.Write(fields); err != nil {
+		return "", cleanups, err
+	}
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		return "", cleanups, err
+	}
+	return strings.TrimRight(buf.String(), "\r\n"), cleanups, nil
+}
+
+// looksLikePath checks if the given string is a host path rather than a name.
+func looksLikePath(input string) bool {
+	if filepath.IsAbs(input) || strings.HasPrefix(input, ".") {
+		return true
+	}
+	return strings.ContainsAny(input, `/\`)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+const (
+	// defaultAuditMaxSize is the size at which the audit log is rotated, if
+	// not configured.
+	defaultAuditMaxSize = 10 * 1024 * 1024
+	// defaultAuditMaxBackups is the number of rotated audit logs to keep, if
+	// not configured.
+	defaultAuditMaxBackups = 3
+	// redacted replaces sensitive values in the audit log.
+	redacted = "<redacted>"
+)
+
+// auditOption is an option recorded in the audit log.
+type auditOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// auditRecord is a single line in the audit log.
+type auditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	// UID is the (real) user ID of the caller; this is not available on
+	// Windows.
+	UID *int `json:"uid,omitempty"`
+	// User is the name of the caller.
+	User string `json:"user,omitempty"`
+	// Distro is the WSL distribution the caller is in, if any.
+	Distro string `json:"distro,omitempty"`
+	// Command is the command path, e.g. `container run`.
+	Command string `json:"command"`
+	// Options are the options given, with sensitive values redacted.
+	Options []auditOption `json:"options,omitempty"`
+	// Images are the images referred to.
+	Images []string `json:"images,omitempty"`
+	// Mounts are the host paths bind mounted into containers.
+	Mounts []string `json:"mounts,omitempty"`
+	// Privileged are the options that grant extra privileges.
+	Privileged []string `json:"privileged,omitempty"`
+	// Denied are the reasons the command was denied by policy, if it was.
+	Denied []string `json:"denied,omitempty"`
+	// Error describes why nerdctl could not be run, if applicable.
+	Error string `json:"error,omitempty"`
+	// ExitCode is the exit code of nerdctl.
+	ExitCode int `json:"exitCode"`
+}
+
+// auditLog is an open audit log file.
+type auditLog struct {
+	file *os.File
+}
+
+// loadAuditConfig reads the audit log settings from the system-wide
+// configuration file only.  The log is opened as root, so settings in the
+// per-user configuration file are ignored; they would otherwise let any user
+// write to (or rotate away) arbitrary files, or hide their commands from the
+// administrator.
+func loadAuditConfig() (auditConfig, error) {
+	dir := systemConfigDir()
+	if dir == "" {
+		return auditConfig{}, nil
+	}
+	config, err := loadConfigFile(filepath.Join(dir, configFileName))
+	if err != nil {
+		return auditConfig{}, err
+	}
+	return config.Audit, nil
+}
+
+// openAuditLog opens the configured audit log, rotating it if necessary.  If
+// auditing is not configured, nil is returned.  As the log may not be writable
+// by the user, this should be done before dropping privileges.
+func openAuditLog(config auditConfig) (*auditLog, error) {
+	if config.Path == "" {
+		return nil, nil
+	}
+	maxSize := int64(defaultAuditMaxSize)
+	if config.MaxSize != "" {
+		var err error
+		if maxSize, err = parseMemorySize(config.MaxSize); err != nil {
+			return nil, fmt.Errorf("invalid audit log maximum size: %w", err)
+		}
+	}
+	maxBackups := config.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = defaultAuditMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0o755); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(config.Path); err == nil && info.Size() >= maxSize {
+		if err = rotateAuditLog(config.Path, maxBackups); err != nil {
+			return nil, fmt.Errorf("could not rotate audit log: %w", err)
+		}
+	}
+	file, err := os.OpenFile(config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file}, nil
+}
+
+// rotateAuditLog renames the audit log at the given path to `<path>.1`, moving
+// any existing backups up by one and dropping the oldest.
+func rotateAuditLog(logPath string, maxBackups int) error {
+	for i := maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", logPath, i), fmt.Sprintf("%s.%d", logPath, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(logPath, logPath+".1")
+}
+
+// write a record to the audit log, and close it.  This is a no-op if auditing
+// is disabled.
+func (a *auditLog) write(record *auditRecord) error {
+	if a == nil {
+		return nil
+	}
+	defer a.file.Close()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// Write the record in one call, so that concurrent invocations do not
+	// interleave records.
+	_, err = a.file.Write(append(data, '\n'))
+	return err
+}
+
+// newAuditRecord creates an audit record from the parsed arguments.
+func newAuditRecord(registry *cliparse.Registry, result *cliparse.Result) *auditRecord {
+	record := &auditRecord{
+		Timestamp: time.Now().UTC(),
+		Command:   result.CommandPath,
+		Distro:    os.Getenv("WSL_DISTRO_NAME"),
+	}
+	if uid := os.Getuid(); uid >= 0 {
+		record.UID = &uid
+	}
+	if currentUser, err := user.Current(); err == nil {
+		record.User = currentUser.Username
+	}
+	for _, option := range result.Options {
+		record.addOption(optionNames(registry, option), option)
+	}
+	if count, ok := imagePositionals[result.CommandPath]; ok {
+		for i, positional := range result.Positionals {
+			if (count >= 0 && i >= count) || strings.HasPrefix(positional, "-") {
+				break
+			}
+			record.Images = append(record.Images, positional)
+		}
+	}
+	return record
+}
+
+// addOption records the given option, with the given names (including
+// synonyms), in the audit record.
+func (r *auditRecord) addOption(names []string, option cliparse.Option) {
+	recorded := auditOption{Name: option.Name, Value: option.Value}
+	for _, name := range names {
+		switch name {
+		case "--env", "--build-arg":
+			// Keep the name of the variable, but not its value.
+			if index := strings.Index(option.Value, "="); index >= 0 {
+				recorded.Value = option.Value[:index+1] + redacted
+			}
+		case "--secret", "--password":
+			recorded.Value = redacted
+		case "--tag":
+			r.Images = append(r.Images, option.Value)
+		case "--volume":
+			if source := volumeSource(option.Value); looksLikePath(source) {
+				r.Mounts = append(r.Mounts, absPath(source))
+			}
+		case "--mount":
+			for _, source := range mountSources(option.Value) {
+				r.Mounts = append(r.Mounts, absPath(source))
+			}
+		case "--privileged":
+			if enabled, err := strconv.ParseBool(option.Value); option.Value == "" || err != nil || enabled {
+				r.Privileged = append(r.Privileged, option.Name)
+			}
+		case "--pid", "--net", "--network", "--ipc", "--uts", "--userns":
+			if option.Value == "host" {
+				r.Privileged = append(r.Privileged, option.Name+"=host")
+			}
+		case "--cap-add", "--device", "--security-opt":
+			r.Privileged = append(r.Privileged, option.Name+"="+option.Value)
+		default:
+			continue
+		}
+		break
+	}
+	r.Options = append(r.Options, recorded)
+}
+
+// absPath returns the absolute form of the given path, if possible.
+func absPath(input string) string {
+	if result, err := filepath.Abs(input); err == nil {
+		return result
+	}
+	return input
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditRecord(t *testing.T) {
+	t.Parallel()
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	require.NoError(t, err)
+	hostDir := t.TempDir()
+
+	result, err := registry.Parse([]string{
+		"run", "-e", "TOKEN=hunter2", "--env", "HOME", "--privileged", "--net=host",
+		"-v", hostDir + ":/data", "-v", "named:/cache", "--cap-add", "SYS_ADMIN",
+		"nginx", "--password", "not-an-option",
+	})
+	require.NoError(t, err)
+	record := newAuditRecord(registry, result)
+	assert.Equal(t, "container run", record.Command)
+	assert.Equal(t, []string{"nginx"}, record.Images)
+	assert.Equal(t, []string{hostDir}, record.Mounts)
+	assert.Equal(t, []string{"--privileged", "--net=host", "--cap-add=SYS_ADMIN"}, record.Privileged)
+	assert.Equal(t, []auditOption{
+		{Name: "-e", Value: "TOKEN=" + redacted},
+		{Name: "--env", Value: "HOME"},
+		{Name: "--privileged"},
+		{Name: "--net", Value: "host"},
+		{Name: "-v", Value: hostDir + ":/data"},
+		{Name: "-v", Value: "named:/cache"},
+		{Name: "--cap-add", Value: "SYS_ADMIN"},
+	}, record.Options)
+
+	result, err = registry.Parse([]string{"login", "-u", "me", "-p", "hunter2", "registry.example.com"})
+	require.NoError(t, err)
+	record = newAuditRecord(registry, result)
+	assert.Equal(t, []auditOption{{Name: "-u", Value: "me"}, {Name: "-p", Value: redacted}}, record.Options)
+
+	result, err = registry.Parse([]string{"build", "-t", "me/app", "--secret", "id=npm,src=.npmrc", "."})
+	require.NoError(t, err)
+	record = newAuditRecord(registry, result)
+	assert.Equal(t, []string{"me/app"}, record.Images)
+	assert.Equal(t, []auditOption{{Name: "-t", Value: "me/app"}, {Name: "--secret", Value: redacted}}, record.Options)
+
+	data, err := json.Marshal(record)
+	if assert.NoError(t, err) {
+		assert.NotContains(t, string(data), "npmrc")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+	logPath := filepath.Join(t.TempDir(), "logs", "audit.jsonl")
+	config := auditConfig{Path: logPath, MaxSize: "100b", MaxBackups: 2}
+
+	audit, err := openAuditLog(auditConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, audit)
+	assert.NoError(t, audit.write(&auditRecord{}))
+
+	for i := 0; i < 6; i++ {
+		audit, err := openAuditLog(config)
+		require.NoError(t, err)
+		require.NoError(t, audit.write(&auditRecord{Command: fmt.Sprintf("command %d", i), ExitCode: i}))
+	}
+	readRecords := func(suffix string) []string {
+		file, err := os.Open(logPath + suffix)
+		require.NoError(t, err)
+		defer file.Close()
+		var commands []string
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record auditRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			commands = append(commands, record.Command)
+		}
+		return commands
+	}
+	// Each record is just over 50 bytes, so the log rotates after every two.
+	assert.Equal(t, []string{"command 4", "command 5"}, readRecords(""))
+	assert.Equal(t, []string{"command 2", "command 3"}, readRecords(".1"))
+	assert.Equal(t, []string{"command 0", "command 1"}, readRecords(".2"))
+	assert.NoFileExists(t, logPath+".3")
+
+	_, err = openAuditLog(auditConfig{Path: logPath, MaxSize: "huge"})
+	assert.Error(t, err)
+}
@@ -1,36 +1,32 @@
 package main
 
 import (
-	"log"
 	"regexp"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
 )
 
 // This file contains handlers for specific commands.
 
 // imageBuildHandler handles `nerdctl image build`
-func imageBuildHandler(c *commandDefinition, args []string) (*parsedArgs, error) {
+func (h *argHandlers) imageBuildHandler(c *cliparse.Command, args []string) (*cliparse.Result, error) {
 	// The first argument is the directory to build; the rest are ignored.
 	if len(args) < 1 {
 		// This will return an error
-		return &parsedArgs{args: args}, nil
+		return &cliparse.Result{Args: args}, nil
 	}
 	input := args[0]
 	if input == "-" {
-		return &parsedArgs{args: args}, nil
+		return &cliparse.Result{Args: args}, nil
 	}
 	if match, _ := regexp.MatchString(`^[^:/]*://`, input); match {
 		// input is a URL
-		return &parsedArgs{args: args}, nil
+		return &cliparse.Result{Args: args}, nil
 	}
-	newPath, cleanups, err := filePathArgHandler(args[0])
+	newPath, cleanups, err := h.filePathArgHandler(args[0])
 	if err != nil {
-		for _, cleanup := range cleanups {
-			cleanupErr := cleanup()
-			if cleanupErr != nil {
-				log.Printf("Error cleaning up: %s", cleanupErr)
-			}
-		}
+		cliparse.RunCleanups(cleanups)
 		return nil, err
 	}
-	return &parsedArgs{args: append([]string{newPath}, args[1:]...), cleanup: cleanups}, nil
+	return &cliparse.Result{Args: append([]string{newPath}, args[1:]...), Cleanup: cleanups}, nil
 }
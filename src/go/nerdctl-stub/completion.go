@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// This file implements shell completion.  The completion scripts call back
+// into the stub (`nerdctl __complete <index> <words...>`) to get the list of
+// candidates, so the command table does not need to be embedded in them.
+
+// completeCommand is the hidden command used by the completion scripts.  The
+// first argument is the index of the word being completed (i.e. the number of
+// complete words before it), followed by all words (not including the
+// executable name).  The word being completed may be omitted if it is empty,
+// as some shells (PowerShell) drop empty arguments.
+const completeCommand = "__complete"
+
+// completionScripts are the completion scripts for each supported shell.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for nerdctl (Rancher Desktop)
+_nerdctl_rd_complete() {
+    local IFS=$'\n'
+    COMPREPLY=( $(nerdctl __complete "$((COMP_CWORD - 1))" "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null) )
+}
+complete -o default -F _nerdctl_rd_complete nerdctl
+`,
+	"zsh": `#compdef nerdctl
+# zsh completion for nerdctl (Rancher Desktop)
+_nerdctl_rd_complete() {
+    local -a candidates
+    candidates=(${(f)"$(nerdctl __complete "$((CURRENT - 2))" "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    compadd -- "${candidates[@]}"
+}
+compdef _nerdctl_rd_complete nerdctl
+`,
+	"fish": `# fish completion for nerdctl (Rancher Desktop)
+function __nerdctl_rd_complete
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    nerdctl __complete (count $tokens) $tokens (commandline -ct) 2>/dev/null
+end
+complete -c nerdctl -f -a '(__nerdctl_rd_complete)'
+`,
+	"powershell": `# PowerShell completion for nerdctl (Rancher Desktop)
+Register-ArgumentCompleter -Native -CommandName nerdctl -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements |
+        Where-Object { $_.Extent.EndOffset -lt $cursorPosition } |
+        Select-Object -Skip 1 |
+        ForEach-Object { $_.ToString() })
+    $arguments = @('__complete', $words.Count) + $words
+    if ($wordToComplete -ne '') {
+        $arguments += $wordToComplete
+    }
+    & nerdctl @arguments 2>$null | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`,
+}
+
+// resourceKind is a kind of object that can be listed by nerdctl.
+type resourceKind string
+
+const (
+	resourceContainers = resourceKind("containers")
+	resourceImages     = resourceKind("images")
+	resourceNetworks   = resourceKind("networks")
+	resourceVolumes    = resourceKind("volumes")
+)
+
+// resourceQueries are the nerdctl arguments to list the names of each kind of
+// resource, one per line.
+var resourceQueries = map[resourceKind][]string{
+	resourceContainers: {"container", "ls", "--all", "--format", "{{.Names}}"},
+	resourceImages:     {"image", "ls", "--format", "{{.Repository}}:{{.Tag}}"},
+	resourceNetworks:   {"network", "ls", "--format", "{{.Name}}"},
+	resourceVolumes:    {"volume", "ls", "--format", "{{.Name}}"},
+}
+
+// positionalResource describes what the positional arguments of a command
+// refer to.
+type positionalResource struct {
+	kinds []resourceKind
+	// firstOnly is set if only the first positional argument is a resource.
+	firstOnly bool
+}
+
+// positionalResources maps command paths to their positional arguments.
+var positionalResources = map[string]positionalResource{
+	"container commit":  {[]resourceKind{resourceContainers}, true},
+	"container exec":    {[]resourceKind{resourceContainers}, true},
+	"container inspect": {[]resourceKind{resourceContainers}, false},
+	"container kill":    {[]resourceKind{resourceContainers}, false},
+	"container logs":    {[]resourceKind{resourceContainers}, true},
+	"container pause":   {[]resourceKind{resourceContainers}, false},
+	"container port":    {[]resourceKind{resourceContainers}, true},
+	"container rm":      {[]resourceKind{resourceContainers}, false},
+	"container run":     {[]resourceKind{resourceImages}, true},
+	"container start":   {[]resourceKind{resourceContainers}, false},
+	"container stop":    {[]resourceKind{resourceContainers}, false},
+	"container unpause": {[]resourceKind{resourceContainers}, false},
+	"container wait":    {[]resourceKind{resourceContainers}, false},
+	"image convert":     {[]resourceKind{resourceImages}, true},
+	"image inspect":     {[]resourceKind{resourceImages}, false},
+	"image push":        {[]resourceKind{resourceImages}, false},
+	"image rm":          {[]resourceKind{resourceImages}, false},
+	"image save":        {[]resourceKind{resourceImages}, false},
+	"image tag":         {[]resourceKind{resourceImages}, true},
+	"inspect":           {[]resourceKind{resourceContainers, resourceImages}, false},
+	"network inspect":   {[]resourceKind{resourceNetworks}, false},
+	"network remove":    {[]resourceKind{resourceNetworks}, false},
+	"network rm":        {[]resourceKind{resourceNetworks}, false},
+	"rmi":               {[]resourceKind{resourceImages}, false},
+	"top":               {[]resourceKind{resourceContainers}, true},
+	"volume inspect":    {[]resourceKind{resourceVolumes}, false},
+	"volume remove":     {[]resourceKind{resourceVolumes}, false},
+	"volume rm":         {[]resourceKind{resourceVolumes}, false},
+}
+
+// optionResources maps command paths and option names to the kind of resource
+// the value of the option refers to.
+var optionResources = map[string]map[string]resourceKind{
+	"container run": {
+		"--net":     resourceNetworks,
+		"--network": resourceNetworks,
+	},
+}
+
+// completionCacheTTL is how long the results of listing resources are cached.
+const completionCacheTTL = 10 * time.Second
+
+// resourceLister returns the names of the resources of the given kind in the
+// given namespace (which may be empty for the default).
+type resourceLister func(kind resourceKind, namespace string) ([]string, error)
+
+// handleCompletion handles the commands related to completion that are
+// implemented in the stub rather than nerdctl.  It returns whether the command
+// was handled.
+func handleCompletion(t transport, registry *cliparse.Registry, args []string, output io.Writer) (bool, error) {
+	if len(args) == 2 && args[0] == "completion" {
+		script, ok := completionScripts[args[1]]
+		if !ok {
+			// Let nerdctl handle (and report) anything else.
+			return false, nil
+		}
+		_, err := io.WriteString(output, script)
+		return true, err
+	}
+	if len(args) < 1 || args[0] != completeCommand {
+		return false, nil
+	}
+	lister := func(kind resourceKind, namespace string) ([]string, error) {
+		return listResourcesCached(t, kind, namespace)
+	}
+	candidates, err := completeWords(registry, args[1:], lister)
+	if err != nil {
+		return true, err
+	}
+	for _, candidate := range candidates {
+		fmt.Fprintln(output, candidate)
+	}
+	return true, nil
+}
+
+// completeWords returns the completion candidates for the arguments of the
+// __complete command.
+func completeWords(registry *cliparse.Registry, args []string, lister resourceLister) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("%s: missing word index", completeCommand)
+	}
+	index, err := strconv.Atoi(args[0])
+	if err != nil || index < 0 || index > len(args)-1 {
+		return nil, fmt.Errorf("%s: invalid word index %q", completeCommand, args[0])
+	}
+	words := args[1 : index+1]
+	word := ""
+	if len(args) > index+1 {
+		word = args[index+1]
+	}
+
+	completion := registry.Complete(words, word)
+	candidates := completion.Candidates
+	if completion.Command == nil {
+		return candidates, nil
+	}
+	if completion.Command.Path == "completion" && !strings.HasPrefix(word, "-") && len(completion.Positionals) == 0 {
+		for shell := range completionScripts {
+			if strings.HasPrefix(shell, word) && !contains(candidates, shell) {
+				candidates = append(candidates, shell)
+			}
+		}
+		sort.Strings(candidates)
+	}
+
+	var kinds []resourceKind
+	if completion.Option != "" {
+		if kind, ok := optionResources[completion.Command.Path][completion.Option]; ok {
+			kinds = append(kinds, kind)
+		}
+	} else if !strings.HasPrefix(word, "-") {
+		if resource, ok := positionalResources[completion.Command.Path]; ok {
+			if !resource.firstOnly || len(completion.Positionals) == 0 {
+				kinds = resource.kinds
+			}
+		}
+	}
+	namespace := findNamespace(registry, words)
+	for _, kind := range kinds {
+		names, err := lister(kind, namespace)
+		if err != nil {
+			// Completion should not fail just because nerdctl isn't running.
+			continue
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// contains checks if the slice contains the given string.
+func contains(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// findNamespace returns the namespace given on the command line, if any.  Only
+// the global options (before the first subcommand) are examined.
+func findNamespace(registry *cliparse.Registry, words []string) string {
+	root, ok := registry.Command("")
+	if !ok {
+		return ""
+	}
+	for i, word := range words {
+		if _, ok := root.Subcommands[word]; ok {
+			break
+		}
+		for _, option := range []string{"--namespace", "-n"} {
+			if word == option && i+1 < len(words) {
+				return words[i+1]
+			}
+			if strings.HasPrefix(word, option+"=") {
+				return strings.TrimPrefix(word, option+"=")
+			}
+		}
+	}
+	return ""
+}
+
+// completionCachePath returns the path to the file caching the list of
+// resources of the given kind.
+func completionCachePath(kind resourceKind, namespace string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	name := fmt.Sprintf("%s.%s", filepath.Base(namespace), kind)
+	return filepath.Join(dir, "rancher-desktop", "nerdctl-completion", name), nil
+}
+
+// listResourcesCached returns the names of resources of the given kind, using
+// a cached copy if it is recent enough.  The cache is in a directory chosen by
+// the caller, so it is only accessed as the real user.
+func listResourcesCached(t transport, kind resourceKind, namespace string) ([]string, error) {
+	cachePath, err := completionCachePath(kind, namespace)
+	if err != nil {
+		return listResources(t, kind, namespace)
+	}
+	var cached []string
+	_ = asUser(func() error {
+		info, err := os.Stat(cachePath)
+		if err != nil || time.Since(info.ModTime()) >= completionCacheTTL {
+			return err
+		}
+		data, err := os.ReadFile(cachePath)
+		if err == nil {
+			cached = splitLines(data)
+		}
+		return err
+	})
+	if cached != nil {
+		return cached, nil
+	}
+	names, err := listResources(t, kind, namespace)
+	if err != nil {
+		return nil, err
+	}
+	// Failure to write the cache is not fatal.
+	_ = asUser(func() error {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(cachePath, []byte(strings.Join(names, "\n")+"\n"), 0o644)
+	})
+	return names, nil
+}
+
+// listResources runs nerdctl to list the names of resources of the given kind.
+func listResources(t transport, kind resourceKind, namespace string) ([]string, error) {
+	query, ok := resourceQueries[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource kind %q", kind)
+	}
+	var args []string
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	}
+	args = append(args, query...)
+	output := &bytes.Buffer{}
+	exitCode, err := t.run(args, nil, nil, output, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("nerdctl exited with status %d", exitCode)
+	}
+	return splitLines(output.Bytes()), nil
+}
+
+// splitLines splits the given output into non-empty lines.
+func splitLines(data []byte) []string {
+	var result []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && line != "<none>:<none>" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompleteWords(t *testing.T) {
+	t.Parallel()
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	resources := map[resourceKind][]string{
+		resourceContainers: {"web", "db"},
+		resourceImages:     {"nginx:latest", "alpine:3.14"},
+		resourceNetworks:   {"bridge", "host"},
+		resourceVolumes:    {"data"},
+	}
+	var namespaces []string
+	lister := func(kind resourceKind, namespace string) ([]string, error) {
+		namespaces = append(namespaces, namespace)
+		return resources[kind], nil
+	}
+	failingLister := func(kind resourceKind, namespace string) ([]string, error) {
+		return nil, fmt.Errorf("nerdctl is not running")
+	}
+
+	testCases := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"0", "ima"}, []string{"image", "images"}},
+		{[]string{"1", "image", "l"}, []string{"load", "ls"}},
+		{[]string{"1", "volume", "--h"}, []string{"--help", "--host"}},
+		{[]string{"1", "run"}, []string{"nginx:latest", "alpine:3.14"}},
+		{[]string{"1", "run", "a"}, []string{"alpine:3.14"}},
+		{[]string{"3", "run", "--rm", "-it", "n"}, []string{"nginx:latest"}},
+		{[]string{"2", "run", "alpine", ""}, nil},
+		{[]string{"3", "container", "run", "--network"}, []string{"bridge", "host"}},
+		{[]string{"2", "container", "rm", "web"}, []string{"web"}},
+		{[]string{"3", "container", "rm", "web"}, []string{"web", "db"}},
+		{[]string{"1", "logs"}, []string{"web", "db"}},
+		{[]string{"2", "network", "rm", "b"}, []string{"bridge"}},
+		{[]string{"1", "completion", "p"}, []string{"powershell"}},
+	}
+	for _, testCase := range testCases {
+		candidates, err := completeWords(registry, testCase.args, lister)
+		if assert.NoError(t, err, "%v", testCase.args) {
+			assert.Equal(t, testCase.expected, candidates, "%v", testCase.args)
+		}
+	}
+
+	t.Run("namespace", func(t *testing.T) {
+		namespaces = nil
+		_, err := completeWords(registry, []string{"3", "--namespace", "k8s.io", "rmi"}, lister)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"k8s.io"}, namespaces)
+		}
+		namespaces = nil
+		_, err = completeWords(registry, []string{"3", "logs", "-n", "10"}, lister)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{""}, namespaces)
+		}
+	})
+	t.Run("lister failure", func(t *testing.T) {
+		candidates, err := completeWords(registry, []string{"1", "rmi"}, failingLister)
+		assert.NoError(t, err)
+		assert.Empty(t, candidates)
+	})
+	t.Run("invalid index", func(t *testing.T) {
+		_, err := completeWords(registry, []string{"5", "run"}, lister)
+		assert.Error(t, err)
+		_, err = completeWords(registry, []string{}, lister)
+		assert.Error(t, err)
+	})
+}
+
+func TestHandleCompletion(t *testing.T) {
+	t.Parallel()
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	for shell, script := range completionScripts {
+		output := &bytes.Buffer{}
+		handled, err := handleCompletion(&directTransport{}, registry, []string{"completion", shell}, output)
+		assert.True(t, handled, shell)
+		if assert.NoError(t, err, shell) {
+			assert.Equal(t, script, output.String(), shell)
+		}
+	}
+	handled, err := handleCompletion(&directTransport{}, registry, []string{"completion", "tcsh"}, &bytes.Buffer{})
+	assert.False(t, handled)
+	assert.NoError(t, err)
+	handled, err = handleCompletion(&directTransport{}, registry, []string{"run", "alpine"}, &bytes.Buffer{})
+	assert.False(t, handled)
+	assert.NoError(t, err)
+}
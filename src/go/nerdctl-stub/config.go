@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the name of the configuration file for the stub; it is
+// looked for in the system-wide configuration directory and then the per-user
+// configuration directory.  As YAML is a superset of JSON, either may be used.
+const configFileName = "nerdctl-stub.yaml"
+
+// stubConfig describes the configuration file for the stub.
+type stubConfig struct {
+	// PathOptions declares additional options that carry paths (or override
+	// the built-in ones); the keys are the command path and the option name.
+	PathOptions map[string]map[string]pathKind `yaml:"pathOptions"`
+	// Transport configures how nerdctl is run.
+	Transport transportConfig `yaml:"transport"`
+	// ImageRewrites are rules to rewrite image references (e.g. to use a
+	// registry mirror); the first matching rule is used.
+	ImageRewrites []imageRewriteRule `yaml:"imageRewrites"`
+	// PrintImageRewrites reports any rewritten image references on stderr.
+	PrintImageRewrites bool `yaml:"printImageRewrites"`
+	// Audit configures the audit log; this is only read from the system-wide
+	// configuration file.
+	Audit auditConfig `yaml:"audit"`
+	// Credentials configures how registry credentials are supplied.
+	Credentials credentialsConfig `yaml:"credentials"`
+	// BuildContextSync configures copying build contexts to where nerdctl
+	// runs before building.
+	BuildContextSync contextSyncConfig `yaml:"buildContextSync"`
+	// Aliases defines additional commands, in the manner of git aliases; each
+	// alias is replaced by its expansion.  Built-in commands cannot be
+	// overridden.
+	Aliases map[string]commandLine `yaml:"aliases"`
+	// DefaultOptions are options added to each command (keyed by command
+	// path, with the empty path for global options); options given on the
+	// command line come later, and therefore take precedence.
+	DefaultOptions map[string]commandLine `yaml:"defaultOptions"`
+}
+
+// auditConfig describes the audit log.
+type auditConfig struct {
+	// Path is the audit log file; if unset, auditing is disabled.
+	Path string `yaml:"path"`
+	// MaxSize is the size at which the log is rotated, e.g. `10m`.
+	MaxSize string `yaml:"maxSize"`
+	// MaxBackups is the number of rotated logs to keep.
+	MaxBackups int `yaml:"maxBackups"`
+}
+
+// transportConfig describes how nerdctl is run.
+type transportConfig struct {
+	// Type is the kind of transport; it may be overridden by the
+	// RD_NERDCTL_TRANSPORT environment variable.
+	Type transportType `yaml:"type"`
+	// Nerdctl is the path to the nerdctl executable; it may be overridden by
+	// the RD_NERDCTL environment variable.
+	Nerdctl string `yaml:"nerdctl"`
+	// ContainerdSocket is the path to the containerd socket.
+	ContainerdSocket string `yaml:"containerdSocket"`
+	// SSH configures the ssh transport.
+	SSH sshConfig `yaml:"ssh"`
+}
+
+// sshConfig describes how to connect to a remote containerd host.
+type sshConfig struct {
+	// Host is the host to connect to, as `host` or `host:port`.
+	Host string `yaml:"host"`
+	// User is the remote user name; defaults to the current user.
+	User string `yaml:"user"`
+	// IdentityFile is the path to the private key; if unset, the SSH agent is
+	// used instead.
+	IdentityFile string `yaml:"identityFile"`
+	// KnownHostsFile is used to verify the host key; defaults to
+	// `~/.ssh/known_hosts`.
+	KnownHostsFile string `yaml:"knownHostsFile"`
+	// StagingDir is the remote directory in which files are staged; defaults
+	// to `/tmp`.
+	StagingDir string `yaml:"stagingDir"`
+}
+
+// configPaths returns the configuration files to load, in order; files later
+// in the list override earlier ones.
+func configPaths() []string {
+	var result []string
+	if dir := systemConfigDir(); dir != "" {
+		result = append(result, filepath.Join(dir, configFileName))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		result = append(result, filepath.Join(dir, "rancher-desktop", configFileName))
+	}
+	return result
+}
+
+// loadConfigFile reads a single configuration file.  A missing file is not an
+// error, and results in an empty configuration.
+func loadConfigFile(configPath string) (*stubConfig, error) {
+	var config stubConfig
+	file, err := os.Open(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &config, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse %s: %w", configPath, err)
+	}
+	return &config, nil
+}
+
+// merge the given configuration into this one; values from other override
+// values in this configuration.  The audit settings are not merged, as they
+// may only be set by the administrator; see loadAuditConfig.
+func (c *stubConfig) merge(other *stubConfig) {
+	for command, options := range other.PathOptions {
+		if c.PathOptions == nil {
+			c.PathOptions = make(map[string]map[string]pathKind)
+		}
+		if c.PathOptions[command] == nil {
+			c.PathOptions[command] = make(map[string]pathKind)
+		}
+		for option, kind := range options {
+			c.PathOptions[command][option] = kind
+		}
+	}
+	if len(other.ImageRewrites) > 0 {
+		// Rules from later files take precedence.
+		c.ImageRewrites = append(append([]imageRewriteRule{}, other.ImageRewrites...), c.ImageRewrites...)
+	}
+	c.PrintImageRewrites = c.PrintImageRewrites || other.PrintImageRewrites
+	for name, expansion := range other.Aliases {
+		if c.Aliases == nil {
+			c.Aliases = make(map[string]commandLine)
+		}
+		c.Aliases[name] = expansion
+	}
+	for command, options := range other.DefaultOptions {
+		if c.DefaultOptions == nil {
+			c.DefaultOptions = make(map[string]commandLine)
+		}
+		c.DefaultOptions[command] = options
+	}
+	c.Credentials.Disabled = c.Credentials.Disabled || other.Credentials.Disabled
+	mergeString(&c.Credentials.DockerConfig, other.Credentials.DockerConfig)
+	c.BuildContextSync.Enabled = c.BuildContextSync.Enabled || other.BuildContextSync.Enabled
+	mergeString(&c.BuildContextSync.CacheDir, other.BuildContextSync.CacheDir)
+	if other.BuildContextSync.MaxEntries != 0 {
+		c.BuildContextSync.MaxEntries = other.BuildContextSync.MaxEntries
+	}
+	if other.Transport.Type != "" {
+		c.Transport.Type = other.Transport.Type
+	}
+	mergeString(&c.Transport.Nerdctl, other.Transport.Nerdctl)
+	mergeString(&c.Transport.ContainerdSocket, other.Transport.ContainerdSocket)
+	mergeString(&c.Transport.SSH.Host, other.Transport.SSH.Host)
+	mergeString(&c.Transport.SSH.User, other.Transport.SSH.User)
+	mergeString(&c.Transport.SSH.IdentityFile, other.Transport.SSH.IdentityFile)
+	mergeString(&c.Transport.SSH.KnownHostsFile, other.Transport.SSH.KnownHostsFile)
+	mergeString(&c.Transport.SSH.StagingDir, other.Transport.SSH.StagingDir)
+}
+
+// mergeString overwrites the target with the value, if the value is not empty.
+func mergeString(target *string, value string) {
+	if value != "" {
+		*target = value
+	}
+}
+
+// loadConfig loads all configuration files.  Errors loading any one file are
+// returned, but do not prevent other files from being loaded.
+func loadConfig() (*stubConfig, []error) {
+	var errs []error
+	config := &stubConfig{}
+	for _, configPath := range configPaths() {
+		fileConfig, err := loadConfigFile(configPath)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		config.merge(fileConfig)
+	}
+	return config, errs
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Parallel()
+	t.Run("missing file", func(t *testing.T) {
+		t.Parallel()
+		config, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, &stubConfig{}, config)
+		}
+	})
+	t.Run("yaml", func(t *testing.T) {
+		t.Parallel()
+		configPath := filepath.Join(t.TempDir(), configFileName)
+		contents := "pathOptions:\n  container run:\n    --mount: mount-csv\n"
+		if !assert.NoError(t, os.WriteFile(configPath, []byte(contents), 0o644)) {
+			return
+		}
+		config, err := loadConfigFile(configPath)
+		if assert.NoError(t, err) {
+			expected := map[string]map[string]pathKind{"container run": {"--mount": pathKindMountCSV}}
+			assert.Equal(t, expected, config.PathOptions)
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		configPath := filepath.Join(t.TempDir(), configFileName)
+		contents := `{"pathOptions": {"image build": {"--secret": "input-path"}}}`
+		if !assert.NoError(t, os.WriteFile(configPath, []byte(contents), 0o644)) {
+			return
+		}
+		config, err := loadConfigFile(configPath)
+		if assert.NoError(t, err) {
+			expected := map[string]map[string]pathKind{"image build": {"--secret": pathKindInputPath}}
+			assert.Equal(t, expected, config.PathOptions)
+		}
+	})
+	t.Run("unknown fields", func(t *testing.T) {
+		t.Parallel()
+		configPath := filepath.Join(t.TempDir(), configFileName)
+		if !assert.NoError(t, os.WriteFile(configPath, []byte("pathOption: {}\n"), 0o644)) {
+			return
+		}
+		_, err := loadConfigFile(configPath)
+		assert.Error(t, err)
+	})
+}
+
+func TestConfigMerge(t *testing.T) {
+	t.Parallel()
+	config := &stubConfig{}
+	config.merge(&stubConfig{PathOptions: map[string]map[string]pathKind{
+		"container run": {"--mount": pathKindMountCSV, "--cidfile": pathKindOutputPath},
+	}})
+	config.merge(&stubConfig{PathOptions: map[string]map[string]pathKind{
+		"container run": {"--cidfile": pathKindIgnored},
+		"compose":       {"--file": pathKindIgnored},
+	}})
+	expected := map[string]map[string]pathKind{
+		"container run": {"--mount": pathKindMountCSV, "--cidfile": pathKindIgnored},
+		"compose":       {"--file": pathKindIgnored},
+	}
+	assert.Equal(t, expected, config.PathOptions)
+}
+
+func TestConfigMergeIgnoresAudit(t *testing.T) {
+	t.Parallel()
+	config := &stubConfig{}
+	config.merge(&stubConfig{Audit: auditConfig{Path: "/etc/passwd", MaxSize: "1b", MaxBackups: 1}})
+	assert.Equal(t, auditConfig{}, config.Audit)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// contextsFileName is the name of the file (in the per-user configuration
+	// directory) that stores the contexts.
+	contextsFileName = "nerdctl-contexts.yaml"
+	// defaultContextName is the name of the implicit context that uses the
+	// environment and configuration files.
+	defaultContextName = "default"
+	// contextOption selects the context for a single invocation; it must be
+	// given before the command.
+	contextOption = "--context"
+)
+
+// contextNamePattern matches valid context names.
+var contextNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.+-]*$`)
+
+// stubContext is a named set of target settings; any fields not set fall back
+// to the environment and configuration files.
+type stubContext struct {
+	// Distro is the WSL distribution to run nerdctl in.
+	Distro string `yaml:"distro,omitempty"`
+	// Transport is the kind of transport used to run nerdctl.
+	Transport transportType `yaml:"transport,omitempty"`
+	// ContainerdSocket is the path to the containerd socket.
+	ContainerdSocket string `yaml:"containerdSocket,omitempty"`
+	// Namespace is the containerd namespace to use.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Nerdctl is the path to the nerdctl executable.
+	Nerdctl string `yaml:"nerdctl,omitempty"`
+}
+
+// contextStore is the file containing the contexts.
+type contextStore struct {
+	path string
+	// Current is the context used when none is selected explicitly.
+	Current string `yaml:"current,omitempty"`
+	// Contexts are the contexts, keyed by name.
+	Contexts map[string]stubContext `yaml:"contexts,omitempty"`
+}
+
+// contextsPath returns the path of the file containing the contexts.
+func contextsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rancher-desktop", contextsFileName), nil
+}
+
+// loadContexts reads the contexts from the given file.  A missing file is not
+// an error, and results in no contexts.
+func loadContexts(contextsPath string) (*contextStore, error) {
+	store := &contextStore{path: contextsPath}
+	file, err := os.Open(contextsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(store); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse %s: %w", contextsPath, err)
+	}
+	return store, nil
+}
+
+// save writes the contexts back to the file, replacing it atomically.
+func (s *contextStore) save() error {
+	if s.path == "" {
+		return fmt.Errorf("could not find the configuration directory to store contexts in")
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(s.path), ".nerdctl-contexts-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
+
+// lookup returns the context with the given name; if the name is empty, the
+// current context is used.  The default context is returned as nil.
+func (s *contextStore) lookup(name string) (string, *stubContext, error) {
+	if name == "" {
+		name = s.Current
+	}
+	if name == "" || name == defaultContextName {
+		return defaultContextName, nil, nil
+	}
+	context, ok := s.Contexts[name]
+	if !ok {
+		return "", nil, fmt.Errorf("context %q does not exist", name)
+	}
+	return name, &context, nil
+}
+
+// apply the context to the spawn options.
+func (c *stubContext) apply(opts *spawnOptions) {
+	if c.Transport != "" {
+		opts.transport = c.Transport
+	}
+	mergeString(&opts.distro, c.Distro)
+	mergeString(&opts.containerdSocket, c.ContainerdSocket)
+	mergeString(&opts.namespace, c.Namespace)
+	mergeString(&opts.nerdctl, c.Nerdctl)
+}
+
+// extractContextOption removes the `--context` option from the global options
+// at the start of the arguments, and returns its value.
+func extractContextOption(args []string) (string, []string, error) {
+	name := ""
+	result := make([]string, 0, len(args))
+	index := 0
+	for ; index < len(args); index++ {
+		arg := args[index]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			break
+		}
+		if arg == contextOption {
+			if index+1 >= len(args) {
+				return "", nil, fmt.Errorf("option %s requires a value", contextOption)
+			}
+			name = args[index+1]
+			index++
+			continue
+		}
+		if strings.HasPrefix(arg, contextOption+"=") {
+			name = strings.TrimPrefix(arg, contextOption+"=")
+			continue
+		}
+		result = append(result, arg)
+		// Skip over the values of global options.
+		if handler, ok := commands[""].Options[arg]; ok && handler != nil && index+1 < len(args) {
+			result = append(result, args[index+1])
+			index++
+		}
+	}
+	return name, append(result, args[index:]...), nil
+}
+
+// handleContextCommand implements `nerdctl context`; the arguments are the
+// ones after `context`.  The options used for the default context are given so
+// that it can be described.
+func handleContextCommand(store *contextStore, args []string, defaults spawnOptions, output io.Writer) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: nerdctl context create|ls|use|rm")
+	}
+	switch args[0] {
+	case "create":
+		return store.create(args[1:], output)
+	case "ls", "list":
+		return store.list(defaults, output)
+	case "use":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: nerdctl context use NAME")
+		}
+		return store.use(args[1], output)
+	case "rm", "remove":
+		return store.remove(args[1:], output)
+	}
+	return fmt.Errorf("unknown context command %q", args[0])
+}
+
+// create implements `nerdctl context create`.
+func (s *contextStore) create(args []string, output io.Writer) error {
+	var context stubContext
+	var transport string
+	flags := flag.NewFlagSet("nerdctl context create", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&context.Distro, "distro", "", "WSL distribution to run nerdctl in")
+	flags.StringVar(&transport, "transport", "", "transport to use (wsl, direct or ssh)")
+	flags.StringVar(&context.ContainerdSocket, "containerd-socket", "", "path to the containerd socket")
+	flags.StringVar(&context.Namespace, "namespace", "", "containerd namespace")
+	flags.StringVar(&context.Nerdctl, "nerdctl", "", "path to the nerdctl executable")
+	// Allow the name to come before or after the options.
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: nerdctl context create NAME [OPTIONS]")
+	}
+	name := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	if name == defaultContextName || !contextNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid context name %q", name)
+	}
+	if _, ok := s.Contexts[name]; ok {
+		return fmt.Errorf("context %q already exists", name)
+	}
+	switch transportType(transport) {
+	case "", transportWSL, transportDirect, transportSSH:
+		context.Transport = transportType(transport)
+	default:
+		return fmt.Errorf("unknown transport %q", transport)
+	}
+	if s.Contexts == nil {
+		s.Contexts = make(map[string]stubContext)
+	}
+	s.Contexts[name] = context
+	if err := s.save(); err != nil {
+		return err
+	}
+	fmt.Fprintln(output, name)
+	return nil
+}
+
+// list implements `nerdctl context ls`.
+func (s *contextStore) list(defaults spawnOptions, output io.Writer) error {
+	names := []string{defaultContextName}
+	for name := range s.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	current, _, err := s.lookup("")
+	if err != nil {
+		current = s.Current
+	}
+	writer := tabwriter.NewWriter(output, 10, 1, 3, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tTRANSPORT\tDISTRO\tSOCKET\tNAMESPACE\tNERDCTL")
+	for _, name := range names {
+		opts := defaults
+		if context, ok := s.Contexts[name]; ok {
+			context.apply(&opts)
+		}
+		if opts.transport == "" {
+			opts.transport = transportWSL
+		}
+		if opts.transport != transportWSL {
+			opts.distro = ""
+		}
+		if name == current {
+			name += " *"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", name, opts.transport, opts.distro, opts.containerdSocket, opts.namespace, opts.nerdctl)
+	}
+	return writer.Flush()
+}
+
+// use implements `nerdctl context use`.
+func (s *contextStore) use(name string, output io.Writer) error {
+	if _, _, err := s.lookup(name); err != nil {
+		return err
+	}
+	s.Current = name
+	if name == defaultContextName {
+		s.Current = ""
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Current context is now %q\n", name)
+	return nil
+}
+
+// remove implements `nerdctl context rm`.
+func (s *contextStore) remove(args []string, output io.Writer) error {
+	force := false
+	flags := flag.NewFlagSet("nerdctl context rm", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.BoolVar(&force, "force", false, "remove the context even if it is in use")
+	flags.BoolVar(&force, "f", false, "shorthand for --force")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: nerdctl context rm [--force] NAME...")
+	}
+	for _, name := range flags.Args() {
+		if _, ok := s.Contexts[name]; !ok {
+			return fmt.Errorf("context %q does not exist", name)
+		}
+		if name == s.Current && !force {
+			return fmt.Errorf("context %q is in use; switch to another context, or use --force", name)
+		}
+	}
+	for _, name := range flags.Args() {
+		delete(s.Contexts, name)
+		if name == s.Current {
+			s.Current = ""
+		}
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+	for _, name := range flags.Args() {
+		fmt.Fprintln(output, name)
+	}
+	return nil
+}
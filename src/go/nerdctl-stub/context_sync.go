@@ -0,0 +1,535 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+const (
+	// defaultContextSyncEntries is the number of build contexts kept in the
+	// cache if the configuration does not say otherwise.
+	defaultContextSyncEntries = 10
+	// contextSyncDirName is the name of the directory (in the per-project
+	// cache directory) holding the copy of the build context.
+	contextSyncDirName = "context"
+	// contextSyncManifestName is the name of the file (in the per-project
+	// cache directory) that describes the copy of the build context.
+	contextSyncManifestName = "manifest.json"
+	// contextSyncDeletedName is the name of the file (in the per-project
+	// cache directory) listing the paths to remove after extracting.
+	contextSyncDeletedName = "deleted"
+)
+
+// contextSyncPrepareScript is run where nerdctl runs to create the cache
+// directory for a project; it prints the directory, followed by the manifest
+// (if any).  The arguments are the cache root and the project key.
+const contextSyncPrepareScript = `set -e
+root="${1:-${XDG_CACHE_HOME:-$HOME/.cache}/rancher-desktop/build-contexts}"
+mkdir -p "$root/$2"
+cd "$root/$2"
+pwd
+if [ -f ` + contextSyncManifestName + ` ]; then cat ` + contextSyncManifestName + `; fi
+`
+
+// contextSyncApplyScript is run where nerdctl runs to update the cache
+// directory for a project from a tar archive on stdin, and to evict the least
+// recently used projects.  The arguments are the cache root, the project key,
+// the number of projects to keep, whether to start afresh, and any paths to
+// remove before extracting.
+const contextSyncApplyScript = `set -e
+root="${1:-${XDG_CACHE_HOME:-$HOME/.cache}/rancher-desktop/build-contexts}"
+cd "$root/$2"
+keep="$3"
+if [ "$4" = reset ]; then rm -rf ` + contextSyncDirName + ` ` + contextSyncManifestName + `; fi
+shift 4
+if [ $# -gt 0 ]; then rm -rf -- "$@"; fi
+mkdir -p ` + contextSyncDirName + `
+tar -xf -
+if [ -f ` + contextSyncDeletedName + ` ]; then
+	xargs -0 rm -rf -- < ` + contextSyncDeletedName + `
+	rm -f ` + contextSyncDeletedName + `
+fi
+touch .
+cd "$root"
+ls -1t | tail -n +$((keep + 1)) | while IFS= read -r old; do rm -rf -- "$old"; done
+`
+
+// contextSyncConfig describes how build contexts are copied to where nerdctl
+// runs before building.
+type contextSyncConfig struct {
+	// Enabled turns on syncing build contexts; it may also be enabled by the
+	// RD_NERDCTL_SYNC_BUILD_CONTEXT environment variable.
+	Enabled bool `yaml:"enabled"`
+	// CacheDir is the directory, where nerdctl runs, holding the copies;
+	// defaults to `~/.cache/rancher-desktop/build-contexts` for the user
+	// nerdctl runs as.
+	CacheDir string `yaml:"cacheDir"`
+	// MaxEntries is the number of build contexts to keep; the least recently
+	// used ones are removed first.
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+// enabled checks if build contexts should be synced.
+func (c contextSyncConfig) enabled() bool {
+	return c.Enabled || os.Getenv("RD_NERDCTL_SYNC_BUILD_CONTEXT") != ""
+}
+
+// syncEntry describes one file, directory or symbolic link in a build context.
+type syncEntry struct {
+	// Mode is the os.FileMode of the entry.
+	Mode uint32 `json:"mode"`
+	// Size is the size of a file.
+	Size int64 `json:"size,omitempty"`
+	// ModTime is the modification time of a file, in nanoseconds.
+	ModTime int64 `json:"mtime,omitempty"`
+	// Hash is the SHA-256 hash of the contents of a file.
+	Hash string `json:"sha256,omitempty"`
+	// Link is the target of a symbolic link.
+	Link string `json:"link,omitempty"`
+}
+
+// syncManifest describes the contents of a build context.
+type syncManifest struct {
+	// Entries are keyed by the path relative to the build context, using
+	// forward slashes.
+	Entries map[string]syncEntry `json:"entries"`
+}
+
+// syncPlan describes how to update a cached build context.
+type syncPlan struct {
+	// copy are the paths to send.
+	copy []string
+	// replace are the paths that changed type, and must be removed first.
+	replace []string
+	// remove are the paths that no longer exist.
+	remove []string
+}
+
+// contextSyncStats summarizes a sync.
+type contextSyncStats struct {
+	// Files is the number of files (and symbolic links) in the build context.
+	Files int
+	// Copied is the number of files that were sent.
+	Copied int
+	// Bytes is the total size of the files that were sent.
+	Bytes int64
+	// Removed is the number of files that were removed from the copy.
+	Removed int
+	// Duration is how long the sync took.
+	Duration time.Duration
+}
+
+func (s *contextSyncStats) String() string {
+	return fmt.Sprintf("Synced build context: %d files, %d copied (%s), %d removed, %d unchanged in %s",
+		s.Files, s.Copied, humanSize(s.Bytes), s.Removed, s.Files-s.Copied, s.Duration.Round(time.Millisecond))
+}
+
+// contextSyncer copies build contexts to a cache where nerdctl runs, so that
+// they are not read through a slow file system share during the build.
+type contextSyncer struct {
+	t      transport
+	config contextSyncConfig
+	// output receives a summary of each sync.
+	output io.Writer
+}
+
+func newContextSyncer(t transport, config contextSyncConfig, output io.Writer) *contextSyncer {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultContextSyncEntries
+	}
+	return &contextSyncer{t: t, config: config, output: output}
+}
+
+// applies checks if the syncer would act on the given parse result; whether the
+// build context is a local directory is not checked.
+func (s *contextSyncer) applies(registry *cliparse.Registry, result *cliparse.Result) bool {
+	if result.CommandPath != "image build" || len(result.Positionals) == 0 || result.Positionals[0] == "-" {
+		return false
+	}
+	match, _ := regexp.MatchString(`^[^:/]*://`, result.Positionals[0])
+	return !match
+}
+
+// register wraps the handler for `image build` so that local build contexts
+// are synced; anything else (or any failure to sync) is left to the existing
+// handler.
+func (s *contextSyncer) register(registry *cliparse.Registry) error {
+	command, ok := registry.Command("image build")
+	if !ok || command.Handler == nil {
+		return nil
+	}
+	handler := command.Handler
+	return registry.RegisterCommandHandler("image build", func(c *cliparse.Command, args []string) (*cliparse.Result, error) {
+		if len(args) < 1 {
+			return handler(c, args)
+		}
+		if info, err := os.Stat(args[0]); err != nil || !info.IsDir() {
+			return handler(c, args)
+		}
+		cached, stats, err := s.sync(args[0])
+		if err != nil {
+			log.Printf("Could not sync build context %s, using it directly: %s", args[0], err)
+			return handler(c, args)
+		}
+		if s.output != nil {
+			fmt.Fprintln(s.output, stats)
+		}
+		return &cliparse.Result{Args: append([]string{cached}, args[1:]...)}, nil
+	})
+}
+
+// sync updates the cached copy of the given build context, and returns its
+// path where nerdctl runs.
+func (s *contextSyncer) sync(contextDir string) (string, *contextSyncStats, error) {
+	start := time.Now()
+	absDir, err := filepath.Abs(contextDir)
+	if err != nil {
+		return "", nil, err
+	}
+	hash := sha256.Sum256([]byte(absDir))
+	key := hex.EncodeToString(hash[:8])
+
+	cacheDir, previous, err := s.prepare(key)
+	if err != nil {
+		return "", nil, err
+	}
+	reset := previous == nil
+	if reset {
+		previous = &syncManifest{}
+	}
+	manifest, err := scanContext(absDir, previous)
+	if err != nil {
+		return "", nil, err
+	}
+	plan, stats := planSync(previous, manifest)
+	if err = s.apply(absDir, key, reset, plan, manifest); err != nil {
+		return "", nil, err
+	}
+	stats.Duration = time.Since(start)
+	return path.Join(cacheDir, contextSyncDirName), stats, nil
+}
+
+// runScript runs a shell script where nerdctl runs, returning its output.
+func (s *contextSyncer) runScript(script string, stdin io.Reader, args ...string) ([]byte, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	command := append([]string{"/bin/sh", "-c", script, "sh"}, args...)
+	code, err := s.t.exec(command, nil, stdin, stdout, stderr)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("exit code %d: %s", code, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// prepare creates the cache directory for the given project, returning its
+// path and the manifest of its contents (or nil, if it has none).
+func (s *contextSyncer) prepare(key string) (string, *syncManifest, error) {
+	output, err := s.runScript(contextSyncPrepareScript, nil, s.config.CacheDir, key)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not create cache directory: %w", err)
+	}
+	parts := bytes.SplitN(output, []byte("\n"), 2)
+	cacheDir := strings.TrimSpace(string(parts[0]))
+	if !path.IsAbs(cacheDir) {
+		return "", nil, fmt.Errorf("unexpected cache directory %q", cacheDir)
+	}
+	if len(parts) < 2 || len(bytes.TrimSpace(parts[1])) == 0 {
+		return cacheDir, nil, nil
+	}
+	var manifest syncManifest
+	if err = json.Unmarshal(parts[1], &manifest); err != nil || manifest.Entries == nil {
+		// Start afresh if the manifest is damaged.
+		return cacheDir, nil, nil
+	}
+	return cacheDir, &manifest, nil
+}
+
+// apply sends the changes to the cache directory for the given project.
+func (s *contextSyncer) apply(contextDir, key string, reset bool, plan *syncPlan, manifest *syncManifest) error {
+	reader, writer := io.Pipe()
+	archiveErr := make(chan error, 1)
+	go func() {
+		err := writeSyncArchive(writer, contextDir, plan, manifest)
+		writer.CloseWithError(err)
+		archiveErr <- err
+	}()
+	resetFlag := "keep"
+	if reset {
+		resetFlag = "reset"
+	}
+	args := []string{s.config.CacheDir, key, strconv.Itoa(s.config.MaxEntries), resetFlag}
+	for _, relPath := range plan.replace {
+		args = append(args, path.Join(contextSyncDirName, relPath))
+	}
+	_, err := s.runScript(contextSyncApplyScript, reader, args...)
+	// Unblock the archive writer if the script did not read everything.
+	reader.Close()
+	if writeErr := <-archiveErr; writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+		return fmt.Errorf("could not send build context: %w", writeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update cache directory: %w", err)
+	}
+	return nil
+}
+
+// scanContext lists the contents of the build context that are not excluded by
+// its .dockerignore file.  The hashes of files that have the same size and
+// modification time as in the previous manifest are assumed to be unchanged.
+func scanContext(contextDir string, previous *syncManifest) (*syncManifest, error) {
+	ignore, err := readDockerIgnore(contextDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &syncManifest{Entries: make(map[string]syncEntry)}
+	err = filepath.WalkDir(contextDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(contextDir, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "." {
+			return nil
+		}
+		// As with docker, the Dockerfile and .dockerignore are always sent.
+		if ignore.matches(relPath) && relPath != "Dockerfile" && relPath != dockerIgnoreFileName {
+			if d.IsDir() && !ignore.hasExclusions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entry, ok, err := newSyncEntry(filePath, previous.Entries[relPath])
+		if err != nil || !ok {
+			return err
+		}
+		// Keep any parent directories that were excluded, but whose contents
+		// were included again.
+		for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+			if _, ok := manifest.Entries[dir]; ok {
+				break
+			}
+			dirEntry, _, err := newSyncEntry(filepath.Join(contextDir, filepath.FromSlash(dir)), syncEntry{})
+			if err != nil {
+				return err
+			}
+			manifest.Entries[dir] = dirEntry
+		}
+		manifest.Entries[relPath] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// newSyncEntry describes the given file; if it is not a directory, regular file
+// or symbolic link, false is returned.
+func newSyncEntry(filePath string, previous syncEntry) (syncEntry, bool, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return syncEntry{}, false, err
+	}
+	mode := info.Mode()
+	if runtime.GOOS == "windows" {
+		// Windows has no permission bits; docker sends everything as 0755.
+		mode = mode&os.ModeType | 0o755
+	}
+	entry := syncEntry{Mode: uint32(mode & (os.ModeType | os.ModePerm))}
+	switch {
+	case mode.IsDir():
+	case mode&os.ModeSymlink != 0:
+		if entry.Link, err = os.Readlink(filePath); err != nil {
+			return syncEntry{}, false, err
+		}
+		entry.Link = filepath.ToSlash(entry.Link)
+	case mode.IsRegular():
+		entry.Size = info.Size()
+		entry.ModTime = info.ModTime().UnixNano()
+		if previous.Hash != "" && previous.Size == entry.Size && previous.ModTime == entry.ModTime {
+			entry.Hash = previous.Hash
+		} else if entry.Hash, err = hashFile(filePath); err != nil {
+			return syncEntry{}, false, err
+		}
+	default:
+		return syncEntry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+// hashFile returns the SHA-256 hash of the contents of the given file.
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// planSync determines the changes needed to update the copy described by the
+// previous manifest.  Files whose contents are unchanged are not copied, even
+// if their modification time differs.
+func planSync(previous, manifest *syncManifest) (*syncPlan, *contextSyncStats) {
+	plan := &syncPlan{}
+	stats := &contextSyncStats{}
+	for relPath, entry := range manifest.Entries {
+		isDir := os.FileMode(entry.Mode).IsDir()
+		if !isDir {
+			stats.Files++
+		}
+		old, ok := previous.Entries[relPath]
+		if ok && os.FileMode(old.Mode)&os.ModeType != os.FileMode(entry.Mode)&os.ModeType {
+			plan.replace = append(plan.replace, relPath)
+			ok = false
+		}
+		if ok && old.Mode == entry.Mode && old.Hash == entry.Hash && old.Link == entry.Link {
+			continue
+		}
+		plan.copy = append(plan.copy, relPath)
+		if !isDir {
+			stats.Copied++
+			stats.Bytes += entry.Size
+		}
+	}
+	for relPath, entry := range previous.Entries {
+		if _, ok := manifest.Entries[relPath]; !ok {
+			plan.remove = append(plan.remove, relPath)
+			if !os.FileMode(entry.Mode).IsDir() {
+				stats.Removed++
+			}
+		}
+	}
+	// Parent directories are sent before their contents.
+	sort.Strings(plan.copy)
+	sort.Strings(plan.replace)
+	sort.Strings(plan.remove)
+	return plan, stats
+}
+
+// writeSyncArchive writes a tar archive containing the paths to copy, the list
+// of paths to remove, and the new manifest.
+func writeSyncArchive(writer io.Writer, contextDir string, plan *syncPlan, manifest *syncManifest) error {
+	tarWriter := tar.NewWriter(writer)
+	for _, relPath := range plan.copy {
+		entry := manifest.Entries[relPath]
+		mode := os.FileMode(entry.Mode)
+		header := &tar.Header{
+			Name:    path.Join(contextSyncDirName, relPath),
+			Mode:    int64(mode.Perm()),
+			ModTime: time.Unix(0, entry.ModTime),
+		}
+		switch {
+		case mode.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.ModTime = time.Now()
+		case mode&os.ModeSymlink != 0:
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.Link
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = entry.Size
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if err := copyFileTo(tarWriter, filepath.Join(contextDir, filepath.FromSlash(relPath)), entry.Size); err != nil {
+				return err
+			}
+		}
+	}
+	if len(plan.remove) > 0 {
+		var deleted bytes.Buffer
+		for _, relPath := range plan.remove {
+			deleted.WriteString(path.Join(contextSyncDirName, relPath))
+			deleted.WriteByte(0)
+		}
+		if err := writeTarFile(tarWriter, contextSyncDeletedName, deleted.Bytes()); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	// The manifest is written last, so that it is only updated if everything
+	// else was extracted.
+	if err = writeTarFile(tarWriter, contextSyncManifestName, data); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// copyFileTo writes exactly size bytes of the given file; it fails if the file
+// was changed while being synced.
+func copyFileTo(writer io.Writer, filePath string, size int64) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = io.CopyN(writer, file, size); err != nil {
+		return fmt.Errorf("could not read %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// writeTarFile writes a regular file with the given contents to the archive.
+func writeTarFile(tarWriter *tar.Writer, name string, contents []byte) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(contents)),
+		ModTime:  time.Now(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tarWriter.Write(contents)
+	return err
+}
+
+// humanSize formats a number of bytes for display.
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeContextFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0o755))
+		require.NoError(t, os.WriteFile(filePath, []byte(contents), 0o644))
+	}
+}
+
+func TestContextSync(t *testing.T) {
+	t.Parallel()
+	contextDir := t.TempDir()
+	cacheRoot := t.TempDir()
+	writeContextFiles(t, contextDir, map[string]string{
+		"Dockerfile":         "FROM alpine\nCOPY . /src\n",
+		".dockerignore":      "node_modules\nDockerfile\n",
+		"src/a.txt":          "a",
+		"src/b.txt":          "b",
+		"node_modules/x.js":  "x",
+		"docs/guide/readme":  "docs",
+		"replaced/child.txt": "child",
+	})
+	syncer := newContextSyncer(&directTransport{}, contextSyncConfig{CacheDir: cacheRoot}, nil)
+	readCached := func(cached, name string) string {
+		data, err := os.ReadFile(filepath.Join(cached, filepath.FromSlash(name)))
+		require.NoError(t, err, name)
+		return string(data)
+	}
+
+	cached, stats, err := syncer.sync(contextDir)
+	require.NoError(t, err)
+	assert.Equal(t, cacheRoot, filepath.Dir(filepath.Dir(cached)))
+	assert.Equal(t, 6, stats.Files)
+	assert.Equal(t, 6, stats.Copied)
+	assert.Equal(t, "a", readCached(cached, "src/a.txt"))
+	assert.Equal(t, "FROM alpine\nCOPY . /src\n", readCached(cached, "Dockerfile"))
+	assert.NoDirExists(t, filepath.Join(cached, "node_modules"))
+
+	// Nothing is copied if nothing changed.
+	_, stats, err = syncer.sync(contextDir)
+	require.NoError(t, err)
+	assert.Equal(t, contextSyncStats{Files: 6, Duration: stats.Duration}, *stats)
+
+	// Files with only a new modification time are not copied.
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(contextDir, "src", "a.txt"), future, future))
+	writeContextFiles(t, contextDir, map[string]string{"src/b.txt": "bb", "src/c.txt": "c"})
+	require.NoError(t, os.RemoveAll(filepath.Join(contextDir, "docs")))
+	require.NoError(t, os.RemoveAll(filepath.Join(contextDir, "replaced")))
+	writeContextFiles(t, contextDir, map[string]string{"replaced": "file"})
+	_, stats, err = syncer.sync(contextDir)
+	require.NoError(t, err)
+	assert.Equal(t, 6, stats.Files)
+	assert.Equal(t, 3, stats.Copied)
+	assert.Equal(t, int64(7), stats.Bytes)
+	assert.Equal(t, 2, stats.Removed)
+	assert.Equal(t, "bb", readCached(cached, "src/b.txt"))
+	assert.Equal(t, "c", readCached(cached, "src/c.txt"))
+	assert.Equal(t, "file", readCached(cached, "replaced"))
+	assert.NoDirExists(t, filepath.Join(cached, "docs"))
+
+	// A damaged cache is replaced.
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cached), contextSyncManifestName), []byte("{"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(cached, "stale"), nil, 0o644))
+	_, stats, err = syncer.sync(contextDir)
+	require.NoError(t, err)
+	assert.Equal(t, 6, stats.Copied)
+	assert.NoFileExists(t, filepath.Join(cached, "stale"))
+}
+
+func TestContextSyncEviction(t *testing.T) {
+	t.Parallel()
+	cacheRoot := t.TempDir()
+	syncer := newContextSyncer(&directTransport{}, contextSyncConfig{CacheDir: cacheRoot, MaxEntries: 2}, nil)
+	var cached []string
+	for i := 0; i < 3; i++ {
+		contextDir := t.TempDir()
+		writeContextFiles(t, contextDir, map[string]string{"Dockerfile": "FROM scratch\n"})
+		dir, _, err := syncer.sync(contextDir)
+		require.NoError(t, err)
+		cached = append(cached, filepath.Dir(dir))
+		// Make sure the modification times differ.
+		past := time.Now().Add(time.Duration(i-3) * time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Dir(dir), past, past))
+	}
+	assert.NoDirExists(t, cached[0])
+	assert.DirExists(t, cached[1])
+	assert.DirExists(t, cached[2])
+}
+
+func TestContextSyncRegister(t *testing.T) {
+	t.Parallel()
+	contextDir := t.TempDir()
+	writeContextFiles(t, contextDir, map[string]string{"Dockerfile": "FROM scratch\n"})
+	handlers := &argHandlers{directPathTranslator{}}
+	registry, err := newRegistry(handlers)
+	require.NoError(t, err)
+	cacheRoot := t.TempDir()
+	syncer := newContextSyncer(&directTransport{}, contextSyncConfig{CacheDir: cacheRoot}, nil)
+	require.NoError(t, syncer.register(registry))
+
+	result, err := registry.Parse([]string{"build", "-t", "app", contextDir})
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Args)
+	cached := result.Args[len(result.Args)-1]
+	assert.Equal(t, cacheRoot, filepath.Dir(filepath.Dir(cached)))
+	assert.FileExists(t, filepath.Join(cached, "Dockerfile"))
+
+	// Anything other than a local directory is left alone.
+	for _, input := range []string{"https://example.com/repo.git", filepath.Join(contextDir, "Dockerfile")} {
+		result, err = registry.Parse([]string{"build", input})
+		if assert.NoError(t, err) {
+			assert.Equal(t, input, result.Args[len(result.Args)-1])
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	t.Parallel()
+	testCases := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 40:         "3.0 TiB",
+	}
+	for size, expected := range testCases {
+		assert.Equal(t, expected, humanSize(size), size)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractContextOption(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		args     []string
+		name     string
+		expected []string
+	}{
+		{[]string{"--context", "remote", "ps"}, "remote", []string{"ps"}},
+		{[]string{"--namespace", "k8s.io", "--context=remote", "ps", "-a"}, "remote", []string{"--namespace", "k8s.io", "ps", "-a"}},
+		{[]string{"--debug", "ps"}, "", []string{"--debug", "ps"}},
+		{[]string{"run", "--context", "x", "alpine"}, "", []string{"run", "--context", "x", "alpine"}},
+		// The value of a global option is not mistaken for the command.
+		{[]string{"--address", "/run/x.sock", "--context", "remote", "ps"}, "remote", []string{"--address", "/run/x.sock", "ps"}},
+	}
+	for _, testCase := range testCases {
+		name, args, err := extractContextOption(testCase.args)
+		if assert.NoError(t, err, testCase.args) {
+			assert.Equal(t, testCase.name, name, testCase.args)
+			assert.Equal(t, testCase.expected, args, testCase.args)
+		}
+	}
+	_, _, err := extractContextOption([]string{"--context"})
+	assert.Error(t, err)
+}
+
+func TestContextCommands(t *testing.T) {
+	t.Parallel()
+	contextsFile := filepath.Join(t.TempDir(), "config", contextsFileName)
+	store, err := loadContexts(contextsFile)
+	require.NoError(t, err)
+	defaults := spawnOptions{distro: "rancher-desktop", nerdctl: "/usr/local/bin/nerdctl", containerdSocket: "/run/containerd.sock"}
+	run := func(args ...string) (string, error) {
+		output := &bytes.Buffer{}
+		err := handleContextCommand(store, args, defaults, output)
+		return output.String(), err
+	}
+
+	_, err = run("create", "remote", "--transport", "direct", "--namespace", "k8s.io", "--containerd-socket", "/run/k3s.sock")
+	require.NoError(t, err)
+	_, err = run("create", "--distro", "other", "other")
+	require.NoError(t, err)
+	for _, args := range [][]string{
+		{"create", "remote"},
+		{"create", "default"},
+		{"create", "-bad"},
+		{"create", "bad name"},
+		{"create", "x", "--transport", "carrier-pigeon"},
+		{"create"},
+		{"use", "missing"},
+		{"rm", "missing"},
+		{"frobnicate"},
+	} {
+		_, err = run(args...)
+		assert.Error(t, err, args)
+	}
+
+	_, err = run("use", "remote")
+	require.NoError(t, err)
+	store, err = loadContexts(contextsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "remote", store.Current)
+	assert.Equal(t, map[string]stubContext{
+		"remote": {Transport: transportDirect, Namespace: "k8s.io", ContainerdSocket: "/run/k3s.sock"},
+		"other":  {Distro: "other"},
+	}, store.Contexts)
+
+	output, err := run("ls")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, []string{"NAME", "TRANSPORT", "DISTRO", "SOCKET", "NAMESPACE", "NERDCTL"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"default", "wsl", "rancher-desktop", "/run/containerd.sock", "/usr/local/bin/nerdctl"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"other", "wsl", "other", "/run/containerd.sock", "/usr/local/bin/nerdctl"}, strings.Fields(lines[2]))
+	assert.Equal(t, []string{"remote", "*", "direct", "/run/k3s.sock", "k8s.io", "/usr/local/bin/nerdctl"}, strings.Fields(lines[3]))
+
+	// The current context can only be removed with --force.
+	_, err = run("rm", "remote")
+	assert.Error(t, err)
+	_, err = run("rm", "--force", "remote")
+	require.NoError(t, err)
+	store, err = loadContexts(contextsFile)
+	require.NoError(t, err)
+	assert.Empty(t, store.Current)
+	assert.NotContains(t, store.Contexts, "remote")
+}
+
+func TestContextLookup(t *testing.T) {
+	t.Parallel()
+	contextsFile := filepath.Join(t.TempDir(), contextsFileName)
+	contents := "current: remote\ncontexts:\n  remote:\n    transport: ssh\n    namespace: k8s.io\n  local:\n    nerdctl: /opt/nerdctl\n"
+	require.NoError(t, os.WriteFile(contextsFile, []byte(contents), 0o644))
+	store, err := loadContexts(contextsFile)
+	require.NoError(t, err)
+
+	name, context, err := store.lookup("")
+	require.NoError(t, err)
+	assert.Equal(t, "remote", name)
+	opts := spawnOptions{transport: transportWSL, distro: "rancher-desktop", nerdctl: "/usr/local/bin/nerdctl"}
+	context.apply(&opts)
+	assert.Equal(t, spawnOptions{transport: transportSSH, distro: "rancher-desktop", nerdctl: "/usr/local/bin/nerdctl", namespace: "k8s.io"}, opts)
+
+	name, context, err = store.lookup("local")
+	require.NoError(t, err)
+	assert.Equal(t, "local", name)
+	assert.Equal(t, "/opt/nerdctl", context.Nerdctl)
+
+	name, context, err = store.lookup(defaultContextName)
+	require.NoError(t, err)
+	assert.Equal(t, defaultContextName, name)
+	assert.Nil(t, context)
+
+	_, _, err = store.lookup("missing")
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(contextsFile, []byte("contexts:\n  x:\n    distribution: y\n"), 0o644))
+	_, err = loadContexts(contextsFile)
+	assert.Error(t, err)
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+const (
+	// dockerHubServer is the key used for Docker Hub in docker config files.
+	dockerHubServer = "https://index.docker.io/v1/"
+	// dockerConfigFileName is the name of the docker config file in the
+	// docker config directory.
+	dockerConfigFileName = "config.json"
+	// credentialHelperPrefix is the prefix of credential helper executables.
+	credentialHelperPrefix = "docker-credential-"
+	// credentialsNotFound is the message credential helpers return when they
+	// have no credentials for a server.
+	credentialsNotFound = "credentials not found in native keychain"
+	// identityTokenUsername is the user name credential helpers use for
+	// identity tokens.
+	identityTokenUsername = "<token>"
+)
+
+// credentialImageCommands are the commands that pull or push the images in
+// their positional arguments.
+var credentialImageCommands = map[string]bool{
+	"container run":    true,
+	"container create": true,
+	"image pull":       true,
+	"image push":       true,
+}
+
+// credentialsConfig configures how registry credentials are supplied to
+// nerdctl.
+type credentialsConfig struct {
+	// Disabled turns off credential bridging, so that nerdctl uses the
+	// credentials stored where it runs.
+	Disabled bool `yaml:"disabled"`
+	// DockerConfig is the caller's docker config directory; defaults to
+	// $DOCKER_CONFIG, or `~/.docker`.
+	DockerConfig string `yaml:"dockerConfig"`
+}
+
+// credential is a set of credentials, as used by the credential helper
+// protocol.
+type credential struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// dockerAuthEntry is an entry in the `auths` section of a docker config file.
+type dockerAuthEntry struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// newDockerAuthEntry converts credentials to a docker config entry.
+func newDockerAuthEntry(cred *credential) dockerAuthEntry {
+	if cred.Username == identityTokenUsername {
+		return dockerAuthEntry{IdentityToken: cred.Secret}
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(cred.Username + ":" + cred.Secret))
+	return dockerAuthEntry{Auth: auth}
+}
+
+// credential converts a docker config entry to credentials.
+func (e dockerAuthEntry) credential(server string) (*credential, error) {
+	cred := &credential{ServerURL: server, Username: e.Username, Secret: e.Password}
+	switch {
+	case e.IdentityToken != "":
+		cred.Username, cred.Secret = identityTokenUsername, e.IdentityToken
+	case e.Auth != "":
+		decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth for %s: %w", server, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid auth for %s", server)
+		}
+		cred.Username, cred.Secret = parts[0], parts[1]
+	}
+	if cred.Username == "" && cred.Secret == "" {
+		return nil, nil
+	}
+	return cred, nil
+}
+
+// dockerConfigFile is a docker config file; only the fields related to
+// credentials are parsed, and the rest are preserved.
+type dockerConfigFile struct {
+	path        string
+	raw         map[string]json.RawMessage
+	Auths       map[string]dockerAuthEntry
+	CredsStore  string
+	CredHelpers map[string]string
+}
+
+// loadDockerConfig reads the docker config file in the given directory.  A
+// missing file is not an error.  As the directory is chosen by the caller, it
+// is read as the real user.
+func loadDockerConfig(dir string) (*dockerConfigFile, error) {
+	config := &dockerConfigFile{path: filepath.Join(dir, dockerConfigFileName), raw: make(map[string]json.RawMessage)}
+	data, err := readUserFile(config.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &config.raw); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", config.path, err)
+	}
+	for key, target := range map[string]interface{}{
+		"auths":       &config.Auths,
+		"credsStore":  &config.CredsStore,
+		"credHelpers": &config.CredHelpers,
+	} {
+		if value, ok := config.raw[key]; ok {
+			if err = json.Unmarshal(value, target); err != nil {
+				return nil, fmt.Errorf("could not parse %s in %s: %w", key, config.path, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// save writes the credentials back to the docker config file, preserving any
+// other fields.  This is done as the real user, who then owns the file.
+func (c *dockerConfigFile) save() error {
+	auths, err := json.Marshal(c.Auths)
+	if err != nil {
+		return err
+	}
+	c.raw["auths"] = auths
+	data, err := json.MarshalIndent(c.raw, "", "\t")
+	if err != nil {
+		return err
+	}
+	return asUser(func() error {
+		if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+			return err
+		}
+		file, err := os.CreateTemp(filepath.Dir(c.path), ".config-*.json")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file.Name())
+		if _, err = file.Write(data); err != nil {
+			file.Close()
+			return err
+		}
+		if err = file.Close(); err != nil {
+			return err
+		}
+		return os.Rename(file.Name(), c.path)
+	})
+}
+
+// registryHost returns the host name for a server in a docker config file;
+// Docker Hub is `docker.io`, as in image references.
+func registryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	if index := strings.Index(host, "/"); index >= 0 {
+		host = host[:index]
+	}
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return host
+}
+
+// registryServer returns the key for the given host in docker config files.
+func registryServer(host string) string {
+	if host == "docker.io" {
+		return dockerHubServer
+	}
+	return host
+}
+
+// credentialHelper runs a docker credential helper.
+type credentialHelper struct {
+	name    string
+	command func(name string, args ...string) *exec.Cmd
+}
+
+// run the helper with the given action and input, and return its output.
+func (h credentialHelper) run(action string, input string) ([]byte, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := h.command(credentialHelperPrefix+h.name, action)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stdout.String())
+		if message == "" {
+			message = strings.TrimSpace(stderr.String())
+		}
+		if message == "" {
+			message = err.Error()
+		}
+		return nil, errors.New(message)
+	}
+	return stdout.Bytes(), nil
+}
+
+// get returns the credentials for the given server, or nil if there are none.
+func (h credentialHelper) get(server string) (*credential, error) {
+	output, err := h.run("get", server)
+	if err != nil {
+		if err.Error() == credentialsNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("credential helper %s: %w", h.name, err)
+	}
+	var cred credential
+	if err = json.Unmarshal(output, &cred); err != nil {
+		return nil, fmt.Errorf("credential helper %s: %w", h.name, err)
+	}
+	cred.ServerURL = server
+	return &cred, nil
+}
+
+// store saves the given credentials.
+func (h credentialHelper) store(cred *credential) error {
+	input, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	if _, err = h.run("store", string(input)); err != nil {
+		return fmt.Errorf("credential helper %s: %w", h.name, err)
+	}
+	return nil
+}
+
+// erase removes the credentials for the given server.
+func (h credentialHelper) erase(server string) error {
+	if _, err := h.run("erase", server); err != nil && err.Error() != credentialsNotFound {
+		return fmt.Errorf("credential helper %s: %w", h.name, err)
+	}
+	return nil
+}
+
+// list returns the servers the helper has credentials for.
+func (h credentialHelper) list() ([]string, error) {
+	output, err := h.run("list", "")
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s: %w", h.name, err)
+	}
+	var servers map[string]string
+	if err = json.Unmarshal(output, &servers); err != nil {
+		return nil, fmt.Errorf("credential helper %s: %w", h.name, err)
+	}
+	var result []string
+	for server := range servers {
+		result = append(result, server)
+	}
+	return result, nil
+}
+
+// credentialBridge supplies the caller's registry credentials to nerdctl, and
+// saves credentials from `nerdctl login` on the caller's side, so that they are
+// not shared with everyone else using the VM.
+type credentialBridge struct {
+	config *dockerConfigFile
+	// command creates the command to run a credential helper.
+	command func(name string, args ...string) *exec.Cmd
+}
+
+// newCredentialBridge reads the caller's docker config.
+func newCredentialBridge(config credentialsConfig) (*credentialBridge, error) {
+	dir := config.DockerConfig
+	if dir == "" {
+		dir = os.Getenv("DOCKER_CONFIG")
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	dockerConfig, err := loadDockerConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &credentialBridge{config: dockerConfig, command: userCommand}, nil
+}
+
+// helperFor returns the credential helper to use for the given host, along
+// with the server name to give it.
+func (b *credentialBridge) helperFor(host string) (credentialHelper, string, bool) {
+	for server, name := range b.config.CredHelpers {
+		if registryHost(server) == host {
+			return credentialHelper{name: name, command: b.command}, server, true
+		}
+	}
+	if b.config.CredsStore != "" {
+		return credentialHelper{name: b.config.CredsStore, command: b.command}, registryServer(host), true
+	}
+	return credentialHelper{}, "", false
+}
+
+// authEntry returns the `auths` entry for the given host, if any.
+func (b *credentialBridge) authEntry(host string) (string, dockerAuthEntry, bool) {
+	for server, entry := range b.config.Auths {
+		if registryHost(server) == host {
+			return server, entry, true
+		}
+	}
+	return "", dockerAuthEntry{}, false
+}
+
+// resolve returns the caller's credentials for the given host, or nil.
+func (b *credentialBridge) resolve(host string) (*credential, error) {
+	if helper, server, ok := b.helperFor(host); ok {
+		cred, err := helper.get(server)
+		if err != nil || cred != nil {
+			return cred, err
+		}
+	}
+	if server, entry, ok := b.authEntry(host); ok {
+		return entry.credential(server)
+	}
+	return nil, nil
+}
+
+// hosts returns all hosts the caller may have credentials for.
+func (b *credentialBridge) hosts() []string {
+	seen := make(map[string]bool)
+	for server := range b.config.Auths {
+		seen[registryHost(server)] = true
+	}
+	for server := range b.config.CredHelpers {
+		seen[registryHost(server)] = true
+	}
+	if b.config.CredsStore != "" {
+		helper := credentialHelper{name: b.config.CredsStore, command: b.command}
+		servers, err := helper.list()
+		if err != nil {
+			log.Printf("Error listing credentials: %s", err)
+		}
+		for _, server := range servers {
+			seen[registryHost(server)] = true
+		}
+	}
+	var result []string
+	for host := range seen {
+		result = append(result, host)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// store saves the given credentials on the caller's side.
+func (b *credentialBridge) store(cred *credential) error {
+	host := registryHost(cred.ServerURL)
+	if helper, server, ok := b.helperFor(host); ok {
+		return helper.store(&credential{ServerURL: server, Username: cred.Username, Secret: cred.Secret})
+	}
+	if b.config.Auths == nil {
+		b.config.Auths = make(map[string]dockerAuthEntry)
+	}
+	if server, _, ok := b.authEntry(host); ok {
+		delete(b.config.Auths, server)
+	}
+	b.config.Auths[registryServer(host)] = newDockerAuthEntry(cred)
+	return b.config.save()
+}
+
+// erase removes the caller's credentials for the given host.
+func (b *credentialBridge) erase(host string) error {
+	if helper, server, ok := b.helperFor(host); ok {
+		if err := helper.erase(server); err != nil {
+			return err
+		}
+	}
+	changed := false
+	for server := range b.config.Auths {
+		if registryHost(server) == host {
+			delete(b.config.Auths, server)
+			changed = true
+		}
+	}
+	if changed {
+		return b.config.save()
+	}
+	return nil
+}
+
+// credentialHosts returns the registry hosts the command needs credentials
+// for; if all is set, the command may need credentials for any registry.
+func credentialHosts(result *cliparse.Result) (hosts []string, all bool) {
+	switch {
+	case credentialImageCommands[result.CommandPath]:
+		count := imagePositionals[result.CommandPath]
+		for i, positional := range result.Positionals {
+			if (count >= 0 && i >= count) || strings.HasPrefix(positional, "-") {
+				break
+			}
+			hosts = append(hosts, parseImageReference(positional).domain)
+		}
+	case result.CommandPath == "image build", strings.HasPrefix(result.CommandPath, "compose"):
+		return nil, true
+	}
+	return hosts, false
+}
+
+// credentialsApply checks if the credential bridge would act on the given
+// parse result.
+func credentialsApply(registry *cliparse.Registry, result *cliparse.Result) bool {
+	if result.CommandPath == "login" || result.CommandPath == "logout" {
+		return true
+	}
+	hosts, all := credentialHosts(result)
+	return all || len(hosts) > 0
+}
+
+// prepare stages a docker config directory containing the credentials the
+// command needs, and returns the environment for nerdctl to use it.  For
+// `login`, the credentials nerdctl saves are stored on the caller's side
+// afterwards, if saveLogins is set; for `logout`, the caller's credentials are
+// removed.  Cleanups are added to the parse result.
+func (b *credentialBridge) prepare(handlers *argHandlers, result *cliparse.Result, saveLogins bool) ([]string, error) {
+	var hosts []string
+	login := false
+	switch result.CommandPath {
+	case "login", "logout":
+		host := "docker.io"
+		if len(result.Positionals) > 0 {
+			host = registryHost(result.Positionals[0])
+		}
+		if result.CommandPath == "logout" {
+			return nil, b.erase(host)
+		}
+		if !saveLogins {
+			return nil, nil
+		}
+		hosts, login = []string{host}, true
+	default:
+		var all bool
+		if hosts, all = credentialHosts(result); all {
+			hosts = b.hosts()
+		}
+		if len(hosts) == 0 {
+			return nil, nil
+		}
+	}
+
+	auths := make(map[string]dockerAuthEntry)
+	for _, host := range hosts {
+		cred, err := b.resolve(host)
+		if err != nil {
+			log.Printf("Error getting credentials for %s: %s", host, err)
+			continue
+		}
+		if cred != nil {
+			auths[registryServer(host)] = newDockerAuthEntry(cred)
+		}
+	}
+	if len(auths) == 0 && !login {
+		return nil, nil
+	}
+
+	// The staging directory is in the caller's temporary directory, so it is
+	// created as the real user.
+	var dir string
+	err := asUser(func() error {
+		var err error
+		dir, err = os.MkdirTemp("", "nerdctl-credentials.*")
+		if err == nil {
+			err = writeDockerConfig(dir, auths)
+		}
+		return err
+	})
+	if err != nil {
+		if dir != "" {
+			_ = asUser(func() error { return os.RemoveAll(dir) })
+		}
+		return nil, err
+	}
+	converted, cleanups, err := handlers.filePathArgHandler(dir)
+	if login {
+		configPath := filepath.Join(dir, dockerConfigFileName)
+		cleanups = append(cleanups, func() error {
+			return b.saveLogins(configPath, auths)
+		})
+	}
+	result.Cleanup = append(append(result.Cleanup, cleanups...), func() error {
+		return asUser(func() error { return os.RemoveAll(dir) })
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []string{"DOCKER_CONFIG=" + converted}, nil
+}
+
+// writeDockerConfig writes a docker config file with the given credentials in
+// the given directory.
+func writeDockerConfig(dir string, auths map[string]dockerAuthEntry) error {
+	data, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, dockerConfigFileName), data, 0o600)
+}
+
+// saveLogins stores any credentials nerdctl saved in the staged docker config
+// (other than the ones staged) on the caller's side.
+func (b *credentialBridge) saveLogins(configPath string, staged map[string]dockerAuthEntry) error {
+	saved, err := loadDockerConfig(filepath.Dir(configPath))
+	if err != nil {
+		return err
+	}
+	for server, entry := range saved.Auths {
+		if entry == staged[server] {
+			continue
+		}
+		cred, err := entry.credential(server)
+		if err != nil {
+			return err
+		}
+		if cred != nil {
+			if err = b.store(cred); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// fakeCredentialHelperEnv is set (to the path of a JSON file holding the
+// credentials) when the test executable should act as a credential helper.
+const fakeCredentialHelperEnv = "RD_NERDCTL_STUB_FAKE_CREDENTIAL_HELPER"
+
+// fakeCredentialHelper implements the credential helper protocol, storing
+// credentials in the given file.
+func fakeCredentialHelper(storePath string, args []string) int {
+	var store map[string]credential
+	if data, err := os.ReadFile(storePath); err == nil {
+		_ = json.Unmarshal(data, &store)
+	}
+	if store == nil {
+		store = make(map[string]credential)
+	}
+	input, _ := io.ReadAll(os.Stdin)
+	server := strings.TrimSpace(string(input))
+	switch args[0] {
+	case "get":
+		cred, ok := store[server]
+		if !ok {
+			fmt.Println(credentialsNotFound)
+			return 1
+		}
+		_ = json.NewEncoder(os.Stdout).Encode(cred)
+		return 0
+	case "list":
+		servers := make(map[string]string)
+		for server, cred := range store {
+			servers[server] = cred.Username
+		}
+		_ = json.NewEncoder(os.Stdout).Encode(servers)
+		return 0
+	case "store":
+		var cred credential
+		if err := json.Unmarshal(input, &cred); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		store[cred.ServerURL] = cred
+	case "erase":
+		delete(store, server)
+	default:
+		fmt.Printf("unknown action %q\n", args[0])
+		return 1
+	}
+	data, _ := json.Marshal(store)
+	if err := os.WriteFile(storePath, data, 0o600); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// newTestCredentialBridge creates a credential bridge with the given docker
+// config, where every helper is the fake one backed by the given credentials.
+func newTestCredentialBridge(t *testing.T, dockerConfig string, helperCreds map[string]credential) (*credentialBridge, string) {
+	dir := t.TempDir()
+	configDir := filepath.Join(dir, "docker")
+	require.NoError(t, os.MkdirAll(configDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, dockerConfigFileName), []byte(dockerConfig), 0o600))
+	storePath := filepath.Join(dir, "helper.json")
+	data, err := json.Marshal(helperCreds)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(storePath, data, 0o600))
+	executable, err := os.Executable()
+	require.NoError(t, err)
+
+	bridge, err := newCredentialBridge(credentialsConfig{DockerConfig: configDir})
+	require.NoError(t, err)
+	bridge.command = func(name string, args ...string) *exec.Cmd {
+		require.True(t, strings.HasPrefix(name, credentialHelperPrefix), name)
+		cmd := exec.Command(executable, args...)
+		cmd.Env = append(os.Environ(), fakeCredentialHelperEnv+"="+storePath)
+		return cmd
+	}
+	return bridge, storePath
+}
+
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+func TestCredentialBridgeResolve(t *testing.T) {
+	t.Parallel()
+	dockerConfig := fmt.Sprintf(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": %q},
+			"quay.io": {"identitytoken": "quay-token"},
+			"broken.example.com": {"auth": "!!!"}
+		},
+		"credsStore": "store",
+		"credHelpers": {"ghcr.io": "gh"},
+		"experimental": "enabled"
+	}`, basicAuth("hubuser", "hubpass"))
+	bridge, _ := newTestCredentialBridge(t, dockerConfig, map[string]credential{
+		"ghcr.io":              {Username: "ghuser", Secret: "ghpass"},
+		"registry.example.com": {Username: "reguser", Secret: "regpass"},
+	})
+
+	testCases := map[string]*credential{
+		"docker.io":            {ServerURL: dockerHubServer, Username: "hubuser", Secret: "hubpass"},
+		"quay.io":              {ServerURL: "quay.io", Username: identityTokenUsername, Secret: "quay-token"},
+		"ghcr.io":              {ServerURL: "ghcr.io", Username: "ghuser", Secret: "ghpass"},
+		"registry.example.com": {ServerURL: "registry.example.com", Username: "reguser", Secret: "regpass"},
+		"missing.example.com":  nil,
+	}
+	for host, expected := range testCases {
+		cred, err := bridge.resolve(host)
+		if assert.NoError(t, err, host) {
+			assert.Equal(t, expected, cred, host)
+		}
+	}
+	_, err := bridge.resolve("broken.example.com")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"broken.example.com", "docker.io", "ghcr.io", "quay.io", "registry.example.com"}, bridge.hosts())
+}
+
+func TestCredentialBridgePrepare(t *testing.T) {
+	t.Parallel()
+	dockerConfig := fmt.Sprintf(`{"auths": {"https://index.docker.io/v1/": {"auth": %q}, "ghcr.io": {"auth": %q}}}`,
+		basicAuth("hubuser", "hubpass"), basicAuth("ghuser", "ghpass"))
+	handlers := &argHandlers{directPathTranslator{}}
+	registry, err := newRegistry(handlers)
+	require.NoError(t, err)
+
+	readStaged := func(t *testing.T, env []string) map[string]dockerAuthEntry {
+		require.Len(t, env, 1)
+		require.True(t, strings.HasPrefix(env[0], "DOCKER_CONFIG="))
+		staged, err := loadDockerConfig(strings.TrimPrefix(env[0], "DOCKER_CONFIG="))
+		require.NoError(t, err)
+		return staged.Auths
+	}
+
+	t.Run("images", func(t *testing.T) {
+		t.Parallel()
+		bridge, _ := newTestCredentialBridge(t, dockerConfig, nil)
+		result, err := registry.Parse([]string{"pull", "ghcr.io/org/app"})
+		require.NoError(t, err)
+		env, err := bridge.prepare(handlers, result, true)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]dockerAuthEntry{"ghcr.io": {Auth: basicAuth("ghuser", "ghpass")}}, readStaged(t, env))
+		cliparse.RunCleanups(result.Cleanup)
+		assert.NoDirExists(t, strings.TrimPrefix(env[0], "DOCKER_CONFIG="))
+
+		// Nothing is staged if there are no credentials to supply.
+		for _, args := range [][]string{{"pull", "quay.io/x"}, {"ps"}} {
+			result, err = registry.Parse(args)
+			require.NoError(t, err)
+			env, err = bridge.prepare(handlers, result, true)
+			assert.NoError(t, err)
+			assert.Empty(t, env, args)
+		}
+	})
+
+	t.Run("build", func(t *testing.T) {
+		t.Parallel()
+		bridge, _ := newTestCredentialBridge(t, dockerConfig, nil)
+		result, err := registry.Parse([]string{"build", "."})
+		require.NoError(t, err)
+		env, err := bridge.prepare(handlers, result, true)
+		require.NoError(t, err)
+		assert.Len(t, readStaged(t, env), 2)
+		cliparse.RunCleanups(result.Cleanup)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		t.Parallel()
+		bridge, storePath := newTestCredentialBridge(t, `{"credHelpers": {"registry.example.com": "fake"}}`, nil)
+		result, err := registry.Parse([]string{"login", "-u", "me", "registry.example.com"})
+		require.NoError(t, err)
+		env, err := bridge.prepare(handlers, result, true)
+		require.NoError(t, err)
+		assert.Empty(t, readStaged(t, env))
+
+		// Act as nerdctl saving the login.
+		stagedPath := filepath.Join(strings.TrimPrefix(env[0], "DOCKER_CONFIG="), dockerConfigFileName)
+		data := fmt.Sprintf(`{"auths": {"registry.example.com": {"auth": %q}}}`, basicAuth("me", "secret"))
+		require.NoError(t, os.WriteFile(stagedPath, []byte(data), 0o600))
+		cliparse.RunCleanups(result.Cleanup)
+		assert.NoFileExists(t, stagedPath)
+
+		stored, err := os.ReadFile(storePath)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"registry.example.com": {"ServerURL": "registry.example.com", "Username": "me", "Secret": "secret"}}`, string(stored))
+
+		// Without saving logins, nothing is staged.
+		result, err = registry.Parse([]string{"login", "registry.example.com"})
+		require.NoError(t, err)
+		env, err = bridge.prepare(handlers, result, false)
+		assert.NoError(t, err)
+		assert.Empty(t, env)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		t.Parallel()
+		bridge, _ := newTestCredentialBridge(t, dockerConfig, nil)
+		result, err := registry.Parse([]string{"logout"})
+		require.NoError(t, err)
+		env, err := bridge.prepare(handlers, result, true)
+		require.NoError(t, err)
+		assert.Empty(t, env)
+
+		saved, err := loadDockerConfig(filepath.Dir(bridge.config.path))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]dockerAuthEntry{"ghcr.io": {Auth: basicAuth("ghuser", "ghpass")}}, saved.Auths)
+	})
+}
+
+func TestDockerConfigSave(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	contents := `{"auths": {}, "proxies": {"default": {"httpProxy": "http://proxy:3128"}}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, dockerConfigFileName), []byte(contents), 0o600))
+	bridge, err := newCredentialBridge(credentialsConfig{DockerConfig: dir})
+	require.NoError(t, err)
+	require.NoError(t, bridge.store(&credential{ServerURL: "https://index.docker.io/v1/", Username: "me", Secret: "pass"}))
+
+	data, err := os.ReadFile(filepath.Join(dir, dockerConfigFileName))
+	require.NoError(t, err)
+	expected := fmt.Sprintf(`{"auths": {%q: {"auth": %q}}, "proxies": {"default": {"httpProxy": "http://proxy:3128"}}}`,
+		dockerHubServer, basicAuth("me", "pass"))
+	assert.JSONEq(t, expected, string(data))
+}
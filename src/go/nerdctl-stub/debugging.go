@@ -1,40 +1,40 @@
-//+build debug
+//go:build debug
+// +build debug
 
 package main
 
 import (
 	"fmt"
+	"log"
+	"sort"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
 )
 
 // describeCommands is a debugging function that prints out all commands.
 // This is normally never called, but we keep this implemented as it is useful
 // for debugging.
-func describeCommands() {
+func describeCommands(registry *cliparse.Registry, handlers *argHandlers) {
 	handlerNames := make(map[string]string)
 	handlerNames[fmt.Sprintf("%v", nil)] = "~"
 	// The next few lines should ignore govet's "printf" lint because we are
 	// intentionally printing a function instead of calling it.
-	handlerNames[fmt.Sprintf("%v", ignoredArgHandler)] = "ignored"        //nolint:govet,printf
-	handlerNames[fmt.Sprintf("%v", volumeArgHandler)] = "volume"          //nolint:govet,printf
-	handlerNames[fmt.Sprintf("%v", filePathArgHandler)] = "file path"     //nolint:govet,printf
-	handlerNames[fmt.Sprintf("%v", outputPathArgHandler)] = "output path" //nolint:govet,printf
+	handlerNames[fmt.Sprintf("%v", cliparse.IgnoredArgHandler)] = "ignored"        //nolint:govet,printf
+	handlerNames[fmt.Sprintf("%v", handlers.volumeArgHandler)] = "volume"          //nolint:govet,printf
+	handlerNames[fmt.Sprintf("%v", handlers.filePathArgHandler)] = "file path"     //nolint:govet,printf
+	handlerNames[fmt.Sprintf("%v", handlers.outputPathArgHandler)] = "output path" //nolint:govet,printf
 
 	log.Println("========== COMMAND STRUCTURE ==========")
-	var paths []string
-	for path := range commands {
-		paths = append(paths, path)
-	}
-	sort.Strings(paths)
-	for _, path := range paths {
-		command := commands[path]
-		log.Printf("%-20s %v", path, command.handler) //nolint:govet,printf
+	for _, path := range registry.Paths() {
+		command, _ := registry.Command(path)
+		log.Printf("%-20s %v", path, command.Handler) //nolint:govet,printf
 		var optionNames []string
-		for optionName := range command.options {
+		for optionName := range command.Options {
 			optionNames = append(optionNames, optionName)
 		}
 		sort.Strings(optionNames)
 		for _, optionName := range optionNames {
-			handler := command.options[optionName]
+			handler := command.Options[optionName]
 			handlerName, ok := handlerNames[fmt.Sprintf("%v", handler)]
 			if !ok {
 				handlerName = "<invalid handler>"
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// dockerIgnoreFileName is the name of the file listing the paths to exclude
+// from a build context.
+const dockerIgnoreFileName = ".dockerignore"
+
+// ignorePattern is a single line in a .dockerignore file.
+type ignorePattern struct {
+	// regexp matches the paths the pattern applies to.
+	regexp *regexp.Regexp
+	// exclusion is set if the pattern started with `!`, re-including paths.
+	exclusion bool
+}
+
+// dockerIgnore matches paths (relative to the build context, using forward
+// slashes) in the same way as docker does.
+type dockerIgnore struct {
+	patterns []ignorePattern
+	// hasExclusions is set if any pattern re-includes paths, in which case
+	// ignored directories must still be searched.
+	hasExclusions bool
+}
+
+// readDockerIgnore reads the .dockerignore file in the given build context.
+// A missing file is not an error, and results in nothing being ignored.
+func readDockerIgnore(contextDir string) (*dockerIgnore, error) {
+	file, err := os.Open(filepath.Join(contextDir, dockerIgnoreFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &dockerIgnore{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	return parseDockerIgnore(file)
+}
+
+// parseDockerIgnore parses the contents of a .dockerignore file.
+func parseDockerIgnore(reader io.Reader) (*dockerIgnore, error) {
+	result := &dockerIgnore{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclusion := false
+		if strings.HasPrefix(line, "!") {
+			exclusion = true
+			line = strings.TrimSpace(line[1:])
+			if line == "" {
+				return nil, fmt.Errorf("illegal exclusion pattern: %q", "!")
+			}
+		}
+		pattern := path.Clean(filepath.ToSlash(line))
+		if len(pattern) > 1 && pattern[0] == '/' {
+			pattern = pattern[1:]
+		}
+		expr, err := ignorePatternRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", line, err)
+		}
+		result.patterns = append(result.patterns, ignorePattern{regexp: expr, exclusion: exclusion})
+		result.hasExclusions = result.hasExclusions || exclusion
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ignorePatternRegexp converts a pattern into a regular expression: `*` and
+// `?` do not match `/`, while `**` matches any number of directories.
+func ignorePatternRegexp(pattern string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+	builder.WriteString("^")
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case ch == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			i++
+			// Treat `**/` as `**`.
+			if i+1 < len(pattern) && pattern[i+1] == '/' {
+				i++
+			}
+			if i+1 >= len(pattern) {
+				builder.WriteString(".*")
+			} else {
+				builder.WriteString("(.*/)?")
+			}
+		case ch == '*':
+			builder.WriteString("[^/]*")
+		case ch == '?':
+			builder.WriteString("[^/]")
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			builder.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case ch == '[' || ch == ']':
+			// Character classes are passed through.
+			inClass = ch == '['
+			builder.WriteByte(ch)
+		case inClass && (ch == '-' || ch == '^'):
+			builder.WriteByte(ch)
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+// matches returns whether the given path should be excluded from the build
+// context.  A path is excluded if it, or any of its parent directories,
+// matches a pattern; the last matching pattern wins.
+func (d *dockerIgnore) matches(relPath string) bool {
+	parts := strings.Split(relPath, "/")
+	matched := false
+	for _, pattern := range d.patterns {
+		match := pattern.regexp.MatchString(relPath)
+		// Check whether the pattern matches a parent directory.
+		for i := 1; !match && i < len(parts); i++ {
+			match = pattern.regexp.MatchString(strings.Join(parts[:i], "/"))
+		}
+		if match {
+			matched = !pattern.exclusion
+		}
+	}
+	return matched
+}
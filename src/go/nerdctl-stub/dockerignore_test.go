@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerIgnore(t *testing.T) {
+	t.Parallel()
+	contents := `
+# comment
+node_modules
+*.log
+/build
+**/tmp
+docs/**/*.md
+!docs/keep/README.md
+secret?.txt
+v[0-9].bin
+a^b
+`
+	ignore, err := parseDockerIgnore(strings.NewReader(contents))
+	require.NoError(t, err)
+	assert.True(t, ignore.hasExclusions)
+
+	testCases := map[string]bool{
+		"node_modules":              true,
+		"node_modules/x/index.js":   true,
+		"app.log":                   true,
+		"src/app.log":               false,
+		"build/out.bin":             true,
+		"src/build/out.bin":         false,
+		"tmp":                       true,
+		"a/b/tmp/file":              true,
+		"docs/guide.md":             true,
+		"docs/a/b/guide.md":         true,
+		"docs/keep/README.md":       false,
+		"docs/guide.txt":            false,
+		"secret1.txt":               true,
+		"secret12.txt":              false,
+		"v1.bin":                    true,
+		"vx.bin":                    false,
+		"a^b":                       true,
+		"Dockerfile":                false,
+		"# comment":                 false,
+		"src/node_modules/index.js": false,
+	}
+	for relPath, expected := range testCases {
+		assert.Equal(t, expected, ignore.matches(relPath), relPath)
+	}
+
+	_, err = parseDockerIgnore(strings.NewReader("!\n"))
+	assert.Error(t, err)
+}
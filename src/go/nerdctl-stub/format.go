@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// formatKind describes the field model used to render `--format` templates.
+type formatKind string
+
+const (
+	formatKindContainers formatKind = "containers"
+	formatKindImages     formatKind = "images"
+	formatKindVolumes    formatKind = "volumes"
+	formatKindNetworks   formatKind = "networks"
+	formatKindInspect    formatKind = "inspect"
+)
+
+// jsonTemplate is the format passed to nerdctl when rendering templates
+// locally.
+const jsonTemplate = "{{json .}}"
+
+// formatCommands lists the commands where `--format` templates are rendered by
+// the stub rather than by nerdctl.
+var formatCommands = map[string]formatKind{
+	"ps":                formatKindContainers,
+	"container ls":      formatKindContainers,
+	"images":            formatKindImages,
+	"image ls":          formatKindImages,
+	"volume ls":         formatKindVolumes,
+	"network ls":        formatKindNetworks,
+	"inspect":           formatKindInspect,
+	"container inspect": formatKindInspect,
+	"image inspect":     formatKindInspect,
+	"network inspect":   formatKindInspect,
+	"volume inspect":    formatKindInspect,
+}
+
+// formatHeaders are the table headers for the fields docker provides, for each
+// kind of list; they also determine which fields are always available.
+var formatHeaders = map[formatKind]map[string]string{
+	formatKindContainers: {
+		"ID":           "CONTAINER ID",
+		"Image":        "IMAGE",
+		"Command":      "COMMAND",
+		"CreatedAt":    "CREATED AT",
+		"RunningFor":   "CREATED",
+		"Ports":        "PORTS",
+		"State":        "STATE",
+		"Status":       "STATUS",
+		"Size":         "SIZE",
+		"Names":        "NAMES",
+		"Labels":       "LABELS",
+		"Mounts":       "MOUNTS",
+		"Networks":     "NETWORKS",
+		"LocalVolumes": "LOCAL VOLUMES",
+	},
+	formatKindImages: {
+		"ID":           "IMAGE ID",
+		"Repository":   "REPOSITORY",
+		"Tag":          "TAG",
+		"Digest":       "DIGEST",
+		"CreatedSince": "CREATED",
+		"CreatedAt":    "CREATED AT",
+		"Size":         "SIZE",
+		"Containers":   "CONTAINERS",
+		"SharedSize":   "SHARED SIZE",
+		"UniqueSize":   "UNIQUE SIZE",
+		"VirtualSize":  "SIZE",
+	},
+	formatKindVolumes: {
+		"Name":       "VOLUME NAME",
+		"Driver":     "DRIVER",
+		"Scope":      "SCOPE",
+		"Mountpoint": "MOUNTPOINT",
+		"Labels":     "LABELS",
+		"Links":      "LINKS",
+		"Size":       "SIZE",
+	},
+	formatKindNetworks: {
+		"ID":        "NETWORK ID",
+		"Name":      "NAME",
+		"Driver":    "DRIVER",
+		"Scope":     "SCOPE",
+		"IPv6":      "IPV6",
+		"Internal":  "INTERNAL",
+		"Labels":    "LABELS",
+		"CreatedAt": "CREATED AT",
+	},
+}
+
+// formatDefaults are values for docker fields nerdctl does not provide.
+var formatDefaults = map[formatKind]map[string]interface{}{
+	formatKindImages: {
+		"Containers": "N/A",
+		"SharedSize": "N/A",
+		"UniqueSize": "N/A",
+	},
+	formatKindVolumes: {
+		"Driver": "local",
+		"Scope":  "local",
+		"Links":  "N/A",
+		"Size":   "N/A",
+	},
+	formatKindNetworks: {
+		"Scope":    "local",
+		"IPv6":     "false",
+		"Internal": "false",
+	},
+}
+
+// formatContext is a single object being rendered; fields are looked up by
+// key, so templates written for docker work as long as the key exists.
+type formatContext map[string]interface{}
+
+// Label returns the value of the given label, as in docker.
+func (c formatContext) Label(name string) string {
+	switch labels := c["Labels"].(type) {
+	case map[string]interface{}:
+		if value, ok := labels[name].(string); ok {
+			return value
+		}
+	case string:
+		for _, label := range strings.Split(labels, ",") {
+			if parts := strings.SplitN(label, "=", 2); parts[0] == name && len(parts) > 1 {
+				return parts[1]
+			}
+		}
+	}
+	return ""
+}
+
+// formatHeader is the context used to render the header of a table.
+type formatHeader map[string]interface{}
+
+// Label returns the header for a label column.
+func (h formatHeader) Label(name string) string {
+	return "LABEL"
+}
+
+// templateFuncs are the functions available in templates; these match the
+// ones docker provides.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		buf := &bytes.Buffer{}
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	},
+	"split": strings.Split,
+	"join": func(v interface{}, sep string) string {
+		switch items := v.(type) {
+		case []string:
+			return strings.Join(items, sep)
+		case []interface{}:
+			var words []string
+			for _, item := range items {
+				words = append(words, fmt.Sprint(item))
+			}
+			return strings.Join(words, sep)
+		}
+		return fmt.Sprint(v)
+	},
+	"title": strings.Title,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"pad": func(s string, before, after int) string {
+		return strings.Repeat(" ", before) + s + strings.Repeat(" ", after)
+	},
+	"truncate": func(s string, length int) string {
+		if len(s) > length {
+			return s[:length]
+		}
+		return s
+	},
+	"println": fmt.Sprintln,
+}
+
+// templateFormatter renders the output of a nerdctl command (run with
+// `--format '{{json .}}'`) using a docker-compatible template.
+type templateFormatter struct {
+	kind     formatKind
+	table    bool
+	template *template.Template
+	// now is used to calculate relative times.
+	now func() time.Time
+}
+
+// registerFormatOptions adds the `--format` option to the inspect commands
+// that nerdctl does not support it on; the option is removed before nerdctl
+// is run.
+func registerFormatOptions(registry *cliparse.Registry) error {
+	for commandPath, kind := range formatCommands {
+		command, ok := registry.Command(commandPath)
+		if !ok {
+			return fmt.Errorf("unknown command %q", commandPath)
+		}
+		if _, ok := command.Options["--format"]; kind != formatKindInspect || ok {
+			continue
+		}
+		for _, option := range []string{"--format", "-f"} {
+			if err := registry.AddOption(commandPath, option, cliparse.IgnoredArgHandler); err != nil {
+				return err
+			}
+		}
+		if command.Synonyms == nil {
+			command.Synonyms = make(map[string][]string)
+		}
+		command.Synonyms["--format"] = []string{"--format", "-f"}
+		command.Synonyms["-f"] = []string{"--format", "-f"}
+	}
+	return nil
+}
+
+// newTemplateFormatter checks the parse result for a `--format` template that
+// the stub should render; if there is one, the arguments are modified so that
+// nerdctl emits JSON instead.  If there is nothing to render, nil is returned.
+func newTemplateFormatter(registry *cliparse.Registry, result *cliparse.Result) (*templateFormatter, error) {
+	kind, ok := formatCommands[result.CommandPath]
+	if !ok {
+		return nil, nil
+	}
+	format, ok := findOption(registry, result, result.CommandPath, "--format")
+	if !ok {
+		return nil, nil
+	}
+	if kind != formatKindInspect {
+		// Only templates need to be rendered; nerdctl understands the rest.
+		if !strings.Contains(format, "{{") {
+			return nil, nil
+		}
+		// With `--quiet`, only IDs are printed, as in docker.
+		if _, quiet := findOption(registry, result, result.CommandPath, "--quiet"); quiet {
+			return nil, nil
+		}
+	} else if format == "json" || format == "" {
+		format = jsonTemplate
+	}
+
+	formatter := &templateFormatter{kind: kind, now: time.Now}
+	if kind != formatKindInspect && strings.HasPrefix(format, "table") {
+		formatter.table = true
+		format = strings.TrimPrefix(format, "table")
+	}
+	format = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(strings.Trim(format, " "))
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("template parsing error: %w", err)
+	}
+	formatter.template = tmpl
+
+	// Replace the format given to nerdctl; as the option always takes a value,
+	// it appears in the arguments as a name followed by the value.
+	names := []string{"--format"}
+	if command, ok := registry.Command(result.CommandPath); ok {
+		names = command.OptionNames("--format")
+	}
+	end := len(result.Args) - len(result.Positionals)
+	var args []string
+	for index := 0; index < end; index++ {
+		arg := result.Args[index]
+		if contains(names, arg) && index+1 < end {
+			if kind != formatKindInspect {
+				args = append(args, arg, jsonTemplate)
+			}
+			index++
+			continue
+		}
+		args = append(args, arg)
+	}
+	result.Args = append(args, result.Args[end:]...)
+	return formatter, nil
+}
+
+// render the JSON emitted by nerdctl using the template.
+func (f *templateFormatter) render(input io.Reader, output io.Writer) error {
+	var contexts []formatContext
+	decoder := json.NewDecoder(input)
+	decoder.UseNumber()
+	for {
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("could not parse nerdctl output: %w", err)
+		}
+		// `inspect` emits an array of objects, and list commands emit one
+		// object per line.
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("could not parse nerdctl output: unexpected %T", value)
+			}
+			contexts = append(contexts, f.context(object))
+		}
+	}
+
+	writer := output
+	var tabs *tabwriter.Writer
+	if f.table {
+		tabs = tabwriter.NewWriter(output, 10, 1, 3, ' ', 0)
+		writer = tabs
+		header := formatHeader{}
+		for field, name := range formatHeaders[f.kind] {
+			header[field] = name
+		}
+		for _, context := range contexts {
+			for field := range context {
+				if _, ok := header[field]; !ok {
+					header[field] = strings.ToUpper(field)
+				}
+			}
+		}
+		if err := f.template.Execute(writer, header); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer)
+	}
+	for _, context := range contexts {
+		if err := f.template.Execute(writer, context); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer)
+	}
+	if tabs != nil {
+		return tabs.Flush()
+	}
+	return nil
+}
+
+// context converts an object emitted by nerdctl to the docker field model.
+func (f *templateFormatter) context(object map[string]interface{}) formatContext {
+	context := formatContext(object)
+	if f.kind == formatKindInspect {
+		return context
+	}
+	for field := range formatHeaders[f.kind] {
+		if _, ok := context[field]; !ok {
+			context[field] = ""
+		}
+	}
+	for field, value := range formatDefaults[f.kind] {
+		if context[field] == "" {
+			context[field] = value
+		}
+	}
+	switch f.kind {
+	case formatKindContainers:
+		status, _ := context["Status"].(string)
+		if context["State"] == "" {
+			context["State"] = containerState(status)
+		}
+		if createdAt, ok := context["CreatedAt"].(string); ok && context["RunningFor"] == "" {
+			if created, err := time.Parse("2006-01-02 15:04:05 -0700 MST", createdAt); err == nil {
+				context["RunningFor"] = humanDuration(f.now().Sub(created)) + " ago"
+			}
+		}
+	case formatKindImages:
+		if context["VirtualSize"] == "" {
+			context["VirtualSize"] = context["Size"]
+		}
+	}
+	return context
+}
+
+// containerState derives the docker container state from the status.
+func containerState(status string) string {
+	words := strings.Fields(status)
+	if len(words) == 0 {
+		return ""
+	}
+	switch words[0] {
+	case "Up":
+		if strings.Contains(status, "(Paused)") {
+			return "paused"
+		}
+		return "running"
+	case "Exited":
+		return "exited"
+	}
+	return strings.ToLower(words[0])
+}
+
+// humanDuration describes the duration in the same way as docker.
+func humanDuration(d time.Duration) string {
+	seconds := int(d.Seconds())
+	switch {
+	case seconds < 1:
+		return "Less than a second"
+	case seconds == 1:
+		return "1 second"
+	case seconds < 60:
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := int(d.Minutes())
+	switch {
+	case minutes == 1:
+		return "About a minute"
+	case minutes < 60:
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	hours := int(d.Hours() + 0.5)
+	switch {
+	case hours == 1:
+		return "About an hour"
+	case hours < 48:
+		return fmt.Sprintf("%d hours", hours)
+	case hours < 24*7*2:
+		return fmt.Sprintf("%d days", hours/24)
+	case hours < 24*30*2:
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	case hours < 24*365*2:
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+	return fmt.Sprintf("%d years", int(d.Hours())/24/365)
+}
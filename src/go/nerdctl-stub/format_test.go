@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTemplateFormatter(t *testing.T) {
+	t.Parallel()
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	require.NoError(t, err)
+
+	t.Run("rewrites arguments", func(t *testing.T) {
+		t.Parallel()
+		testCases := []struct {
+			args     []string
+			expected []string
+		}{
+			{[]string{"ps", "-a", "--format", "{{.Names}}"}, []string{"ps", "-a", "--format", jsonTemplate}},
+			{[]string{"image", "ls", "--format=table {{.ID}}"}, []string{"image", "ls", "--format", jsonTemplate}},
+			{[]string{"inspect", "-f", "{{.State.Pid}}", "web"}, []string{"inspect", "web"}},
+			{[]string{"volume", "inspect", "--format=json", "data"}, []string{"volume", "inspect", "data"}},
+			{[]string{"container", "inspect", "--mode", "native", "web"}, nil},
+			{[]string{"ps", "--format", "json"}, nil},
+			{[]string{"ps", "-q", "--format", "{{.ID}}"}, nil},
+			{[]string{"network", "ls"}, nil},
+		}
+		for _, testCase := range testCases {
+			result, err := registry.Parse(testCase.args)
+			require.NoError(t, err, testCase.args)
+			formatter, err := newTemplateFormatter(registry, result)
+			require.NoError(t, err, testCase.args)
+			if testCase.expected == nil {
+				assert.Nil(t, formatter, testCase.args)
+				assert.Equal(t, testCase.args, result.Args)
+			} else if assert.NotNil(t, formatter, testCase.args) {
+				assert.Equal(t, testCase.expected, result.Args)
+			}
+		}
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		t.Parallel()
+		result, err := registry.Parse([]string{"ps", "--format", "{{.Names"})
+		require.NoError(t, err)
+		_, err = newTemplateFormatter(registry, result)
+		assert.Error(t, err)
+	})
+}
+
+func TestTemplateFormatterRender(t *testing.T) {
+	t.Parallel()
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	require.NoError(t, err)
+	now := time.Date(2022, 1, 2, 15, 0, 0, 0, time.UTC)
+	render := func(t *testing.T, args []string, input string) string {
+		result, err := registry.Parse(args)
+		require.NoError(t, err)
+		formatter, err := newTemplateFormatter(registry, result)
+		require.NoError(t, err)
+		require.NotNil(t, formatter)
+		formatter.now = func() time.Time { return now }
+		output := &bytes.Buffer{}
+		require.NoError(t, formatter.render(strings.NewReader(input), output))
+		return output.String()
+	}
+	containers := strings.Join([]string{
+		fmt.Sprintf(`{"ID":"abc123","Names":"web","Image":"nginx","Status":"Up","CreatedAt":%q,"Labels":"app=web,tier=front","Size":1234567}`,
+			now.Add(-3*time.Hour).Format("2006-01-02 15:04:05 -0700 MST")),
+		`{"ID":"def456","Names":"db","Image":"postgres","Status":"Exited (0) 2 minutes ago","Labels":""}`,
+		"",
+	}, "\n")
+
+	t.Run("custom", func(t *testing.T) {
+		t.Parallel()
+		output := render(t, []string{"ps", "--format", `{{.Names}}\t{{.State}}\t{{.RunningFor}}\t{{.Label "tier"}}\t{{.Size}}`}, containers)
+		assert.Equal(t, "web\trunning\t3 hours ago\tfront\t1234567\ndb\texited\t\t\t\n", output)
+	})
+
+	t.Run("table", func(t *testing.T) {
+		t.Parallel()
+		output := render(t, []string{"ps", "--format", `table {{.ID}}\t{{.Names | upper}}`}, containers)
+		assert.Equal(t, "CONTAINER ID   NAMES\nabc123         WEB\ndef456         DB\n", output)
+
+		// The header is printed even with no rows.
+		output = render(t, []string{"volume", "ls", "--format", `table {{.Name}}\t{{.Driver}}`}, "")
+		assert.Equal(t, "VOLUME NAME   DRIVER\n", output)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		output := render(t, []string{"network", "ls", "--format", `{{json .Name}} {{.Scope}}`}, `{"ID":"1","Name":"<bridge>"}`)
+		assert.Equal(t, "\"<bridge>\" local\n", output)
+	})
+
+	t.Run("inspect", func(t *testing.T) {
+		t.Parallel()
+		input := `[{"Id":"abc","State":{"Pid":42,"Running":true},"Config":{"Labels":{"a":"b"}}},{"Id":"def","State":{"Pid":0}}]`
+		output := render(t, []string{"inspect", "-f", "{{.Id}} {{.State.Pid}} {{json .Config.Labels}}", "web", "db"}, input)
+		assert.Equal(t, "abc 42 {\"a\":\"b\"}\ndef 0 null\n", output)
+	})
+
+	t.Run("invalid output", func(t *testing.T) {
+		t.Parallel()
+		result, err := registry.Parse([]string{"ps", "--format", "{{.ID}}"})
+		require.NoError(t, err)
+		formatter, err := newTemplateFormatter(registry, result)
+		require.NoError(t, err)
+		assert.Error(t, formatter.render(strings.NewReader("not json"), &bytes.Buffer{}))
+	})
+}
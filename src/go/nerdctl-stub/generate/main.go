@@ -1,18 +1,25 @@
-// +build linux
-
 // package main produces stubs for the nerdctl subcommands (and their
 // options); this is expected to be overridden for options that involve paths.
 // All options generated this will have their values ignored.
+//
+// The help text can either come from running nerdctl directly, or from a
+// directory of previously captured help output (see -help-dir); the latter
+// makes it possible to regenerate the stubs on machines without nerdctl.
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"text/template"
 	"unicode"
@@ -24,15 +31,44 @@ var nerdctl = "/usr/local/bin/nerdctl"
 // outputPath is the file we should generate.
 var outputPath = "../nerdctl_commands_generated.go"
 
+// helpDir is a directory of captured help output to use instead of running
+// nerdctl; see helpFileName for the file naming convention.
+var helpDir = ""
+
+// captureDir is a directory to write the help output into while running
+// nerdctl, in the format expected by helpDir.
+var captureDir = ""
+
+// optionData describes a single option, as parsed from the help text.  Only
+// TakesValue is emitted into the generated table, as that is all the stub
+// needs to parse command lines; the rest is used when comparing commands.
+type optionData struct {
+	// TakesValue is whether the option requires a value.
+	TakesValue bool
+	// Type is the value type as printed in the help text (e.g. `string`,
+	// `strings`, or `value` for urfave/cli); empty if it takes no value.
+	Type string
+	// Default is the default value as printed in the help text, if any.
+	Default string
+	// Repeatable is whether the option can be given multiple times.
+	Repeatable bool
+}
+
 type helpData struct {
 	// Commands lists the subcommands available
 	Commands []string
 	// options available for this command; the key is the long option
-	// (`--version`) or the short option (`-v`), and the value is whether the
-	// option takes an argument.
-	Options map[string]bool
+	// (`--version`) or the short option (`-v`).
+	Options map[string]optionData
+	// Synonyms maps each option that has more than one name to all of its
+	// names (including itself), sorted.
+	Synonyms map[string][]string
 }
 
+// helpSource returns the help text for the command reached by the given
+// arguments.
+type helpSource func(args []string) (string, error)
+
 // prologueTemplate describes the file header for the generated file.
 const prologueTemplate = `
 // Code generated by {{ .package }} - DO NOT EDIT.
@@ -40,22 +76,70 @@ const prologueTemplate = `
 // package main implements a stub for nerdctl
 package main
 
+import "github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+
 // commands supported by nerdctl; the key here is a space-separated subcommand
 // path to reach the given subcommand (where the root command is empty).
-var commands = map[string]commandDefinition {
+var commands = map[string]cliparse.Command {
 `
 
 // epilogueTemplate describes the file trailer for the generated file.
 const epilogueTemplate = `
 }
+
+// commandAliases maps top-level commands to the subcommands they mirror (i.e.
+// have the same options and subcommands as).
+var commandAliases = map[string]string {
+{{- range $alias, $target := .aliases }}
+	{{ printf "%q" $alias }}: {{ printf "%q" $target }},
+{{- end }}
+}
 `
 
 func main() {
+	flag.StringVar(&nerdctl, "nerdctl", nerdctl, "Path to the nerdctl executable")
+	flag.StringVar(&outputPath, "output", outputPath, "Path to the generated file")
+	flag.StringVar(&helpDir, "help-dir", helpDir, "Read help output from this directory instead of running nerdctl")
+	flag.StringVar(&captureDir, "capture-dir", captureDir, "Save help output from nerdctl into this directory")
+	flag.Parse()
+
+	var source helpSource = getHelp
+	if helpDir != "" {
+		source = func(args []string) (string, error) {
+			return readHelp(helpDir, args)
+		}
+	} else if captureDir != "" {
+		err := os.MkdirAll(captureDir, 0o755)
+		if err != nil {
+			log.Fatalf("Error creating capture directory %s: %s", captureDir, err)
+		}
+		source = func(args []string) (string, error) {
+			help, err := getHelp(args)
+			if err != nil {
+				return "", err
+			}
+			err = os.WriteFile(filepath.Join(captureDir, helpFileName(args)), []byte(help), 0o644)
+			if err != nil {
+				return "", err
+			}
+			return help, nil
+		}
+	}
+
 	output, err := os.Create(outputPath)
 	if err != nil {
 		log.Fatalf("Error creating output file %s: %s", outputPath, err)
 	}
 	defer output.Close()
+	err = generate(source, output)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+}
+
+// generate writes the full generated file to the given writer, using the
+// given source to look up help text.
+func generate(source helpSource, writer io.Writer) error {
 	_, filename, _, _ := runtime.Caller(0)
 	data := map[string]interface{}{
 		"package": filename,
@@ -63,18 +147,17 @@ func main() {
 	if buildInfo, ok := debug.ReadBuildInfo(); ok {
 		data["package"] = buildInfo.Main.Path
 	}
-	err = template.Must(template.New("").Parse(prologueTemplate)).Execute(output, data)
-	if err != nil {
-		log.Fatalf("Error: %s", err)
-	}
-	err = buildSubcommand([]string{}, output)
+	err := template.Must(template.New("").Parse(prologueTemplate)).Execute(writer, data)
 	if err != nil {
-		log.Fatalf("Error: %s", err)
+		return err
 	}
-	err = template.Must(template.New("").Parse(epilogueTemplate)).Execute(output, data)
+	seen := make(map[string]helpData)
+	err = buildSubcommand([]string{}, source, writer, seen)
 	if err != nil {
-		log.Fatalf("Error: %s", err)
+		return err
 	}
+	data["aliases"] = findAliases(seen)
+	return template.Must(template.New("").Parse(epilogueTemplate)).Execute(writer, data)
 }
 
 // buildSubcommand generates the option parser data for a given subcommand.
@@ -82,8 +165,9 @@ func main() {
 // element in the slice is the name of the subcommand.
 // writer is the file to write to for the result; it is expected that `go fmt`
 // will be run on it eventually.
-func buildSubcommand(args []string, writer io.Writer) error {
-	help, err := getHelp(args)
+// seen is updated with the parsed help for every command visited.
+func buildSubcommand(args []string, source helpSource, writer io.Writer, seen map[string]helpData) error {
+	help, err := source(args)
 	if err != nil {
 		return fmt.Errorf("Error getting help for %v: %w", args, err)
 	}
@@ -91,6 +175,7 @@ func buildSubcommand(args []string, writer io.Writer) error {
 	if err != nil {
 		return fmt.Errorf("Error parsing help for %v: %w", args, err)
 	}
+	seen[strings.Join(args, " ")] = subcommands
 
 	err = emitCommand(args, subcommands, writer)
 	if err != nil {
@@ -101,7 +186,7 @@ func buildSubcommand(args []string, writer io.Writer) error {
 		newArgs := make([]string, 0, len(args))
 		newArgs = append(newArgs, args...)
 		newArgs = append(newArgs, subcommand)
-		err := buildSubcommand(newArgs, writer)
+		err := buildSubcommand(newArgs, source, writer, seen)
 		if err != nil {
 			return err
 		}
@@ -110,11 +195,12 @@ func buildSubcommand(args []string, writer io.Writer) error {
 	return nil
 }
 
-// getHelp runs `nerdctl <args...> -help` and returns the result.
+// getHelp runs `nerdctl <args...> --help` and returns the result.  Both
+// urfave/cli and cobra accept `--help`.
 func getHelp(args []string) (string, error) {
 	newArgs := make([]string, 0, len(args)+1)
 	newArgs = append(newArgs, args...)
-	newArgs = append(newArgs, "-help")
+	newArgs = append(newArgs, "--help")
 	cmd := exec.Command(nerdctl, newArgs...)
 	cmd.Stderr = os.Stderr
 	result, err := cmd.Output()
@@ -124,16 +210,116 @@ func getHelp(args []string) (string, error) {
 	return string(result), nil
 }
 
+// helpFileName returns the name of the file (within a help directory) that
+// contains the help text for the given arguments; for example, `nerdctl.txt`
+// for the root command and `nerdctl_container_run.txt` for `container run`.
+func helpFileName(args []string) string {
+	return strings.Join(append([]string{"nerdctl"}, args...), "_") + ".txt"
+}
+
+// readHelp reads previously captured help text for the given arguments from
+// the given directory.
+func readHelp(dir string, args []string) (string, error) {
+	help, err := os.ReadFile(filepath.Join(dir, helpFileName(args)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("no captured help for %q: %w", strings.Join(args, " "), err)
+		}
+		return "", err
+	}
+	return string(help), nil
+}
+
 const (
 	STATE_OTHER = iota
 	STATE_COMMANDS
 	STATE_OPTIONS
 )
 
+// sectionState returns the parser state for a section header line.  This
+// handles both the urfave/cli layout (`COMMANDS:`, `OPTIONS:`) and the cobra
+// layout (`Available Commands:`, `Management commands:`, `Flags:`).  Options
+// inherited from the parent (cobra's `Global Flags:`) are skipped as they are
+// looked up through the parent command instead.
+func sectionState(line string) int {
+	header := strings.ToLower(strings.TrimSpace(line))
+	switch {
+	case strings.HasSuffix(header, "commands:"):
+		return STATE_COMMANDS
+	case strings.HasSuffix(header, "options:"):
+		return STATE_OPTIONS
+	case header == "flags:":
+		return STATE_OPTIONS
+	}
+	return STATE_OTHER
+}
+
+// defaultPattern matches the default value at the end of an option's
+// description; urfave/cli uses `(default: x)` (possibly followed by the
+// environment variable, `[$ENV]`), cobra uses `(default x)`.
+var defaultPattern = regexp.MustCompile(`\(default:? (.*)\)(?:\s*\[\$[^\]]*\])?\s*$`)
+
+// repeatableTypes are the cobra (pflag) value types that accumulate values
+// across multiple uses of the option.
+var repeatableTypes = map[string]struct{}{
+	"stringArray": {},
+	"stringSlice": {},
+	"strings":     {},
+	"ints":        {},
+	"uints":       {},
+	"bools":       {},
+	"floats":      {},
+	"durations":   {},
+}
+
+// parseOptionLine parses the (left-trimmed) help line of an option, which may
+// look like `--volume value, -v value  Bind mount (accepts multiple inputs)`
+// (urfave/cli) or `-p, --publish strings   Publish ports` (cobra).  It
+// returns the names of the option, plus what we know about it.
+func parseOptionLine(line string) ([]string, optionData, bool) {
+	var data optionData
+	parts := strings.SplitN(line, "  ", 2)
+	if len(parts) < 2 && !strings.HasPrefix(line, "-") {
+		// This line does not contain an option.
+		return nil, data, false
+	}
+	description := ""
+	if len(parts) > 1 {
+		description = strings.TrimSpace(parts[1])
+	}
+	var names []string
+	for _, word := range strings.Split(strings.TrimSpace(parts[0]), ", ") {
+		fields := strings.Fields(word)
+		if len(fields) < 1 || !strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		names = append(names, fields[0])
+		if len(fields) > 1 {
+			data.TakesValue = true
+			data.Type = fields[1]
+		}
+	}
+	if len(names) < 1 {
+		return nil, data, false
+	}
+	if match := defaultPattern.FindStringSubmatch(description); match != nil {
+		data.Default = match[1]
+	}
+	if _, ok := repeatableTypes[data.Type]; ok {
+		data.Repeatable = true
+	} else if strings.Contains(description, "(accepts multiple inputs)") {
+		data.Repeatable = true
+	}
+	return names, data, true
+}
+
 // parseHelp consumes the output of `nerdctl help` (possibly for a subcommand)
 // and returns the available subcommands and options.
 func parseHelp(args []string, help string) (helpData, error) {
-	result := helpData{Options: make(map[string]bool)}
+	result := helpData{
+		Options:  make(map[string]optionData),
+		Synonyms: make(map[string][]string),
+	}
 	state := STATE_OTHER
 	for _, line := range strings.Split(help, "\n") {
 		line = strings.TrimRightFunc(line, unicode.IsSpace)
@@ -143,13 +329,7 @@ func parseHelp(args []string, help string) (helpData, error) {
 		}
 		if !strings.HasPrefix(line, " ") {
 			// Line does not start with a space; it's a section header.
-			if strings.HasSuffix(line, "COMMANDS:") {
-				state = STATE_COMMANDS
-			} else if strings.HasSuffix(line, "OPTIONS:") {
-				state = STATE_OPTIONS
-			} else {
-				state = STATE_OTHER
-			}
+			state = sectionState(line)
 			continue
 		}
 		line = strings.TrimLeftFunc(line, unicode.IsSpace)
@@ -162,17 +342,16 @@ func parseHelp(args []string, help string) (helpData, error) {
 			words := strings.Split(strings.TrimSpace(parts[0]), ", ")
 			result.Commands = append(result.Commands, words...)
 		} else if state == STATE_OPTIONS {
-			parts := strings.SplitN(line, "  ", 2)
-			if len(parts) < 2 {
-				// This line does not contain an option.
+			names, data, ok := parseOptionLine(line)
+			if !ok {
 				continue
 			}
-			for _, word := range strings.Split(strings.TrimSpace(parts[0]), ", ") {
-				spaceIndex := strings.Index(word, " ")
-				if spaceIndex > -1 {
-					word = word[:spaceIndex]
+			sort.Strings(names)
+			for _, name := range names {
+				result.Options[name] = data
+				if len(names) > 1 {
+					result.Synonyms[name] = names
 				}
-				result.Options[word] = spaceIndex > -1
 			}
 		}
 	}
@@ -182,17 +361,24 @@ func parseHelp(args []string, help string) (helpData, error) {
 // commandTemplate is the text/template template for a single subcommand.
 const commandTemplate = `
 	{{ printf "%q" .Args }}: {
-		commandPath: {{ printf "%q" .Args }},
-		subcommands: map[string]struct{} {
+		Path: {{ printf "%q" .Args }},
+		Subcommands: map[string]struct{} {
 			{{- range .Data.Commands }}
 				{{ printf "%q" . }}: {},
 			{{- end }}
 		},
-		options: map[string]argHandler {
+		Options: map[string]cliparse.ArgHandler {
 			{{ range $k, $v := .Data.Options }}
-				{{- printf "%q" $k -}}: {{ if $v -}} ignoredArgHandler {{- else -}} nil {{- end -}},
+				{{- printf "%q" $k -}}: {{ if $v.TakesValue -}} cliparse.IgnoredArgHandler {{- else -}} nil {{- end -}},
 			{{ end }}
 		},
+		{{- if .Data.Synonyms }}
+		Synonyms: map[string][]string {
+			{{ range $k, $v := .Data.Synonyms }}
+				{{- printf "%q" $k -}}: { {{- range $i, $name := $v }}{{ if $i }}, {{ end }}{{ printf "%q" $name }}{{ end -}} },
+			{{ end }}
+		},
+		{{- end }}
 	},
 `
 
@@ -217,3 +403,54 @@ func emitCommand(args []string, data helpData, writer io.Writer) error {
 	}
 	return nil
 }
+
+// aliasParents are the commands whose subcommands may be mirrored at the top
+// level (e.g. `nerdctl run` for `nerdctl container run`).
+var aliasParents = []string{"container", "image"}
+
+// sameCommand checks if the two commands have the same subcommands and options.
+func sameCommand(a, b helpData) bool {
+	if len(a.Commands) != len(b.Commands) || len(a.Options) != len(b.Options) {
+		return false
+	}
+	subcommands := make(map[string]struct{})
+	for _, subcommand := range a.Commands {
+		subcommands[subcommand] = struct{}{}
+	}
+	for _, subcommand := range b.Commands {
+		if _, ok := subcommands[subcommand]; !ok {
+			return false
+		}
+	}
+	for name, option := range a.Options {
+		other, ok := b.Options[name]
+		if !ok || other != option {
+			return false
+		}
+	}
+	return true
+}
+
+// findAliases detects top-level commands that mirror a subcommand of one of
+// aliasParents.  Commands that match more than one candidate (e.g. `inspect`,
+// which matches both `container inspect` and `image inspect`) are ambiguous and
+// are not considered aliases.
+func findAliases(seen map[string]helpData) map[string]string {
+	result := make(map[string]string)
+	for path, data := range seen {
+		if path == "" || strings.Contains(path, " ") {
+			continue
+		}
+		var candidates []string
+		for _, parent := range aliasParents {
+			target := parent + " " + path
+			if targetData, ok := seen[target]; ok && sameCommand(data, targetData) {
+				candidates = append(candidates, target)
+			}
+		}
+		if len(candidates) == 1 {
+			result[path] = candidates[0]
+		}
+	}
+	return result
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHelp(t *testing.T) {
+	t.Parallel()
+	t.Run("urfave/cli", func(t *testing.T) {
+		t.Parallel()
+		help, err := readHelp(filepath.Join("testdata", "urfave"), []string{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		result, err := parseHelp([]string{}, help)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []string{"run", "help", "h", "container"}, result.Commands)
+		assert.Equal(t, optionData{Default: "false"}, result.Options["--debug"])
+		address := optionData{
+			TakesValue: true,
+			Type:       "value",
+			Default:    `"/run/containerd/containerd.sock"`,
+		}
+		for _, name := range []string{"--address", "-a", "--host", "-H"} {
+			assert.Equal(t, address, result.Options[name], name)
+		}
+	})
+	t.Run("urfave/cli repeatable", func(t *testing.T) {
+		t.Parallel()
+		help, err := readHelp(filepath.Join("testdata", "urfave"), []string{"run"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		result, err := parseHelp([]string{"run"}, help)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Empty(t, result.Commands)
+		volume := optionData{TakesValue: true, Type: "value", Repeatable: true}
+		assert.Equal(t, volume, result.Options["--volume"])
+		assert.Equal(t, volume, result.Options["-v"])
+		assert.Equal(t, optionData{TakesValue: true, Type: "value"}, result.Options["--cidfile"])
+		assert.False(t, result.Options["-t"].TakesValue)
+		assert.Equal(t, []string{"--volume", "-v"}, result.Synonyms["-v"])
+		assert.NotContains(t, result.Synonyms, "--cidfile")
+	})
+	t.Run("cobra", func(t *testing.T) {
+		t.Parallel()
+		help, err := readHelp(filepath.Join("testdata", "cobra"), []string{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		result, err := parseHelp([]string{}, help)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []string{"container", "run"}, result.Commands)
+		assert.Equal(t, optionData{}, result.Options["--debug"])
+		namespace := optionData{TakesValue: true, Type: "string", Default: `"default"`}
+		assert.Equal(t, namespace, result.Options["--namespace"])
+		assert.Equal(t, namespace, result.Options["-n"])
+		assert.Len(t, result.Options, 10)
+		assert.Equal(t, []string{"--address", "-H"}, result.Synonyms["--address"])
+	})
+	t.Run("cobra types and global flags", func(t *testing.T) {
+		t.Parallel()
+		help, err := readHelp(filepath.Join("testdata", "cobra"), []string{"run"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		result, err := parseHelp([]string{"run"}, help)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, optionData{TakesValue: true, Type: "strings", Repeatable: true}, result.Options["-p"])
+		assert.Equal(t, optionData{TakesValue: true, Type: "stringArray", Repeatable: true}, result.Options["--volume"])
+		assert.Equal(t, optionData{TakesValue: true, Type: "float"}, result.Options["--cpus"])
+		assert.Equal(t, optionData{TakesValue: true, Type: "string", Default: `"no"`}, result.Options["--restart"])
+		assert.False(t, result.Options["--tty"].TakesValue)
+		// Global flags are resolved via the parent command.
+		assert.NotContains(t, result.Options, "--debug")
+		assert.NotContains(t, result.Options, "--address")
+	})
+}
+
+func TestFindAliases(t *testing.T) {
+	t.Parallel()
+	run := helpData{Options: map[string]optionData{"--rm": {}, "--name": {TakesValue: true}}}
+	inspect := helpData{Options: map[string]optionData{"--format": {TakesValue: true}}}
+	stop := helpData{Options: map[string]optionData{"--time": {TakesValue: true, Type: "int", Default: "10"}}}
+	seen := map[string]helpData{
+		"":                  {Commands: []string{"run", "inspect", "rm", "stop", "container", "image"}},
+		"run":               run,
+		"inspect":           inspect,
+		"rm":                {Options: map[string]optionData{"--force": {}}},
+		"stop":              stop,
+		"container":         {Commands: []string{"run", "inspect", "rm", "stop"}},
+		"container run":     run,
+		"container inspect": inspect,
+		"container rm":      {Options: map[string]optionData{"--force": {TakesValue: true}}},
+		"container stop":    {Options: map[string]optionData{"--time": {TakesValue: true, Type: "int", Default: "5"}}},
+		"image":             {Commands: []string{"inspect"}},
+		"image inspect":     inspect,
+	}
+	// `inspect` is ambiguous, `rm` has different options, and `stop` has a
+	// different default.
+	assert.Equal(t, map[string]string{"run": "container run"}, findAliases(seen))
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+	for _, format := range []string{"urfave", "cobra"} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+			dir := filepath.Join("testdata", format)
+			source := func(args []string) (string, error) {
+				return readHelp(dir, args)
+			}
+			buf := &bytes.Buffer{}
+			if assert.NoError(t, generate(source, buf)) {
+				output := buf.String()
+				assert.Contains(t, output, `"container run": {`)
+				assert.Contains(t, output, `"--volume": cliparse.IgnoredArgHandler,`)
+				assert.Contains(t, output, `"--tty": nil,`)
+				assert.Contains(t, output, `"-v": {"--volume", "-v"},`)
+				assert.Contains(t, output, `"run": "container run",`)
+			}
+		})
+	}
+	t.Run("missing help file", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, helpFileName(nil)), []byte("COMMANDS:\n   missing  Does not exist\n"), 0o644)
+		if !assert.NoError(t, err) {
+			return
+		}
+		source := func(args []string) (string, error) {
+			return readHelp(dir, args)
+		}
+		err = generate(source, &bytes.Buffer{})
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+// generatedHeaderPattern matches the line naming the generator, which depends on
+// how it was built.
+var generatedHeaderPattern = regexp.MustCompile(`(?m)^// Code generated by .* - DO NOT EDIT\.$`)
+
+func TestGeneratedFileUpToDate(t *testing.T) {
+	t.Parallel()
+	source := func(args []string) (string, error) {
+		return readHelp("help", args)
+	}
+	buf := &bytes.Buffer{}
+	if !assert.NoError(t, generate(source, buf)) {
+		return
+	}
+	actual, err := format.Source(buf.Bytes())
+	if !assert.NoError(t, err) {
+		return
+	}
+	expected, err := os.ReadFile(filepath.Join("..", "nerdctl_commands_generated.go"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	normalize := func(data []byte) string {
+		return generatedHeaderPattern.ReplaceAllString(string(data), "// Code generated - DO NOT EDIT.")
+	}
+	assert.Equal(t, normalize(expected), normalize(actual),
+		"nerdctl_commands_generated.go does not match the output for the help files in generate/help")
+}
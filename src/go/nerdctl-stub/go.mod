@@ -3,6 +3,9 @@ module github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub
 go 1.16
 
 require (
+	github.com/pkg/sftp v1.13.4
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	golang.org/x/sys v0.0.0-20210915083310-ed5796bab164
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
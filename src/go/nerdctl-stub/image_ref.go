@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+// defaultRegistry is the registry used for image references that do not name
+// one explicitly.
+const defaultRegistry = "docker.io"
+
+// imageReference is a parsed image reference, such as
+// `registry.example.com:5000/project/image:tag@sha256:...`.
+type imageReference struct {
+	// domain is the registry host (and optional port).
+	domain string
+	// path is the repository path within the registry.
+	path string
+	// tag is the tag, if any.
+	tag string
+	// digest is the digest, if any.
+	digest string
+}
+
+// parseImageReference parses an image reference, filling in the default
+// registry (and the `library/` prefix for it) as necessary.  This is lenient,
+// as nerdctl will report any invalid references.
+func parseImageReference(ref string) imageReference {
+	var result imageReference
+	if index := strings.Index(ref, "@"); index >= 0 {
+		result.digest = ref[index+1:]
+		ref = ref[:index]
+	}
+	if index := strings.LastIndex(ref, ":"); index > strings.LastIndex(ref, "/") {
+		result.tag = ref[index+1:]
+		ref = ref[:index]
+	}
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		result.domain = parts[0]
+		result.path = parts[1]
+	} else {
+		result.domain = defaultRegistry
+		result.path = ref
+	}
+	if result.domain == "index.docker.io" {
+		result.domain = defaultRegistry
+	}
+	if result.domain == defaultRegistry && !strings.Contains(result.path, "/") {
+		result.path = "library/" + result.path
+	}
+	return result
+}
+
+// name returns the fully-qualified repository name, without tag or digest.
+func (r imageReference) name() string {
+	return r.domain + "/" + r.path
+}
+
+// String returns the fully-qualified image reference.
+func (r imageReference) String() string {
+	result := r.name()
+	if r.tag != "" {
+		result += ":" + r.tag
+	}
+	if r.digest != "" {
+		result += "@" + r.digest
+	}
+	return result
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// imageRewriteRule describes how to rewrite image references; exactly one of
+// Prefix or Regex should be set.  Rules are matched against the fully-qualified
+// reference, e.g. `docker.io/library/nginx:latest`.
+type imageRewriteRule struct {
+	// Prefix is replaced by Replacement if the reference starts with it.
+	Prefix string `yaml:"prefix"`
+	// Regex is a regular expression; any matches are replaced by Replacement,
+	// which may refer to capture groups as `$1` or `${name}`.
+	Regex string `yaml:"regex"`
+	// Replacement is the replacement text.
+	Replacement string `yaml:"replacement"`
+	// regex is the compiled form of Regex.
+	regex *regexp.Regexp
+}
+
+// imagePositionals are the commands with images as positional arguments, and
+// how many of the positional arguments are images (-1 for all of them).
+var imagePositionals = map[string]int{
+	"container create": 1,
+	"container run":    1,
+	"image pull":       1,
+	"image push":       1,
+	"image rm":         -1,
+	"image save":       -1,
+	"image tag":        2,
+	"rmi":              -1,
+}
+
+// composeFileNames are the default compose file names, in order of preference.
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// dockerfileFromPattern matches `FROM` lines in a Dockerfile; the groups are
+// the instruction (with any flags), the image, and the rest of the line.
+var dockerfileFromPattern = regexp.MustCompile(`(?i)^(\s*FROM\s+(?:--\S+\s+)*)(\S+)(.*)$`)
+
+// dockerfileStagePattern matches the stage name after the image in a `FROM`
+// line.
+var dockerfileStagePattern = regexp.MustCompile(`(?i)^\s+AS\s+(\S+)`)
+
+// imageRewriter rewrites image references in arguments and files.
+type imageRewriter struct {
+	rules []imageRewriteRule
+	// output is where rewrites are reported; if nil, they are not reported.
+	output io.Writer
+}
+
+// newImageRewriter creates a rewriter with the given rules.
+func newImageRewriter(rules []imageRewriteRule, output io.Writer) (*imageRewriter, error) {
+	result := &imageRewriter{output: output}
+	for _, rule := range rules {
+		switch {
+		case rule.Prefix != "" && rule.Regex != "":
+			return nil, fmt.Errorf("image rewrite rule has both prefix %q and regex %q", rule.Prefix, rule.Regex)
+		case rule.Regex != "":
+			regex, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("image rewrite rule has invalid regex: %w", err)
+			}
+			rule.regex = regex
+		case rule.Prefix == "":
+			return nil, fmt.Errorf("image rewrite rule has neither prefix nor regex")
+		}
+		result.rules = append(result.rules, rule)
+	}
+	return result, nil
+}
+
+// apply the rules to the given image reference, without reporting it; if no
+// rules match, it is returned as-is.
+func (r *imageRewriter) apply(image string) (string, bool) {
+	normalized := parseImageReference(image).String()
+	for _, rule := range r.rules {
+		if rule.regex != nil {
+			if rule.regex.MatchString(normalized) {
+				return rule.regex.ReplaceAllString(normalized, rule.Replacement), true
+			}
+		} else if strings.HasPrefix(normalized, rule.Prefix) {
+			return rule.Replacement + strings.TrimPrefix(normalized, rule.Prefix), true
+		}
+	}
+	return image, false
+}
+
+// rewrite the given image reference; if no rules match, it is returned as-is.
+func (r *imageRewriter) rewrite(image string) string {
+	result, ok := r.apply(image)
+	if ok && r.output != nil {
+		fmt.Fprintf(r.output, "Rewrote image %s to %s\n", image, result)
+	}
+	return result
+}
+
+// dockerfileFrom is an image referred to by a `FROM` line of a Dockerfile.
+type dockerfileFrom struct {
+	// line is the index of the line.
+	line int
+	// prefix is the instruction (with any flags), and rest is the text after
+	// the image.
+	prefix, image, rest string
+}
+
+// dockerfileImages returns the images in the `FROM` lines of a Dockerfile.
+// References to earlier build stages, and `scratch`, are skipped.
+func dockerfileImages(lines []string) []dockerfileFrom {
+	var result []dockerfileFrom
+	stages := make(map[string]struct{})
+	for i, line := range lines {
+		match := dockerfileFromPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		image, rest := match[2], match[3]
+		_, isStage := stages[strings.ToLower(image)]
+		if stage := dockerfileStagePattern.FindStringSubmatch(rest); stage != nil {
+			stages[strings.ToLower(stage[1])] = struct{}{}
+		}
+		if isStage || image == "scratch" {
+			continue
+		}
+		result = append(result, dockerfileFrom{line: i, prefix: match[1], image: image, rest: rest})
+	}
+	return result
+}
+
+// applies checks if the rewriter would act on the given parse result.
+func (r *imageRewriter) applies(registry *cliparse.Registry, result *cliparse.Result) bool {
+	if len(r.rules) == 0 {
+		return false
+	}
+	if _, ok := imagePositionals[result.CommandPath]; ok && len(result.Positionals) > 0 {
+		return true
+	}
+	return result.CommandPath == "image build" || strings.HasPrefix(result.CommandPath, "compose ")
+}
+
+// rewriteDockerfile rewrites the images in the `FROM` lines of a Dockerfile,
+// returning the new contents and whether anything changed.  References to
+// earlier build stages, and images containing variables, are not changed.
+func (r *imageRewriter) rewriteDockerfile(contents []byte) ([]byte, bool) {
+	lines := strings.Split(string(contents), "\n")
+	changed := false
+	for _, from := range dockerfileImages(lines) {
+		if strings.Contains(from.image, "$") {
+			continue
+		}
+		if rewritten := r.rewrite(from.image); rewritten != from.image {
+			lines[from.line] = from.prefix + rewritten + from.rest
+			changed = true
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), changed
+}
+
+// mappingValue returns the value for the given key in a YAML mapping node.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// composeServices returns the `services` mapping of a compose file, or nil if
+// there is none.
+func composeServices(document *yaml.Node) *yaml.Node {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil
+	}
+	services := mappingValue(document.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil
+	}
+	return services
+}
+
+// rewriteCompose rewrites the `image:` keys of the services in a compose file,
+// returning the new contents and whether anything changed.
+func (r *imageRewriter) rewriteCompose(contents []byte) ([]byte, bool, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return nil, false, err
+	}
+	services := composeServices(&document)
+	if services == nil {
+		return contents, false, nil
+	}
+	changed := false
+	for i := 1; i < len(services.Content); i += 2 {
+		image := mappingValue(services.Content[i], "image")
+		if image == nil || image.Kind != yaml.ScalarNode || strings.Contains(image.Value, "$") {
+			continue
+		}
+		if rewritten := r.rewrite(image.Value); rewritten != image.Value {
+			image.Value = rewritten
+			changed = true
+		}
+	}
+	if !changed {
+		return contents, false, nil
+	}
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, false, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// rewriteComposeFile rewrites the images in the given compose file.  If any
+// images were changed, a rewritten copy is placed next to the original (so
+// that relative paths still work), and its path returned.  As the directory is
+// chosen by the caller, the files are only accessed as the real user.
+func (r *imageRewriter) rewriteComposeFile(composePath string) (string, []cliparse.CleanupFunc, error) {
+	contents, err := readUserFile(composePath)
+	if err != nil {
+		return "", nil, err
+	}
+	rewritten, changed, err := r.rewriteCompose(contents)
+	if err != nil || !changed {
+		return composePath, nil, err
+	}
+	var rewrittenPath string
+	err = asUser(func() error {
+		file, err := os.CreateTemp(filepath.Dir(composePath), ".nerdctl-rewritten-*-"+filepath.Base(composePath))
+		if err != nil {
+			return err
+		}
+		rewrittenPath = file.Name()
+		_, err = file.Write(rewritten)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(rewrittenPath)
+		}
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	cleanups := []cliparse.CleanupFunc{func() error {
+		return asUser(func() error { return os.Remove(rewrittenPath) })
+	}}
+	return rewrittenPath, cleanups, nil
+}
+
+// register wraps the handler for compose files so that the images in them are
+// rewritten.  This must be called after any other handlers are registered.
+func (r *imageRewriter) register(registry *cliparse.Registry) error {
+	if len(r.rules) == 0 {
+		return nil
+	}
+	command, ok := registry.Command("compose")
+	if !ok {
+		return nil
+	}
+	handler := command.Options["--file"]
+	if handler == nil {
+		return nil
+	}
+	return registry.RegisterArgHandler("compose", "--file", func(arg string) (string, []cliparse.CleanupFunc, error) {
+		if arg == "-" {
+			return handler(arg)
+		}
+		rewritten, cleanups, err := r.rewriteComposeFile(arg)
+		if err != nil {
+			log.Printf("Could not rewrite images in %s: %s", arg, err)
+			return handler(arg)
+		}
+		converted, newCleanups, err := handler(rewritten)
+		// Remove the rewritten copy after any other cleanups.
+		return converted, append(newCleanups, cleanups...), err
+	})
+}
+
+// insertOptions inserts the given arguments before the positional arguments of
+// the parse result.
+func insertOptions(result *cliparse.Result, options ...string) {
+	index := len(result.Args) - len(result.Positionals)
+	args := append([]string{}, result.Args[:index]...)
+	args = append(args, options...)
+	result.Args = append(args, result.Args[index:]...)
+}
+
+// findOption returns the value of the last instance of the given option for the
+// given command in the parse result.
+func findOption(registry *cliparse.Registry, result *cliparse.Result, commandPath, name string) (string, bool) {
+	value, found := "", false
+	for _, option := range result.Options {
+		if option.Command == commandPath && contains(optionNames(registry, option), name) {
+			value, found = option.Value, true
+		}
+	}
+	return value, found
+}
+
+// rewriteResult rewrites the images referred to by the parse result: the
+// positional arguments of image commands, the Dockerfile for `image build`,
+// and the default compose file (explicit compose files are handled by the
+// registered handler).  Any new files are passed through the path handlers.
+func (r *imageRewriter) rewriteResult(registry *cliparse.Registry, handlers *argHandlers, result *cliparse.Result) error {
+	if len(r.rules) == 0 {
+		return nil
+	}
+	if count, ok := imagePositionals[result.CommandPath]; ok {
+		start := len(result.Args) - len(result.Positionals)
+		positionals := append([]string{}, result.Positionals...)
+		for i, positional := range positionals {
+			if (count >= 0 && i >= count) || strings.HasPrefix(positional, "-") {
+				break
+			}
+			positionals[i] = r.rewrite(positional)
+		}
+		copy(result.Args[start:], positionals)
+		result.Positionals = positionals
+	}
+	switch {
+	case result.CommandPath == "image build":
+		return r.rewriteBuild(registry, handlers, result)
+	case strings.HasPrefix(result.CommandPath, "compose "):
+		return r.rewriteDefaultCompose(registry, handlers, result)
+	}
+	return nil
+}
+
+// buildDockerfile returns the path of the Dockerfile for `image build`; if it is
+// not a local file (it is read from standard input, or the build context is
+// remote or missing), false is returned.
+func buildDockerfile(registry *cliparse.Registry, result *cliparse.Result) (string, bool) {
+	dockerfile, ok := findOption(registry, result, "image build", "--file")
+	if !ok {
+		if len(result.Positionals) == 0 {
+			return "", false
+		}
+		buildContext := result.Positionals[0]
+		if match, _ := regexp.MatchString(`^[^:/]*://`, buildContext); match || buildContext == "-" {
+			return "", false
+		}
+		dockerfile = filepath.Join(buildContext, "Dockerfile")
+	}
+	return dockerfile, dockerfile != "-"
+}
+
+// rewriteBuild rewrites the Dockerfile for `image build`; if any images were
+// changed, a rewritten copy is passed to nerdctl instead.
+func (r *imageRewriter) rewriteBuild(registry *cliparse.Registry, handlers *argHandlers, result *cliparse.Result) error {
+	dockerfile, ok := buildDockerfile(registry, result)
+	if !ok {
+		return nil
+	}
+	contents, err := readUserFile(dockerfile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Let nerdctl report the error.
+			return nil
+		}
+		return err
+	}
+	rewritten, changed := r.rewriteDockerfile(contents)
+	if !changed {
+		return nil
+	}
+	// The rewritten file is placed in its own directory, as directories can be
+	// handled by all path translators.  As the temporary directory is chosen by
+	// the caller, it is only accessed as the real user.
+	var dir string
+	err = asUser(func() error {
+		if dir, err = os.MkdirTemp("", "nerdctl-build-*"); err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dir, "Dockerfile"), rewritten, 0o644); err != nil {
+			_ = os.RemoveAll(dir)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	removeDir := func() error {
+		return asUser(func() error { return os.RemoveAll(dir) })
+	}
+	converted, cleanups, err := handlers.filePathArgHandler(dir)
+	result.Cleanup = append(result.Cleanup, append(cleanups, removeDir)...)
+	if err != nil {
+		return err
+	}
+	// nerdctl uses the last `--file` given.
+	insertOptions(result, "--file", path.Join(converted, "Dockerfile"))
+	return nil
+}
+
+// rewriteDefaultCompose rewrites the default compose file, if no compose files
+// were given explicitly.
+func (r *imageRewriter) rewriteDefaultCompose(registry *cliparse.Registry, handlers *argHandlers, result *cliparse.Result) error {
+	if _, ok := findOption(registry, result, "compose", "--file"); ok {
+		return nil
+	}
+	composePath := defaultComposeFile(registry, result)
+	if composePath == "" {
+		return nil
+	}
+	rewritten, cleanups, err := r.rewriteComposeFile(composePath)
+	if err != nil || rewritten == composePath {
+		return err
+	}
+	converted, newCleanups, err := handlers.filePathArgHandler(rewritten)
+	result.Cleanup = append(result.Cleanup, append(newCleanups, cleanups...)...)
+	if err != nil {
+		return err
+	}
+	insertOptions(result, "--file", converted)
+	return nil
+}
+
+// defaultComposeFile returns the compose file nerdctl uses when none are given
+// explicitly, or an empty string if there is none.
+func defaultComposeFile(registry *cliparse.Registry, result *cliparse.Result) string {
+	projectDir, ok := findOption(registry, result, "compose", "--project-directory")
+	if !ok {
+		projectDir = "."
+	}
+	for _, name := range composeFileNames {
+		composePath := filepath.Join(projectDir, name)
+		// The directory is chosen by the caller, so only look as them.
+		err := asUser(func() error {
+			_, err := os.Stat(composePath)
+			return err
+		})
+		if err == nil {
+			return composePath
+		}
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+func newTestImageRewriter(t *testing.T) *imageRewriter {
+	rewriter, err := newImageRewriter([]imageRewriteRule{
+		{Prefix: "docker.io/library/", Replacement: "mirror.example.com/hub/"},
+		{Regex: `^ghcr\.io/(\w+)/`, Replacement: "mirror.example.com/ghcr-$1/"},
+	}, nil)
+	require.NoError(t, err)
+	return rewriter
+}
+
+func TestNewImageRewriter(t *testing.T) {
+	t.Parallel()
+	for _, rule := range []imageRewriteRule{
+		{Replacement: "x"},
+		{Prefix: "a", Regex: "b", Replacement: "x"},
+		{Regex: "(", Replacement: "x"},
+	} {
+		_, err := newImageRewriter([]imageRewriteRule{rule}, nil)
+		assert.Error(t, err, "%+v", rule)
+	}
+}
+
+func TestImageRewrite(t *testing.T) {
+	t.Parallel()
+	output := &bytes.Buffer{}
+	rewriter := newTestImageRewriter(t)
+	rewriter.output = output
+	for input, expected := range map[string]string{
+		"nginx":                      "mirror.example.com/hub/nginx",
+		"nginx:1.21":                 "mirror.example.com/hub/nginx:1.21",
+		"docker.io/library/alpine@x": "mirror.example.com/hub/alpine@x",
+		"ghcr.io/org/app:v1":         "mirror.example.com/ghcr-org/app:v1",
+		"someone/app":                "someone/app",
+		"quay.io/org/app":            "quay.io/org/app",
+	} {
+		assert.Equal(t, expected, rewriter.rewrite(input), input)
+	}
+	assert.Contains(t, output.String(), "Rewrote image nginx to mirror.example.com/hub/nginx\n")
+	assert.NotContains(t, output.String(), "someone/app")
+}
+
+func TestRewriteDockerfile(t *testing.T) {
+	t.Parallel()
+	rewriter := newTestImageRewriter(t)
+	input := strings.Join([]string{
+		"ARG BASE=alpine",
+		"FROM --platform=$BUILDPLATFORM golang:1.17 AS builder",
+		"RUN go build",
+		"from builder as test",
+		"FROM ${BASE}",
+		"FROM scratch",
+		"FROM ghcr.io/org/runtime\r",
+		"COPY --from=builder /app /app",
+		"",
+	}, "\n")
+	expected := strings.Join([]string{
+		"ARG BASE=alpine",
+		"FROM --platform=$BUILDPLATFORM mirror.example.com/hub/golang:1.17 AS builder",
+		"RUN go build",
+		"from builder as test",
+		"FROM ${BASE}",
+		"FROM scratch",
+		"FROM mirror.example.com/ghcr-org/runtime\r",
+		"COPY --from=builder /app /app",
+		"",
+	}, "\n")
+	actual, changed := rewriter.rewriteDockerfile([]byte(input))
+	assert.True(t, changed)
+	assert.Equal(t, expected, string(actual))
+
+	_, changed = rewriter.rewriteDockerfile([]byte("FROM quay.io/org/app\n"))
+	assert.False(t, changed)
+}
+
+func TestRewriteCompose(t *testing.T) {
+	t.Parallel()
+	rewriter := newTestImageRewriter(t)
+	input := "services:\n  web:\n    image: nginx # the web server\n    ports: [\"80:80\"]\n  app:\n    build: .\n  db:\n    image: ${DB_IMAGE}\n"
+	actual, changed, err := rewriter.rewriteCompose([]byte(input))
+	if assert.NoError(t, err) && assert.True(t, changed) {
+		assert.Contains(t, string(actual), "image: mirror.example.com/hub/nginx # the web server\n")
+		assert.Contains(t, string(actual), "image: ${DB_IMAGE}\n")
+		assert.Contains(t, string(actual), "build: .\n")
+	}
+	_, changed, err = rewriter.rewriteCompose([]byte("services:\n  web:\n    image: quay.io/x\n"))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	_, _, err = rewriter.rewriteCompose([]byte("services: [\n"))
+	assert.Error(t, err)
+}
+
+func TestImageRewriteResult(t *testing.T) {
+	t.Parallel()
+	rewriter := newTestImageRewriter(t)
+	handlers := &argHandlers{directPathTranslator{}}
+	registry, err := newRegistry(handlers)
+	require.NoError(t, err)
+	require.NoError(t, rewriter.register(registry))
+
+	t.Run("positionals", func(t *testing.T) {
+		t.Parallel()
+		testCases := map[string][]string{
+			"run --rm nginx nginx -g daemon": {"run", "--rm", "mirror.example.com/hub/nginx", "nginx", "-g", "daemon"},
+			"tag nginx ghcr.io/org/nginx":    {"tag", "mirror.example.com/hub/nginx", "mirror.example.com/ghcr-org/nginx"},
+			"save nginx alpine -o out":       {"save", "mirror.example.com/hub/nginx", "mirror.example.com/hub/alpine", "-o", "out"},
+			"rmi nginx alpine":               {"rmi", "mirror.example.com/hub/nginx", "mirror.example.com/hub/alpine"},
+			"image inspect nginx":            {"image", "inspect", "nginx"},
+		}
+		for input, expected := range testCases {
+			result, err := registry.Parse(strings.Split(input, " "))
+			require.NoError(t, err, input)
+			require.NoError(t, rewriter.rewriteResult(registry, handlers, result), input)
+			assert.Equal(t, expected, result.Args, input)
+		}
+	})
+
+	t.Run("build", func(t *testing.T) {
+		t.Parallel()
+		buildContext := t.TempDir()
+		dockerfile := filepath.Join(buildContext, "Dockerfile")
+		require.NoError(t, os.WriteFile(dockerfile, []byte("FROM nginx\n"), 0o644))
+		result, err := registry.Parse([]string{"build", "-t", "me/app", buildContext})
+		require.NoError(t, err)
+		require.NoError(t, rewriter.rewriteResult(registry, handlers, result))
+		require.Len(t, result.Args, 6)
+		assert.Equal(t, []string{"build", "-t", "me/app", "--file"}, result.Args[:4])
+		assert.Equal(t, buildContext, result.Args[5])
+		contents, err := os.ReadFile(result.Args[4])
+		if assert.NoError(t, err) {
+			assert.Equal(t, "FROM mirror.example.com/hub/nginx\n", string(contents))
+		}
+		cliparse.RunCleanups(result.Cleanup)
+		assert.NoFileExists(t, result.Args[4])
+
+		// Unchanged Dockerfiles are used as-is.
+		otherDockerfile := filepath.Join(buildContext, "Other.Dockerfile")
+		require.NoError(t, os.WriteFile(otherDockerfile, []byte("FROM quay.io/x\n"), 0o644))
+		result, err = registry.Parse([]string{"build", "-f", otherDockerfile, buildContext})
+		require.NoError(t, err)
+		require.NoError(t, rewriter.rewriteResult(registry, handlers, result))
+		assert.Equal(t, []string{"build", "-f", otherDockerfile, buildContext}, result.Args)
+	})
+
+	t.Run("compose", func(t *testing.T) {
+		t.Parallel()
+		projectDir := t.TempDir()
+		composeFile := filepath.Join(projectDir, "compose.yaml")
+		require.NoError(t, os.WriteFile(composeFile, []byte("services:\n  web:\n    image: nginx\n"), 0o644))
+
+		result, err := registry.Parse([]string{"compose", "-f", composeFile, "up"})
+		require.NoError(t, err)
+		require.NoError(t, rewriter.rewriteResult(registry, handlers, result))
+		require.Len(t, result.Args, 4)
+		rewritten := result.Args[2]
+		assert.Equal(t, projectDir, filepath.Dir(rewritten))
+		contents, err := os.ReadFile(rewritten)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "services:\n  web:\n    image: mirror.example.com/hub/nginx\n", string(contents))
+		}
+		cliparse.RunCleanups(result.Cleanup)
+		assert.NoFileExists(t, rewritten)
+
+		result, err = registry.Parse([]string{"compose", "--project-directory", projectDir, "up", "-d"})
+		require.NoError(t, err)
+		require.NoError(t, rewriter.rewriteResult(registry, handlers, result))
+		require.Len(t, result.Args, 7)
+		assert.Equal(t, []string{"compose", "--project-directory", projectDir, "up", "-d", "--file"}, result.Args[:6])
+		assert.Equal(t, projectDir, filepath.Dir(result.Args[6]))
+		cliparse.RunCleanups(result.Cleanup)
+		assert.NoFileExists(t, result.Args[6])
+	})
+}
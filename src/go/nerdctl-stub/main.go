@@ -1,52 +1,302 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"log"
 	"os"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
 )
 
 type spawnOptions struct {
+	// transport is the kind of transport used to run nerdctl.
+	transport transportType
 	// distro is the name of the WSL distribution for rancher-desktop.
 	distro string
 	// nerdctl is the full path to a Linux-native nerdctl executable.
 	nerdctl string
 	// containerdSocket contains the path to the containerd socket.
 	containerdSocket string
+	// context is the name of the selected context.
+	context string
+	// namespace is the containerd namespace from the context, if any.
+	namespace string
+	// env are extra environment variables for nerdctl, as `NAME=value`.
+	env []string
 	// args are the parsed arguments for the WSL executable.
-	args *parsedArgs
+	args *cliparse.Result
+	// formatter renders the output of nerdctl, if the user gave a template.
+	formatter *templateFormatter
+}
+
+// spawn runs nerdctl with the parsed arguments, and returns its exit code.
+func spawn(t transport, opts spawnOptions) (int, error) {
+	if opts.formatter == nil {
+		exitCode, err := t.run(opts.args.Args, opts.env, os.Stdin, os.Stdout, os.Stderr)
+		cliparse.RunCleanups(opts.args.Cleanup)
+		return exitCode, err
+	}
+	output := &bytes.Buffer{}
+	exitCode, err := t.run(opts.args.Args, opts.env, os.Stdin, output, os.Stderr)
+	cliparse.RunCleanups(opts.args.Cleanup)
+	if err != nil || exitCode != 0 {
+		_, _ = io.Copy(os.Stdout, output)
+		return exitCode, err
+	}
+	return exitCode, opts.formatter.render(output, os.Stdout)
+}
+
+// selectContext applies the selected context to the options, and returns the
+// arguments with any `--context` option removed.  If the command is `nerdctl
+// context`, it is run and the process exits.
+func selectContext(opts *spawnOptions) ([]string, error) {
+	contextName, args, err := extractContextOption(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if contextName == "" {
+		contextName = os.Getenv("NERDCTL_CONTEXT")
+	}
+	// Without a configuration directory, only the default context exists.
+	store := &contextStore{}
+	if contextsFile, err := contextsPath(); err == nil {
+		if store, err = loadContexts(contextsFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) > 0 && args[0] == "context" {
+		// Contexts are per-user, so they are managed without privileges.
+		if err = dropPrivileges(); err != nil {
+			return nil, err
+		}
+		if err = handleContextCommand(store, args[1:], *opts, os.Stdout); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+	name, context, err := store.lookup(contextName)
+	if err != nil {
+		return nil, err
+	}
+	opts.context = name
+	if context != nil {
+		context.apply(opts)
+	}
+	return args, nil
+}
+
+// readUserFile reads a file named by the caller, with the caller's permissions.
+func readUserFile(name string) ([]byte, error) {
+	var contents []byte
+	err := asUser(func() error {
+		var err error
+		contents, err = os.ReadFile(name)
+		return err
+	})
+	return contents, err
 }
 
 func main() {
+	config, configErrs := loadConfig()
+	for _, err := range configErrs {
+		log.Printf("Error loading configuration: %s", err)
+	}
+	pol, err := loadPolicy()
+	if err != nil {
+		log.Fatalf("Error loading policy: %s", err)
+	}
+	// The audit log must be opened before any privileges are dropped.
+	auditSettings, err := loadAuditConfig()
+	if err != nil {
+		log.Printf("Error loading audit configuration: %s", err)
+	}
+	audit, err := openAuditLog(auditSettings)
+	if err != nil {
+		log.Printf("Error opening audit log: %s", err)
+	}
+
 	opts := spawnOptions{
-		distro:  os.Getenv("RD_WSL_DISTRO"),
-		nerdctl: os.Getenv("RD_NERDCTL"),
+		transport:        transportType(os.Getenv("RD_NERDCTL_TRANSPORT")),
+		distro:           os.Getenv("RD_WSL_DISTRO"),
+		nerdctl:          os.Getenv("RD_NERDCTL"),
+		containerdSocket: config.Transport.ContainerdSocket,
+	}
+	if opts.transport == "" {
+		opts.transport = config.Transport.Type
 	}
 	if opts.distro == "" {
 		opts.distro = "rancher-desktop"
 	}
+	if opts.nerdctl == "" {
+		opts.nerdctl = config.Transport.Nerdctl
+	}
 	if opts.nerdctl == "" {
 		opts.nerdctl = "/usr/local/bin/nerdctl"
 	}
-	opts.containerdSocket = "/run/k3s/containerd/containerd.sock"
+	if opts.containerdSocket == "" {
+		opts.containerdSocket = "/run/k3s/containerd/containerd.sock"
+	}
+
+	userArgs, err := selectContext(&opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nerdctl: %s\n", err)
+		os.Exit(1)
+	}
+
+	t, err := newTransport(opts, config.Transport)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handlers := &argHandlers{t.paths()}
+	registry, err := setupRegistry(handlers, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var rewriteOutput io.Writer
+	if config.PrintImageRewrites || os.Getenv("RD_NERDCTL_PRINT_REWRITES") != "" {
+		rewriteOutput = os.Stderr
+	}
+	rewriter, err := newImageRewriter(config.ImageRewrites, rewriteOutput)
+	if err != nil {
+		log.Printf("Error in configuration: %s", err)
+		rewriter, _ = newImageRewriter(nil, nil)
+	}
+	if pol != nil {
+		pol.rewriter = rewriter
+	}
+	if err = rewriter.register(registry); err != nil {
+		log.Printf("Error setting up image rewriting: %s", err)
+	}
+	if config.BuildContextSync.enabled() {
+		syncer := newContextSyncer(t, config.BuildContextSync, os.Stderr)
+		if err = syncer.register(registry); err != nil {
+			log.Printf("Error setting up build context sync: %s", err)
+		}
+	}
+
+	handled, err := handleCompletion(t, registry, userArgs, os.Stdout)
+	if handled {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	rawArgs, explain, parseJSON := userArgs, false, false
+	if len(rawArgs) > 0 && rawArgs[0] == explainOption {
+		rawArgs, explain = rawArgs[1:], true
+	} else if len(rawArgs) > 0 && rawArgs[0] == parseJSONOption {
+		rawArgs, parseJSON = rawArgs[1:], true
+		if len(rawArgs) == 0 {
+			if rawArgs, err = readArgsJSON(os.Stdin); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if opts.namespace != "" {
+		// Options given explicitly come later, and take precedence.
+		rawArgs = append([]string{"--namespace", opts.namespace}, rawArgs...)
+	}
+	expandedArgs, steps, err := expandArgs(registry, config, rawArgs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if explain {
+		explainArgs(os.Stderr, opts, steps, expandedArgs)
+		return
+	}
+	if parseJSON {
+		if err = writeParseReport(os.Stdout, config, pol, expandedArgs); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	args, err := parseArgs()
-	if err == nil {
+	args, parseErr := parseArgs(registry, handlers, expandedArgs)
+	if parseErr == nil {
 		opts.args = args
 	} else {
+		opts.args = &cliparse.Result{Args: expandedArgs}
+		if pol != nil {
+			// With a policy, we can't run anything we could not check.
+			record := newAuditRecord(registry, opts.args)
+			record.Denied = []string{fmt.Sprintf("could not parse arguments: %s", parseErr)}
+			record.ExitCode = 1
+			_ = audit.write(record)
+			log.Fatalf("Denied by policy %s: could not parse arguments: %s", pol.path, parseErr)
+		}
 		// If we fail to parse, display an error but still run nerdctl
-		log.Printf("Error parsing arguments: %s", err)
-		opts.args = &parsedArgs{args: os.Args[1:]}
+		log.Printf("Error parsing arguments: %s", parseErr)
 	}
 
 	defer func() {
-		err := cleanupParseArgs()
+		err := handlers.cleanup()
 		if err != nil {
 			log.Fatal(err)
 		}
 	}()
 
-	err = spawn(opts)
+	if err = rewriter.rewriteResult(registry, handlers, opts.args); err != nil {
+		log.Printf("Error rewriting images: %s", err)
+	}
+	if opts.formatter, err = newTemplateFormatter(registry, opts.args); err != nil {
+		fmt.Fprintf(os.Stderr, "nerdctl: %s\n", err)
+		cliparse.RunCleanups(opts.args.Cleanup)
+		_ = handlers.cleanup()
+		os.Exit(1)
+	}
+	// The audit record is built after rewriting, so that the images recorded
+	// are the ones actually used.
+	record := newAuditRecord(registry, opts.args)
+	if parseErr != nil {
+		record.Error = fmt.Sprintf("could not parse arguments: %s", parseErr)
+	}
+
+	if pol != nil {
+		if denials := pol.check(registry, opts.args); len(denials) > 0 {
+			for _, denial := range denials {
+				fmt.Fprintf(os.Stderr, "nerdctl: denied by policy %s: %s\n", pol.path, denial)
+			}
+			record.Denied = denials
+			record.ExitCode = 1
+			if err = audit.write(record); err != nil {
+				log.Printf("Error writing audit log: %s", err)
+			}
+			cliparse.RunCleanups(opts.args.Cleanup)
+			_ = handlers.cleanup()
+			os.Exit(1)
+		}
+	}
+
+	if !config.Credentials.Disabled && parseErr == nil {
+		bridge, err := newCredentialBridge(config.Credentials)
+		if err == nil {
+			// Files staged over SSH are not copied back, so logins stay on
+			// the remote host.
+			opts.env, err = bridge.prepare(handlers, opts.args, opts.transport != transportSSH)
+		}
+		if err != nil {
+			log.Printf("Error bridging credentials: %s", err)
+		}
+	}
+
+	exitCode, err := spawn(t, opts)
+	record.ExitCode = exitCode
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if auditErr := audit.write(record); auditErr != nil {
+		log.Printf("Error writing audit log: %s", auditErr)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	if exitCode != 0 {
+		_ = handlers.cleanup()
+		os.Exit(exitCode)
+	}
 }
@@ -9,40 +9,23 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
 	"golang.org/x/sys/unix"
 )
 
-func spawn(opts spawnOptions) error {
-	args := []string{"--distribution", opts.distro, "--exec", opts.nerdctl, "--address", opts.containerdSocket}
-	args = append(args, opts.args.args...)
-	log.Printf("running: %+v", args)
-	cmd := exec.Command("wsl.exe", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	for _, cleanup := range opts.args.cleanup {
-		if cleanupErr := cleanup(); cleanupErr != nil {
-			log.Printf("Error cleaning up: %s", cleanupErr)
-		}
-	}
-	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			os.Exit(exitErr.ExitCode())
-		} else {
-			return err
-		}
-	}
-	return nil
+// wslPathTranslator translates paths for the wsl transport.  On Linux, paths
+// are bind mounted into a shared directory so that they can be seen from the
+// rancher-desktop distribution.
+type wslPathTranslator struct {
+	// workdir is the directory containing the mounts for this invocation.
+	workdir string
 }
 
-var workdir string
-
-// function prepareParseArgs should be called before argument parsing to set up
-// the system for arg parsing.
-func prepareParseArgs() error {
+// prepare should be called before argument parsing to set up the system for
+// arg parsing.
+func (h *wslPathTranslator) prepare() error {
 	if os.Geteuid() != 0 {
 		return fmt.Errorf("Got unexpected euid %v", os.Geteuid())
 	}
@@ -55,17 +38,17 @@ func prepareParseArgs() error {
 	if err != nil {
 		return err
 	}
-	workdir = d
+	h.workdir = d
 	return nil
 }
 
-// function cleanupParseArgs should be called after the command finishes
-// (regardless of whether it succeeded) to clean up any resources.
-func cleanupParseArgs() error {
-	if workdir == "" {
+// cleanup should be called after the command finishes (regardless of whether
+// it succeeded) to clean up any resources.
+func (h *wslPathTranslator) cleanup() error {
+	if h.workdir == "" {
 		return nil
 	}
-	entries, err := os.ReadDir(workdir)
+	entries, err := os.ReadDir(h.workdir)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return nil
@@ -73,7 +56,7 @@ func cleanupParseArgs() error {
 		return err
 	}
 	for _, entry := range entries {
-		entryPath := filepath.Join(workdir, entry.Name())
+		entryPath := filepath.Join(h.workdir, entry.Name())
 		err = unix.Unmount(entryPath, 0)
 		if err != nil {
 			log.Printf("Error unmounting %s: %s", entryPath, err)
@@ -83,7 +66,7 @@ func cleanupParseArgs() error {
 			log.Printf("Error removing mount directory %s: %s", entryPath, err)
 		}
 	}
-	err = os.Remove(workdir)
+	err = os.Remove(h.workdir)
 	if err != nil {
 		return err
 	}
@@ -91,7 +74,7 @@ func cleanupParseArgs() error {
 }
 
 // volumeArgHandler handles the argument for `nerdctl run --volume=...`
-func volumeArgHandler(arg string) (string, []cleanupFunc, error) {
+func (h *wslPathTranslator) volumeArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
 	// args is of format [host:]container[:ro|:rw]
 	readWrite := ""
 	if strings.HasSuffix(arg, ":rw") || strings.HasSuffix(arg, ":ro") {
@@ -110,7 +93,7 @@ func volumeArgHandler(arg string) (string, []cleanupFunc, error) {
 		containerPath = arg[colonIndex+1:]
 	}
 
-	mountDir, err := os.MkdirTemp(workdir, "mount.*")
+	mountDir, err := os.MkdirTemp(h.workdir, "mount.*")
 	if err != nil {
 		return "", nil, err
 	}
@@ -122,8 +105,8 @@ func volumeArgHandler(arg string) (string, []cleanupFunc, error) {
 }
 
 // filePathArgHandler handles arguments that take a file path for input
-func filePathArgHandler(arg string) (string, []cleanupFunc, error) {
-	result, err := os.MkdirTemp(workdir, "input.*")
+func (h *wslPathTranslator) filePathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	result, err := os.MkdirTemp(h.workdir, "input.*")
 	if err != nil {
 		return "", nil, err
 	}
@@ -136,8 +119,8 @@ func filePathArgHandler(arg string) (string, []cleanupFunc, error) {
 
 // outputPathArgHandler handles arguments that take a file path to indicate
 // where some file should be output.
-func outputPathArgHandler(arg string) (string, []cleanupFunc, error) {
-	file, err := os.CreateTemp(workdir, "output.*")
+func (h *wslPathTranslator) outputPathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	file, err := os.CreateTemp(h.workdir, "output.*")
 	if err != nil {
 		return "", nil, err
 	}
@@ -174,5 +157,61 @@ func outputPathArgHandler(arg string) (string, []cleanupFunc, error) {
 		}
 		return nil
 	}
-	return file.Name(), []cleanupFunc{callback}, nil
+	return file.Name(), []cliparse.CleanupFunc{callback}, nil
+}
+
+// systemConfigDir returns the directory containing system-wide configuration.
+func systemConfigDir() string {
+	return "/etc/rancher-desktop"
+}
+
+// dropPrivileges switches to the real user and group.  As the executable is
+// setuid, this is used by the transports that do not need to set up mounts.
+func dropPrivileges() error {
+	if err := unix.Setresgid(os.Getgid(), os.Getgid(), os.Getgid()); err != nil {
+		return fmt.Errorf("could not drop group privileges: %w", err)
+	}
+	if err := unix.Setresuid(os.Getuid(), os.Getuid(), os.Getuid()); err != nil {
+		return fmt.Errorf("could not drop user privileges: %w", err)
+	}
+	return nil
+}
+
+// asUser runs the given function with the effective user and group switched
+// to the real ones, so that files are accessed with the user's permissions
+// rather than those of the (setuid) executable; the privileges are restored
+// afterwards.
+func asUser(f func() error) error {
+	uid, euid, gid, egid := os.Getuid(), os.Geteuid(), os.Getgid(), os.Getegid()
+	if uid == euid && gid == egid {
+		return f()
+	}
+	// The syscall package applies these to all threads.
+	if err := syscall.Setresgid(-1, gid, -1); err != nil {
+		return fmt.Errorf("could not switch to the real group: %w", err)
+	}
+	if err := syscall.Setresuid(-1, uid, -1); err != nil {
+		_ = syscall.Setresgid(-1, egid, -1)
+		return fmt.Errorf("could not switch to the real user: %w", err)
+	}
+	result := f()
+	if err := syscall.Setresuid(-1, euid, -1); err != nil {
+		log.Fatalf("Could not restore user privileges: %s", err)
+	}
+	if err := syscall.Setresgid(-1, egid, -1); err != nil {
+		log.Fatalf("Could not restore group privileges: %s", err)
+	}
+	return result
+}
+
+// userCommand creates a command that runs as the real user, rather than with
+// the privileges of the (setuid) executable.
+func userCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	if os.Geteuid() != os.Getuid() {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())},
+		}
+	}
+	return cmd
 }
@@ -3,29 +3,59 @@
 
 package main
 
+import (
+	"os/exec"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
 // This file is a stub for unsupported platforms to make IDEs happy.
 
+// wslPathTranslator translates paths for the wsl transport.
+type wslPathTranslator struct{}
+
 // unhandledArgHandler is a handler for unsupported arguments.
-func unhandledArgHandler(arg string) (string, []cleanupFunc, error) {
+func unhandledArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
 	panic("Plaform is unsupported")
 }
 
-var volumeArgHandler = unhandledArgHandler
-var filePathArgHandler = unhandledArgHandler
-var outputPathArgHandler = unhandledArgHandler
+func (h *wslPathTranslator) volumeArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	return unhandledArgHandler(arg)
+}
+
+func (h *wslPathTranslator) filePathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	return unhandledArgHandler(arg)
+}
+
+func (h *wslPathTranslator) outputPathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	return unhandledArgHandler(arg)
+}
+
+func dropPrivileges() error {
+	panic("Platform is unsupported")
+}
+
+// prepare should be called before argument parsing to set up the system for
+// arg parsing.
+func (h *wslPathTranslator) prepare() error {
+	panic("Platform is unsupported")
+}
+
+// cleanup should be called after the command finishes (regardless of whether
+// it succeeded) to clean up any resources.
+func (h *wslPathTranslator) cleanup() error {
+	panic("Platform is unsupported")
+}
 
-func spawn(opts spawnOptions) error {
+// systemConfigDir returns the directory containing system-wide configuration.
+func systemConfigDir() string {
 	panic("Platform is unsupported")
 }
 
-// function prepareParseArgs should be called before argument parsing to set up
-// the system for arg parsing.
-func prepareParseArgs() error {
+func asUser(f func() error) error {
 	panic("Platform is unsupported")
 }
 
-// function cleanupParseArgs should be called after the command finishes
-// (regardless of whether it succeeded) to clean up any resources.
-func cleanupParseArgs() error {
+func userCommand(name string, args ...string) *exec.Cmd {
 	panic("Platform is unsupported")
 }
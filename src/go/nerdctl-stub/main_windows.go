@@ -2,48 +2,28 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
 )
 
-func spawn(opts spawnOptions) error {
-	args := []string{"--distribution", opts.distro, "--exec", opts.nerdctl, "--address", opts.containerdSocket}
-	args = append(args, opts.args.args...)
-	cmd := exec.Command("wsl.exe", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	for _, handler := range opts.args.cleanup {
-		cleanupErr := handler()
-		if cleanupErr != nil {
-			log.Printf("Error cleaning up: %s", cleanupErr)
-		}
-	}
-	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			os.Exit(exitErr.ExitCode())
-		} else {
-			return err
-		}
-	}
-	return nil
-}
+// wslPathTranslator translates paths for the wsl transport.  On Windows, paths
+// are converted to their /mnt/... equivalents.
+type wslPathTranslator struct{}
 
-// function prepareParseArgs should be called before argument parsing to set up
-// the system for arg parsing.
-func prepareParseArgs() error {
+// prepare should be called before argument parsing to set up the system for
+// arg parsing.
+func (h *wslPathTranslator) prepare() error {
 	// Nothing is required on Windows.
 	return nil
 }
 
-// function cleanupParseArgs should be called after the command finishes
-// (regardless of whether it succeeded) to clean up any resources.
-func cleanupParseArgs() error {
+// cleanup should be called after the command finishes (regardless of whether
+// it succeeded) to clean up any resources.
+func (h *wslPathTranslator) cleanup() error {
 	// Nothing is required on Windows.
 	return nil
 }
@@ -66,7 +46,7 @@ func pathToWSL(arg string) (string, error) {
 }
 
 // volumeArgHandler handles the argument for `nerdctl run --volume=...`
-func volumeArgHandler(arg string) (string, []cleanupFunc, error) {
+func (h *wslPathTranslator) volumeArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
 	// Valid arguments are:
 	// <host path>:<container path>
 	// <host path>:<container path>:rw
@@ -95,7 +75,7 @@ func volumeArgHandler(arg string) (string, []cleanupFunc, error) {
 }
 
 // filePathArgHandler handles arguments that take a file path for input
-func filePathArgHandler(arg string) (string, []cleanupFunc, error) {
+func (h *wslPathTranslator) filePathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
 	result, err := pathToWSL(arg)
 	if err != nil {
 		return "", nil, err
@@ -105,10 +85,36 @@ func filePathArgHandler(arg string) (string, []cleanupFunc, error) {
 
 // outputPathArgHandler handles arguments that take a file path to indicate
 // where some file should be output.
-func outputPathArgHandler(arg string) (string, []cleanupFunc, error) {
+func (h *wslPathTranslator) outputPathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
 	result, err := pathToWSL(arg)
 	if err != nil {
 		return "", nil, err
 	}
 	return result, nil, nil
 }
+
+// systemConfigDir returns the directory containing system-wide configuration.
+func systemConfigDir() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		return ""
+	}
+	return filepath.Join(programData, "rancher-desktop")
+}
+
+// dropPrivileges switches to the real user; nothing is required on Windows.
+func dropPrivileges() error {
+	return nil
+}
+
+// asUser runs the given function as the real user; on Windows, this is the
+// current user.
+func asUser(f func() error) error {
+	return f()
+}
+
+// userCommand creates a command that runs as the real user; on Windows, this
+// is the current user.
+func userCommand(name string, args ...string) *exec.Cmd {
+	return exec.Command(name, args...)
+}
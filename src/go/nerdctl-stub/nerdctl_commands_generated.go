@@ -3,13 +3,15 @@
 // package main implements a stub for nerdctl
 package main
 
+import "github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+
 // commands supported by nerdctl; the key here is a space-separated subcommand
 // path to reach the given subcommand (where the root command is empty).
-var commands = map[string]commandDefinition{
+var commands = map[string]cliparse.Command{
 
 	"": {
-		commandPath: "",
-		subcommands: map[string]struct{}{
+		Path: "",
+		Subcommands: map[string]struct{}{
 			"run":        {},
 			"exec":       {},
 			"ps":         {},
@@ -49,114 +51,166 @@ var commands = map[string]commandDefinition{
 			"system":     {},
 			"namespace":  {},
 		},
-		options: map[string]argHandler{
-			"--address":           ignoredArgHandler,
-			"--cgroup-manager":    ignoredArgHandler,
-			"--cni-netconfpath":   ignoredArgHandler,
-			"--cni-path":          ignoredArgHandler,
-			"--data-root":         ignoredArgHandler,
+		Options: map[string]cliparse.ArgHandler{
+			"--address":           cliparse.IgnoredArgHandler,
+			"--cgroup-manager":    cliparse.IgnoredArgHandler,
+			"--cni-netconfpath":   cliparse.IgnoredArgHandler,
+			"--cni-path":          cliparse.IgnoredArgHandler,
+			"--data-root":         cliparse.IgnoredArgHandler,
 			"--debug":             nil,
 			"--debug-full":        nil,
 			"--help":              nil,
-			"--host":              ignoredArgHandler,
+			"--host":              cliparse.IgnoredArgHandler,
 			"--insecure-registry": nil,
-			"--namespace":         ignoredArgHandler,
-			"--snapshotter":       ignoredArgHandler,
-			"--storage-driver":    ignoredArgHandler,
+			"--namespace":         cliparse.IgnoredArgHandler,
+			"--snapshotter":       cliparse.IgnoredArgHandler,
+			"--storage-driver":    cliparse.IgnoredArgHandler,
 			"--version":           nil,
-			"-H":                  ignoredArgHandler,
-			"-a":                  ignoredArgHandler,
+			"-H":                  cliparse.IgnoredArgHandler,
+			"-a":                  cliparse.IgnoredArgHandler,
 			"-h":                  nil,
-			"-n":                  ignoredArgHandler,
+			"-n":                  cliparse.IgnoredArgHandler,
 			"-v":                  nil,
 		},
+		Synonyms: map[string][]string{
+			"--address":   {"--address", "--host", "-H", "-a"},
+			"--help":      {"--help", "-h"},
+			"--host":      {"--address", "--host", "-H", "-a"},
+			"--namespace": {"--namespace", "-n"},
+			"--version":   {"--version", "-v"},
+			"-H":          {"--address", "--host", "-H", "-a"},
+			"-a":          {"--address", "--host", "-H", "-a"},
+			"-h":          {"--help", "-h"},
+			"-n":          {"--namespace", "-n"},
+			"-v":          {"--version", "-v"},
+		},
 	},
 
 	"run": {
-		commandPath: "run",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--cap-add":      ignoredArgHandler,
-			"--cap-drop":     ignoredArgHandler,
-			"--cgroupns":     ignoredArgHandler,
-			"--cidfile":      ignoredArgHandler,
-			"--cpu-shares":   ignoredArgHandler,
-			"--cpus":         ignoredArgHandler,
-			"--cpuset-cpus":  ignoredArgHandler,
+		Path:        "run",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--cap-add":      cliparse.IgnoredArgHandler,
+			"--cap-drop":     cliparse.IgnoredArgHandler,
+			"--cgroupns":     cliparse.IgnoredArgHandler,
+			"--cidfile":      cliparse.IgnoredArgHandler,
+			"--cpu-shares":   cliparse.IgnoredArgHandler,
+			"--cpus":         cliparse.IgnoredArgHandler,
+			"--cpuset-cpus":  cliparse.IgnoredArgHandler,
 			"--detach":       nil,
-			"--device":       ignoredArgHandler,
-			"--dns":          ignoredArgHandler,
-			"--entrypoint":   ignoredArgHandler,
-			"--env":          ignoredArgHandler,
-			"--env-file":     ignoredArgHandler,
-			"--gpus":         ignoredArgHandler,
+			"--device":       cliparse.IgnoredArgHandler,
+			"--dns":          cliparse.IgnoredArgHandler,
+			"--entrypoint":   cliparse.IgnoredArgHandler,
+			"--env":          cliparse.IgnoredArgHandler,
+			"--env-file":     cliparse.IgnoredArgHandler,
+			"--gpus":         cliparse.IgnoredArgHandler,
 			"--help":         nil,
-			"--hostname":     ignoredArgHandler,
+			"--hostname":     cliparse.IgnoredArgHandler,
 			"--interactive":  nil,
-			"--label":        ignoredArgHandler,
-			"--label-file":   ignoredArgHandler,
-			"--memory":       ignoredArgHandler,
-			"--name":         ignoredArgHandler,
-			"--net":          ignoredArgHandler,
-			"--network":      ignoredArgHandler,
-			"--pid":          ignoredArgHandler,
-			"--pidfile":      ignoredArgHandler,
-			"--pids-limit":   ignoredArgHandler,
+			"--label":        cliparse.IgnoredArgHandler,
+			"--label-file":   cliparse.IgnoredArgHandler,
+			"--memory":       cliparse.IgnoredArgHandler,
+			"--name":         cliparse.IgnoredArgHandler,
+			"--net":          cliparse.IgnoredArgHandler,
+			"--network":      cliparse.IgnoredArgHandler,
+			"--pid":          cliparse.IgnoredArgHandler,
+			"--pidfile":      cliparse.IgnoredArgHandler,
+			"--pids-limit":   cliparse.IgnoredArgHandler,
 			"--privileged":   nil,
-			"--publish":      ignoredArgHandler,
-			"--pull":         ignoredArgHandler,
+			"--publish":      cliparse.IgnoredArgHandler,
+			"--pull":         cliparse.IgnoredArgHandler,
 			"--read-only":    nil,
-			"--restart":      ignoredArgHandler,
+			"--restart":      cliparse.IgnoredArgHandler,
 			"--rm":           nil,
 			"--rootfs":       nil,
-			"--runtime":      ignoredArgHandler,
-			"--security-opt": ignoredArgHandler,
-			"--shm-size":     ignoredArgHandler,
-			"--sysctl":       ignoredArgHandler,
+			"--runtime":      cliparse.IgnoredArgHandler,
+			"--security-opt": cliparse.IgnoredArgHandler,
+			"--shm-size":     cliparse.IgnoredArgHandler,
+			"--sysctl":       cliparse.IgnoredArgHandler,
 			"--tty":          nil,
-			"--user":         ignoredArgHandler,
-			"--volume":       ignoredArgHandler,
-			"--workdir":      ignoredArgHandler,
+			"--user":         cliparse.IgnoredArgHandler,
+			"--volume":       cliparse.IgnoredArgHandler,
+			"--workdir":      cliparse.IgnoredArgHandler,
 			"-d":             nil,
-			"-e":             ignoredArgHandler,
-			"-h":             ignoredArgHandler,
+			"-e":             cliparse.IgnoredArgHandler,
+			"-h":             cliparse.IgnoredArgHandler,
 			"-i":             nil,
-			"-l":             ignoredArgHandler,
-			"-m":             ignoredArgHandler,
-			"-p":             ignoredArgHandler,
+			"-l":             cliparse.IgnoredArgHandler,
+			"-m":             cliparse.IgnoredArgHandler,
+			"-p":             cliparse.IgnoredArgHandler,
 			"-t":             nil,
-			"-u":             ignoredArgHandler,
-			"-v":             ignoredArgHandler,
-			"-w":             ignoredArgHandler,
+			"-u":             cliparse.IgnoredArgHandler,
+			"-v":             cliparse.IgnoredArgHandler,
+			"-w":             cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--detach":      {"--detach", "-d"},
+			"--env":         {"--env", "-e"},
+			"--hostname":    {"--hostname", "-h"},
+			"--interactive": {"--interactive", "-i"},
+			"--label":       {"--label", "-l"},
+			"--memory":      {"--memory", "-m"},
+			"--net":         {"--net", "--network"},
+			"--network":     {"--net", "--network"},
+			"--publish":     {"--publish", "-p"},
+			"--tty":         {"--tty", "-t"},
+			"--user":        {"--user", "-u"},
+			"--volume":      {"--volume", "-v"},
+			"--workdir":     {"--workdir", "-w"},
+			"-d":            {"--detach", "-d"},
+			"-e":            {"--env", "-e"},
+			"-h":            {"--hostname", "-h"},
+			"-i":            {"--interactive", "-i"},
+			"-l":            {"--label", "-l"},
+			"-m":            {"--memory", "-m"},
+			"-p":            {"--publish", "-p"},
+			"-t":            {"--tty", "-t"},
+			"-u":            {"--user", "-u"},
+			"-v":            {"--volume", "-v"},
+			"-w":            {"--workdir", "-w"},
 		},
 	},
 
 	"exec": {
-		commandPath: "exec",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "exec",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--detach":      nil,
-			"--env":         ignoredArgHandler,
+			"--env":         cliparse.IgnoredArgHandler,
 			"--help":        nil,
 			"--interactive": nil,
 			"--privileged":  nil,
 			"--tty":         nil,
-			"--workdir":     ignoredArgHandler,
+			"--workdir":     cliparse.IgnoredArgHandler,
 			"-d":            nil,
-			"-e":            ignoredArgHandler,
+			"-e":            cliparse.IgnoredArgHandler,
 			"-h":            nil,
 			"-i":            nil,
 			"-t":            nil,
-			"-w":            ignoredArgHandler,
+			"-w":            cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--detach":      {"--detach", "-d"},
+			"--env":         {"--env", "-e"},
+			"--help":        {"--help", "-h"},
+			"--interactive": {"--interactive", "-i"},
+			"--tty":         {"--tty", "-t"},
+			"--workdir":     {"--workdir", "-w"},
+			"-d":            {"--detach", "-d"},
+			"-e":            {"--env", "-e"},
+			"-h":            {"--help", "-h"},
+			"-i":            {"--interactive", "-i"},
+			"-t":            {"--tty", "-t"},
+			"-w":            {"--workdir", "-w"},
 		},
 	},
 
 	"ps": {
-		commandPath: "ps",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "ps",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--all":      nil,
-			"--format":   ignoredArgHandler,
+			"--format":   cliparse.IgnoredArgHandler,
 			"--help":     nil,
 			"--no-trunc": nil,
 			"--quiet":    nil,
@@ -164,69 +218,107 @@ var commands = map[string]commandDefinition{
 			"-h":         nil,
 			"-q":         nil,
 		},
+		Synonyms: map[string][]string{
+			"--all":   {"--all", "-a"},
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-a":      {"--all", "-a"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"logs": {
-		commandPath: "logs",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "logs",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--follow":     nil,
 			"--help":       nil,
-			"--since":      ignoredArgHandler,
-			"--tail":       ignoredArgHandler,
+			"--since":      cliparse.IgnoredArgHandler,
+			"--tail":       cliparse.IgnoredArgHandler,
 			"--timestamps": nil,
-			"--until":      ignoredArgHandler,
+			"--until":      cliparse.IgnoredArgHandler,
 			"-f":           nil,
 			"-h":           nil,
-			"-n":           ignoredArgHandler,
+			"-n":           cliparse.IgnoredArgHandler,
 			"-t":           nil,
 		},
+		Synonyms: map[string][]string{
+			"--follow":     {"--follow", "-f"},
+			"--help":       {"--help", "-h"},
+			"--tail":       {"--tail", "-n"},
+			"--timestamps": {"--timestamps", "-t"},
+			"-f":           {"--follow", "-f"},
+			"-h":           {"--help", "-h"},
+			"-n":           {"--tail", "-n"},
+			"-t":           {"--timestamps", "-t"},
+		},
 	},
 
 	"port": {
-		commandPath: "port",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "port",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"stop": {
-		commandPath: "stop",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "stop",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
-			"--time": ignoredArgHandler,
+			"--time": cliparse.IgnoredArgHandler,
 			"-h":     nil,
-			"-t":     ignoredArgHandler,
+			"-t":     cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"--time": {"--time", "-t"},
+			"-h":     {"--help", "-h"},
+			"-t":     {"--time", "-t"},
 		},
 	},
 
 	"start": {
-		commandPath: "start",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "start",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"kill": {
-		commandPath: "kill",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "kill",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":   nil,
-			"--signal": ignoredArgHandler,
+			"--signal": cliparse.IgnoredArgHandler,
 			"-h":       nil,
-			"-s":       ignoredArgHandler,
+			"-s":       cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":   {"--help", "-h"},
+			"--signal": {"--signal", "-s"},
+			"-h":       {"--help", "-h"},
+			"-s":       {"--signal", "-s"},
 		},
 	},
 
 	"rm": {
-		commandPath: "rm",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "rm",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--force":   nil,
 			"--help":    nil,
 			"--volumes": nil,
@@ -234,216 +326,322 @@ var commands = map[string]commandDefinition{
 			"-h":        nil,
 			"-v":        nil,
 		},
+		Synonyms: map[string][]string{
+			"--force":   {"--force", "-f"},
+			"--help":    {"--help", "-h"},
+			"--volumes": {"--volumes", "-v"},
+			"-f":        {"--force", "-f"},
+			"-h":        {"--help", "-h"},
+			"-v":        {"--volumes", "-v"},
+		},
 	},
 
 	"pause": {
-		commandPath: "pause",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "pause",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"unpause": {
-		commandPath: "unpause",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "unpause",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"commit": {
-		commandPath: "commit",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--author":  ignoredArgHandler,
+		Path:        "commit",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--author":  cliparse.IgnoredArgHandler,
 			"--help":    nil,
-			"--message": ignoredArgHandler,
-			"-a":        ignoredArgHandler,
+			"--message": cliparse.IgnoredArgHandler,
+			"-a":        cliparse.IgnoredArgHandler,
 			"-h":        nil,
-			"-m":        ignoredArgHandler,
+			"-m":        cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--author":  {"--author", "-a"},
+			"--help":    {"--help", "-h"},
+			"--message": {"--message", "-m"},
+			"-a":        {"--author", "-a"},
+			"-h":        {"--help", "-h"},
+			"-m":        {"--message", "-m"},
 		},
 	},
 
 	"wait": {
-		commandPath: "wait",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "wait",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"build": {
-		commandPath: "build",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--build-arg":     ignoredArgHandler,
-			"--buildkit-host": ignoredArgHandler,
-			"--file":          ignoredArgHandler,
+		Path:        "build",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--build-arg":     cliparse.IgnoredArgHandler,
+			"--buildkit-host": cliparse.IgnoredArgHandler,
+			"--file":          cliparse.IgnoredArgHandler,
 			"--help":          nil,
 			"--no-cache":      nil,
-			"--progress":      ignoredArgHandler,
-			"--secret":        ignoredArgHandler,
-			"--ssh":           ignoredArgHandler,
-			"--tag":           ignoredArgHandler,
-			"--target":        ignoredArgHandler,
-			"-f":              ignoredArgHandler,
+			"--progress":      cliparse.IgnoredArgHandler,
+			"--secret":        cliparse.IgnoredArgHandler,
+			"--ssh":           cliparse.IgnoredArgHandler,
+			"--tag":           cliparse.IgnoredArgHandler,
+			"--target":        cliparse.IgnoredArgHandler,
+			"-f":              cliparse.IgnoredArgHandler,
 			"-h":              nil,
-			"-t":              ignoredArgHandler,
+			"-t":              cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--file": {"--file", "-f"},
+			"--help": {"--help", "-h"},
+			"--tag":  {"--tag", "-t"},
+			"-f":     {"--file", "-f"},
+			"-h":     {"--help", "-h"},
+			"-t":     {"--tag", "-t"},
 		},
 	},
 
 	"images": {
-		commandPath: "images",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format":   ignoredArgHandler,
+		Path:        "images",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format":   cliparse.IgnoredArgHandler,
 			"--help":     nil,
 			"--no-trunc": nil,
 			"--quiet":    nil,
 			"-h":         nil,
 			"-q":         nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"pull": {
-		commandPath: "pull",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "pull",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"push": {
-		commandPath: "push",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "push",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"load": {
-		commandPath: "load",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "load",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--all-platforms": nil,
 			"--help":          nil,
-			"--input":         ignoredArgHandler,
+			"--input":         cliparse.IgnoredArgHandler,
 			"-h":              nil,
-			"-i":              ignoredArgHandler,
+			"-i":              cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--input": {"--input", "-i"},
+			"-h":      {"--help", "-h"},
+			"-i":      {"--input", "-i"},
 		},
 	},
 
 	"save": {
-		commandPath: "save",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "save",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":   nil,
-			"--output": ignoredArgHandler,
+			"--output": cliparse.IgnoredArgHandler,
 			"-h":       nil,
-			"-o":       ignoredArgHandler,
+			"-o":       cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":   {"--help", "-h"},
+			"--output": {"--output", "-o"},
+			"-h":       {"--help", "-h"},
+			"-o":       {"--output", "-o"},
 		},
 	},
 
 	"tag": {
-		commandPath: "tag",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "tag",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"rmi": {
-		commandPath: "rmi",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "rmi",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"events": {
-		commandPath: "events",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "events",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"info": {
-		commandPath: "info",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format": ignoredArgHandler,
+		Path:        "info",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format": cliparse.IgnoredArgHandler,
 			"--help":   nil,
-			"-f":       ignoredArgHandler,
+			"-f":       cliparse.IgnoredArgHandler,
 			"-h":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--format": {"--format", "-f"},
+			"--help":   {"--help", "-h"},
+			"-f":       {"--format", "-f"},
+			"-h":       {"--help", "-h"},
+		},
 	},
 
 	"version": {
-		commandPath: "version",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format": ignoredArgHandler,
+		Path:        "version",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format": cliparse.IgnoredArgHandler,
 			"--help":   nil,
-			"-f":       ignoredArgHandler,
+			"-f":       cliparse.IgnoredArgHandler,
 			"-h":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--format": {"--format", "-f"},
+			"--help":   {"--help", "-h"},
+			"-f":       {"--format", "-f"},
+			"-h":       {"--help", "-h"},
+		},
 	},
 
 	"inspect": {
-		commandPath: "inspect",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "inspect",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
-			"--mode": ignoredArgHandler,
+			"--mode": cliparse.IgnoredArgHandler,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"top": {
-		commandPath: "top",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "top",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"login": {
-		commandPath: "login",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "login",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":           nil,
-			"--password":       ignoredArgHandler,
+			"--password":       cliparse.IgnoredArgHandler,
 			"--password-stdin": nil,
-			"--username":       ignoredArgHandler,
+			"--username":       cliparse.IgnoredArgHandler,
 			"-h":               nil,
-			"-p":               ignoredArgHandler,
-			"-u":               ignoredArgHandler,
+			"-p":               cliparse.IgnoredArgHandler,
+			"-u":               cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":     {"--help", "-h"},
+			"--password": {"--password", "-p"},
+			"--username": {"--username", "-u"},
+			"-h":         {"--help", "-h"},
+			"-p":         {"--password", "-p"},
+			"-u":         {"--username", "-u"},
 		},
 	},
 
 	"logout": {
-		commandPath: "logout",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "logout",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"compose": {
-		commandPath: "compose",
-		subcommands: map[string]struct{}{
+		Path: "compose",
+		Subcommands: map[string]struct{}{
 			"up":    {},
 			"logs":  {},
 			"build": {},
@@ -451,22 +649,30 @@ var commands = map[string]commandDefinition{
 			"help":  {},
 			"h":     {},
 		},
-		options: map[string]argHandler{
-			"--env-file":          ignoredArgHandler,
-			"--file":              ignoredArgHandler,
+		Options: map[string]cliparse.ArgHandler{
+			"--env-file":          cliparse.IgnoredArgHandler,
+			"--file":              cliparse.IgnoredArgHandler,
 			"--help":              nil,
-			"--project-directory": ignoredArgHandler,
-			"--project-name":      ignoredArgHandler,
-			"-f":                  ignoredArgHandler,
+			"--project-directory": cliparse.IgnoredArgHandler,
+			"--project-name":      cliparse.IgnoredArgHandler,
+			"-f":                  cliparse.IgnoredArgHandler,
 			"-h":                  nil,
-			"-p":                  ignoredArgHandler,
+			"-p":                  cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--file":         {"--file", "-f"},
+			"--help":         {"--help", "-h"},
+			"--project-name": {"--project-name", "-p"},
+			"-f":             {"--file", "-f"},
+			"-h":             {"--help", "-h"},
+			"-p":             {"--project-name", "-p"},
 		},
 	},
 
 	"compose up": {
-		commandPath: "compose up",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "compose up",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--build":         nil,
 			"--detach":        nil,
 			"--help":          nil,
@@ -475,126 +681,182 @@ var commands = map[string]commandDefinition{
 			"-d":              nil,
 			"-h":              nil,
 		},
+		Synonyms: map[string][]string{
+			"--detach": {"--detach", "-d"},
+			"--help":   {"--help", "-h"},
+			"-d":       {"--detach", "-d"},
+			"-h":       {"--help", "-h"},
+		},
 	},
 
 	"compose logs": {
-		commandPath: "compose logs",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "compose logs",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--follow":        nil,
 			"--help":          nil,
 			"--no-color":      nil,
 			"--no-log-prefix": nil,
-			"--tail":          ignoredArgHandler,
+			"--tail":          cliparse.IgnoredArgHandler,
 			"--timestamps":    nil,
 			"-f":              nil,
 			"-h":              nil,
 			"-t":              nil,
 		},
+		Synonyms: map[string][]string{
+			"--follow":     {"--follow", "-f"},
+			"--help":       {"--help", "-h"},
+			"--timestamps": {"--timestamps", "-t"},
+			"-f":           {"--follow", "-f"},
+			"-h":           {"--help", "-h"},
+			"-t":           {"--timestamps", "-t"},
+		},
 	},
 
 	"compose build": {
-		commandPath: "compose build",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--build-arg": ignoredArgHandler,
+		Path:        "compose build",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--build-arg": cliparse.IgnoredArgHandler,
 			"--help":      nil,
 			"--no-cache":  nil,
-			"--progress":  ignoredArgHandler,
+			"--progress":  cliparse.IgnoredArgHandler,
 			"-h":          nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"compose down": {
-		commandPath: "compose down",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "compose down",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":    nil,
-			"--volumes": ignoredArgHandler,
+			"--volumes": cliparse.IgnoredArgHandler,
 			"-h":        nil,
-			"-v":        ignoredArgHandler,
+			"-v":        cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":    {"--help", "-h"},
+			"--volumes": {"--volumes", "-v"},
+			"-h":        {"--help", "-h"},
+			"-v":        {"--volumes", "-v"},
 		},
 	},
 
 	"compose help": {
-		commandPath: "compose help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "compose help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"compose h": {
-		commandPath: "compose h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "compose h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"completion": {
-		commandPath: "completion",
-		subcommands: map[string]struct{}{
+		Path: "completion",
+		Subcommands: map[string]struct{}{
 			"bash": {},
 			"help": {},
 			"h":    {},
 		},
-		options: map[string]argHandler{
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"completion bash": {
-		commandPath: "completion bash",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "completion bash",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"completion help": {
-		commandPath: "completion help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "completion help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"completion h": {
-		commandPath: "completion h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "completion h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"help": {
-		commandPath: "help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"h": {
-		commandPath: "h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container": {
-		commandPath: "container",
-		subcommands: map[string]struct{}{
+		Path: "container",
+		Subcommands: map[string]struct{}{
 			"run":     {},
 			"exec":    {},
 			"ls":      {},
@@ -612,97 +874,141 @@ var commands = map[string]commandDefinition{
 			"help":    {},
 			"h":       {},
 		},
-		options: map[string]argHandler{
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container run": {
-		commandPath: "container run",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--cap-add":      ignoredArgHandler,
-			"--cap-drop":     ignoredArgHandler,
-			"--cgroupns":     ignoredArgHandler,
-			"--cidfile":      ignoredArgHandler,
-			"--cpu-shares":   ignoredArgHandler,
-			"--cpus":         ignoredArgHandler,
-			"--cpuset-cpus":  ignoredArgHandler,
+		Path:        "container run",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--cap-add":      cliparse.IgnoredArgHandler,
+			"--cap-drop":     cliparse.IgnoredArgHandler,
+			"--cgroupns":     cliparse.IgnoredArgHandler,
+			"--cidfile":      cliparse.IgnoredArgHandler,
+			"--cpu-shares":   cliparse.IgnoredArgHandler,
+			"--cpus":         cliparse.IgnoredArgHandler,
+			"--cpuset-cpus":  cliparse.IgnoredArgHandler,
 			"--detach":       nil,
-			"--device":       ignoredArgHandler,
-			"--dns":          ignoredArgHandler,
-			"--entrypoint":   ignoredArgHandler,
-			"--env":          ignoredArgHandler,
-			"--env-file":     ignoredArgHandler,
-			"--gpus":         ignoredArgHandler,
+			"--device":       cliparse.IgnoredArgHandler,
+			"--dns":          cliparse.IgnoredArgHandler,
+			"--entrypoint":   cliparse.IgnoredArgHandler,
+			"--env":          cliparse.IgnoredArgHandler,
+			"--env-file":     cliparse.IgnoredArgHandler,
+			"--gpus":         cliparse.IgnoredArgHandler,
 			"--help":         nil,
-			"--hostname":     ignoredArgHandler,
+			"--hostname":     cliparse.IgnoredArgHandler,
 			"--interactive":  nil,
-			"--label":        ignoredArgHandler,
-			"--label-file":   ignoredArgHandler,
-			"--memory":       ignoredArgHandler,
-			"--name":         ignoredArgHandler,
-			"--net":          ignoredArgHandler,
-			"--network":      ignoredArgHandler,
-			"--pid":          ignoredArgHandler,
-			"--pidfile":      ignoredArgHandler,
-			"--pids-limit":   ignoredArgHandler,
+			"--label":        cliparse.IgnoredArgHandler,
+			"--label-file":   cliparse.IgnoredArgHandler,
+			"--memory":       cliparse.IgnoredArgHandler,
+			"--name":         cliparse.IgnoredArgHandler,
+			"--net":          cliparse.IgnoredArgHandler,
+			"--network":      cliparse.IgnoredArgHandler,
+			"--pid":          cliparse.IgnoredArgHandler,
+			"--pidfile":      cliparse.IgnoredArgHandler,
+			"--pids-limit":   cliparse.IgnoredArgHandler,
 			"--privileged":   nil,
-			"--publish":      ignoredArgHandler,
-			"--pull":         ignoredArgHandler,
+			"--publish":      cliparse.IgnoredArgHandler,
+			"--pull":         cliparse.IgnoredArgHandler,
 			"--read-only":    nil,
-			"--restart":      ignoredArgHandler,
+			"--restart":      cliparse.IgnoredArgHandler,
 			"--rm":           nil,
 			"--rootfs":       nil,
-			"--runtime":      ignoredArgHandler,
-			"--security-opt": ignoredArgHandler,
-			"--shm-size":     ignoredArgHandler,
-			"--sysctl":       ignoredArgHandler,
+			"--runtime":      cliparse.IgnoredArgHandler,
+			"--security-opt": cliparse.IgnoredArgHandler,
+			"--shm-size":     cliparse.IgnoredArgHandler,
+			"--sysctl":       cliparse.IgnoredArgHandler,
 			"--tty":          nil,
-			"--user":         ignoredArgHandler,
-			"--volume":       ignoredArgHandler,
-			"--workdir":      ignoredArgHandler,
+			"--user":         cliparse.IgnoredArgHandler,
+			"--volume":       cliparse.IgnoredArgHandler,
+			"--workdir":      cliparse.IgnoredArgHandler,
 			"-d":             nil,
-			"-e":             ignoredArgHandler,
-			"-h":             ignoredArgHandler,
+			"-e":             cliparse.IgnoredArgHandler,
+			"-h":             cliparse.IgnoredArgHandler,
 			"-i":             nil,
-			"-l":             ignoredArgHandler,
-			"-m":             ignoredArgHandler,
-			"-p":             ignoredArgHandler,
+			"-l":             cliparse.IgnoredArgHandler,
+			"-m":             cliparse.IgnoredArgHandler,
+			"-p":             cliparse.IgnoredArgHandler,
 			"-t":             nil,
-			"-u":             ignoredArgHandler,
-			"-v":             ignoredArgHandler,
-			"-w":             ignoredArgHandler,
+			"-u":             cliparse.IgnoredArgHandler,
+			"-v":             cliparse.IgnoredArgHandler,
+			"-w":             cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--detach":      {"--detach", "-d"},
+			"--env":         {"--env", "-e"},
+			"--hostname":    {"--hostname", "-h"},
+			"--interactive": {"--interactive", "-i"},
+			"--label":       {"--label", "-l"},
+			"--memory":      {"--memory", "-m"},
+			"--net":         {"--net", "--network"},
+			"--network":     {"--net", "--network"},
+			"--publish":     {"--publish", "-p"},
+			"--tty":         {"--tty", "-t"},
+			"--user":        {"--user", "-u"},
+			"--volume":      {"--volume", "-v"},
+			"--workdir":     {"--workdir", "-w"},
+			"-d":            {"--detach", "-d"},
+			"-e":            {"--env", "-e"},
+			"-h":            {"--hostname", "-h"},
+			"-i":            {"--interactive", "-i"},
+			"-l":            {"--label", "-l"},
+			"-m":            {"--memory", "-m"},
+			"-p":            {"--publish", "-p"},
+			"-t":            {"--tty", "-t"},
+			"-u":            {"--user", "-u"},
+			"-v":            {"--volume", "-v"},
+			"-w":            {"--workdir", "-w"},
 		},
 	},
 
 	"container exec": {
-		commandPath: "container exec",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container exec",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--detach":      nil,
-			"--env":         ignoredArgHandler,
+			"--env":         cliparse.IgnoredArgHandler,
 			"--help":        nil,
 			"--interactive": nil,
 			"--privileged":  nil,
 			"--tty":         nil,
-			"--workdir":     ignoredArgHandler,
+			"--workdir":     cliparse.IgnoredArgHandler,
 			"-d":            nil,
-			"-e":            ignoredArgHandler,
+			"-e":            cliparse.IgnoredArgHandler,
 			"-h":            nil,
 			"-i":            nil,
 			"-t":            nil,
-			"-w":            ignoredArgHandler,
+			"-w":            cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--detach":      {"--detach", "-d"},
+			"--env":         {"--env", "-e"},
+			"--help":        {"--help", "-h"},
+			"--interactive": {"--interactive", "-i"},
+			"--tty":         {"--tty", "-t"},
+			"--workdir":     {"--workdir", "-w"},
+			"-d":            {"--detach", "-d"},
+			"-e":            {"--env", "-e"},
+			"-h":            {"--help", "-h"},
+			"-i":            {"--interactive", "-i"},
+			"-t":            {"--tty", "-t"},
+			"-w":            {"--workdir", "-w"},
 		},
 	},
 
 	"container ls": {
-		commandPath: "container ls",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container ls",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--all":      nil,
-			"--format":   ignoredArgHandler,
+			"--format":   cliparse.IgnoredArgHandler,
 			"--help":     nil,
 			"--no-trunc": nil,
 			"--quiet":    nil,
@@ -710,48 +1016,74 @@ var commands = map[string]commandDefinition{
 			"-h":         nil,
 			"-q":         nil,
 		},
+		Synonyms: map[string][]string{
+			"--all":   {"--all", "-a"},
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-a":      {"--all", "-a"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"container inspect": {
-		commandPath: "container inspect",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container inspect",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
-			"--mode": ignoredArgHandler,
+			"--mode": cliparse.IgnoredArgHandler,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container logs": {
-		commandPath: "container logs",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container logs",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--follow":     nil,
 			"--help":       nil,
-			"--since":      ignoredArgHandler,
-			"--tail":       ignoredArgHandler,
+			"--since":      cliparse.IgnoredArgHandler,
+			"--tail":       cliparse.IgnoredArgHandler,
 			"--timestamps": nil,
-			"--until":      ignoredArgHandler,
+			"--until":      cliparse.IgnoredArgHandler,
 			"-f":           nil,
 			"-h":           nil,
-			"-n":           ignoredArgHandler,
+			"-n":           cliparse.IgnoredArgHandler,
 			"-t":           nil,
 		},
+		Synonyms: map[string][]string{
+			"--follow":     {"--follow", "-f"},
+			"--help":       {"--help", "-h"},
+			"--tail":       {"--tail", "-n"},
+			"--timestamps": {"--timestamps", "-t"},
+			"-f":           {"--follow", "-f"},
+			"-h":           {"--help", "-h"},
+			"-n":           {"--tail", "-n"},
+			"-t":           {"--timestamps", "-t"},
+		},
 	},
 
 	"container port": {
-		commandPath: "container port",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container port",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container rm": {
-		commandPath: "container rm",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container rm",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--force":   nil,
 			"--help":    nil,
 			"--volumes": nil,
@@ -759,100 +1091,152 @@ var commands = map[string]commandDefinition{
 			"-h":        nil,
 			"-v":        nil,
 		},
+		Synonyms: map[string][]string{
+			"--force":   {"--force", "-f"},
+			"--help":    {"--help", "-h"},
+			"--volumes": {"--volumes", "-v"},
+			"-f":        {"--force", "-f"},
+			"-h":        {"--help", "-h"},
+			"-v":        {"--volumes", "-v"},
+		},
 	},
 
 	"container stop": {
-		commandPath: "container stop",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container stop",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
-			"--time": ignoredArgHandler,
+			"--time": cliparse.IgnoredArgHandler,
 			"-h":     nil,
-			"-t":     ignoredArgHandler,
+			"-t":     cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"--time": {"--time", "-t"},
+			"-h":     {"--help", "-h"},
+			"-t":     {"--time", "-t"},
 		},
 	},
 
 	"container start": {
-		commandPath: "container start",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container start",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container kill": {
-		commandPath: "container kill",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container kill",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":   nil,
-			"--signal": ignoredArgHandler,
+			"--signal": cliparse.IgnoredArgHandler,
 			"-h":       nil,
-			"-s":       ignoredArgHandler,
+			"-s":       cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":   {"--help", "-h"},
+			"--signal": {"--signal", "-s"},
+			"-h":       {"--help", "-h"},
+			"-s":       {"--signal", "-s"},
 		},
 	},
 
 	"container pause": {
-		commandPath: "container pause",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container pause",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container wait": {
-		commandPath: "container wait",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container wait",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container unpause": {
-		commandPath: "container unpause",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container unpause",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container commit": {
-		commandPath: "container commit",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--author":  ignoredArgHandler,
+		Path:        "container commit",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--author":  cliparse.IgnoredArgHandler,
 			"--help":    nil,
-			"--message": ignoredArgHandler,
-			"-a":        ignoredArgHandler,
+			"--message": cliparse.IgnoredArgHandler,
+			"-a":        cliparse.IgnoredArgHandler,
 			"-h":        nil,
-			"-m":        ignoredArgHandler,
+			"-m":        cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--author":  {"--author", "-a"},
+			"--help":    {"--help", "-h"},
+			"--message": {"--message", "-m"},
+			"-a":        {"--author", "-a"},
+			"-h":        {"--help", "-h"},
+			"-m":        {"--message", "-m"},
 		},
 	},
 
 	"container help": {
-		commandPath: "container help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"container h": {
-		commandPath: "container h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "container h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image": {
-		commandPath: "image",
-		subcommands: map[string]struct{}{
+		Path: "image",
+		Subcommands: map[string]struct{}{
 			"build":   {},
 			"ls":      {},
 			"pull":    {},
@@ -866,152 +1250,214 @@ var commands = map[string]commandDefinition{
 			"help":    {},
 			"h":       {},
 		},
-		options: map[string]argHandler{
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image build": {
-		commandPath: "image build",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--build-arg":     ignoredArgHandler,
-			"--buildkit-host": ignoredArgHandler,
-			"--file":          ignoredArgHandler,
+		Path:        "image build",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--build-arg":     cliparse.IgnoredArgHandler,
+			"--buildkit-host": cliparse.IgnoredArgHandler,
+			"--file":          cliparse.IgnoredArgHandler,
 			"--help":          nil,
 			"--no-cache":      nil,
-			"--progress":      ignoredArgHandler,
-			"--secret":        ignoredArgHandler,
-			"--ssh":           ignoredArgHandler,
-			"--tag":           ignoredArgHandler,
-			"--target":        ignoredArgHandler,
-			"-f":              ignoredArgHandler,
+			"--progress":      cliparse.IgnoredArgHandler,
+			"--secret":        cliparse.IgnoredArgHandler,
+			"--ssh":           cliparse.IgnoredArgHandler,
+			"--tag":           cliparse.IgnoredArgHandler,
+			"--target":        cliparse.IgnoredArgHandler,
+			"-f":              cliparse.IgnoredArgHandler,
 			"-h":              nil,
-			"-t":              ignoredArgHandler,
+			"-t":              cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--file": {"--file", "-f"},
+			"--help": {"--help", "-h"},
+			"--tag":  {"--tag", "-t"},
+			"-f":     {"--file", "-f"},
+			"-h":     {"--help", "-h"},
+			"-t":     {"--tag", "-t"},
 		},
 	},
 
 	"image ls": {
-		commandPath: "image ls",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format":   ignoredArgHandler,
+		Path:        "image ls",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format":   cliparse.IgnoredArgHandler,
 			"--help":     nil,
 			"--no-trunc": nil,
 			"--quiet":    nil,
 			"-h":         nil,
 			"-q":         nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"image pull": {
-		commandPath: "image pull",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image pull",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image push": {
-		commandPath: "image push",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image push",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image load": {
-		commandPath: "image load",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image load",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--all-platforms": nil,
 			"--help":          nil,
-			"--input":         ignoredArgHandler,
+			"--input":         cliparse.IgnoredArgHandler,
 			"-h":              nil,
-			"-i":              ignoredArgHandler,
+			"-i":              cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--input": {"--input", "-i"},
+			"-h":      {"--help", "-h"},
+			"-i":      {"--input", "-i"},
 		},
 	},
 
 	"image save": {
-		commandPath: "image save",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image save",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":   nil,
-			"--output": ignoredArgHandler,
+			"--output": cliparse.IgnoredArgHandler,
 			"-h":       nil,
-			"-o":       ignoredArgHandler,
+			"-o":       cliparse.IgnoredArgHandler,
+		},
+		Synonyms: map[string][]string{
+			"--help":   {"--help", "-h"},
+			"--output": {"--output", "-o"},
+			"-h":       {"--help", "-h"},
+			"-o":       {"--output", "-o"},
 		},
 	},
 
 	"image tag": {
-		commandPath: "image tag",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image tag",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image rm": {
-		commandPath: "image rm",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image rm",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image convert": {
-		commandPath: "image convert",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image convert",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--all-platforms":             nil,
 			"--estargz":                   nil,
-			"--estargz-chunk-size":        ignoredArgHandler,
-			"--estargz-compression-level": ignoredArgHandler,
-			"--estargz-record-in":         ignoredArgHandler,
+			"--estargz-chunk-size":        cliparse.IgnoredArgHandler,
+			"--estargz-compression-level": cliparse.IgnoredArgHandler,
+			"--estargz-record-in":         cliparse.IgnoredArgHandler,
 			"--help":                      nil,
 			"--oci":                       nil,
-			"--platform":                  ignoredArgHandler,
+			"--platform":                  cliparse.IgnoredArgHandler,
 			"--uncompress":                nil,
 			"-h":                          nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image inspect": {
-		commandPath: "image inspect",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image inspect",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
-			"--mode": ignoredArgHandler,
+			"--mode": cliparse.IgnoredArgHandler,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image help": {
-		commandPath: "image help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"image h": {
-		commandPath: "image h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "image h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"network": {
-		commandPath: "network",
-		subcommands: map[string]struct{}{
+		Path: "network",
+		Subcommands: map[string]struct{}{
 			"ls":      {},
 			"list":    {},
 			"inspect": {},
@@ -1021,96 +1467,136 @@ var commands = map[string]commandDefinition{
 			"help":    {},
 			"h":       {},
 		},
-		options: map[string]argHandler{
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"network ls": {
-		commandPath: "network ls",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format": ignoredArgHandler,
+		Path:        "network ls",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format": cliparse.IgnoredArgHandler,
 			"--help":   nil,
 			"--quiet":  nil,
 			"-h":       nil,
 			"-q":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"network list": {
-		commandPath: "network list",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format": ignoredArgHandler,
+		Path:        "network list",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format": cliparse.IgnoredArgHandler,
 			"--help":   nil,
 			"--quiet":  nil,
 			"-h":       nil,
 			"-q":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"network inspect": {
-		commandPath: "network inspect",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "network inspect",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
-			"--mode": ignoredArgHandler,
+			"--mode": cliparse.IgnoredArgHandler,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"network create": {
-		commandPath: "network create",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "network create",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":   nil,
-			"--label":  ignoredArgHandler,
-			"--subnet": ignoredArgHandler,
+			"--label":  cliparse.IgnoredArgHandler,
+			"--subnet": cliparse.IgnoredArgHandler,
 			"-h":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"network rm": {
-		commandPath: "network rm",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "network rm",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"network remove": {
-		commandPath: "network remove",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "network remove",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"network help": {
-		commandPath: "network help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "network help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"network h": {
-		commandPath: "network h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "network h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"volume": {
-		commandPath: "volume",
-		subcommands: map[string]struct{}{
+		Path: "volume",
+		Subcommands: map[string]struct{}{
 			"ls":      {},
 			"list":    {},
 			"inspect": {},
@@ -1120,198 +1606,311 @@ var commands = map[string]commandDefinition{
 			"help":    {},
 			"h":       {},
 		},
-		options: map[string]argHandler{
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"volume ls": {
-		commandPath: "volume ls",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format": ignoredArgHandler,
+		Path:        "volume ls",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format": cliparse.IgnoredArgHandler,
 			"--help":   nil,
 			"--quiet":  nil,
 			"-h":       nil,
 			"-q":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"volume list": {
-		commandPath: "volume list",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format": ignoredArgHandler,
+		Path:        "volume list",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format": cliparse.IgnoredArgHandler,
 			"--help":   nil,
 			"--quiet":  nil,
 			"-h":       nil,
 			"-q":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"volume inspect": {
-		commandPath: "volume inspect",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "volume inspect",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"volume create": {
-		commandPath: "volume create",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "volume create",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":  nil,
-			"--label": ignoredArgHandler,
+			"--label": cliparse.IgnoredArgHandler,
 			"-h":      nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"volume rm": {
-		commandPath: "volume rm",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "volume rm",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--force": nil,
 			"--help":  nil,
 			"-f":      nil,
 			"-h":      nil,
 		},
+		Synonyms: map[string][]string{
+			"--force": {"--force", "-f"},
+			"--help":  {"--help", "-h"},
+			"-f":      {"--force", "-f"},
+			"-h":      {"--help", "-h"},
+		},
 	},
 
 	"volume remove": {
-		commandPath: "volume remove",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "volume remove",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--force": nil,
 			"--help":  nil,
 			"-f":      nil,
 			"-h":      nil,
 		},
+		Synonyms: map[string][]string{
+			"--force": {"--force", "-f"},
+			"--help":  {"--help", "-h"},
+			"-f":      {"--force", "-f"},
+			"-h":      {"--help", "-h"},
+		},
 	},
 
 	"volume help": {
-		commandPath: "volume help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "volume help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"volume h": {
-		commandPath: "volume h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "volume h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"system": {
-		commandPath: "system",
-		subcommands: map[string]struct{}{
+		Path: "system",
+		Subcommands: map[string]struct{}{
 			"events": {},
 			"info":   {},
 			"help":   {},
 			"h":      {},
 		},
-		options: map[string]argHandler{
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"system events": {
-		commandPath: "system events",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "system events",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"system info": {
-		commandPath: "system info",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
-			"--format": ignoredArgHandler,
+		Path:        "system info",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
+			"--format": cliparse.IgnoredArgHandler,
 			"--help":   nil,
-			"-f":       ignoredArgHandler,
+			"-f":       cliparse.IgnoredArgHandler,
 			"-h":       nil,
 		},
+		Synonyms: map[string][]string{
+			"--format": {"--format", "-f"},
+			"--help":   {"--help", "-h"},
+			"-f":       {"--format", "-f"},
+			"-h":       {"--help", "-h"},
+		},
 	},
 
 	"system help": {
-		commandPath: "system help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "system help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"system h": {
-		commandPath: "system h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "system h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"namespace": {
-		commandPath: "namespace",
-		subcommands: map[string]struct{}{
+		Path: "namespace",
+		Subcommands: map[string]struct{}{
 			"ls":   {},
 			"list": {},
 			"help": {},
 			"h":    {},
 		},
-		options: map[string]argHandler{
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"namespace ls": {
-		commandPath: "namespace ls",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "namespace ls",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":  nil,
 			"--quiet": nil,
 			"-h":      nil,
 			"-q":      nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"namespace list": {
-		commandPath: "namespace list",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "namespace list",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help":  nil,
 			"--quiet": nil,
 			"-h":      nil,
 			"-q":      nil,
 		},
+		Synonyms: map[string][]string{
+			"--help":  {"--help", "-h"},
+			"--quiet": {"--quiet", "-q"},
+			"-h":      {"--help", "-h"},
+			"-q":      {"--quiet", "-q"},
+		},
 	},
 
 	"namespace help": {
-		commandPath: "namespace help",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "namespace help",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 
 	"namespace h": {
-		commandPath: "namespace h",
-		subcommands: map[string]struct{}{},
-		options: map[string]argHandler{
+		Path:        "namespace h",
+		Subcommands: map[string]struct{}{},
+		Options: map[string]cliparse.ArgHandler{
 			"--help": nil,
 			"-h":     nil,
 		},
+		Synonyms: map[string][]string{
+			"--help": {"--help", "-h"},
+			"-h":     {"--help", "-h"},
+		},
 	},
 }
+
+// commandAliases maps top-level commands to the subcommands they mirror (i.e.
+// have the same options and subcommands as).
+var commandAliases = map[string]string{
+	"build":   "image build",
+	"commit":  "container commit",
+	"exec":    "container exec",
+	"kill":    "container kill",
+	"load":    "image load",
+	"logs":    "container logs",
+	"pause":   "container pause",
+	"port":    "container port",
+	"pull":    "image pull",
+	"push":    "image push",
+	"rm":      "container rm",
+	"run":     "container run",
+	"save":    "image save",
+	"start":   "container start",
+	"stop":    "container stop",
+	"tag":     "image tag",
+	"unpause": "container unpause",
+	"wait":    "container wait",
+}
@@ -3,315 +3,177 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
+	"sort"
 	"strings"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
 )
 
-type cleanupFunc func() error
+// pathKind describes how the value of an option is handled.
+type pathKind string
+
+const (
+	// pathKindInputPath is for options that take a file path for input.
+	pathKindInputPath = pathKind("input-path")
+	// pathKindOutputPath is for options that take a file path for output.
+	pathKindOutputPath = pathKind("output-path")
+	// pathKindVolume is for options like `--volume host:container:ro`.
+	pathKindVolume = pathKind("volume")
+	// pathKindMountCSV is for options like `--mount type=bind,source=...`.
+	pathKindMountCSV = pathKind("mount-csv")
+	// pathKindIgnored is for options whose values are passed through as-is.
+	pathKindIgnored = pathKind("ignored")
+)
 
-// parsedArgs describes the result of calling parseArgs.
-type parsedArgs struct {
-	// Arguments for nerdctl with paths replaced.
-	args []string
-	// cleanup functions to call
-	cleanup []cleanupFunc
+// builtinPathOptions lists the options that contain paths; the keys are the
+// command path and the option name.  Synonyms of the given options (e.g. `-f`
+// for `--file`) are handled automatically.  These may be extended or overridden
+// by the configuration file.
+var builtinPathOptions = map[string]map[string]pathKind{
+	"compose": {
+		"--file":              pathKindInputPath,
+		"--project-directory": pathKindInputPath,
+		"--env-file":          pathKindInputPath,
+	},
+	"container run": {
+		"--volume":     pathKindVolume,
+		"--env-file":   pathKindInputPath,
+		"--label-file": pathKindInputPath,
+		"--cidfile":    pathKindOutputPath,
+		"--pidfile":    pathKindOutputPath,
+	},
+	"image build": {
+		"--file": pathKindInputPath,
+	},
+	"image convert": {
+		"--estargz-record-in": pathKindInputPath,
+	},
+	"image load": {
+		"--input": pathKindInputPath,
+	},
+	"image save": {
+		"--output": pathKindOutputPath,
+	},
 }
 
-// argHandler is the type of a function that handles some argument.
-type argHandler func(string) (string, []cleanupFunc, error)
-
-type commandDefinition struct {
-	// commands points to the global command map; if this is null, then the global
-	// variable named "commands" is used instead.
-	commands *map[string]commandDefinition
-	// commandPath is the arguements needed to get to this command.
-	commandPath string
-	// subcommands that can be spawned from this command.
-	subcommands map[string]struct{}
-	// options for this (sub) command.  If the handler is null, the option does
-	// not take arguments.
-	options map[string]argHandler
-	// handler for any positional arguments and subcommands.  This should not
-	// include the name of the subcommand itself.  If this is not given, all
-	// subcommands are searched for, and positional arguments are ignored.
-	handler func(*commandDefinition, []string) (*parsedArgs, error)
+// handlerForKind returns the argument handler for the given kind of option.
+func (h *argHandlers) handlerForKind(kind pathKind) (cliparse.ArgHandler, error) {
+	switch kind {
+	case pathKindInputPath:
+		return h.filePathArgHandler, nil
+	case pathKindOutputPath:
+		return h.outputPathArgHandler, nil
+	case pathKindVolume:
+		return h.volumeArgHandler, nil
+	case pathKindMountCSV:
+		return h.mountArgHandler, nil
+	case pathKindIgnored:
+		return cliparse.IgnoredArgHandler, nil
+	}
+	return nil, fmt.Errorf("unknown option kind %q", kind)
 }
 
-// parseOption takes an argument (that is known to start with `-` or `--`) plus
-// the next argument (which may be needed if a value is required), and returns
-// whether the value argument was consumed, plus any cleanup functions.
-func (c *commandDefinition) parseOption(arg, next string) ([]string, bool, []cleanupFunc, error) {
-	if !strings.HasPrefix(arg, "-") {
-		panic(fmt.Sprintf("commandDefinition.parseOption called with invalid arg %q", arg))
-	}
-
-	// Figure out what the option name is
-	option := arg
-	value := next
-	consumed := true
-	sep := strings.Index(option, "=")
-	if sep >= 0 {
-		value = option[sep+1:]
-		option = option[:sep]
-		consumed = false
-	}
-	handler, ok := c.options[option]
-	if !ok {
-		// There may be multiple single-character options bunched together, e.g. `-itp 80`.
-		if len(option) > 1 && option[0] == '-' && option[1] != '-' {
-			// Make sure all options (except the last) exist and take no arguments.
-			for _, ch := range option[1 : len(option)-1] {
-				handler, ok = c.options[fmt.Sprintf("-%c", ch)]
-				if !ok || handler != nil {
-					ok = false
-					break
-				}
-			}
-			// If all earlier options are fine, use the arg handler for the last option.
-			if ok {
-				handler, ok = c.options[fmt.Sprintf("-%s", option[len(option)-1:])]
-			}
-		}
-		if !ok {
-			// The user may say `-foo` instead of `--foo`
-			option = "-" + option
-			handler, ok = c.options[option]
-		}
-	}
-	if ok {
-		if handler == nil {
-			// This does not consume a value, and therefore doesn't need munging
-			return []string{arg}, false, nil, nil
-		}
-		converted, cleanups, err := handler(value)
-		if err != nil {
-			// Note that we still need to pass along any cleanups even on failure
-			return nil, consumed, cleanups, err
-		}
-		return []string{option, converted}, consumed, cleanups, nil
-	}
+// registerPathOptions sets up handlers for the given path options.  Options
+// that are not in the command table are added; this allows handling options
+// from newer versions of nerdctl.  Any invalid entries are skipped, and
+// reported in the returned error.
+func registerPathOptions(registry *cliparse.Registry, handlers *argHandlers, pathOptions map[string]map[string]pathKind) error {
+	return registerPathOptionsWith(registry, handlers.handlerForKind, pathOptions)
+}
 
-	// Check if we can resolve this with the parent command.
-	var extraCleanups []cleanupFunc
-	parentName := ""
-	if lastSpace := strings.LastIndex(c.commandPath, " "); lastSpace > -1 {
-		parentName = c.commandPath[:lastSpace]
-	}
-	if parentName != c.commandPath {
-		globalCommands := c.commands
-		if globalCommands == nil {
-			globalCommands = &commands
-		}
-		parent, ok := (*globalCommands)[parentName]
+// registerPathOptionsWith sets up handlers for the given path options, using
+// the given function to pick the handler for each kind of option.
+func registerPathOptionsWith(registry *cliparse.Registry, handlerForKind func(pathKind) (cliparse.ArgHandler, error), pathOptions map[string]map[string]pathKind) error {
+	var problems []string
+	for commandPath, options := range pathOptions {
+		command, ok := registry.Command(commandPath)
 		if !ok {
-			panic(fmt.Sprintf("command %q could not find parent %q", c.commandPath, parentName))
-		}
-		parentResult, parentConsumed, parentCleanups, parentErr := parent.parseOption(arg, next)
-		if parentErr == nil {
-			return parentResult, parentConsumed, parentCleanups, nil
+			problems = append(problems, fmt.Sprintf("unknown command %q", commandPath))
+			continue
 		}
-		extraCleanups = parentCleanups
-	}
-	return nil, false, extraCleanups, fmt.Errorf("command %q does not support option %s", c.commandPath, arg)
-}
-
-// parse arguments for this command; this includes options (--long, -x) as well
-// as subcommands and positional arguments.
-func (c commandDefinition) parse(args []string) (*parsedArgs, error) {
-	var result parsedArgs
-	for argIndex := 0; argIndex < len(args); argIndex++ {
-		arg := args[argIndex]
-		if strings.HasPrefix(arg, "-") {
-			next := ""
-			if argIndex+1 < len(args) {
-				next = args[argIndex+1]
-			}
-			newArgs, consumed, cleanups, err := c.parseOption(arg, next)
+		for option, kind := range options {
+			handler, err := handlerForKind(kind)
 			if err != nil {
-				// We need to run any cleanups we have so far
-				for _, cleanup := range append(cleanups, result.cleanup...) {
-					cleanupErr := cleanup()
-					if cleanupErr != nil {
-						log.Printf("Error running cleanup: %s", cleanupErr)
-					}
-				}
-				return nil, err
-			}
-			result.args = append(result.args, newArgs...)
-			result.cleanup = append(result.cleanup, cleanups...)
-			if consumed {
-				argIndex++
-			}
-		} else {
-			// Handler positional arguments and subcommands.
-			if c.handler != nil {
-				childResult, err := c.handler(&c, args[argIndex:])
-				if err != nil {
-					return nil, err
-				}
-				result.args = append(result.args, childResult.args...)
-				result.cleanup = append(result.cleanup, childResult.cleanup...)
-				break
-			}
-			// No custom handler; look for subcommands.
-			subcommandPath := c.commandPath
-			if subcommandPath != "" {
-				subcommandPath += " "
+				problems = append(problems, fmt.Sprintf("command %q option %q: %s", commandPath, option, err))
+				continue
 			}
-			subcommandPath += arg
-			globalCommands := c.commands
-			if globalCommands == nil {
-				globalCommands = &commands
-			}
-			if subcommand, ok := (*globalCommands)[subcommandPath]; ok {
-				childResult, err := subcommand.parse(args[argIndex+1:])
-				if err != nil {
-					return nil, err
-				}
-				result.args = append(result.args, arg)
-				result.args = append(result.args, childResult.args...)
-				result.cleanup = append(result.cleanup, childResult.cleanup...)
+			existing, ok := command.Options[option]
+			if !ok {
+				err = registry.AddOption(commandPath, option, handler)
+			} else if existing == nil {
+				err = fmt.Errorf("command %q option %q does not take a value", commandPath, option)
 			} else {
-				// No subcommand; ignore positional arguments.
-				result.args = append(result.args, args[argIndex:]...)
+				err = registry.RegisterArgHandler(commandPath, option, handler)
+			}
+			if err != nil {
+				problems = append(problems, err.Error())
 			}
-			break
 		}
 	}
-	return &result, nil
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid path options: %s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
-type optionDefinition struct {
-	// long name for the argument
-	long string
-	// short name for the argument (optional)
-	short string
-	// handler to convert values; if unset, this argument does not take a value.
-	// It returns the converted value, plus any cleanup functions.
-	handler func(string) (string, []func(*parsedArgs) error, error)
-}
+// newRegistry creates the command registry for nerdctl, with handlers for any
+// options that contain paths.
+func newRegistry(handlers *argHandlers) (*cliparse.Registry, error) {
+	registry := cliparse.NewRegistry(commands)
 
-// parseArgs parses the process arguments (os.Args) and returns them with any
-// strings referring to paths replaced with replacements that will work with
-// nerdctl (i.e. inside the correct WSL container).
-func parseArgs() (*parsedArgs, error) {
-	err := prepareParseArgs()
-	if err != nil {
+	// Set up the argument handlers
+	if err := registerPathOptions(registry, handlers, builtinPathOptions); err != nil {
 		return nil, err
 	}
-	result, err := commands[""].parse(os.Args[1:])
-	if err != nil {
-		_ = cleanupParseArgs()
+
+	if err := registerFormatOptions(registry); err != nil {
 		return nil, err
 	}
-	return result, nil
-}
 
-// ignoredArgHandler handles arguments that do not contain paths.
-func ignoredArgHandler(input string) (string, []cleanupFunc, error) {
-	return input, nil, nil
-}
-
-// registerArgHandler sets option handlers.  This should be called from init()
-// to set up any option handlers that need to handle paths.
-func registerArgHandler(command, option string, handler argHandler) {
-	// Do some extra checking to guard against typos.
-	if _, ok := commands[command]; !ok {
-		panic(fmt.Sprintf("unknown command %q", command))
-	}
-	if _, ok := commands[command].options[option]; !ok {
-		panic(fmt.Sprintf("command %q does not have option %q", command, option))
+	// Set up command handlers
+	if err := registry.RegisterCommandHandler("image build", handlers.imageBuildHandler); err != nil {
+		return nil, err
 	}
-	commands[command].options[option] = handler
-}
 
-// registerCommandHandler sets handlers for positional arguments.  This should
-// be called from init().
-func registerCommandHandler(command string, handler func(*commandDefinition, []string) (*parsedArgs, error)) {
-	// Do some extra checking to guard against typos.
-	if _, ok := commands[command]; !ok {
-		panic(fmt.Sprintf("unknown command %q", command))
+	// Set up aliases; these are detected by the generator.
+	for alias, target := range commandAliases {
+		if err := registry.Alias(alias, target); err != nil {
+			return nil, err
+		}
 	}
-	c := commands[command]
-	c.handler = handler
-	commands[command] = c
+
+	return registry, nil
 }
 
-// aliasCommand sets up an alias to a different command.  Both the alias and the
-// target command must already exist and have the same options / subcommands (as
-// it should already be an alias).  This is normally not needed for the help
-// commands, as they do not take any arguments.
-func aliasCommand(alias, target string) {
-	aliasCommand, ok := commands[alias]
-	if !ok {
-		panic(fmt.Sprintf("unknown alias command %q", alias))
+// setupRegistry creates the command registry, including any configured path
+// options.  Errors in the configuration are logged, but otherwise ignored.
+func setupRegistry(handlers *argHandlers, config *stubConfig) (*cliparse.Registry, error) {
+	registry, err := newRegistry(handlers)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up commands: %w", err)
 	}
-	targetCommand, ok := commands[target]
-	if !ok {
-		panic(fmt.Sprintf("unknown target command %q", target))
+	// Apply the configuration after the built-ins so that it can override them.
+	// Errors here should not prevent running the command.
+	err = registerPathOptions(registry, handlers, config.PathOptions)
+	if err != nil {
+		log.Printf("Error in configuration: %s", err)
 	}
+	return registry, nil
+}
 
-	// Try harder to check that the commands look similar
-	if len(aliasCommand.subcommands) != len(targetCommand.subcommands) {
-		panic(fmt.Sprintf("cannot alias %q to %q: different subcommands", alias, target))
-	}
-	for subcommand := range aliasCommand.subcommands {
-		if _, ok := targetCommand.subcommands[subcommand]; !ok {
-			panic(fmt.Sprintf("cannot alias %q to %q: missing subcommand %q", alias, target, subcommand))
-		}
-	}
-	if len(aliasCommand.options) != len(targetCommand.options) {
-		panic(fmt.Sprintf("cannot alias %q to %q: different options", alias, target))
+// parseArgs parses the given arguments (after any alias expansion) and returns
+// them with any strings referring to paths replaced with replacements that will work with
+// nerdctl (i.e. inside the correct WSL container).
+func parseArgs(registry *cliparse.Registry, handlers *argHandlers, args []string) (*cliparse.Result, error) {
+	err := handlers.prepare()
+	if err != nil {
+		return nil, err
 	}
-	for option := range aliasCommand.options {
-		if _, ok := targetCommand.options[option]; !ok {
-			panic(fmt.Sprintf("cannot alias %q to %q: missing option %q", alias, target, option))
-		}
+	result, err := registry.Parse(args)
+	if err != nil {
+		_ = handlers.cleanup()
+		return nil, err
 	}
-
-	commands[alias] = commands[target]
-}
-
-func init() {
-	// Set up the argument handlers
-	registerArgHandler("compose", "--file", filePathArgHandler)
-	registerArgHandler("compose", "-f", filePathArgHandler)
-	registerArgHandler("compose", "--project-directory", filePathArgHandler)
-	registerArgHandler("compose", "--env-file", filePathArgHandler)
-	registerArgHandler("container run", "--volume", volumeArgHandler)
-	registerArgHandler("container run", "-v", volumeArgHandler)
-	registerArgHandler("container run", "--env-file", filePathArgHandler)
-	registerArgHandler("container run", "--label-file", filePathArgHandler)
-	registerArgHandler("container run", "--cidfile", outputPathArgHandler)
-	registerArgHandler("container run", "--pidfile", outputPathArgHandler)
-	registerArgHandler("image build", "--file", filePathArgHandler)
-	registerArgHandler("image build", "-f", filePathArgHandler)
-	registerArgHandler("image convert", "--estargz-record-in", filePathArgHandler)
-	registerArgHandler("image load", "--input", filePathArgHandler)
-	registerArgHandler("image save", "--output", outputPathArgHandler)
-
-	// Set up command handlers
-	registerCommandHandler("image build", imageBuildHandler)
-
-	// Set up aliases
-	aliasCommand("commit", "container commit")
-	aliasCommand("exec", "container exec")
-	aliasCommand("kill", "container kill")
-	aliasCommand("logs", "container logs")
-	aliasCommand("pause", "container pause")
-	aliasCommand("port", "container port")
-	aliasCommand("rm", "container rm")
-	aliasCommand("run", "container run")
-	aliasCommand("start", "container start")
-	aliasCommand("stop", "container stop")
-	aliasCommand("unpause", "container unpause")
-	aliasCommand("wait", "container wait")
-	aliasCommand("build", "image build")
-	aliasCommand("load", "image load")
-	aliasCommand("pull", "image pull")
-	aliasCommand("push", "image push")
-	aliasCommand("save", "image save")
-	aliasCommand("tag", "image tag")
-
-	// describeCommands()
+	return result, nil
 }
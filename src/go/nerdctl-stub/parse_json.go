@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+const (
+	// parseJSONOption, given as the first argument, prints how the command
+	// line would be parsed (as JSON) instead of running it.  If there are no
+	// further arguments, they are read from stdin as a JSON array.
+	parseJSONOption = "--rd-parse-json"
+	// pathKindBuildContext is the classification of the build context given to
+	// `image build`; it is only used in parse reports.
+	pathKindBuildContext = pathKind("build-context")
+)
+
+// parseReportOption describes an option that was recognized.
+type parseReportOption struct {
+	// Command is the path of the command that defines the option.
+	Command string `json:"command"`
+	// Name is the name of the option, as given.
+	Name string `json:"name"`
+	// HasValue is whether the option takes a value.
+	HasValue bool `json:"hasValue"`
+	// Value is the value of the option, as given.
+	Value string `json:"value,omitempty"`
+}
+
+// parseReportPath describes a value that refers to a path on the host.
+type parseReportPath struct {
+	// Command is the path of the command the value belongs to.
+	Command string `json:"command"`
+	// Option is the name of the option, or empty for positional arguments.
+	Option string `json:"option,omitempty"`
+	// Kind is how the value is handled.
+	Kind pathKind `json:"kind"`
+	// Value is the value as given.
+	Value string `json:"value"`
+}
+
+// parseReport describes how a command line would be parsed.
+type parseReport struct {
+	// Args are the arguments after alias expansion and default options.
+	Args []string `json:"args"`
+	// CommandPath is the resolved command.
+	CommandPath string `json:"commandPath"`
+	// Subcommands are the subcommand names, as given.
+	Subcommands []string `json:"subcommands"`
+	// Options are the options that were recognized, in order.
+	Options []parseReportOption `json:"options"`
+	// UnknownOptions are the options that were not recognized.
+	UnknownOptions []string `json:"unknownOptions"`
+	// Positionals are the positional arguments.
+	Positionals []string `json:"positionals"`
+	// Paths are the values that refer to host paths, with their kind.
+	Paths []parseReportPath `json:"paths"`
+	// Handlers are the kinds of path handlers, and the names of the other
+	// features, that would act on the command line, sorted.
+	Handlers []string `json:"handlers"`
+	// Error is set if the command line could not be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// readArgsJSON reads arguments given as a JSON array of strings.
+func readArgsJSON(input io.Reader) ([]string, error) {
+	var args []string
+	if err := json.NewDecoder(input).Decode(&args); err != nil {
+		return nil, fmt.Errorf("could not read arguments: %w", err)
+	}
+	return args, nil
+}
+
+// newParseReportRegistry creates a registry like setupRegistry, except that no
+// handler does anything: values are passed through unchanged.  The returned
+// function reports the build contexts seen by the `image build` handler.
+func newParseReportRegistry(config *stubConfig) (*cliparse.Registry, map[string]map[string]pathKind, func() []string, error) {
+	handlers := &argHandlers{directPathTranslator{}}
+	registry, err := newRegistry(handlers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ignoredHandler := func(kind pathKind) (cliparse.ArgHandler, error) {
+		if _, err := handlers.handlerForKind(kind); err != nil {
+			return nil, err
+		}
+		return cliparse.IgnoredArgHandler, nil
+	}
+	kinds := make(map[string]map[string]pathKind)
+	for _, pathOptions := range []map[string]map[string]pathKind{builtinPathOptions, config.PathOptions} {
+		// Errors in the configuration are reported when the command is run.
+		_ = registerPathOptionsWith(registry, ignoredHandler, pathOptions)
+		for commandPath, options := range pathOptions {
+			command, ok := registry.Command(commandPath)
+			if !ok {
+				continue
+			}
+			if kinds[command.Path] == nil {
+				kinds[command.Path] = make(map[string]pathKind)
+			}
+			for option, kind := range options {
+				for _, name := range command.OptionNames(option) {
+					kinds[command.Path][name] = kind
+				}
+			}
+		}
+	}
+	var buildContexts []string
+	err = registry.RegisterCommandHandler("image build", func(c *cliparse.Command, args []string) (*cliparse.Result, error) {
+		if len(args) > 0 && args[0] != "-" {
+			if match, _ := regexp.MatchString(`^[^:/]*://`, args[0]); !match {
+				buildContexts = append(buildContexts, args[0])
+			}
+		}
+		return &cliparse.Result{Args: args}, nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return registry, kinds, func() []string { return buildContexts }, nil
+}
+
+// parseReportFeatures returns the features, other than the path handlers, that
+// are set up for the given configuration and policy, keyed by their name in
+// parse reports.  Each checks if it would act on a parse result.
+func parseReportFeatures(config *stubConfig, pol *policy) map[string]func(*cliparse.Registry, *cliparse.Result) bool {
+	features := map[string]func(*cliparse.Registry, *cliparse.Result) bool{
+		"format": func(registry *cliparse.Registry, result *cliparse.Result) bool {
+			formatter, err := newTemplateFormatter(registry, result)
+			return formatter != nil || err != nil
+		},
+	}
+	// Errors in the configuration are reported when the command is run.
+	if rewriter, err := newImageRewriter(config.ImageRewrites, nil); err == nil {
+		features["image-rewrite"] = rewriter.applies
+	}
+	if !config.Credentials.Disabled {
+		features["credentials"] = credentialsApply
+	}
+	if config.BuildContextSync.enabled() {
+		features["build-context-sync"] = newContextSyncer(nil, config.BuildContextSync, nil).applies
+	}
+	if pol != nil {
+		// Every command is checked against the policy.
+		features["policy"] = func(*cliparse.Registry, *cliparse.Result) bool { return true }
+	}
+	return features
+}
+
+// newParseReport parses the given arguments (after alias expansion) without
+// touching the file system, and describes the result.
+func newParseReport(config *stubConfig, pol *policy, args []string) (*parseReport, error) {
+	registry, kinds, buildContexts, err := newParseReportRegistry(config)
+	if err != nil {
+		return nil, err
+	}
+	report := &parseReport{
+		Args:           args,
+		Subcommands:    []string{},
+		Options:        []parseReportOption{},
+		UnknownOptions: []string{},
+		Positionals:    []string{},
+		Paths:          []parseReportPath{},
+		Handlers:       []string{},
+	}
+	result, err := registry.ParseLenient(args)
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.CommandPath = result.CommandPath
+	report.Subcommands = append(report.Subcommands, result.Subcommands...)
+	report.UnknownOptions = append(report.UnknownOptions, result.UnknownOptions...)
+	report.Positionals = append(report.Positionals, result.Positionals...)
+	handlers := make(map[string]struct{})
+	for _, option := range result.Options {
+		report.Options = append(report.Options, parseReportOption{
+			Command:  option.Command,
+			Name:     option.Name,
+			HasValue: option.HasValue,
+			Value:    option.Value,
+		})
+		kind, ok := kinds[option.Command][option.Name]
+		if !ok || !option.HasValue {
+			continue
+		}
+		report.Paths = append(report.Paths, parseReportPath{
+			Command: option.Command,
+			Option:  option.Name,
+			Kind:    kind,
+			Value:   option.Value,
+		})
+		if kind != pathKindIgnored {
+			handlers[string(kind)] = struct{}{}
+		}
+	}
+	for _, buildContext := range buildContexts() {
+		report.Paths = append(report.Paths, parseReportPath{
+			Command: result.CommandPath,
+			Kind:    pathKindBuildContext,
+			Value:   buildContext,
+		})
+		handlers[string(pathKindBuildContext)] = struct{}{}
+	}
+	for name, applies := range parseReportFeatures(config, pol) {
+		if applies(registry, result) {
+			handlers[name] = struct{}{}
+		}
+	}
+	for handler := range handlers {
+		report.Handlers = append(report.Handlers, handler)
+	}
+	sort.Strings(report.Handlers)
+	return report, nil
+}
+
+// writeParseReport prints how the given arguments would be parsed as JSON.
+func writeParseReport(output io.Writer, config *stubConfig, pol *policy, args []string) error {
+	report, err := newParseReport(config, pol, args)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
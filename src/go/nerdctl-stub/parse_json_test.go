@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReport(t *testing.T) {
+	t.Parallel()
+	config := &stubConfig{PathOptions: map[string]map[string]pathKind{
+		"run": {"--seccomp-profile": pathKindInputPath},
+	}}
+
+	t.Run("run", func(t *testing.T) {
+		t.Parallel()
+		args := []string{"--namespace", "k8s.io", "run", "-v", "/src:/src", "--cidfile=/tmp/cid", "--frob", "--seccomp-profile", "/p.json", "alpine", "ls"}
+		report, err := newParseReport(config, nil, args)
+		require.NoError(t, err)
+		assert.Empty(t, report.Error)
+		assert.Equal(t, "container run", report.CommandPath)
+		assert.Equal(t, []string{"run"}, report.Subcommands)
+		assert.Equal(t, []string{"--frob"}, report.UnknownOptions)
+		assert.Equal(t, []string{"alpine", "ls"}, report.Positionals)
+		assert.Contains(t, report.Options, parseReportOption{Command: "", Name: "--namespace", HasValue: true, Value: "k8s.io"})
+		assert.Equal(t, []parseReportPath{
+			{Command: "container run", Option: "-v", Kind: pathKindVolume, Value: "/src:/src"},
+			{Command: "container run", Option: "--cidfile", Kind: pathKindOutputPath, Value: "/tmp/cid"},
+			{Command: "container run", Option: "--seccomp-profile", Kind: pathKindInputPath, Value: "/p.json"},
+		}, report.Paths)
+		assert.Equal(t, []string{"credentials", "input-path", "output-path", "volume"}, report.Handlers)
+	})
+
+	t.Run("build", func(t *testing.T) {
+		t.Parallel()
+		// The build context does not need to exist, as nothing is mounted.
+		report, err := newParseReport(config, nil, []string{"build", "-f", "/missing/Dockerfile", "/missing"})
+		require.NoError(t, err)
+		assert.Equal(t, "image build", report.CommandPath)
+		assert.Equal(t, []parseReportPath{
+			{Command: "image build", Option: "-f", Kind: pathKindInputPath, Value: "/missing/Dockerfile"},
+			{Command: "image build", Kind: pathKindBuildContext, Value: "/missing"},
+		}, report.Paths)
+		assert.Equal(t, []string{"build-context", "credentials", "input-path"}, report.Handlers)
+	})
+
+	t.Run("features", func(t *testing.T) {
+		t.Parallel()
+		config := &stubConfig{
+			ImageRewrites:    []imageRewriteRule{{Prefix: "docker.io/", Replacement: "mirror.example.com/"}},
+			Credentials:      credentialsConfig{Disabled: true},
+			BuildContextSync: contextSyncConfig{Enabled: true},
+		}
+		testCases := []struct {
+			args     []string
+			pol      *policy
+			expected []string
+		}{
+			{[]string{"ps"}, nil, []string{}},
+			{[]string{"ps", "--format", "{{.ID}}"}, nil, []string{"format"}},
+			{[]string{"ps", "--format", "json"}, &policy{}, []string{"policy"}},
+			{[]string{"pull", "alpine"}, nil, []string{"image-rewrite"}},
+			{[]string{"compose", "up"}, nil, []string{"image-rewrite"}},
+			{[]string{"build", "https://example.com/context.git"}, nil, []string{"image-rewrite"}},
+			{[]string{"build", "/missing"}, nil, []string{"build-context", "build-context-sync", "image-rewrite"}},
+			{[]string{"inspect", "--format", "{{.Name}}", "web"}, &policy{}, []string{"format", "policy"}},
+		}
+		for _, testCase := range testCases {
+			report, err := newParseReport(config, testCase.pol, testCase.args)
+			if assert.NoError(t, err, "%v", testCase.args) {
+				assert.Equal(t, testCase.expected, report.Handlers, "%v", testCase.args)
+			}
+		}
+		report, err := newParseReport(&stubConfig{}, nil, []string{"login", "registry.example.com"})
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"credentials"}, report.Handlers)
+		}
+	})
+
+	t.Run("output", func(t *testing.T) {
+		t.Parallel()
+		output := &bytes.Buffer{}
+		require.NoError(t, writeParseReport(output, &stubConfig{}, nil, []string{"ps"}))
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(output.Bytes(), &decoded))
+		assert.Equal(t, "ps", decoded["commandPath"])
+		assert.Equal(t, []interface{}{}, decoded["paths"])
+		assert.NotContains(t, decoded, "error")
+	})
+}
+
+func TestReadArgsJSON(t *testing.T) {
+	t.Parallel()
+	args, err := readArgsJSON(strings.NewReader(`["run", "-v", "C:\\src:/src", "alpine"]`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"run", "-v", `C:\src:/src`, "alpine"}, args)
+	_, err = readArgsJSON(strings.NewReader(`{"args": []}`))
+	assert.Error(t, err)
+}
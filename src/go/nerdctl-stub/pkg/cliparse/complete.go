@@ -0,0 +1,110 @@
+package cliparse
+
+import (
+	"sort"
+	"strings"
+)
+
+// Completion describes the context of a word being completed.
+type Completion struct {
+	// Command is the (sub) command the word belongs to.
+	Command *Command
+	// Option is the name of the option whose value is being completed, if any.
+	Option string
+	// Positionals are the positional arguments before the word.
+	Positionals []string
+	// Candidates are the subcommands or option names that match the word.
+	Candidates []string
+}
+
+// LookupOption finds the given option in this command or its parents.  The
+// option may include a value (`--foo=bar`), and may be a set of bunched
+// single-character options (`-it`), in which case the last one is returned.
+// This does not run any handlers.
+func (c *Command) LookupOption(arg string) (*Command, string, bool) {
+	option := arg
+	if sep := strings.Index(option, "="); sep >= 0 {
+		option = option[:sep]
+	}
+	for command, ok := c, true; ok; command, ok = command.Parent() {
+		if _, found := command.Options[option]; found {
+			return command, option, true
+		}
+		if len(option) > 2 && option[0] == '-' && option[1] != '-' {
+			last := "-" + option[len(option)-1:]
+			if _, found := command.Options[last]; found {
+				return command, last, true
+			}
+			if _, found := command.Options["-"+option]; found {
+				return command, "-" + option, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// allOptions returns the names of all options applicable to this command,
+// including those inherited from its parents.
+func (c *Command) allOptions() []string {
+	seen := make(map[string]struct{})
+	for command, ok := c, true; ok; command, ok = command.Parent() {
+		for option := range command.Options {
+			seen[option] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(seen))
+	for option := range seen {
+		result = append(result, option)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Complete determines how to complete the given word, given the (complete)
+// words before it.  The words do not include the executable name.
+func (r *Registry) Complete(args []string, word string) Completion {
+	command, ok := r.commands[""]
+	if !ok {
+		return Completion{}
+	}
+	var positionals []string
+	for argIndex := 0; argIndex < len(args); argIndex++ {
+		arg := args[argIndex]
+		if strings.HasPrefix(arg, "-") && len(positionals) == 0 {
+			owner, option, found := command.LookupOption(arg)
+			if !found || owner.Options[option] == nil || strings.Contains(arg, "=") {
+				continue
+			}
+			if argIndex+1 == len(args) {
+				// The word is the value of this option.
+				return Completion{Command: command, Option: option}
+			}
+			argIndex++
+			continue
+		}
+		if len(positionals) == 0 && command.Handler == nil {
+			if subcommand, ok := command.Subcommand(arg); ok {
+				command = subcommand
+				continue
+			}
+		}
+		positionals = append(positionals, arg)
+	}
+
+	result := Completion{Command: command, Positionals: positionals}
+	var candidates []string
+	if strings.HasPrefix(word, "-") {
+		candidates = command.allOptions()
+	} else if len(positionals) == 0 {
+		for subcommand := range command.Subcommands {
+			candidates = append(candidates, subcommand)
+		}
+		sort.Strings(candidates)
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			result.Candidates = append(result.Candidates, candidate)
+		}
+	}
+	return result
+}
@@ -0,0 +1,57 @@
+package cliparse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	t.Parallel()
+	registry := NewRegistry(map[string]Command{
+		"": {
+			Subcommands: map[string]struct{}{"container": {}, "compose": {}},
+			Options:     map[string]ArgHandler{"--namespace": IgnoredArgHandler, "--debug": nil},
+		},
+		"container": {
+			Path:        "container",
+			Subcommands: map[string]struct{}{"run": {}},
+		},
+		"container run": {
+			Path:    "container run",
+			Options: map[string]ArgHandler{"--network": IgnoredArgHandler, "-i": nil, "-t": nil},
+		},
+		"compose": {Path: "compose"},
+	})
+	t.Run("subcommands", func(t *testing.T) {
+		t.Parallel()
+		completion := registry.Complete(nil, "co")
+		assert.Equal(t, "", completion.Command.Path)
+		assert.Equal(t, []string{"compose", "container"}, completion.Candidates)
+	})
+	t.Run("skips option values", func(t *testing.T) {
+		t.Parallel()
+		completion := registry.Complete([]string{"--namespace", "container", "container"}, "")
+		assert.Equal(t, "container", completion.Command.Path)
+		assert.Equal(t, []string{"run"}, completion.Candidates)
+	})
+	t.Run("options including parents", func(t *testing.T) {
+		t.Parallel()
+		completion := registry.Complete([]string{"container", "run"}, "--")
+		assert.Equal(t, []string{"--debug", "--namespace", "--network"}, completion.Candidates)
+	})
+	t.Run("option value", func(t *testing.T) {
+		t.Parallel()
+		completion := registry.Complete([]string{"container", "run", "-it", "--network"}, "")
+		assert.Equal(t, "container run", completion.Command.Path)
+		assert.Equal(t, "--network", completion.Option)
+		assert.Empty(t, completion.Candidates)
+	})
+	t.Run("positionals", func(t *testing.T) {
+		t.Parallel()
+		completion := registry.Complete([]string{"container", "run", "--network=host", "alpine", "--network"}, "")
+		assert.Equal(t, "", completion.Option)
+		assert.Equal(t, []string{"alpine", "--network"}, completion.Positionals)
+		assert.Empty(t, completion.Candidates)
+	})
+}
@@ -0,0 +1,252 @@
+package cliparse
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Option describes a single option found while parsing.
+type Option struct {
+	// Command is the path of the command that defines the option; this may be
+	// a parent of the command being parsed.
+	Command string
+	// Name is the name of the option as defined, e.g. `--volume` or `-v`.
+	Name string
+	// HasValue is whether the option takes a value.
+	HasValue bool
+	// Value is the value of the option, as given on the command line.  For
+	// options that do not take a value, this is only set if it was given
+	// explicitly (e.g. `--flag=false`).
+	Value string
+	// Converted is the value of the option after the handler has run.
+	Converted string
+}
+
+// Result describes the result of parsing a command line.
+type Result struct {
+	// Args are the arguments with any values replaced by their handlers.
+	Args []string
+	// Cleanup functions to call after the command has run.
+	Cleanup []CleanupFunc
+	// CommandPath is the path of the command that was resolved; for aliases,
+	// this is the path of the target command.
+	CommandPath string
+	// Subcommands are the subcommand names, as given on the command line.
+	Subcommands []string
+	// Options found, in the order given.
+	Options []Option
+	// Positionals are the positional arguments (and anything after them), as
+	// given on the command line.
+	Positionals []string
+	// UnknownOptions are the options that were not recognized, as given on the
+	// command line; this is only set when parsing leniently.
+	UnknownOptions []string
+}
+
+// UnknownOptionError is returned when parsing an option that is not supported
+// by the command (or any of its parents).
+type UnknownOptionError struct {
+	// Command is the path of the command being parsed.
+	Command string
+	// Option is the argument as given on the command line.
+	Option string
+}
+
+func (e *UnknownOptionError) Error() string {
+	return fmt.Sprintf("command %q does not support option %s", e.Command, e.Option)
+}
+
+// merge the given child result into this one.
+func (r *Result) merge(child *Result) {
+	r.Args = append(r.Args, child.Args...)
+	r.Cleanup = append(r.Cleanup, child.Cleanup...)
+	if child.CommandPath != "" {
+		r.CommandPath = child.CommandPath
+	}
+	r.Subcommands = append(r.Subcommands, child.Subcommands...)
+	r.Options = append(r.Options, child.Options...)
+	if child.Positionals != nil {
+		r.Positionals = child.Positionals
+	}
+	r.UnknownOptions = append(r.UnknownOptions, child.UnknownOptions...)
+}
+
+// RunCleanups runs the given cleanup functions, logging any errors.
+func RunCleanups(cleanups []CleanupFunc) {
+	for _, cleanup := range cleanups {
+		if err := cleanup(); err != nil {
+			log.Printf("Error cleaning up: %s", err)
+		}
+	}
+}
+
+// parseOption takes an argument (that is known to start with `-` or `--`) plus
+// the next argument (which may be needed if a value is required), and returns
+// the result plus whether the value argument was consumed.  On failure, the
+// result is still returned so that any cleanup functions can be run.
+func (c *Command) parseOption(arg, next string) (*Result, bool, error) {
+	if !strings.HasPrefix(arg, "-") {
+		panic(fmt.Sprintf("Command.parseOption called with invalid arg %q", arg))
+	}
+
+	// Figure out what the option name is
+	option := arg
+	value := next
+	consumed := true
+	sep := strings.Index(option, "=")
+	if sep >= 0 {
+		value = option[sep+1:]
+		option = option[:sep]
+		consumed = false
+	}
+	// flags are any single-character options without values bunched together
+	// before the last one, e.g. `-it` in `-itp 80`.
+	var flags []string
+	handler, ok := c.Options[option]
+	name := option
+	if !ok {
+		// There may be multiple single-character options bunched together, e.g. `-itp 80`.
+		if len(option) > 1 && option[0] == '-' && option[1] != '-' {
+			// Make sure all options (except the last) exist and take no arguments.
+			for _, ch := range option[1 : len(option)-1] {
+				flag := fmt.Sprintf("-%c", ch)
+				handler, ok = c.Options[flag]
+				if !ok || handler != nil {
+					ok = false
+					break
+				}
+				flags = append(flags, flag)
+			}
+			// If all earlier options are fine, use the arg handler for the last option.
+			if ok {
+				name = fmt.Sprintf("-%s", option[len(option)-1:])
+				handler, ok = c.Options[name]
+			}
+		}
+		if !ok {
+			// The user may say `-foo` instead of `--foo`
+			option = "-" + option
+			name = option
+			flags = nil
+			handler, ok = c.Options[option]
+		}
+	}
+	if ok {
+		result := &Result{}
+		for _, flag := range flags {
+			result.Options = append(result.Options, Option{Command: c.Path, Name: flag})
+		}
+		if handler == nil {
+			// This does not consume a value, and therefore doesn't need munging
+			result.Args = []string{arg}
+			flagOption := Option{Command: c.Path, Name: name}
+			if sep >= 0 {
+				flagOption.Value = value
+			}
+			result.Options = append(result.Options, flagOption)
+			return result, false, nil
+		}
+		converted, cleanups, err := handler(value)
+		result.Cleanup = cleanups
+		if err != nil {
+			// Note that we still need to pass along any cleanups even on failure
+			return result, consumed, err
+		}
+		result.Args = []string{option, converted}
+		result.Options = append(result.Options, Option{
+			Command:   c.Path,
+			Name:      name,
+			HasValue:  true,
+			Value:     value,
+			Converted: converted,
+		})
+		return result, consumed, nil
+	}
+
+	// Check if we can resolve this with the parent command.
+	var extraCleanups []CleanupFunc
+	if parent, ok := c.Parent(); ok {
+		parentResult, parentConsumed, parentErr := parent.parseOption(arg, next)
+		if parentErr == nil {
+			return parentResult, parentConsumed, nil
+		}
+		extraCleanups = parentResult.Cleanup
+	}
+	return &Result{Cleanup: extraCleanups}, false, &UnknownOptionError{Command: c.Path, Option: arg}
+}
+
+// Parse arguments for this command; this includes options (--long, -x) as well
+// as subcommands and positional arguments.
+func (c *Command) Parse(args []string) (*Result, error) {
+	return c.parse(args, false)
+}
+
+// ParseLenient parses arguments like Parse, except that unknown options are
+// recorded in the result (and passed through, assuming they take no value)
+// instead of causing an error.
+func (c *Command) ParseLenient(args []string) (*Result, error) {
+	return c.parse(args, true)
+}
+
+func (c *Command) parse(args []string, lenient bool) (*Result, error) {
+	result := &Result{CommandPath: c.Path}
+	for argIndex := 0; argIndex < len(args); argIndex++ {
+		arg := args[argIndex]
+		if strings.HasPrefix(arg, "-") {
+			next := ""
+			if argIndex+1 < len(args) {
+				next = args[argIndex+1]
+			}
+			optionResult, consumed, err := c.parseOption(arg, next)
+			var unknownErr *UnknownOptionError
+			if lenient && errors.As(err, &unknownErr) {
+				result.Cleanup = append(result.Cleanup, optionResult.Cleanup...)
+				result.Args = append(result.Args, arg)
+				result.UnknownOptions = append(result.UnknownOptions, arg)
+				continue
+			}
+			if err != nil {
+				// We need to run any cleanups we have so far
+				RunCleanups(append(optionResult.Cleanup, result.Cleanup...))
+				return nil, err
+			}
+			result.merge(optionResult)
+			if consumed {
+				argIndex++
+			}
+		} else {
+			// Handler positional arguments and subcommands.
+			if c.Handler != nil {
+				childResult, err := c.Handler(c, args[argIndex:])
+				if err != nil {
+					RunCleanups(result.Cleanup)
+					return nil, err
+				}
+				if childResult.Positionals == nil {
+					childResult.Positionals = args[argIndex:]
+				}
+				result.merge(childResult)
+				break
+			}
+			// No custom handler; look for subcommands.
+			if subcommand, ok := c.Subcommand(arg); ok {
+				childResult, err := subcommand.parse(args[argIndex+1:], lenient)
+				if err != nil {
+					RunCleanups(result.Cleanup)
+					return nil, err
+				}
+				result.Args = append(result.Args, arg)
+				result.Subcommands = append(result.Subcommands, arg)
+				result.merge(childResult)
+			} else {
+				// No subcommand; pass positional arguments through.
+				result.Args = append(result.Args, args[argIndex:]...)
+				result.Positionals = args[argIndex:]
+			}
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,308 @@
+package cliparse
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var expectedError = fmt.Errorf("expected error")
+
+func generateCleanupFunc(output *bool, withError bool) CleanupFunc {
+	return func() error {
+		if output != nil {
+			*output = true
+		}
+		if withError {
+			return expectedError
+		}
+		return nil
+	}
+}
+
+func generateOptionHandler(output *bool, argError, cleanupError bool) ArgHandler {
+	cleanup := generateCleanupFunc(output, cleanupError)
+	return func(arg string) (string, []CleanupFunc, error) {
+		if argError {
+			return "", []CleanupFunc{cleanup}, expectedError
+		}
+		return arg, []CleanupFunc{cleanup}, nil
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	t.Parallel()
+	t.Run("unsupported option", func(t *testing.T) {
+		t.Parallel()
+		c := Command{}
+		_, _, err := c.parseOption("-hello", "world")
+		assert.EqualError(t, err, `command "" does not support option -hello`)
+	})
+	t.Run("option with no value", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--hello": nil}}
+		result, consumed, err := c.parseOption("--hello", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--hello"}, result.Args)
+			assert.False(t, consumed)
+			assert.Nil(t, result.Cleanup)
+			assert.Equal(t, []Option{{Name: "--hello"}}, result.Options)
+		}
+	})
+	t.Run("option with no value given explicitly", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--hello": nil}}
+		result, consumed, err := c.parseOption("--hello=false", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--hello=false"}, result.Args)
+			assert.False(t, consumed)
+			assert.Equal(t, []Option{{Name: "--hello", Value: "false"}}, result.Options)
+		}
+	})
+	t.Run("option with value", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--hello": IgnoredArgHandler}}
+		result, consumed, err := c.parseOption("--hello", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--hello", "world"}, result.Args)
+			assert.True(t, consumed)
+			assert.Nil(t, result.Cleanup)
+			expected := Option{Name: "--hello", HasValue: true, Value: "world", Converted: "world"}
+			assert.Equal(t, []Option{expected}, result.Options)
+		}
+	})
+	t.Run("option with embedded value", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--hello": IgnoredArgHandler}}
+		result, consumed, err := c.parseOption("--hello=moo", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--hello", "moo"}, result.Args)
+			assert.False(t, consumed)
+			assert.Nil(t, result.Cleanup)
+		}
+	})
+	t.Run("option with short name", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--hello": nil}}
+		result, consumed, err := c.parseOption("-hello", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"-hello"}, result.Args)
+			assert.False(t, consumed)
+			assert.Nil(t, result.Cleanup)
+			assert.Equal(t, []Option{{Name: "--hello"}}, result.Options)
+		}
+	})
+	t.Run("option with bunched up single-letter options", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--ab": IgnoredArgHandler, "-a": nil, "-b": nil}}
+		result, consumed, err := c.parseOption("-ab", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"-ab"}, result.Args)
+			assert.False(t, consumed)
+			assert.Nil(t, result.Cleanup)
+			assert.Equal(t, []Option{{Name: "-a"}, {Name: "-b"}}, result.Options)
+		}
+	})
+	t.Run("option with bunched up single-letter options, with argument", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--ab": nil, "-a": nil, "-b": IgnoredArgHandler}}
+		result, consumed, err := c.parseOption("-ab", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"-ab", "world"}, result.Args)
+			assert.True(t, consumed)
+			assert.Nil(t, result.Cleanup)
+			expected := []Option{
+				{Name: "-a"},
+				{Name: "-b", HasValue: true, Value: "world", Converted: "world"},
+			}
+			assert.Equal(t, expected, result.Options)
+		}
+	})
+	t.Run("short option, not all characters are single-letter options", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--abc": nil, "-a": nil, "-c": IgnoredArgHandler}}
+		result, consumed, err := c.parseOption("-abc", "world")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"-abc"}, result.Args)
+			assert.False(t, consumed)
+			assert.Nil(t, result.Cleanup)
+			assert.Equal(t, []Option{{Name: "--abc"}}, result.Options)
+		}
+	})
+	t.Run("passes along any cleanups on failure", func(t *testing.T) {
+		t.Parallel()
+		c := Command{
+			Options: map[string]ArgHandler{
+				"--hello": generateOptionHandler(nil, true, true),
+			},
+		}
+		result, _, err := c.parseOption("--hello", "world")
+		assert.Error(t, err)
+		if assert.Len(t, result.Cleanup, 1) {
+			assert.Same(t, expectedError, result.Cleanup[0]())
+		}
+	})
+	t.Run("looks for options in parent commands", func(t *testing.T) {
+		t.Parallel()
+		registry := NewRegistry(map[string]Command{
+			"": {
+				Path:    "",
+				Options: map[string]ArgHandler{"--hello": nil},
+			},
+			"subcommand": {
+				Path:    "subcommand",
+				Options: map[string]ArgHandler{"--world": nil},
+			},
+			"subcommand more": {
+				Path:    "subcommand more",
+				Options: map[string]ArgHandler{"--foo": nil},
+			},
+		})
+		command, _ := registry.Command("subcommand more")
+		result, _, err := command.parseOption("--hello", "")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--hello"}, result.Args)
+			assert.Equal(t, []Option{{Command: "", Name: "--hello"}}, result.Options)
+		}
+
+		result, _, err = command.parseOption("--world", "")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--world"}, result.Args)
+			assert.Equal(t, []Option{{Command: "subcommand", Name: "--world"}}, result.Options)
+		}
+
+		result, _, err = command.parseOption("--foo", "")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"--foo"}, result.Args)
+			assert.Equal(t, []Option{{Command: "subcommand more", Name: "--foo"}}, result.Options)
+		}
+	})
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	t.Run("options", func(t *testing.T) {
+		t.Parallel()
+		c := Command{Options: map[string]ArgHandler{"--option": nil}}
+		result, err := c.Parse([]string{"--option"})
+		if assert.NoError(t, err) {
+			expected := &Result{
+				Args:    []string{"--option"},
+				Options: []Option{{Name: "--option"}},
+			}
+			assert.Equal(t, expected, result)
+		}
+	})
+	t.Run("options with parse error", func(t *testing.T) {
+		t.Parallel()
+		cleanupRun := false
+		c := Command{
+			Options: map[string]ArgHandler{
+				"-o": generateOptionHandler(&cleanupRun, true, false),
+			},
+		}
+		_, err := c.Parse([]string{"-o=xxx"})
+		assert.Error(t, err)
+		assert.True(t, cleanupRun)
+	})
+	t.Run("positional argument handler", func(t *testing.T) {
+		t.Parallel()
+		run := false
+		c := Command{
+			Handler: func(c *Command, args []string) (*Result, error) {
+				run = true
+				assert.Equal(t, []string{"positional", "arguments"}, args)
+				return &Result{}, nil
+			},
+		}
+		result, err := c.Parse([]string{"positional", "arguments"})
+		assert.NoError(t, err)
+		assert.True(t, run)
+		if assert.NotNil(t, result) {
+			assert.Equal(t, []string{"positional", "arguments"}, result.Positionals)
+		}
+	})
+	t.Run("positional args without handler", func(t *testing.T) {
+		t.Parallel()
+		c := Command{}
+		result, err := c.Parse([]string{"hello", "world"})
+		assert.NoError(t, err)
+		if assert.NotNil(t, result) {
+			assert.Equal(t, []string{"hello", "world"}, result.Args)
+			assert.Equal(t, []string{"hello", "world"}, result.Positionals)
+		}
+	})
+	t.Run("subcommand handler", func(t *testing.T) {
+		t.Parallel()
+		run := false
+		registry := NewRegistry(map[string]Command{
+			"": {},
+			"subcommand": {
+				Path: "subcommand",
+				Handler: func(c *Command, args []string) (*Result, error) {
+					run = true
+					assert.Equal(t, []string{"a", "b"}, args)
+					return &Result{}, nil
+				},
+			},
+		})
+		_, err := registry.Parse([]string{"subcommand", "a", "b"})
+		assert.NoError(t, err)
+		assert.True(t, run)
+	})
+	t.Run("result structure", func(t *testing.T) {
+		t.Parallel()
+		registry := NewRegistry(map[string]Command{
+			"": {
+				Options: map[string]ArgHandler{"--namespace": IgnoredArgHandler},
+			},
+			"container": {
+				Path: "container",
+			},
+			"container run": {
+				Path:    "container run",
+				Options: map[string]ArgHandler{"-i": nil, "-t": nil},
+			},
+		})
+		result, err := registry.Parse([]string{"--namespace", "k8s.io", "container", "run", "-it", "alpine", "sh"})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "container run", result.CommandPath)
+			assert.Equal(t, []string{"container", "run"}, result.Subcommands)
+			assert.Equal(t, []string{"alpine", "sh"}, result.Positionals)
+			expected := []Option{
+				{Command: "", Name: "--namespace", HasValue: true, Value: "k8s.io", Converted: "k8s.io"},
+				{Command: "container run", Name: "-i"},
+				{Command: "container run", Name: "-t"},
+			}
+			assert.Equal(t, expected, result.Options)
+			assert.Equal(t, []string{"--namespace", "k8s.io", "container", "run", "-it", "alpine", "sh"}, result.Args)
+		}
+	})
+	t.Run("lenient", func(t *testing.T) {
+		t.Parallel()
+		registry := NewRegistry(map[string]Command{
+			"": {
+				Options: map[string]ArgHandler{"--namespace": IgnoredArgHandler},
+			},
+			"run": {
+				Path:    "run",
+				Options: map[string]ArgHandler{"-i": nil},
+			},
+		})
+		args := []string{"--frobnicate", "run", "-i", "--new-flag", "alpine"}
+		_, err := registry.Parse(args)
+		var unknownErr *UnknownOptionError
+		if assert.ErrorAs(t, err, &unknownErr) {
+			assert.Equal(t, &UnknownOptionError{Command: "", Option: "--frobnicate"}, unknownErr)
+		}
+		result, err := registry.ParseLenient(args)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "run", result.CommandPath)
+			assert.Equal(t, []string{"--frobnicate", "--new-flag"}, result.UnknownOptions)
+			assert.Equal(t, []Option{{Command: "run", Name: "-i"}}, result.Options)
+			assert.Equal(t, []string{"alpine"}, result.Positionals)
+			assert.Equal(t, args, result.Args)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+// Package cliparse implements a parser for nested command lines (such as those
+// of nerdctl), where the set of commands and options is known ahead of time.
+// Options that carry values can have handlers to rewrite those values (e.g. to
+// translate paths), and commands can have handlers for positional arguments.
+package cliparse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CleanupFunc is a function to be called after the command has run.
+type CleanupFunc func() error
+
+// ArgHandler is the type of a function that handles the value of an option; it
+// returns the converted value, plus any cleanup functions.
+type ArgHandler func(string) (string, []CleanupFunc, error)
+
+// CommandHandler is the type of a function that handles positional arguments
+// (and any subcommands) of a command.  The arguments do not include the name
+// of the command itself.
+type CommandHandler func(*Command, []string) (*Result, error)
+
+// IgnoredArgHandler handles option values that do not need to be converted.
+func IgnoredArgHandler(input string) (string, []CleanupFunc, error) {
+	return input, nil, nil
+}
+
+// Command describes a single (sub) command.
+type Command struct {
+	// registry is the registry this command belongs to; it is used to look up
+	// subcommands and parent commands.  If this is nil, the command is parsed
+	// in isolation.
+	registry *Registry
+	// Path is the arguments needed to get to this command, separated by
+	// spaces; the root command has an empty path.
+	Path string
+	// Subcommands that can be spawned from this command.
+	Subcommands map[string]struct{}
+	// Options for this (sub) command.  If the handler is nil, the option does
+	// not take arguments.
+	Options map[string]ArgHandler
+	// Synonyms maps each option that has more than one name to all of its
+	// names (including itself).  Options not listed here only have one name.
+	Synonyms map[string][]string
+	// Handler for any positional arguments and subcommands.  If this is not
+	// given, all subcommands are searched for, and positional arguments are
+	// passed through unchanged.
+	Handler CommandHandler
+}
+
+// Registry is a set of commands, keyed by their paths.
+type Registry struct {
+	commands map[string]*Command
+}
+
+// NewRegistry creates a registry from the given command definitions.  The
+// definitions are copied, so that changes to the registry (such as registering
+// handlers) do not affect the input.
+func NewRegistry(commands map[string]Command) *Registry {
+	registry := &Registry{commands: make(map[string]*Command, len(commands))}
+	for path, command := range commands {
+		c := &Command{
+			registry:    registry,
+			Path:        command.Path,
+			Subcommands: make(map[string]struct{}, len(command.Subcommands)),
+			Options:     make(map[string]ArgHandler, len(command.Options)),
+			Synonyms:    make(map[string][]string, len(command.Synonyms)),
+			Handler:     command.Handler,
+		}
+		for name := range command.Subcommands {
+			c.Subcommands[name] = struct{}{}
+		}
+		for name, handler := range command.Options {
+			c.Options[name] = handler
+		}
+		for name, synonyms := range command.Synonyms {
+			c.Synonyms[name] = append([]string(nil), synonyms...)
+		}
+		registry.commands[path] = c
+	}
+	return registry
+}
+
+// Command returns the command at the given path.
+func (r *Registry) Command(path string) (*Command, bool) {
+	command, ok := r.commands[path]
+	return command, ok
+}
+
+// Paths returns the paths of all commands (including aliases), sorted.
+func (r *Registry) Paths() []string {
+	paths := make([]string, 0, len(r.commands))
+	for path := range r.commands {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Parse the given arguments (not including the executable name) starting
+// from the root command.
+func (r *Registry) Parse(args []string) (*Result, error) {
+	root, ok := r.commands[""]
+	if !ok {
+		return nil, fmt.Errorf("registry has no root command")
+	}
+	return root.Parse(args)
+}
+
+// ParseLenient parses the given arguments like Parse, except that unknown
+// options are recorded instead of causing an error.
+func (r *Registry) ParseLenient(args []string) (*Result, error) {
+	root, ok := r.commands[""]
+	if !ok {
+		return nil, fmt.Errorf("registry has no root command")
+	}
+	return root.ParseLenient(args)
+}
+
+// RegisterArgHandler sets the handler for an option.  The handler is also set
+// for all synonyms of the option (e.g. `-v` for `--volume`).
+func (r *Registry) RegisterArgHandler(command, option string, handler ArgHandler) error {
+	c, ok := r.commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if _, ok := c.Options[option]; !ok {
+		return fmt.Errorf("command %q does not have option %q", command, option)
+	}
+	for _, name := range c.OptionNames(option) {
+		c.Options[name] = handler
+	}
+	return nil
+}
+
+// RegisterCommandHandler sets the handler for positional arguments.
+func (r *Registry) RegisterCommandHandler(command string, handler CommandHandler) error {
+	c, ok := r.commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	c.Handler = handler
+	return nil
+}
+
+// AddOption adds an option (that is not already known) to a command.
+func (r *Registry) AddOption(command, option string, handler ArgHandler) error {
+	c, ok := r.commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if !strings.HasPrefix(option, "-") {
+		return fmt.Errorf("invalid option %q for command %q", option, command)
+	}
+	if _, ok := c.Options[option]; ok {
+		return fmt.Errorf("command %q already has option %q", command, option)
+	}
+	c.Options[option] = handler
+	return nil
+}
+
+// Alias sets up an alias to a different command.  Both the alias and the
+// target command must already exist and have the same options / subcommands (as
+// it should already be an alias).  After this, both paths refer to the same
+// command, so handlers registered on either apply to both.
+func (r *Registry) Alias(alias, target string) error {
+	aliasCommand, ok := r.commands[alias]
+	if !ok {
+		return fmt.Errorf("unknown alias command %q", alias)
+	}
+	targetCommand, ok := r.commands[target]
+	if !ok {
+		return fmt.Errorf("unknown target command %q", target)
+	}
+
+	// Try harder to check that the commands look similar
+	if len(aliasCommand.Subcommands) != len(targetCommand.Subcommands) {
+		return fmt.Errorf("cannot alias %q to %q: different subcommands", alias, target)
+	}
+	for subcommand := range aliasCommand.Subcommands {
+		if _, ok := targetCommand.Subcommands[subcommand]; !ok {
+			return fmt.Errorf("cannot alias %q to %q: missing subcommand %q", alias, target, subcommand)
+		}
+	}
+	if len(aliasCommand.Options) != len(targetCommand.Options) {
+		return fmt.Errorf("cannot alias %q to %q: different options", alias, target)
+	}
+	for option := range aliasCommand.Options {
+		if _, ok := targetCommand.Options[option]; !ok {
+			return fmt.Errorf("cannot alias %q to %q: missing option %q", alias, target, option)
+		}
+	}
+
+	r.commands[alias] = targetCommand
+	return nil
+}
+
+// OptionNames returns all names of the given option, including itself.
+func (c *Command) OptionNames(option string) []string {
+	if names, ok := c.Synonyms[option]; ok {
+		return names
+	}
+	return []string{option}
+}
+
+// Parent returns the parent of this command, if any.
+func (c *Command) Parent() (*Command, bool) {
+	if c.registry == nil || c.Path == "" {
+		return nil, false
+	}
+	parentPath := ""
+	if lastSpace := strings.LastIndex(c.Path, " "); lastSpace > -1 {
+		parentPath = c.Path[:lastSpace]
+	}
+	parent, ok := c.registry.commands[parentPath]
+	if !ok {
+		panic(fmt.Sprintf("command %q could not find parent %q", c.Path, parentPath))
+	}
+	return parent, true
+}
+
+// Subcommand returns the given subcommand of this command, if any.
+func (c *Command) Subcommand(name string) (*Command, bool) {
+	if c.registry == nil {
+		return nil, false
+	}
+	path := name
+	if c.Path != "" {
+		path = c.Path + " " + name
+	}
+	subcommand, ok := c.registry.commands[path]
+	return subcommand, ok
+}
@@ -0,0 +1,87 @@
+package cliparse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRegistry() *Registry {
+	return NewRegistry(map[string]Command{
+		"": {
+			Subcommands: map[string]struct{}{"run": {}, "container": {}},
+		},
+		"run": {
+			Path:     "run",
+			Options:  map[string]ArgHandler{"--volume": IgnoredArgHandler, "-v": IgnoredArgHandler},
+			Synonyms: map[string][]string{"--volume": {"--volume", "-v"}, "-v": {"--volume", "-v"}},
+		},
+		"container": {
+			Path:        "container",
+			Subcommands: map[string]struct{}{"run": {}},
+		},
+		"container run": {
+			Path:     "container run",
+			Options:  map[string]ArgHandler{"--volume": IgnoredArgHandler, "-v": IgnoredArgHandler},
+			Synonyms: map[string][]string{"--volume": {"--volume", "-v"}, "-v": {"--volume", "-v"}},
+		},
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	t.Run("registers handlers for synonyms", func(t *testing.T) {
+		t.Parallel()
+		registry := newTestRegistry()
+		handler := func(arg string) (string, []CleanupFunc, error) {
+			return "converted:" + arg, nil, nil
+		}
+		assert.NoError(t, registry.RegisterArgHandler("container run", "-v", handler))
+		for _, option := range []string{"--volume=a", "-v=b"} {
+			result, err := registry.Parse([]string{"container", "run", option})
+			if assert.NoError(t, err) {
+				assert.Equal(t, "converted:"+option[len(option)-1:], result.Args[3])
+			}
+		}
+	})
+	t.Run("rejects unknown commands and options", func(t *testing.T) {
+		t.Parallel()
+		registry := newTestRegistry()
+		assert.EqualError(t, registry.RegisterArgHandler("missing", "-v", nil), `unknown command "missing"`)
+		assert.EqualError(t, registry.RegisterArgHandler("run", "-x", nil), `command "run" does not have option "-x"`)
+		assert.EqualError(t, registry.RegisterCommandHandler("missing", nil), `unknown command "missing"`)
+	})
+	t.Run("aliases share handlers", func(t *testing.T) {
+		t.Parallel()
+		registry := newTestRegistry()
+		assert.NoError(t, registry.Alias("run", "container run"))
+		handler := func(arg string) (string, []CleanupFunc, error) {
+			return "converted", nil, nil
+		}
+		assert.NoError(t, registry.RegisterArgHandler("container run", "--volume", handler))
+		result, err := registry.Parse([]string{"run", "-v", "x", "image"})
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"run", "-v", "converted", "image"}, result.Args)
+			assert.Equal(t, "container run", result.CommandPath)
+			assert.Equal(t, []string{"run"}, result.Subcommands)
+		}
+	})
+	t.Run("rejects mismatched aliases", func(t *testing.T) {
+		t.Parallel()
+		registry := newTestRegistry()
+		assert.EqualError(t, registry.Alias("run", "container"), `cannot alias "run" to "container": different subcommands`)
+	})
+	t.Run("is isolated from its input", func(t *testing.T) {
+		t.Parallel()
+		commands := map[string]Command{
+			"": {Options: map[string]ArgHandler{"--debug": nil}},
+		}
+		registry := NewRegistry(commands)
+		assert.NoError(t, registry.RegisterArgHandler("", "--debug", IgnoredArgHandler))
+		assert.Nil(t, commands[""].Options["--debug"])
+	})
+	t.Run("lists paths", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, []string{"", "container", "container run", "run"}, newTestRegistry().Paths())
+	})
+}
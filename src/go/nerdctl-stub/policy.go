@@ -0,0 +1,565 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// policyFileName is the name of the administrator policy file; it is only
+// looked for in the system-wide configuration directory, so that users can not
+// override it.
+const policyFileName = "nerdctl-policy.yaml"
+
+// policy describes restrictions on what nerdctl may be asked to do.  The zero
+// value allows everything.
+type policy struct {
+	// path is the file the policy was loaded from, for messages.
+	path string
+	// DenyPrivileged denies `--privileged`.
+	DenyPrivileged bool `yaml:"denyPrivileged"`
+	// DenyHostPID denies `--pid=host`.
+	DenyHostPID bool `yaml:"denyHostPID"`
+	// DenyHostNetwork denies `--net=host` (and `--network=host`).
+	DenyHostNetwork bool `yaml:"denyHostNetwork"`
+	// AllowedMountPrefixes, if set, are the host directories that may be bind
+	// mounted (including their subdirectories).
+	AllowedMountPrefixes []string `yaml:"allowedMountPrefixes"`
+	// AllowedRegistries, if set, are the registries that images may come
+	// from; these may include a repository path prefix, e.g.
+	// `docker.io/library`.
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+	// MaxCPUs, if set, is the maximum value for `--cpus`.
+	MaxCPUs float64 `yaml:"maxCPUs"`
+	// MaxMemory, if set, is the maximum value for `--memory`, e.g. `4g`.
+	MaxMemory string `yaml:"maxMemory"`
+	// maxMemoryBytes is MaxMemory, parsed.
+	maxMemoryBytes int64
+	// rewriter, if set, rewrites the images in Dockerfiles and compose files
+	// before nerdctl sees them; it is applied before checking those images.
+	rewriter *imageRewriter
+}
+
+// imageCommands are the commands with images as positional arguments, and how
+// many of the positional arguments are images.
+var imageCommands = map[string]int{
+	"container create": 1,
+	"container run":    1,
+	"image pull":       1,
+	"image push":       1,
+	"image tag":        2,
+}
+
+// composeReadOnlyCommands are the compose commands that do not create
+// containers or images, so the compose files are not checked for them.
+var composeReadOnlyCommands = map[string]struct{}{
+	"compose down": {},
+	"compose h":    {},
+	"compose help": {},
+	"compose logs": {},
+}
+
+// composeServiceOptions are the keys of compose services that are checked, and
+// the equivalent `container run` options.
+var composeServiceOptions = []struct{ key, option string }{
+	{"privileged", "--privileged"},
+	{"pid", "--pid"},
+	{"network_mode", "--net"},
+	{"cpus", "--cpus"},
+	{"mem_limit", "--memory"},
+}
+
+// loadPolicy loads the administrator policy, if any.  If there is no policy
+// file, nil is returned.
+func loadPolicy() (*policy, error) {
+	dir := systemConfigDir()
+	if dir == "" {
+		return nil, nil
+	}
+	return loadPolicyFile(filepath.Join(dir, policyFileName))
+}
+
+// loadPolicyFile loads the policy from the given file.  If the file does not
+// exist, nil is returned.
+func loadPolicyFile(policyPath string) (*policy, error) {
+	file, err := os.Open(policyPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	result := &policy{path: policyPath}
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(result)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse %s: %w", policyPath, err)
+	}
+	if result.MaxMemory != "" {
+		result.maxMemoryBytes, err = parseMemorySize(result.MaxMemory)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: invalid maxMemory: %w", policyPath, err)
+		}
+	}
+	for i, prefix := range result.AllowedMountPrefixes {
+		result.AllowedMountPrefixes[i] = resolvePath(prefix)
+	}
+	return result, nil
+}
+
+// memorySizePattern matches memory sizes such as `512m` or `1.5GiB`.
+var memorySizePattern = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*([kmgtp])?i?b?$`)
+
+// parseMemorySize parses a memory size as accepted by nerdctl, using binary
+// units.
+func parseMemorySize(input string) (int64, error) {
+	match := memorySizePattern.FindStringSubmatch(strings.TrimSpace(input))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q", input)
+	}
+	size, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", input, err)
+	}
+	if match[2] != "" {
+		exponent := strings.Index("kmgtp", strings.ToLower(match[2])) + 1
+		for i := 0; i < exponent; i++ {
+			size *= 1024
+		}
+	}
+	return int64(size), nil
+}
+
+// resolvePath returns the absolute path, with any symbolic links resolved if
+// the path exists.
+func resolvePath(input string) string {
+	result, err := filepath.Abs(input)
+	if err != nil {
+		return filepath.Clean(input)
+	}
+	if resolved, err := filepath.EvalSymlinks(result); err == nil {
+		return resolved
+	}
+	return result
+}
+
+// hasPathPrefix checks if the target path is the prefix or inside it.
+func hasPathPrefix(target, prefix string) bool {
+	rel, err := filepath.Rel(prefix, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// optionNames returns the names of the given option, including synonyms.
+func optionNames(registry *cliparse.Registry, option cliparse.Option) []string {
+	if command, ok := registry.Command(option.Command); ok {
+		if synonyms, ok := command.Synonyms[option.Name]; ok {
+			return synonyms
+		}
+	}
+	return []string{option.Name}
+}
+
+// volumeSource returns the host part of a `--volume` argument, in the same way
+// that the path translators do.
+func volumeSource(arg string) string {
+	if strings.HasSuffix(arg, ":ro") || strings.HasSuffix(arg, ":rw") {
+		arg = arg[:len(arg)-3]
+	}
+	index := strings.Index(arg, ":")
+	if runtime.GOOS == "windows" {
+		index = strings.LastIndex(arg, ":")
+	}
+	if index < 0 {
+		return arg
+	}
+	return arg[:index]
+}
+
+// mountSources returns the host paths of a `--mount` argument.
+func mountSources(arg string) []string {
+	fields, err := csv.NewReader(strings.NewReader(arg)).Read()
+	if err != nil {
+		return nil
+	}
+	mountType := ""
+	var sources []string
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		switch parts[0] {
+		case "type":
+			mountType = parts[1]
+		case "source", "src":
+			sources = append(sources, parts[1])
+		}
+	}
+	if mountType != "" && mountType != "bind" {
+		return nil
+	}
+	return sources
+}
+
+// check the parsed arguments against the policy, returning the reasons the
+// command is denied (if any).
+func (p *policy) check(registry *cliparse.Registry, result *cliparse.Result) []string {
+	var denials []string
+	for _, option := range result.Options {
+		for _, name := range optionNames(registry, option) {
+			denial := p.checkOption(name, option)
+			if denial != "" {
+				denials = append(denials, denial)
+				break
+			}
+		}
+	}
+	for i, positional := range result.Positionals {
+		if i >= imageCommands[result.CommandPath] || strings.HasPrefix(positional, "-") {
+			break
+		}
+		if denial := p.checkImage(positional); denial != "" {
+			denials = append(denials, denial)
+		}
+	}
+	switch {
+	case result.CommandPath == "container start":
+		// The container may have been created before the policy applied, or
+		// with a different one.
+		denials = append(denials, "container start can not be checked; use container run instead")
+	case result.CommandPath == "image load" && p.AllowedRegistries != nil:
+		denials = append(denials, "image load can not be checked against the allowed registries")
+	case result.CommandPath == "image build":
+		denials = append(denials, p.checkBuild(registry, result)...)
+	case strings.HasPrefix(result.CommandPath, "compose "):
+		if _, ok := composeReadOnlyCommands[result.CommandPath]; !ok {
+			denials = append(denials, p.checkCompose(registry, result)...)
+		}
+	}
+	return denials
+}
+
+// restricts checks if the policy restricts the given option at all.
+func (p *policy) restricts(name string) bool {
+	switch name {
+	case "--privileged":
+		return p.DenyPrivileged
+	case "--pid":
+		return p.DenyHostPID
+	case "--net":
+		return p.DenyHostNetwork
+	case "--volume":
+		return p.AllowedMountPrefixes != nil
+	case "--cpus":
+		return p.MaxCPUs > 0
+	case "--memory":
+		return p.maxMemoryBytes > 0
+	}
+	return false
+}
+
+// checkOption checks a single option, given one of its names.
+func (p *policy) checkOption(name string, option cliparse.Option) string {
+	switch name {
+	case "--privileged":
+		enabled, err := strconv.ParseBool(option.Value)
+		if p.DenyPrivileged && (option.Value == "" || err != nil || enabled) {
+			return fmt.Sprintf("%s is not allowed", option.Name)
+		}
+	case "--pid":
+		if p.DenyHostPID && option.Value == "host" {
+			return fmt.Sprintf("%s=host is not allowed", option.Name)
+		}
+	case "--net", "--network":
+		if p.DenyHostNetwork && option.Value == "host" {
+			return fmt.Sprintf("%s=host is not allowed", option.Name)
+		}
+	case "--volume":
+		if source := volumeSource(option.Value); looksLikePath(source) {
+			return p.checkMount(source)
+		}
+	case "--mount":
+		for _, source := range mountSources(option.Value) {
+			if denial := p.checkMount(source); denial != "" {
+				return denial
+			}
+		}
+	case "--cpus":
+		if p.MaxCPUs > 0 {
+			cpus, err := strconv.ParseFloat(option.Value, 64)
+			if err != nil {
+				return fmt.Sprintf("%s value %q is invalid", option.Name, option.Value)
+			}
+			if cpus > p.MaxCPUs {
+				return fmt.Sprintf("%s=%s exceeds the maximum of %g", option.Name, option.Value, p.MaxCPUs)
+			}
+		}
+	case "--memory":
+		if p.maxMemoryBytes > 0 {
+			memory, err := parseMemorySize(option.Value)
+			if err != nil {
+				return fmt.Sprintf("%s value %q is invalid", option.Name, option.Value)
+			}
+			if memory > p.maxMemoryBytes {
+				return fmt.Sprintf("%s=%s exceeds the maximum of %s", option.Name, option.Value, p.MaxMemory)
+			}
+		}
+	}
+	return ""
+}
+
+// checkMount checks that the given host path may be bind mounted.
+func (p *policy) checkMount(source string) string {
+	if p.AllowedMountPrefixes == nil {
+		return ""
+	}
+	resolved := resolvePath(source)
+	for _, prefix := range p.AllowedMountPrefixes {
+		if hasPathPrefix(resolved, prefix) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("bind mount of %s is not allowed; allowed directories are: %s",
+		source, strings.Join(p.AllowedMountPrefixes, ", "))
+}
+
+// checkImage checks that the given image is from an allowed registry.
+func (p *policy) checkImage(image string) string {
+	if p.AllowedRegistries == nil {
+		return ""
+	}
+	name := parseImageReference(image).name()
+	for _, allowed := range p.AllowedRegistries {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if name == allowed || strings.HasPrefix(name, allowed+"/") {
+			return ""
+		}
+	}
+	return fmt.Sprintf("image %s is not from an allowed registry; allowed registries are: %s",
+		image, strings.Join(p.AllowedRegistries, ", "))
+}
+
+// checkFileImage checks an image named in a Dockerfile or compose file; these
+// are rewritten before nerdctl sees them, and may use variables.
+func (p *policy) checkFileImage(image string) string {
+	if p.AllowedRegistries == nil {
+		return ""
+	}
+	if strings.Contains(image, "$") {
+		return fmt.Sprintf("image %s uses variables, so it can not be checked", image)
+	}
+	if p.rewriter != nil {
+		image, _ = p.rewriter.apply(image)
+	}
+	return p.checkImage(image)
+}
+
+// checkDockerfile checks the images in the `FROM` lines of a Dockerfile.
+func (p *policy) checkDockerfile(name string, contents []byte) []string {
+	var denials []string
+	for _, from := range dockerfileImages(strings.Split(string(contents), "\n")) {
+		if denial := p.checkFileImage(from.image); denial != "" {
+			denials = append(denials, fmt.Sprintf("%s: %s", name, denial))
+		}
+	}
+	return denials
+}
+
+// checkDockerfilePath checks the Dockerfile at the given path.  If it does not
+// exist, nerdctl will report the error.
+func (p *policy) checkDockerfilePath(dockerfile string) []string {
+	contents, err := readUserFile(dockerfile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return []string{fmt.Sprintf("could not read %s: %s", dockerfile, err)}
+	}
+	return p.checkDockerfile(dockerfile, contents)
+}
+
+// checkBuild checks the images used by `image build`.
+func (p *policy) checkBuild(registry *cliparse.Registry, result *cliparse.Result) []string {
+	if p.AllowedRegistries == nil || len(result.Positionals) == 0 {
+		return nil
+	}
+	dockerfile, ok := buildDockerfile(registry, result)
+	if !ok {
+		return []string{"only local Dockerfiles can be checked against the allowed registries"}
+	}
+	return p.checkDockerfilePath(dockerfile)
+}
+
+// checkCompose checks the services in the compose files used by a compose
+// command.
+func (p *policy) checkCompose(registry *cliparse.Registry, result *cliparse.Result) []string {
+	var composePaths []string
+	for _, option := range result.Options {
+		if option.Command == "compose" && contains(optionNames(registry, option), "--file") {
+			composePaths = append(composePaths, option.Value)
+		}
+	}
+	if len(composePaths) == 0 {
+		if composePath := defaultComposeFile(registry, result); composePath != "" {
+			composePaths = append(composePaths, composePath)
+		}
+	}
+	// Relative paths are relative to the project directory, which defaults to
+	// the directory of the first compose file.
+	projectDir, ok := findOption(registry, result, "compose", "--project-directory")
+	if !ok && len(composePaths) > 0 {
+		projectDir = filepath.Dir(composePaths[0])
+	}
+	var denials []string
+	for _, composePath := range composePaths {
+		if composePath == "-" {
+			denials = append(denials, "compose files read from standard input can not be checked")
+			continue
+		}
+		contents, err := readUserFile(composePath)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				denials = append(denials, fmt.Sprintf("could not read %s: %s", composePath, err))
+			}
+			continue
+		}
+		denials = append(denials, p.checkComposeFile(composePath, projectDir, contents)...)
+	}
+	return denials
+}
+
+// checkComposeFile checks the services in a compose file, in the same way as
+// the options for `container run`.
+func (p *policy) checkComposeFile(composePath, projectDir string, contents []byte) []string {
+	var document yaml.Node
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return []string{fmt.Sprintf("could not parse %s: %s", composePath, err)}
+	}
+	var denials []string
+	if len(document.Content) > 0 && mappingValue(document.Content[0], "include") != nil {
+		denials = append(denials, fmt.Sprintf("%s: included compose files can not be checked", composePath))
+	}
+	services := composeServices(&document)
+	if services == nil {
+		return denials
+	}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, service := services.Content[i].Value, services.Content[i+1]
+		for _, denial := range p.checkComposeService(projectDir, service) {
+			denials = append(denials, fmt.Sprintf("%s: service %s: %s", composePath, name, denial))
+		}
+	}
+	return denials
+}
+
+// checkComposeService checks a single compose service.
+func (p *policy) checkComposeService(projectDir string, service *yaml.Node) []string {
+	var denials []string
+	add := func(denial string) {
+		if denial != "" {
+			denials = append(denials, denial)
+		}
+	}
+	if image := mappingValue(service, "image"); image != nil {
+		add(p.checkFileImage(image.Value))
+	}
+	if extends := mappingValue(service, "extends"); mappingValue(extends, "file") != nil {
+		add("services extended from other files can not be checked")
+	}
+	for _, keyOption := range composeServiceOptions {
+		value := mappingValue(service, keyOption.key)
+		if value == nil {
+			continue
+		}
+		if strings.Contains(value.Value, "$") {
+			if p.restricts(keyOption.option) {
+				add(fmt.Sprintf("%s uses variables, so it can not be checked", keyOption.key))
+			}
+			continue
+		}
+		add(p.checkOption(keyOption.option, cliparse.Option{Name: keyOption.key, HasValue: true, Value: value.Value}))
+	}
+	if volumes := mappingValue(service, "volumes"); volumes != nil {
+		for _, volume := range volumes.Content {
+			source := volumeSource(volume.Value)
+			if volume.Kind == yaml.MappingNode {
+				mountType, value := mappingValue(volume, "type"), mappingValue(volume, "source")
+				if mountType == nil || mountType.Value != "bind" || value == nil {
+					continue
+				}
+				source = value.Value
+			}
+			if strings.Contains(source, "$") {
+				if p.restricts("--volume") {
+					add(fmt.Sprintf("volume %s uses variables, so it can not be checked", source))
+				}
+				continue
+			}
+			if volume.Kind == yaml.MappingNode || looksLikePath(source) {
+				add(p.checkMount(composeHostPath(projectDir, source)))
+			}
+		}
+	}
+	if build := mappingValue(service, "build"); build != nil {
+		denials = append(denials, p.checkComposeBuild(projectDir, build)...)
+	}
+	return denials
+}
+
+// checkComposeBuild checks the images used to build a compose service.
+func (p *policy) checkComposeBuild(projectDir string, build *yaml.Node) []string {
+	if p.AllowedRegistries == nil {
+		return nil
+	}
+	buildContext, dockerfile := build.Value, "Dockerfile"
+	if build.Kind == yaml.MappingNode {
+		if inline := mappingValue(build, "dockerfile_inline"); inline != nil {
+			return p.checkDockerfile("dockerfile_inline", []byte(inline.Value))
+		}
+		buildContext = "."
+		if value := mappingValue(build, "context"); value != nil {
+			buildContext = value.Value
+		}
+		if value := mappingValue(build, "dockerfile"); value != nil {
+			dockerfile = value.Value
+		}
+	}
+	if strings.Contains(buildContext+dockerfile, "$") || strings.Contains(buildContext, "://") {
+		return []string{fmt.Sprintf("build context %s can not be checked against the allowed registries", buildContext)}
+	}
+	buildContext = composeHostPath(projectDir, buildContext)
+	if !filepath.IsAbs(dockerfile) {
+		dockerfile = filepath.Join(buildContext, dockerfile)
+	}
+	return p.checkDockerfilePath(dockerfile)
+}
+
+// composeHostPath resolves a host path in a compose file, which may be relative
+// to the project directory or the home directory.
+func composeHostPath(projectDir, hostPath string) string {
+	if hostPath == "~" || strings.HasPrefix(hostPath, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, hostPath[1:])
+		}
+	}
+	if filepath.IsAbs(hostPath) {
+		return hostPath
+	}
+	return filepath.Join(projectDir, hostPath)
+}
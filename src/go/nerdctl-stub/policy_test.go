@@ -0,0 +1,243 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPolicyFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+		result, err := loadPolicyFile(filepath.Join(dir, "missing.yaml"))
+		assert.NoError(t, err)
+		assert.Nil(t, result)
+	})
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		policyPath := filepath.Join(dir, "valid.yaml")
+		contents := "denyPrivileged: true\nmaxMemory: 1.5g\nallowedRegistries: [docker.io]\n"
+		require.NoError(t, os.WriteFile(policyPath, []byte(contents), 0o644))
+		result, err := loadPolicyFile(policyPath)
+		if assert.NoError(t, err) {
+			assert.True(t, result.DenyPrivileged)
+			assert.Equal(t, int64(1536*1024*1024), result.maxMemoryBytes)
+			assert.Equal(t, []string{"docker.io"}, result.AllowedRegistries)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for name, contents := range map[string]string{
+			"unknown.yaml": "denyEverything: true\n",
+			"memory.yaml":  "maxMemory: lots\n",
+		} {
+			policyPath := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(policyPath, []byte(contents), 0o644))
+			_, err := loadPolicyFile(policyPath)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestParseMemorySize(t *testing.T) {
+	t.Parallel()
+	for input, expected := range map[string]int64{
+		"1024":   1024,
+		"512k":   512 * 1024,
+		"2m":     2 * 1024 * 1024,
+		"1.5GiB": 1536 * 1024 * 1024,
+		"1gb":    1024 * 1024 * 1024,
+	} {
+		actual, err := parseMemorySize(input)
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, expected, actual, input)
+		}
+	}
+	for _, input := range []string{"", "m", "1x", "-1g"} {
+		_, err := parseMemorySize(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	t.Parallel()
+	allowedDir := t.TempDir()
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	require.NoError(t, err)
+	pol := &policy{
+		DenyPrivileged:       true,
+		DenyHostPID:          true,
+		DenyHostNetwork:      true,
+		AllowedMountPrefixes: []string{resolvePath(allowedDir)},
+		AllowedRegistries:    []string{"docker.io/library", "registry.example.com"},
+		MaxCPUs:              2,
+		MaxMemory:            "1g",
+		maxMemoryBytes:       1024 * 1024 * 1024,
+	}
+	otherDir := filepath.Join(filepath.Dir(allowedDir), "other")
+
+	testCases := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"run", "--rm", "alpine"}, nil},
+		{[]string{"run", "--privileged=false", "alpine"}, nil},
+		{[]string{"run", "--privileged", "alpine"}, []string{"--privileged is not allowed"}},
+		{[]string{"exec", "--privileged", "web", "sh"}, []string{"--privileged is not allowed"}},
+		{[]string{"run", "--pid", "host", "--net=host", "alpine"}, []string{
+			"--pid=host is not allowed",
+			"--net=host is not allowed",
+		}},
+		{[]string{"run", "--network", "bridge", "alpine"}, nil},
+		{[]string{"run", "-v", allowedDir + "/sub:/data", "-v", "named:/x", "alpine"}, nil},
+		{[]string{"run", "--volume", otherDir + ":/data:ro", "alpine"}, []string{
+			"bind mount of " + otherDir + " is not allowed; allowed directories are: " + resolvePath(allowedDir),
+		}},
+		{[]string{"run", "--cpus", "1.5", "-m", "512m", "alpine"}, nil},
+		{[]string{"run", "--cpus", "4", "--memory=2g", "alpine"}, []string{
+			"--cpus=4 exceeds the maximum of 2",
+			"--memory=2g exceeds the maximum of 1g",
+		}},
+		{[]string{"run", "--cpus", "many", "alpine"}, []string{`--cpus value "many" is invalid`}},
+		{[]string{"run", "nginx:latest"}, nil},
+		{[]string{"pull", "registry.example.com/team/app@sha256:abc"}, nil},
+		{[]string{"image", "push", "evil.example.com/app"}, []string{
+			"image evil.example.com/app is not from an allowed registry; allowed registries are: docker.io/library, registry.example.com",
+		}},
+		{[]string{"run", "someone/image", "--privileged"}, []string{
+			"image someone/image is not from an allowed registry; allowed registries are: docker.io/library, registry.example.com",
+		}},
+		{[]string{"commit", "-m", "100g", "web"}, nil},
+		{[]string{"tag", "nginx", "evil.example.com/nginx"}, []string{
+			"image evil.example.com/nginx is not from an allowed registry; allowed registries are: docker.io/library, registry.example.com",
+		}},
+		{[]string{"start", "web"}, []string{"container start can not be checked; use container run instead"}},
+		{[]string{"load", "--input", "images.tar"}, []string{"image load can not be checked against the allowed registries"}},
+	}
+	for _, testCase := range testCases {
+		result, err := registry.Parse(testCase.args)
+		if assert.NoError(t, err, "%v", testCase.args) {
+			assert.Equal(t, testCase.expected, pol.check(registry, result), "%v", testCase.args)
+		}
+	}
+
+	var empty policy
+	result, err := registry.Parse([]string{"run", "--privileged", "-v", "/:/host", "evil.example.com/app"})
+	if assert.NoError(t, err) {
+		assert.Empty(t, empty.check(registry, result))
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	t.Parallel()
+	for input, expected := range map[string]imageReference{
+		"alpine":                         {domain: "docker.io", path: "library/alpine"},
+		"alpine:3.14":                    {domain: "docker.io", path: "library/alpine", tag: "3.14"},
+		"index.docker.io/me/app":         {domain: "docker.io", path: "me/app"},
+		"localhost/app":                  {domain: "localhost", path: "app"},
+		"localhost:5000/app:v1":          {domain: "localhost:5000", path: "app", tag: "v1"},
+		"ghcr.io/org/app@sha256:abcdef":  {domain: "ghcr.io", path: "org/app", digest: "sha256:abcdef"},
+		"ghcr.io/org/app:v2@sha256:abcd": {domain: "ghcr.io", path: "org/app", tag: "v2", digest: "sha256:abcd"},
+	} {
+		assert.Equal(t, expected, parseImageReference(input), input)
+	}
+}
+
+func TestPolicyCheckFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	allowedDir := filepath.Join(dir, "allowed")
+	registry, err := newRegistry(&argHandlers{directPathTranslator{}})
+	require.NoError(t, err)
+	rewriter, err := newImageRewriter([]imageRewriteRule{
+		{Prefix: "docker.io/mirrored/", Replacement: "registry.example.com/mirror/"},
+	}, nil)
+	require.NoError(t, err)
+	pol := &policy{
+		DenyPrivileged:       true,
+		DenyHostNetwork:      true,
+		AllowedMountPrefixes: []string{resolvePath(allowedDir)},
+		AllowedRegistries:    []string{"docker.io/library", "registry.example.com"},
+		MaxCPUs:              2,
+		rewriter:             rewriter,
+	}
+	writeFile := func(name, contents string) string {
+		filePath := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0o755))
+		require.NoError(t, os.WriteFile(filePath, []byte(contents), 0o644))
+		return filePath
+	}
+	notAllowed := " is not from an allowed registry; allowed registries are: docker.io/library, registry.example.com"
+
+	allowedCompose := writeFile("allowed.yaml", `
+services:
+  web:
+    image: nginx
+    cpus: 1
+    privileged: false
+    volumes: [./allowed/data:/data, named:/named]
+  mirrored:
+    image: mirrored/app
+  built:
+    build: ./app
+`)
+	writeFile("app/Dockerfile", "FROM nginx AS base\nFROM base\nFROM scratch\n")
+	deniedCompose := writeFile("denied.yaml", `
+services:
+  web:
+    image: evil.example.com/app
+    privileged: true
+    network_mode: host
+    cpus: "4"
+  data:
+    image: nginx:${TAG}
+    volumes:
+      - type: bind
+        source: /etc
+        target: /etc
+  built:
+    build:
+      context: ./evil
+      dockerfile: Containerfile
+`)
+	writeFile("evil/Containerfile", "FROM evil.example.com/base\n")
+	writeFile("default/compose.yaml", "services:\n  web:\n    privileged: true\n")
+	writeFile("build/Dockerfile", "FROM evil.example.com/base AS build\nFROM build\n")
+
+	testCases := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"compose", "-f", allowedCompose, "up"}, nil},
+		{[]string{"compose", "-f", deniedCompose, "down"}, nil},
+		{[]string{"compose", "-f", deniedCompose, "up", "-d"}, []string{
+			deniedCompose + ": service web: image evil.example.com/app" + notAllowed,
+			deniedCompose + ": service web: privileged is not allowed",
+			deniedCompose + ": service web: network_mode=host is not allowed",
+			deniedCompose + ": service web: cpus=4 exceeds the maximum of 2",
+			deniedCompose + ": service data: image nginx:${TAG} uses variables, so it can not be checked",
+			deniedCompose + ": service data: bind mount of /etc is not allowed; allowed directories are: " + resolvePath(allowedDir),
+			deniedCompose + ": service built: " + filepath.Join(dir, "evil", "Containerfile") + ": image evil.example.com/base" + notAllowed,
+		}},
+		{[]string{"compose", "--project-directory", filepath.Join(dir, "default"), "up"}, []string{
+			filepath.Join(dir, "default", "compose.yaml") + ": service web: privileged is not allowed",
+		}},
+		{[]string{"compose", "-f", "-", "up"}, []string{"compose files read from standard input can not be checked"}},
+		{[]string{"build", filepath.Join(dir, "app")}, nil},
+		{[]string{"build", filepath.Join(dir, "build")}, []string{
+			filepath.Join(dir, "build", "Dockerfile") + ": image evil.example.com/base" + notAllowed,
+		}},
+		{[]string{"build", "-f", "-", "."}, []string{"only local Dockerfiles can be checked against the allowed registries"}},
+	}
+	for _, testCase := range testCases {
+		result, err := registry.Parse(testCase.args)
+		if assert.NoError(t, err, "%v", testCase.args) {
+			assert.Equal(t, testCase.expected, pol.check(registry, result), "%v", testCase.args)
+		}
+	}
+}
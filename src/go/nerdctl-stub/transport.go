@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// transportType is the kind of transport used to run nerdctl.
+type transportType string
+
+const (
+	// transportWSL runs nerdctl in the rancher-desktop WSL distribution via
+	// wsl.exe; this is the default.
+	transportWSL = transportType("wsl")
+	// transportDirect runs nerdctl directly; this is for when the stub runs in
+	// the rancher-desktop distribution itself, or on plain Linux.
+	transportDirect = transportType("direct")
+	// transportSSH runs nerdctl on a remote containerd host over SSH; files
+	// are staged over SFTP.
+	transportSSH = transportType("ssh")
+)
+
+// transport runs nerdctl somewhere the containerd socket is available.
+type transport interface {
+	// paths returns the translator for paths in arguments.
+	paths() pathTranslator
+	// run runs nerdctl with the given arguments, extra environment variables
+	// (as `NAME=value`) and streams, and returns its exit code.  An error is
+	// only returned if nerdctl could not be run.
+	run(args, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+	// exec runs an arbitrary command where nerdctl runs, in the same way as
+	// run.
+	exec(command, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+}
+
+// newTransport creates the transport described by the given options.
+func newTransport(opts spawnOptions, config transportConfig) (transport, error) {
+	switch opts.transport {
+	case transportWSL, "":
+		return &wslTransport{opts: opts}, nil
+	case transportDirect:
+		if err := dropPrivileges(); err != nil {
+			return nil, err
+		}
+		return &directTransport{opts: opts}, nil
+	case transportSSH:
+		if err := dropPrivileges(); err != nil {
+			return nil, err
+		}
+		return newSSHTransport(opts, config.SSH)
+	}
+	return nil, fmt.Errorf("unknown transport %q", opts.transport)
+}
+
+// runCommand runs the given command with the given streams, and returns its
+// exit code.
+func runCommand(cmd *exec.Cmd, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// envCommand returns the command line to run the given command with the extra
+// environment variables, for transports that can only run a command line.
+func envCommand(env []string, command ...string) []string {
+	if len(env) == 0 {
+		return command
+	}
+	return append(append([]string{"/usr/bin/env"}, env...), command...)
+}
+
+// wslTransport runs nerdctl in a WSL distribution via wsl.exe.
+type wslTransport struct {
+	opts       spawnOptions
+	translator wslPathTranslator
+}
+
+func (t *wslTransport) paths() pathTranslator {
+	return &t.translator
+}
+
+func (t *wslTransport) run(args, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	command := append([]string{t.opts.nerdctl, "--address", t.opts.containerdSocket}, args...)
+	return t.exec(command, env, stdin, stdout, stderr)
+}
+
+func (t *wslTransport) exec(command, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	wslArgs := append([]string{"--distribution", t.opts.distro, "--exec"}, envCommand(env, command...)...)
+	cmd := exec.Command("wsl.exe", wslArgs...)
+	return runCommand(cmd, stdin, stdout, stderr)
+}
+
+// directTransport runs nerdctl as a child process.
+type directTransport struct {
+	opts spawnOptions
+}
+
+func (t *directTransport) paths() pathTranslator {
+	return directPathTranslator{}
+}
+
+func (t *directTransport) run(args, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	command := append([]string{t.opts.nerdctl, "--address", t.opts.containerdSocket}, args...)
+	return t.exec(command, env, stdin, stdout, stderr)
+}
+
+func (t *directTransport) exec(command, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return runCommand(cmd, stdin, stdout, stderr)
+}
+
+// directPathTranslator is the path translator for the direct transport; as
+// nerdctl sees the same file system as the stub, paths are unchanged.
+type directPathTranslator struct{}
+
+func (directPathTranslator) prepare() error {
+	return nil
+}
+
+func (directPathTranslator) cleanup() error {
+	return nil
+}
+
+func (directPathTranslator) volumeArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	return arg, nil, nil
+}
+
+func (directPathTranslator) filePathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	return arg, nil, nil
+}
+
+func (directPathTranslator) outputPathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	return arg, nil, nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// sshTransport runs nerdctl on a remote host over SSH.  Files referred to by
+// arguments are copied to a staging directory on the remote host over SFTP;
+// output files are copied back once nerdctl exits.
+type sshTransport struct {
+	opts   spawnOptions
+	config sshConfig
+	// client is the SSH connection; it is only set up once needed.
+	client *ssh.Client
+	// sftp is the SFTP session for staging files.
+	sftp *sftp.Client
+	// workdir is the remote staging directory for this invocation.
+	workdir string
+	// staged is the number of files staged so far, used to generate names.
+	staged int
+}
+
+// newSSHTransport creates a SSH transport; this does not connect to the remote
+// host until it is needed.
+func newSSHTransport(opts spawnOptions, config sshConfig) (*sshTransport, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("ssh transport: no host configured")
+	}
+	if _, _, err := net.SplitHostPort(config.Host); err != nil {
+		config.Host = net.JoinHostPort(config.Host, "22")
+	}
+	if config.User == "" {
+		currentUser, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("ssh transport: could not determine user: %w", err)
+		}
+		config.User = currentUser.Username
+	}
+	if config.KnownHostsFile == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("ssh transport: could not find known hosts: %w", err)
+		}
+		config.KnownHostsFile = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+	if config.StagingDir == "" {
+		config.StagingDir = "/tmp"
+	}
+	return &sshTransport{opts: opts, config: config}, nil
+}
+
+// authMethods returns the methods to use to authenticate to the remote host.
+func (t *sshTransport) authMethods() ([]ssh.AuthMethod, error) {
+	if t.config.IdentityFile != "" {
+		keyData, err := os.ReadFile(t.config.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", t.config.IdentityFile, err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("no identity file configured and SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to SSH agent: %w", err)
+	}
+	return []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(conn).Signers)}, nil
+}
+
+// connect to the remote host, if not already connected.
+func (t *sshTransport) connect() error {
+	if t.client != nil {
+		return nil
+	}
+	auth, err := t.authMethods()
+	if err != nil {
+		return fmt.Errorf("ssh transport: %w", err)
+	}
+	hostKeyCallback, err := knownhosts.New(t.config.KnownHostsFile)
+	if err != nil {
+		return fmt.Errorf("ssh transport: could not read known hosts: %w", err)
+	}
+	client, err := ssh.Dial("tcp", t.config.Host, &ssh.ClientConfig{
+		User:            t.config.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("ssh transport: could not connect to %s: %w", t.config.Host, err)
+	}
+	t.client = client
+	return nil
+}
+
+// close the connection to the remote host, if any.
+func (t *sshTransport) close() error {
+	var err error
+	if t.sftp != nil {
+		err = t.sftp.Close()
+		t.sftp = nil
+	}
+	if t.client != nil {
+		if closeErr := t.client.Close(); err == nil {
+			err = closeErr
+		}
+		t.client = nil
+	}
+	return err
+}
+
+func (t *sshTransport) paths() pathTranslator {
+	return t
+}
+
+// shellQuote quotes the given words for use in a POSIX shell command line.
+func shellQuote(words []string) string {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, "'"+strings.ReplaceAll(word, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (t *sshTransport) run(args, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	command := append([]string{t.opts.nerdctl, "--address", t.opts.containerdSocket}, args...)
+	return t.exec(command, env, stdin, stdout, stderr)
+}
+
+func (t *sshTransport) exec(command, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if err := t.connect(); err != nil {
+		return 0, err
+	}
+	session, err := t.client.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("ssh transport: could not create session: %w", err)
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if stdin != nil {
+		// The session waits for any Stdin copy to finish, which would block
+		// if the input is a terminal; copy it ourselves instead.
+		input, err := session.StdinPipe()
+		if err != nil {
+			return 0, err
+		}
+		go func() {
+			_, _ = io.Copy(input, stdin)
+			_ = input.Close()
+		}()
+	}
+	// Servers usually refuse to set environment variables, so use env(1).
+	err = session.Run(shellQuote(envCommand(env, command...)))
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ssh transport: %w", err)
+	}
+	return 0, nil
+}
+
+// prepare connects to the remote host and creates the staging directory.
+func (t *sshTransport) prepare() error {
+	if err := t.connect(); err != nil {
+		return err
+	}
+	client, err := sftp.NewClient(t.client)
+	if err != nil {
+		return fmt.Errorf("ssh transport: could not start SFTP: %w", err)
+	}
+	t.sftp = client
+	suffix := make([]byte, 8)
+	if _, err = rand.Read(suffix); err != nil {
+		return err
+	}
+	workdir := path.Join(t.config.StagingDir, "nerdctl-tmp."+hex.EncodeToString(suffix))
+	if err = t.sftp.Mkdir(workdir); err != nil {
+		return fmt.Errorf("ssh transport: could not create %s: %w", workdir, err)
+	}
+	t.workdir = workdir
+	return t.sftp.Chmod(workdir, 0o700)
+}
+
+// cleanup removes the staging directory and disconnects.
+func (t *sshTransport) cleanup() error {
+	var err error
+	if t.sftp != nil && t.workdir != "" {
+		err = t.removeAll(t.workdir)
+		t.workdir = ""
+	}
+	if closeErr := t.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// removeAll removes the given remote directory and its contents.
+func (t *sshTransport) removeAll(root string) error {
+	var files, dirs []string
+	walker := t.sftp.Walk(root)
+	for walker.Step() {
+		if walker.Err() != nil {
+			continue
+		}
+		if walker.Stat().IsDir() {
+			dirs = append(dirs, walker.Path())
+		} else {
+			files = append(files, walker.Path())
+		}
+	}
+	for _, file := range files {
+		if err := t.sftp.Remove(file); err != nil {
+			return err
+		}
+	}
+	// Remove the deepest directories first.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if err := t.sftp.RemoveDirectory(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagingPath returns a new path in the remote staging directory.
+func (t *sshTransport) stagingPath(prefix string) string {
+	t.staged++
+	return path.Join(t.workdir, fmt.Sprintf("%s.%d", prefix, t.staged))
+}
+
+// upload copies the given local file or directory to the remote host.
+func (t *sshTransport) upload(localPath, remotePath string) error {
+	return filepath.Walk(localPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(localPath, filePath)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remotePath, filepath.ToSlash(relPath))
+		switch {
+		case info.IsDir():
+			if err = t.sftp.MkdirAll(target); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			return t.sftp.Symlink(filepath.ToSlash(link), target)
+		case info.Mode().IsRegular():
+			if err = t.uploadFile(filePath, target); err != nil {
+				return err
+			}
+		default:
+			log.Printf("Skipping special file %s", filePath)
+			return nil
+		}
+		return t.sftp.Chmod(target, info.Mode().Perm())
+	})
+}
+
+// uploadFile copies a single regular file to the remote host.
+func (t *sshTransport) uploadFile(localPath, remotePath string) error {
+	input, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := t.sftp.Create(remotePath)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	_, err = io.Copy(output, input)
+	return err
+}
+
+// download copies a single remote file to the local host.
+func (t *sshTransport) download(remotePath, localPath string) error {
+	input, err := t.sftp.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	_, err = io.Copy(output, input)
+	return err
+}
+
+// stage uploads the given local path into a new directory in the staging
+// directory, keeping its base name, and returns the remote path.
+func (t *sshTransport) stage(localPath string) (string, error) {
+	absPath, err := filepath.Abs(localPath)
+	if err != nil {
+		return "", err
+	}
+	// Copy the target if the path given is a symbolic link.
+	absPath, err = filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", err
+	}
+	dir := t.stagingPath("input")
+	if err = t.sftp.Mkdir(dir); err != nil {
+		return "", err
+	}
+	remotePath := path.Join(dir, filepath.Base(absPath))
+	if err = t.upload(absPath, remotePath); err != nil {
+		return "", fmt.Errorf("could not copy %s to remote host: %w", localPath, err)
+	}
+	return remotePath, nil
+}
+
+// volumeArgHandler handles the argument for `nerdctl run --volume=...`.  The
+// host directory is copied to the remote host; changes made in the container
+// are not copied back.
+func (t *sshTransport) volumeArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	cleanArg := arg
+	readWrite := ""
+	if strings.HasSuffix(arg, ":ro") || strings.HasSuffix(arg, ":rw") {
+		readWrite = arg[len(arg)-3:]
+		cleanArg = arg[:len(arg)-3]
+	}
+	// For now, assume the container path doesn't contain colons.
+	colonIndex := strings.LastIndex(cleanArg, ":")
+	if colonIndex < 0 || !looksLikePath(cleanArg[:colonIndex]) {
+		// This is an anonymous or named volume.
+		return arg, nil, nil
+	}
+	hostPath := cleanArg[:colonIndex]
+	containerPath := cleanArg[colonIndex+1:]
+	remotePath, err := t.stage(hostPath)
+	if err != nil {
+		return "", nil, err
+	}
+	log.Printf("Copied %s to the remote host; changes will not be copied back", hostPath)
+	return remotePath + ":" + containerPath + readWrite, nil, nil
+}
+
+// filePathArgHandler handles arguments that take a file path for input
+func (t *sshTransport) filePathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	remotePath, err := t.stage(arg)
+	if err != nil {
+		return "", nil, err
+	}
+	return remotePath, nil, nil
+}
+
+// outputPathArgHandler handles arguments that take a file path to indicate
+// where some file should be output; the file is copied back after nerdctl
+// exits.
+func (t *sshTransport) outputPathArgHandler(arg string) (string, []cliparse.CleanupFunc, error) {
+	remotePath := t.stagingPath("output")
+	callback := func() error {
+		if _, err := t.sftp.Stat(remotePath); errors.Is(err, os.ErrNotExist) {
+			// nerdctl did not write the file (probably because it failed).
+			return nil
+		}
+		defer t.sftp.Remove(remotePath)
+		return t.download(remotePath, arg)
+	}
+	return remotePath, []cliparse.CleanupFunc{callback}, nil
+}
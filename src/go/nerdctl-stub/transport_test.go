@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/nerdctl-stub/pkg/cliparse"
+)
+
+// fakeNerdctlEnv is set when the test executable should act as nerdctl.
+const fakeNerdctlEnv = "RD_NERDCTL_STUB_FAKE_NERDCTL"
+
+func TestMain(m *testing.M) {
+	if storePath := os.Getenv(fakeCredentialHelperEnv); storePath != "" {
+		os.Exit(fakeCredentialHelper(storePath, os.Args[1:]))
+	}
+	if os.Getenv(fakeNerdctlEnv) != "" {
+		os.Exit(fakeNerdctl(os.Args[1:]))
+	}
+	os.Setenv(fakeNerdctlEnv, "1")
+	os.Exit(m.Run())
+}
+
+// fakeNerdctl acts as nerdctl for the transport tests; it expects to be called
+// as `nerdctl --address <socket> <action> <args...>`.
+func fakeNerdctl(args []string) int {
+	if len(args) < 3 || args[0] != "--address" {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", args)
+		return 100
+	}
+	action, args := args[2], args[3:]
+	switch action {
+	case "echo":
+		fmt.Printf("%s %s\n", args[0], strings.Join(args[1:], "|"))
+	case "cat":
+		_, _ = io.Copy(os.Stdout, os.Stdin)
+	case "env":
+		fmt.Println(os.Getenv(args[0]))
+	case "fail":
+		fmt.Fprintln(os.Stderr, args[1])
+		code, _ := strconv.Atoi(args[0])
+		return code
+	case "read":
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 101
+		}
+		os.Stdout.Write(data)
+	case "write":
+		if err := os.WriteFile(args[0], []byte(args[1]), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 101
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown action %q\n", action)
+		return 100
+	}
+	return 0
+}
+
+// testTransportRun checks that the given transport preserves streams and exit
+// codes.
+func testTransportRun(t *testing.T, tr transport) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code, err := tr.run([]string{"echo", "/socket", "it's", "a test"}, nil, nil, stdout, stderr)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "/socket it's|a test\n", stdout.String())
+		assert.Empty(t, stderr.String())
+	}
+
+	stdout.Reset()
+	code, err = tr.run([]string{"cat"}, nil, strings.NewReader("some input"), stdout, io.Discard)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "some input", stdout.String())
+	}
+
+	stdout.Reset()
+	code, err = tr.run([]string{"env", "DOCKER_CONFIG"}, []string{"DOCKER_CONFIG=/some dir"}, nil, stdout, io.Discard)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "/some dir\n", stdout.String())
+	}
+
+	stdout.Reset()
+	code, err = tr.run([]string{"fail", "3", "oops"}, nil, nil, stdout, stderr)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, code)
+		assert.Empty(t, stdout.String())
+		assert.Equal(t, "oops\n", stderr.String())
+	}
+}
+
+func fakeNerdctlOptions(t *testing.T) spawnOptions {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	return spawnOptions{nerdctl: executable, containerdSocket: "/socket"}
+}
+
+func TestDirectTransport(t *testing.T) {
+	opts := fakeNerdctlOptions(t)
+	opts.transport = transportDirect
+	tr, err := newTransport(opts, transportConfig{})
+	require.NoError(t, err)
+	testTransportRun(t, tr)
+
+	handlers := &argHandlers{tr.paths()}
+	registry, err := newRegistry(handlers)
+	require.NoError(t, err)
+	result, err := registry.Parse([]string{"run", "-v", "/host:/container", "--cidfile", "out", "image"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"run", "-v", "/host:/container", "--cidfile", "out", "image"}, result.Args)
+	}
+}
+
+func TestUnknownTransport(t *testing.T) {
+	_, err := newTransport(spawnOptions{transport: "carrier-pigeon"}, transportConfig{})
+	assert.EqualError(t, err, `unknown transport "carrier-pigeon"`)
+}
+
+// shellSplit reverses shellQuote.
+func shellSplit(command string) []string {
+	var words []string
+	for _, quoted := range strings.Split(command, "' '") {
+		quoted = strings.TrimSuffix(strings.TrimPrefix(quoted, "'"), "'")
+		words = append(words, strings.ReplaceAll(quoted, `'\''`, "'"))
+	}
+	return words
+}
+
+// startSSHServer starts a minimal SSH server that accepts the given client key,
+// runs commands locally, and serves SFTP.  It returns the address to connect
+// to, and the host key.
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) (string, ssh.PublicKey) {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return listener.Addr().String(), hostSigner.PublicKey()
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for request := range requests {
+		var payload struct{ Value string }
+		if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+			_ = request.Reply(false, nil)
+			continue
+		}
+		switch request.Type {
+		case "exec":
+			_ = request.Reply(true, nil)
+			words := shellSplit(payload.Value)
+			cmd := exec.Command(words[0], words[1:]...)
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			stdin, _ := cmd.StdinPipe()
+			go func() {
+				_, _ = io.Copy(stdin, channel)
+				stdin.Close()
+			}()
+			status := struct{ Status uint32 }{}
+			if err := cmd.Run(); err != nil {
+				status.Status = uint32(cmd.ProcessState.ExitCode())
+			}
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+			return
+		case "subsystem":
+			if payload.Value != "sftp" {
+				_ = request.Reply(false, nil)
+				continue
+			}
+			_ = request.Reply(true, nil)
+			server, err := sftp.NewServer(channel)
+			if err == nil {
+				_ = server.Serve()
+			}
+			return
+		default:
+			_ = request.Reply(false, nil)
+		}
+	}
+}
+
+func TestSSHTransport(t *testing.T) {
+	workdir := t.TempDir()
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalECPrivateKey(clientKey)
+	require.NoError(t, err)
+	identityFile := filepath.Join(workdir, "id_ecdsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	require.NoError(t, os.WriteFile(identityFile, keyPEM, 0o600))
+	clientPublicKey, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	require.NoError(t, err)
+
+	addr, hostKey := startSSHServer(t, clientPublicKey)
+	knownHostsFile := filepath.Join(workdir, "known_hosts")
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey) + "\n"
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownHostsLine), 0o600))
+	stagingDir := filepath.Join(workdir, "staging")
+	require.NoError(t, os.Mkdir(stagingDir, 0o755))
+
+	opts := fakeNerdctlOptions(t)
+	opts.transport = transportSSH
+	config := transportConfig{SSH: sshConfig{
+		Host:           addr,
+		User:           "tester",
+		IdentityFile:   identityFile,
+		KnownHostsFile: knownHostsFile,
+		StagingDir:     filepath.ToSlash(stagingDir),
+	}}
+
+	t.Run("run", func(t *testing.T) {
+		tr, err := newTransport(opts, config)
+		require.NoError(t, err)
+		defer tr.(*sshTransport).close()
+		testTransportRun(t, tr)
+	})
+
+	t.Run("unknown host key", func(t *testing.T) {
+		otherConfig := config
+		otherConfig.SSH.KnownHostsFile = filepath.Join(workdir, "empty_known_hosts")
+		require.NoError(t, os.WriteFile(otherConfig.SSH.KnownHostsFile, nil, 0o600))
+		tr, err := newTransport(opts, otherConfig)
+		require.NoError(t, err)
+		_, err = tr.run([]string{"echo", "x"}, nil, nil, io.Discard, io.Discard)
+		assert.Error(t, err)
+	})
+
+	t.Run("staging", func(t *testing.T) {
+		tr, err := newTransport(opts, config)
+		require.NoError(t, err)
+		paths := tr.paths()
+		require.NoError(t, paths.prepare())
+
+		inputDir := filepath.Join(workdir, "context")
+		require.NoError(t, os.MkdirAll(filepath.Join(inputDir, "sub"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, "sub", "Dockerfile"), []byte("FROM scratch\n"), 0o644))
+		remoteDir, _, err := paths.filePathArgHandler(inputDir)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(remoteDir, filepath.ToSlash(stagingDir)+"/"), remoteDir)
+		stdout := &bytes.Buffer{}
+		code, err := tr.run([]string{"read", remoteDir + "/sub/Dockerfile"}, nil, nil, stdout, os.Stderr)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, code)
+			assert.Equal(t, "FROM scratch\n", stdout.String())
+		}
+
+		volume, _, err := paths.volumeArgHandler(inputDir + ":/src:ro")
+		if assert.NoError(t, err) {
+			assert.Regexp(t, `/input\.\d+/context:/src:ro$`, volume)
+		}
+		volume, _, err = paths.volumeArgHandler("named:/data")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "named:/data", volume)
+		}
+
+		outputFile := filepath.Join(workdir, "cidfile")
+		remoteOutput, cleanups, err := paths.outputPathArgHandler(outputFile)
+		require.NoError(t, err)
+		code, err = tr.run([]string{"write", remoteOutput, "abc123"}, nil, nil, io.Discard, os.Stderr)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, code)
+		}
+		cliparse.RunCleanups(cleanups)
+		contents, err := os.ReadFile(outputFile)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "abc123", string(contents))
+		}
+
+		assert.NoError(t, paths.cleanup())
+		entries, err := os.ReadDir(stagingDir)
+		if assert.NoError(t, err) {
+			assert.Empty(t, entries)
+		}
+	})
+}
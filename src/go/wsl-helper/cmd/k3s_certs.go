@@ -0,0 +1,296 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultK3sTLSDir is where k3s keeps its certificates.
+	defaultK3sTLSDir = "/var/lib/rancher/k3s/server/tls"
+	// k3sDynamicCertFile is the file (in the TLS directory) holding the
+	// serving certificate of the API server listener, as a Secret.
+	k3sDynamicCertFile = "dynamic-cert.json"
+	// k3sCertRenewWindow is how long before expiry k3s renews certificates
+	// when it starts.
+	k3sCertRenewWindow = 90 * 24 * time.Hour
+)
+
+// Certificate states.
+const (
+	certStatusOK       = "ok"
+	certStatusExpiring = "expiring"
+	certStatusExpired  = "expired"
+	certStatusInvalid  = "invalid"
+)
+
+// Exit codes for `k3s certs check`.
+const (
+	// certsExitExpiring means some certificates expire soon.
+	certsExitExpiring = 2
+	// certsExitExpired means some certificates have expired (or are invalid).
+	certsExitExpired = 3
+)
+
+// k3sCertInfo describes a certificate used by k3s.
+type k3sCertInfo struct {
+	// Source is the file the certificate is in, or the kubeconfig user.
+	Source      string    `json:"source"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	IsCA        bool      `json:"isCA"`
+	NotBefore   time.Time `json:"notBefore,omitempty"`
+	NotAfter    time.Time `json:"notAfter,omitempty"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	// Status is one of the certStatus* constants.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// files are the files to remove to have k3s regenerate the certificate.
+	files []string
+}
+
+// newK3sCertInfo describes the certificate in the PEM data.
+func newK3sCertInfo(source string, data []byte, now time.Time, warn time.Duration) k3sCertInfo {
+	info := k3sCertInfo{Source: source}
+	cert, err := parseCertificatePEM(data, source)
+	if err != nil {
+		info.Status, info.Error = certStatusInvalid, err.Error()
+		return info
+	}
+	info.Subject = cert.Subject.String()
+	info.Issuer = cert.Issuer.String()
+	info.IsCA = cert.IsCA
+	info.NotBefore, info.NotAfter = cert.NotBefore.UTC(), cert.NotAfter.UTC()
+	info.DNSNames = cert.DNSNames
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	info.Status = certificateStatus(cert, now, warn)
+	return info
+}
+
+// certificateStatus returns whether the certificate has expired, or expires
+// within the given duration.
+func certificateStatus(cert *x509.Certificate, now time.Time, warn time.Duration) string {
+	switch {
+	case now.After(cert.NotAfter) || now.Before(cert.NotBefore):
+		return certStatusExpired
+	case now.Add(warn).After(cert.NotAfter):
+		return certStatusExpiring
+	}
+	return certStatusOK
+}
+
+// listKubeconfigCerts describes the client certificates in the kubeconfig.
+func listKubeconfigCerts(configPath string, now time.Time, warn time.Duration) ([]k3sCertInfo, error) {
+	config, err := readKubeConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var result []k3sCertInfo
+	for _, user := range config.Users {
+		data, err := kubeconfigData(user.User.ClientCertificateData, user.User.ClientCertificate, filepath.Dir(configPath))
+		source := fmt.Sprintf("%s (user %s)", configPath, user.Name)
+		if err != nil {
+			result = append(result, k3sCertInfo{Source: source, Status: certStatusInvalid, Error: err.Error()})
+		} else if data != nil {
+			result = append(result, newK3sCertInfo(source, data, now, warn))
+		}
+	}
+	return result, nil
+}
+
+// listK3sTLSCerts describes the certificates in the k3s TLS directory; a
+// missing directory has no certificates.
+func listK3sTLSCerts(tlsDir string, now time.Time, warn time.Duration) ([]k3sCertInfo, error) {
+	var result []k3sCertInfo
+	err := filepath.WalkDir(tlsDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == tlsDir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		var data []byte
+		var files []string
+		switch {
+		case entry.Name() == k3sDynamicCertFile:
+			data, err = readDynamicCert(filePath)
+			files = []string{filePath}
+		case strings.HasSuffix(entry.Name(), ".crt"):
+			data, err = os.ReadFile(filePath)
+			files = []string{filePath}
+			keyPath := strings.TrimSuffix(filePath, ".crt") + ".key"
+			if _, statErr := os.Stat(keyPath); statErr == nil {
+				files = append(files, keyPath)
+			}
+		default:
+			return nil
+		}
+		var info k3sCertInfo
+		if err != nil {
+			info = k3sCertInfo{Source: filePath, Status: certStatusInvalid, Error: err.Error()}
+		} else {
+			info = newK3sCertInfo(filePath, data, now, warn)
+		}
+		info.files = files
+		result = append(result, info)
+		return nil
+	})
+	return result, err
+}
+
+// readDynamicCert returns the certificate in the dynamic listener's file,
+// which is a serialized Secret.
+func readDynamicCert(filePath string) ([]byte, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err = json.Unmarshal(contents, &secret); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filePath, err)
+	}
+	data, ok := secret.Data["tls.crt"]
+	if !ok {
+		return nil, fmt.Errorf("could not find a certificate in %s", filePath)
+	}
+	return data, nil
+}
+
+// writeCertsTable prints the certificates as a table.
+func writeCertsTable(output io.Writer, certs []k3sCertInfo, now time.Time) error {
+	writer := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "SOURCE\tSUBJECT\tEXPIRES\tREMAINING\tSTATUS\tNAMES")
+	for _, cert := range certs {
+		if cert.Error != "" {
+			fmt.Fprintf(writer, "%s\t\t\t\t%s\t%s\n", cert.Source, cert.Status, cert.Error)
+			continue
+		}
+		remaining := cert.NotAfter.Sub(now).Truncate(time.Hour)
+		names := strings.Join(append(append([]string{}, cert.DNSNames...), cert.IPAddresses...), ",")
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			cert.Source, cert.Subject, cert.NotAfter.Format("2006-01-02"),
+			formatDays(remaining), cert.Status, names)
+	}
+	return writer.Flush()
+}
+
+// formatDays formats a duration as a (possibly negative) number of days.
+func formatDays(duration time.Duration) string {
+	return fmt.Sprintf("%dd", int(duration/(24*time.Hour)))
+}
+
+// certsExitCode returns the exit code for the certificates.
+func certsExitCode(certs []k3sCertInfo) int {
+	code := 0
+	for _, cert := range certs {
+		switch cert.Status {
+		case certStatusExpired, certStatusInvalid:
+			return certsExitExpired
+		case certStatusExpiring:
+			code = certsExitExpiring
+		}
+	}
+	return code
+}
+
+// k3sCertsCmd represents the `k3s certs` command.
+var k3sCertsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Commands for managing k3s certificates",
+}
+
+var k3sCertsCheckViper = viper.New()
+
+// k3sCertsCheckCmd represents the `k3s certs check` command.
+var k3sCertsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Report when the k3s certificates expire",
+	Long: `This command reports the expiry and subject alternative names of the client
+certificate in the k3s kubeconfig, and of the certificates in the k3s TLS
+directory.  The exit code is 2 if any certificate expires within the --warn
+period, and 3 if any has expired (or could not be read).`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output := k3sCertsCheckViper.GetString("output")
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unsupported output format %q", output)
+		}
+		warn := k3sCertsCheckViper.GetDuration("warn")
+		cmd.SilenceUsage = true
+
+		now := time.Now()
+		certs, err := listKubeconfigCerts(k3sCertsCheckViper.GetString("k3sconfig"), now, warn)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		tlsCerts, err := listK3sTLSCerts(k3sCertsCheckViper.GetString("tls-dir"), now, warn)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, tlsCerts...)
+		if output == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if certs == nil {
+				certs = []k3sCertInfo{}
+			}
+			err = encoder.Encode(certs)
+		} else {
+			err = writeCertsTable(os.Stdout, certs, now)
+		}
+		if err != nil {
+			return err
+		}
+		if code := certsExitCode(certs); code != 0 {
+			cmd.SilenceErrors = true
+			return &exitCodeError{code: code}
+		}
+		return nil
+	},
+}
+
+func init() {
+	k3sCertsCheckCmd.Flags().String("k3sconfig", "/etc/rancher/k3s/k3s.yaml", "Path to k3s kubeconfig")
+	k3sCertsCheckCmd.Flags().String("tls-dir", defaultK3sTLSDir, "Path to the k3s TLS directory")
+	k3sCertsCheckCmd.Flags().String("output", "table", "Output format (table or json)")
+	k3sCertsCheckCmd.Flags().Duration("warn", k3sCertRenewWindow, "Report certificates expiring within this period")
+	k3sCertsCheckViper.BindPFlags(k3sCertsCheckCmd.Flags())
+	k3sCertsCheckViper.BindEnv("k3sconfig", "K3SCONFIG")
+	k3sCertsCmd.AddCommand(k3sCertsCheckCmd)
+	k3sCmd.AddCommand(k3sCertsCmd)
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// k3sStopPollInterval is how often to check whether k3s has exited.
+const k3sStopPollInterval = 250 * time.Millisecond
+
+// certsToRotate returns the certificates that k3s will regenerate when they
+// are removed: expiring (or, with all, any) certificates that are not CAs.
+// CA certificates can not be regenerated without invalidating everything
+// signed by them, so they are only reported.
+// Anything skipped is reported to the given output.
+func certsToRotate(certs []k3sCertInfo, all bool, output io.Writer) []k3sCertInfo {
+	var result []k3sCertInfo
+	for _, cert := range certs {
+		switch {
+		case cert.Status == certStatusInvalid:
+			fmt.Fprintf(output, "Skipping %s: %s\n", cert.Source, cert.Error)
+		case cert.IsCA:
+			if cert.Status != certStatusOK {
+				fmt.Fprintf(output, "CA certificate %s expires on %s; it is not rotated\n",
+					cert.Source, cert.NotAfter.Format(time.RFC3339))
+			}
+		case all || cert.Status != certStatusOK:
+			result = append(result, cert)
+		}
+	}
+	return result
+}
+
+// stopK3s stops the running k3s server, and waits for it to exit.
+func stopK3s(ctx context.Context, status k3sProcessStatus, timeout time.Duration) error {
+	process, err := os.FindProcess(status.PID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Stopping k3s (pid %d)\n", status.PID)
+	if err = process.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("could not stop k3s (pid %d): %w", status.PID, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(k3sStopPollInterval)
+	defer ticker.Stop()
+	for findK3sProcess().Running {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out waiting for k3s (pid %d) to stop", status.PID)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// startK3s runs the given shell command to start k3s again; it is not waited
+// for, as it is expected to keep running.
+func startK3s(command string) error {
+	fmt.Fprintf(os.Stderr, "Starting k3s: %s\n", command)
+	cmd := exec.Command("/bin/sh", "-c", command)
+	// Keep stdout for the kubeconfig.
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start k3s: %w", err)
+	}
+	return cmd.Process.Release()
+}
+
+var k3sCertsRotateViper = viper.New()
+
+// k3sCertsRotateCmd represents the `k3s certs rotate` command.
+var k3sCertsRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the k3s certificates",
+	Long: `This command rotates the k3s certificates that expire within the --within
+period (or, with --all, all certificates other than the CAs) the way k3s
+expects: the certificates and their keys are removed while k3s is stopped, and
+k3s regenerates them when it next starts.
+
+If k3s is not running, the certificates are removed, and are regenerated when it
+is started again (e.g. by restarting Kubernetes in Rancher Desktop).  If k3s is
+running, --restart-command is required: k3s is stopped, the certificates are
+removed, k3s is started again with the command, and the new kubeconfig is
+printed, as with "k3s kubeconfig".  As Rancher Desktop treats k3s exiting as
+Kubernetes stopping, this should not be used on a k3s started by Rancher
+Desktop; stop Kubernetes first instead.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configPath := k3sCertsRotateViper.GetString("k3sconfig")
+		output := k3sCertsRotateViper.GetString("output")
+		if output != "yaml" && output != "json" {
+			return fmt.Errorf("unsupported output format %q", output)
+		}
+		restartCommand := k3sCertsRotateViper.GetString("restart-command")
+		cmd.SilenceUsage = true
+
+		certs, err := listK3sTLSCerts(k3sCertsRotateViper.GetString("tls-dir"), time.Now(), k3sCertsRotateViper.GetDuration("within"))
+		if err != nil {
+			return err
+		}
+		certs = certsToRotate(certs, k3sCertsRotateViper.GetBool("all"), os.Stderr)
+		if len(certs) == 0 {
+			fmt.Fprintln(os.Stderr, "No certificates need to be rotated")
+			return nil
+		}
+		for _, cert := range certs {
+			fmt.Fprintf(os.Stderr, "Rotating %s (%s, expires %s)\n", cert.Source, cert.Status, cert.NotAfter.Format(time.RFC3339))
+		}
+		if k3sCertsRotateViper.GetBool("dry-run") {
+			return nil
+		}
+		status := findK3sProcess()
+		if status.Running && restartCommand == "" {
+			// Stopping k3s without a way to start it again would leave
+			// Kubernetes down (and Rancher Desktop would consider it stopped).
+			return fmt.Errorf("k3s is running (pid %d); stop it first, or use --restart-command to restart it", status.PID)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if status.Running {
+			if err = stopK3s(ctx, status, k3sCertsRotateViper.GetDuration("stop-timeout")); err != nil {
+				return err
+			}
+		}
+		for _, cert := range certs {
+			for _, file := range cert.files {
+				if err = os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		if restartCommand == "" {
+			fmt.Fprintln(os.Stderr, "The certificates will be regenerated when k3s is started")
+			return nil
+		}
+		// k3s writes a new kubeconfig when it starts; remove the old one so
+		// that we can tell when that has happened.
+		if err = os.Remove(configPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err = startK3s(restartCommand); err != nil {
+			return err
+		}
+		if err = waitForKubeconfig(ctx, configPath, k3sCertsRotateViper.GetDuration("timeout")); err != nil {
+			return err
+		}
+		policy, err := newAddressPolicy("", "eth0", addressFamilyIPv4, nil)
+		if err != nil {
+			return err
+		}
+		doc, err := renderKubeconfig(configPath, output, &kubeconfigRewrite{policy: policy}, &kubeconfigTransform{})
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(doc)
+		return err
+	},
+}
+
+func init() {
+	k3sCertsRotateCmd.Flags().String("k3sconfig", "/etc/rancher/k3s/k3s.yaml", "Path to k3s kubeconfig")
+	k3sCertsRotateCmd.Flags().String("tls-dir", defaultK3sTLSDir, "Path to the k3s TLS directory")
+	k3sCertsRotateCmd.Flags().Duration("within", k3sCertRenewWindow, "Rotate certificates expiring within this period")
+	k3sCertsRotateCmd.Flags().Bool("all", false, "Rotate all certificates other than the CAs")
+	k3sCertsRotateCmd.Flags().Bool("dry-run", false, "Only report the certificates that would be rotated")
+	k3sCertsRotateCmd.Flags().String("restart-command", "", "Shell command to start k3s again; required if k3s is running")
+	k3sCertsRotateCmd.Flags().Duration("stop-timeout", 30*time.Second, "How long to wait for k3s to stop")
+	k3sCertsRotateCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for k3s to write the new kubeconfig")
+	k3sCertsRotateCmd.Flags().String("output", "yaml", "Output format of the kubeconfig (yaml or json)")
+	// Flags such as --all must not be picked up from unrelated environment
+	// variables; only the kubeconfig path is, as for the other k3s commands.
+	k3sCertsRotateViper.BindPFlags(k3sCertsRotateCmd.Flags())
+	k3sCertsRotateViper.BindEnv("k3sconfig", "K3SCONFIG")
+	k3sCertsCmd.AddCommand(k3sCertsRotateCmd)
+}
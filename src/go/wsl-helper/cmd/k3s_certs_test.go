@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertificateStatus(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	cases := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		expected  string
+	}{
+		{"valid", now.Add(-day), now.Add(365 * day), certStatusOK},
+		{"expiring", now.Add(-day), now.Add(30 * day), certStatusExpiring},
+		{"at the warning boundary", now.Add(-day), now.Add(90 * day), certStatusOK},
+		{"just inside the warning period", now.Add(-day), now.Add(90*day - time.Second), certStatusExpiring},
+		{"expired", now.Add(-365 * day), now.Add(-time.Second), certStatusExpired},
+		{"not yet valid", now.Add(time.Hour), now.Add(365 * day), certStatusExpired},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cert := &x509.Certificate{NotBefore: c.notBefore, NotAfter: c.notAfter}
+			if actual := certificateStatus(cert, now, 90*day); actual != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCertsToRotate(t *testing.T) {
+	certs := []k3sCertInfo{
+		{Source: "client-admin.crt", Status: certStatusOK},
+		{Source: "client-kubelet.crt", Status: certStatusExpiring},
+		{Source: "serving-kube-apiserver.crt", Status: certStatusExpired},
+		{Source: "server-ca.crt", IsCA: true, Status: certStatusExpiring},
+		{Source: "client-ca.crt", IsCA: true, Status: certStatusOK},
+		{Source: "broken.crt", Status: certStatusInvalid, Error: "could not find a certificate"},
+	}
+	cases := []struct {
+		name     string
+		all      bool
+		expected []string
+	}{
+		{"expiring", false, []string{"client-kubelet.crt", "serving-kube-apiserver.crt"}},
+		{"all", true, []string{"client-admin.crt", "client-kubelet.crt", "serving-kube-apiserver.crt"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var output bytes.Buffer
+			var actual []string
+			for _, cert := range certsToRotate(certs, c.all, &output) {
+				actual = append(actual, cert.Source)
+			}
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, actual)
+			}
+			messages := output.String()
+			if !strings.Contains(messages, "Skipping broken.crt") {
+				t.Errorf("the invalid certificate was not reported: %q", messages)
+			}
+			if !strings.Contains(messages, "CA certificate server-ca.crt") {
+				t.Errorf("the expiring CA was not reported: %q", messages)
+			}
+			if strings.Contains(messages, "client-ca.crt") {
+				t.Errorf("the valid CA should not be reported: %q", messages)
+			}
+		})
+	}
+
+	if result := certsToRotate(nil, true, &bytes.Buffer{}); len(result) != 0 {
+		t.Errorf("expected nothing to rotate, got %v", result)
+	}
+}
+
+func TestCertsExitCode(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []string
+		expected int
+	}{
+		{"none", nil, 0},
+		{"ok", []string{certStatusOK, certStatusOK}, 0},
+		{"expiring", []string{certStatusOK, certStatusExpiring}, certsExitExpiring},
+		{"expired", []string{certStatusExpiring, certStatusExpired, certStatusOK}, certsExitExpired},
+		{"expired first", []string{certStatusExpired, certStatusExpiring}, certsExitExpired},
+		{"invalid", []string{certStatusOK, certStatusInvalid}, certsExitExpired},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var certs []k3sCertInfo
+			for _, status := range c.statuses {
+				certs = append(certs, k3sCertInfo{Status: status})
+			}
+			if actual := certsExitCode(certs); actual != c.expected {
+				t.Errorf("expected %d, got %d", c.expected, actual)
+			}
+		})
+	}
+}
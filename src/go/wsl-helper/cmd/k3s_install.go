@@ -0,0 +1,407 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// k3sInstallProgressStep is how many bytes to hash between progress events.
+const k3sInstallProgressStep = 64 << 20
+
+// k3sArchitecture describes the k3s release files for an architecture.
+type k3sArchitecture struct {
+	// binary is the name of the k3s executable in the release.
+	binary string
+	// suffix is used in the names of the checksum and airgap image files.
+	suffix string
+}
+
+// k3sArchitectures maps GOARCH values to the k3s release files.
+var k3sArchitectures = map[string]k3sArchitecture{
+	"amd64": {binary: "k3s", suffix: "amd64"},
+	"arm64": {binary: "k3s-arm64", suffix: "arm64"},
+	"arm":   {binary: "k3s-armhf", suffix: "arm"},
+}
+
+// k3sAirgapExtensions are the extensions of the airgap image file, in order
+// of preference.
+var k3sAirgapExtensions = []string{".tar", ".tar.zst", ".tar.gz"}
+
+// Statuses in k3s install progress events.
+const (
+	installStatusVerifying   = "verifying"
+	installStatusProgress    = "progress"
+	installStatusVerified    = "verified"
+	installStatusUnchanged   = "unchanged"
+	installStatusChanged     = "changed"
+	installStatusWouldChange = "would-change"
+	installStatusSkipped     = "skipped"
+	installStatusFailed      = "failed"
+	installStatusDone        = "done"
+)
+
+// k3sInstallEvent is a line of progress output from `k3s install`.
+type k3sInstallEvent struct {
+	// Action is one of verify, symlink, remove, chmod, mkdir or install.
+	Action string `json:"action"`
+	Path   string `json:"path,omitempty"`
+	Target string `json:"target,omitempty"`
+	// Status is one of the installStatus* constants.
+	Status string `json:"status"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	Total  int64  `json:"total,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// k3sInstallation installs a cached version of k3s.
+type k3sInstallation struct {
+	versionDir string
+	cacheDir   string
+	arch       k3sArchitecture
+	binDir     string
+	imagesDir  string
+	kubeconfig string
+	dryRun     bool
+	output     *json.Encoder
+}
+
+// report writes a progress event.
+func (i *k3sInstallation) report(event k3sInstallEvent) {
+	_ = i.output.Encode(event)
+}
+
+// fail reports a failed action, and returns the error.
+func (i *k3sInstallation) fail(action, path string, err error) error {
+	i.report(k3sInstallEvent{Action: action, Path: path, Status: installStatusFailed, Error: err.Error()})
+	return err
+}
+
+// changed reports whether a change was made (or, for a dry run, would be).
+func (i *k3sInstallation) changed(action, path, target string) {
+	status := installStatusChanged
+	if i.dryRun {
+		status = installStatusWouldChange
+	}
+	i.report(k3sInstallEvent{Action: action, Path: path, Target: target, Status: status})
+}
+
+// install verifies the cached files, and then installs them.  Nothing is
+// changed unless all files are valid.
+func (i *k3sInstallation) install() error {
+	if info, err := os.Stat(i.versionDir); err != nil || !info.IsDir() {
+		return i.fail("verify", i.versionDir, fmt.Errorf("directory %s does not exist", i.versionDir))
+	}
+	sumsPath := filepath.Join(i.versionDir, "sha256sum-"+i.arch.suffix+".txt")
+	sums, err := readSHA256Sums(sumsPath)
+	if err != nil {
+		return i.fail("verify", sumsPath, err)
+	}
+	// Rancher Desktop saves the executable as k3s regardless of architecture.
+	binary, err := i.findFile(i.arch.binary, "k3s")
+	if err != nil {
+		return i.fail("verify", i.versionDir, err)
+	}
+	var airgapNames []string
+	for _, extension := range k3sAirgapExtensions {
+		airgapNames = append(airgapNames, "k3s-airgap-images-"+i.arch.suffix+extension)
+	}
+	images, err := i.findFile(airgapNames...)
+	if err != nil {
+		return i.fail("verify", i.versionDir, err)
+	}
+	if err = i.verify(binary, sums, filepath.Base(binary), i.arch.binary); err != nil {
+		return err
+	}
+	if err = i.verify(images, sums, filepath.Base(images)); err != nil {
+		return err
+	}
+
+	if err = i.symlink(filepath.Join(i.imagesDir, filepath.Base(images)), images); err != nil {
+		return err
+	}
+	if err = i.removeStaleImages(filepath.Join(i.imagesDir, filepath.Base(images))); err != nil {
+		return err
+	}
+	if err = i.symlink(filepath.Join(i.binDir, "k3s"), binary); err != nil {
+		return err
+	}
+	i.makeExecutable(binary)
+	if err = i.mkdir(filepath.Dir(i.kubeconfig)); err != nil {
+		return err
+	}
+	// Make sure any outdated kubeconfig file is gone; this is done last, so
+	// that it is kept if anything else fails.
+	if err = i.remove(i.kubeconfig); err != nil {
+		return err
+	}
+	i.report(k3sInstallEvent{Action: "install", Path: i.versionDir, Status: installStatusDone})
+	return nil
+}
+
+// findFile returns the path of the first of the given files that exists in
+// the version directory.
+func (i *k3sInstallation) findFile(names ...string) (string, error) {
+	for _, name := range names {
+		filePath := filepath.Join(i.versionDir, name)
+		if _, err := os.Stat(filePath); err == nil {
+			return filePath, nil
+		}
+	}
+	return "", fmt.Errorf("could not find %s in %s", strings.Join(names, " or "), i.versionDir)
+}
+
+// readSHA256Sums reads a file in the format written by sha256sum, returning
+// the (lower case) checksums by file name.  Any malformed line is an error, as
+// the file may have been damaged.
+func readSHA256Sums(sumsPath string) (map[string]string, error) {
+	file, err := os.Open(sumsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a checksum and a file name", sumsPath, line)
+		}
+		sum := strings.ToLower(fields[0])
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid checksum %q", sumsPath, line, fields[0])
+		}
+		// A leading asterisk marks files checked in binary mode.
+		sums[strings.TrimPrefix(fields[1], "*")] = sum
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("%s does not contain any checksums", sumsPath)
+	}
+	return sums, nil
+}
+
+// verify checks the file against the checksum for the first of the names
+// that is listed.
+func (i *k3sInstallation) verify(filePath string, sums map[string]string, names ...string) error {
+	var expected string
+	for _, name := range names {
+		if expected = sums[name]; expected != "" {
+			break
+		}
+	}
+	if expected == "" {
+		return i.fail("verify", filePath, fmt.Errorf("no checksum for %s", filepath.Base(filePath)))
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return i.fail("verify", filePath, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return i.fail("verify", filePath, err)
+	}
+	i.report(k3sInstallEvent{Action: "verify", Path: filePath, Status: installStatusVerifying, Total: info.Size()})
+	hash := sha256.New()
+	var done int64
+	for {
+		n, err := io.CopyN(hash, file, k3sInstallProgressStep)
+		done += n
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return i.fail("verify", filePath, err)
+		}
+		i.report(k3sInstallEvent{Action: "verify", Path: filePath, Status: installStatusProgress, Bytes: done, Total: info.Size()})
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return i.fail("verify", filePath, fmt.Errorf("%s has invalid digest %s, expected %s", filepath.Base(filePath), actual, expected))
+	}
+	i.report(k3sInstallEvent{Action: "verify", Path: filePath, Status: installStatusVerified, Bytes: done, Total: info.Size()})
+	return nil
+}
+
+// remove deletes the given file, if it exists.
+func (i *k3sInstallation) remove(filePath string) error {
+	if _, err := os.Lstat(filePath); errors.Is(err, os.ErrNotExist) {
+		i.report(k3sInstallEvent{Action: "remove", Path: filePath, Status: installStatusUnchanged})
+		return nil
+	}
+	i.changed("remove", filePath, "")
+	if i.dryRun {
+		return nil
+	}
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return i.fail("remove", filePath, err)
+	}
+	return nil
+}
+
+// symlink atomically points linkPath at target.
+func (i *k3sInstallation) symlink(linkPath, target string) error {
+	if existing, err := os.Readlink(linkPath); err == nil && existing == target {
+		i.report(k3sInstallEvent{Action: "symlink", Path: linkPath, Target: target, Status: installStatusUnchanged})
+		return nil
+	}
+	i.changed("symlink", linkPath, target)
+	if i.dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0o755); err != nil {
+		return i.fail("symlink", linkPath, err)
+	}
+	tempPath := fmt.Sprintf("%s.tmp-%d", linkPath, os.Getpid())
+	_ = os.Remove(tempPath)
+	if err := os.Symlink(target, tempPath); err != nil {
+		return i.fail("symlink", linkPath, err)
+	}
+	if err := os.Rename(tempPath, linkPath); err != nil {
+		_ = os.Remove(tempPath)
+		return i.fail("symlink", linkPath, err)
+	}
+	return nil
+}
+
+// mkdir creates the given directory, if it does not exist.
+func (i *k3sInstallation) mkdir(dirPath string) error {
+	if info, err := os.Stat(dirPath); err == nil && info.IsDir() {
+		i.report(k3sInstallEvent{Action: "mkdir", Path: dirPath, Status: installStatusUnchanged})
+		return nil
+	}
+	i.changed("mkdir", dirPath, "")
+	if i.dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(dirPath, 0o755); err != nil {
+		return i.fail("mkdir", dirPath, err)
+	}
+	return nil
+}
+
+// removeStaleImages removes symlinks to airgap images (of other versions) in
+// the cache, other than the given one, so that k3s does not import them.
+func (i *k3sInstallation) removeStaleImages(current string) error {
+	links, err := filepath.Glob(filepath.Join(i.imagesDir, "k3s-airgap-images-*"))
+	if err != nil {
+		return err
+	}
+	cachePrefix := filepath.Clean(i.cacheDir) + string(filepath.Separator)
+	for _, link := range links {
+		target, err := os.Readlink(link)
+		if err != nil || link == current || !strings.HasPrefix(target, cachePrefix) {
+			continue
+		}
+		if err = i.remove(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeExecutable marks the k3s executable as executable; failures are
+// reported but ignored, as the file system may be read-only.
+func (i *k3sInstallation) makeExecutable(filePath string) {
+	info, err := os.Stat(filePath)
+	if err == nil && info.Mode()&0o111 == 0o111 {
+		i.report(k3sInstallEvent{Action: "chmod", Path: filePath, Status: installStatusUnchanged})
+		return
+	}
+	if err == nil && !i.dryRun {
+		err = os.Chmod(filePath, info.Mode()|0o111)
+	}
+	if err != nil {
+		i.report(k3sInstallEvent{Action: "chmod", Path: filePath, Status: installStatusSkipped, Error: err.Error()})
+		return
+	}
+	i.changed("chmod", filePath, "")
+}
+
+var k3sInstallViper = viper.New()
+
+// k3sInstallCmd represents the `k3s install` command.
+var k3sInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install a cached version of k3s",
+	Long: `This command installs k3s from the cache directory (which has a directory
+per version, as downloaded by Rancher Desktop): the k3s executable and the
+airgap images for the architecture are checked against the sha256sum file, and
+then symlinked into place.  Finally, the directory for the k3s kubeconfig is
+created, and any outdated kubeconfig is removed.  Progress is reported as one
+JSON object per line.  With --dry-run, nothing is changed, and the changes that
+would be made are reported instead.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		version := k3sInstallViper.GetString("version")
+		cacheDir := k3sInstallViper.GetString("cache-dir")
+		if version == "" || cacheDir == "" {
+			return errors.New("--version and --cache-dir are required")
+		}
+		archName := k3sInstallViper.GetString("arch")
+		arch, ok := k3sArchitectures[archName]
+		if !ok {
+			return fmt.Errorf("unsupported architecture %q", archName)
+		}
+		cmd.SilenceUsage = true
+
+		installation := &k3sInstallation{
+			versionDir: filepath.Join(cacheDir, version),
+			cacheDir:   cacheDir,
+			arch:       arch,
+			binDir:     k3sInstallViper.GetString("bin-dir"),
+			imagesDir:  k3sInstallViper.GetString("images-dir"),
+			kubeconfig: k3sInstallViper.GetString("k3sconfig"),
+			dryRun:     k3sInstallViper.GetBool("dry-run"),
+			output:     json.NewEncoder(os.Stdout),
+		}
+		return installation.install()
+	},
+}
+
+func init() {
+	k3sInstallCmd.Flags().String("version", "", "Version of k3s to install, e.g. v1.21.4+k3s1")
+	k3sInstallCmd.Flags().String("cache-dir", "", "Directory containing the downloaded versions of k3s")
+	k3sInstallCmd.Flags().String("arch", runtime.GOARCH, "Architecture to install k3s for")
+	k3sInstallCmd.Flags().String("bin-dir", "/usr/local/bin", "Directory to install the k3s executable into")
+	k3sInstallCmd.Flags().String("images-dir", "/var/lib/rancher/k3s/agent/images", "Directory to install the airgap images into")
+	k3sInstallCmd.Flags().String("k3sconfig", "/etc/rancher/k3s/k3s.yaml", "Path to k3s kubeconfig, which is removed")
+	k3sInstallCmd.Flags().Bool("dry-run", false, "Report what would change without changing anything")
+	// The flags are not bound to the environment, as names such as VERSION and
+	// ARCH are too generic; only the cache directory is, as the install-k3s
+	// script took it from the environment.
+	k3sInstallViper.BindPFlags(k3sInstallCmd.Flags())
+	k3sInstallViper.BindEnv("cache-dir", "CACHE_DIR")
+	k3sCmd.AddCommand(k3sInstallCmd)
+}
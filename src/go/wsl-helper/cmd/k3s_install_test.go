@@ -0,0 +1,303 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sha256Hex returns the hex-encoded SHA-256 digest of the data.
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReadSHA256Sums(t *testing.T) {
+	binarySum, imagesSum := sha256Hex("binary"), sha256Hex("images")
+	cases := []struct {
+		name     string
+		contents string
+		expected map[string]string
+	}{
+		{
+			name:     "valid",
+			contents: fmt.Sprintf("%s  k3s\n%s *k3s-airgap-images-amd64.tar\n", binarySum, strings.ToUpper(imagesSum)),
+			expected: map[string]string{"k3s": binarySum, "k3s-airgap-images-amd64.tar": imagesSum},
+		},
+		{
+			name:     "blank lines",
+			contents: fmt.Sprintf("\n%s  k3s\n\n", binarySum),
+			expected: map[string]string{"k3s": binarySum},
+		},
+		{name: "empty", contents: ""},
+		{name: "missing file name", contents: binarySum + "\n"},
+		{name: "extra fields", contents: binarySum + "  k3s  extra\n"},
+		{name: "short checksum", contents: binarySum[:32] + "  k3s\n"},
+		{name: "not hex", contents: strings.Repeat("g", 64) + "  k3s\n"},
+		{name: "malformed line after valid one", contents: binarySum + "  k3s\n<html>404 Not Found</html>\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sumsPath := filepath.Join(t.TempDir(), "sha256sum-amd64.txt")
+			writeTestFile(t, sumsPath, c.contents)
+			sums, err := readSHA256Sums(sumsPath)
+			if c.expected == nil {
+				if err == nil {
+					t.Errorf("expected an error, got %v", sums)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sums, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, sums)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := readSHA256Sums(filepath.Join(t.TempDir(), "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
+
+// readInstallEvents parses the progress events written by an installation.
+func readInstallEvents(t *testing.T, output *bytes.Buffer) []k3sInstallEvent {
+	var events []k3sInstallEvent
+	decoder := json.NewDecoder(output)
+	for decoder.More() {
+		var event k3sInstallEvent
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestK3sInstallationVerify(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "k3s")
+	writeTestFile(t, filePath, "binary")
+	cases := []struct {
+		name  string
+		sums  map[string]string
+		names []string
+		err   string
+	}{
+		{"valid", map[string]string{"k3s": sha256Hex("binary")}, []string{"k3s"}, ""},
+		{"fallback name", map[string]string{"k3s-arm64": sha256Hex("binary")}, []string{"k3s", "k3s-arm64"}, ""},
+		{"missing entry", map[string]string{"k3s-airgap-images-amd64.tar": sha256Hex("binary")}, []string{"k3s"}, "no checksum for k3s"},
+		{"mismatch", map[string]string{"k3s": sha256Hex("tampered")}, []string{"k3s"}, "has invalid digest"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var output bytes.Buffer
+			installation := &k3sInstallation{output: json.NewEncoder(&output)}
+			err := installation.verify(filePath, c.sums, c.names...)
+			events := readInstallEvents(t, &output)
+			last := events[len(events)-1]
+			if c.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if last.Status != installStatusVerified || last.Bytes != int64(len("binary")) {
+					t.Errorf("unexpected event %+v", last)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error containing %q, got %v", c.err, err)
+			}
+			if last.Status != installStatusFailed || last.Error != err.Error() {
+				t.Errorf("unexpected event %+v", last)
+			}
+		})
+	}
+}
+
+func TestK3sInstallationSymlink(t *testing.T) {
+	dir := t.TempDir()
+	linkPath := filepath.Join(dir, "bin", "k3s")
+	var output bytes.Buffer
+	installation := &k3sInstallation{output: json.NewEncoder(&output)}
+	for _, target := range []string{"/cache/v1/k3s", "/cache/v1/k3s", "/cache/v2/k3s"} {
+		if err := installation.symlink(linkPath, target); err != nil {
+			t.Fatal(err)
+		}
+		if actual, err := os.Readlink(linkPath); err != nil || actual != target {
+			t.Errorf("expected link to %s, got %s (%v)", target, actual, err)
+		}
+	}
+	var statuses []string
+	for _, event := range readInstallEvents(t, &output) {
+		statuses = append(statuses, event.Status)
+	}
+	expected := []string{installStatusChanged, installStatusUnchanged, installStatusChanged}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected statuses %v, got %v", expected, statuses)
+	}
+	if matches, _ := filepath.Glob(linkPath + ".tmp-*"); len(matches) > 0 {
+		t.Errorf("temporary links were left behind: %v", matches)
+	}
+}
+
+// newTestInstallation creates a cache with a valid k3s version, and returns an
+// installation of it into the same temporary directory.
+func newTestInstallation(t *testing.T, dryRun bool) (*k3sInstallation, *bytes.Buffer) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	versionDir := filepath.Join(cacheDir, "v1.21.4+k3s1")
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(versionDir, "k3s"), "binary")
+	writeTestFile(t, filepath.Join(versionDir, "k3s-airgap-images-amd64.tar"), "images")
+	writeTestFile(t, filepath.Join(versionDir, "sha256sum-amd64.txt"), fmt.Sprintf(
+		"%s  k3s\n%s  k3s-airgap-images-amd64.tar\n", sha256Hex("binary"), sha256Hex("images")))
+
+	var output bytes.Buffer
+	installation := &k3sInstallation{
+		versionDir: versionDir,
+		cacheDir:   cacheDir,
+		arch:       k3sArchitectures["amd64"],
+		binDir:     filepath.Join(dir, "bin"),
+		imagesDir:  filepath.Join(dir, "images"),
+		kubeconfig: filepath.Join(dir, "etc", "rancher", "k3s", "k3s.yaml"),
+		dryRun:     dryRun,
+		output:     json.NewEncoder(&output),
+	}
+	return installation, &output
+}
+
+func TestK3sInstallationInstall(t *testing.T) {
+	t.Run("install", func(t *testing.T) {
+		installation, output := newTestInstallation(t, false)
+		staleImages := filepath.Join(installation.imagesDir, "k3s-airgap-images-amd64.tar.zst")
+		if err := os.MkdirAll(installation.imagesDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(installation.cacheDir, "v1.20.0+k3s1", "k3s-airgap-images-amd64.tar.zst"), staleImages); err != nil {
+			t.Fatal(err)
+		}
+		if err := installation.install(); err != nil {
+			t.Fatal(err)
+		}
+		links := map[string]string{
+			filepath.Join(installation.binDir, "k3s"):                            filepath.Join(installation.versionDir, "k3s"),
+			filepath.Join(installation.imagesDir, "k3s-airgap-images-amd64.tar"): filepath.Join(installation.versionDir, "k3s-airgap-images-amd64.tar"),
+		}
+		for link, expected := range links {
+			if target, err := os.Readlink(link); err != nil || target != expected {
+				t.Errorf("expected %s to link to %s, got %s (%v)", link, expected, target, err)
+			}
+		}
+		if _, err := os.Lstat(staleImages); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("stale images were not removed: %v", err)
+		}
+		if info, err := os.Stat(filepath.Dir(installation.kubeconfig)); err != nil || !info.IsDir() {
+			t.Errorf("the kubeconfig directory was not created: %v", err)
+		}
+		if info, err := os.Stat(filepath.Join(installation.versionDir, "k3s")); err != nil || info.Mode()&0o111 != 0o111 {
+			t.Errorf("k3s was not made executable: %v", err)
+		}
+		events := readInstallEvents(t, output)
+		if last := events[len(events)-1]; last.Action != "install" || last.Status != installStatusDone {
+			t.Errorf("unexpected last event %+v", last)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		installation, output := newTestInstallation(t, true)
+		if err := os.MkdirAll(filepath.Dir(installation.kubeconfig), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, installation.kubeconfig, "old")
+		if err := installation.install(); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{installation.binDir, installation.imagesDir} {
+			if _, err := os.Lstat(p); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s should not have been created: %v", p, err)
+			}
+		}
+		if _, err := os.Stat(installation.kubeconfig); err != nil {
+			t.Errorf("the kubeconfig should not have been removed: %v", err)
+		}
+		if info, err := os.Stat(filepath.Join(installation.versionDir, "k3s")); err != nil || info.Mode()&0o111 != 0 {
+			t.Errorf("k3s should not have been made executable: %v", err)
+		}
+		var changes []string
+		for _, event := range readInstallEvents(t, output) {
+			if event.Status == installStatusChanged {
+				t.Errorf("unexpected change %+v", event)
+			}
+			if event.Status == installStatusWouldChange {
+				changes = append(changes, event.Action)
+			}
+		}
+		expected := []string{"symlink", "symlink", "chmod", "remove"}
+		if !reflect.DeepEqual(changes, expected) {
+			t.Errorf("expected changes %v, got %v", expected, changes)
+		}
+	})
+
+	t.Run("invalid checksum changes nothing", func(t *testing.T) {
+		installation, _ := newTestInstallation(t, false)
+		writeTestFile(t, filepath.Join(installation.versionDir, "k3s"), "tampered")
+		if err := os.MkdirAll(filepath.Dir(installation.kubeconfig), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, installation.kubeconfig, "old")
+		if err := installation.install(); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := os.Lstat(installation.binDir); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("nothing should have been installed: %v", err)
+		}
+		if _, err := os.Stat(installation.kubeconfig); err != nil {
+			t.Errorf("the kubeconfig should not have been removed: %v", err)
+		}
+	})
+
+	t.Run("failure keeps the kubeconfig", func(t *testing.T) {
+		installation, _ := newTestInstallation(t, false)
+		if err := os.MkdirAll(filepath.Dir(installation.kubeconfig), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, installation.kubeconfig, "old")
+		// The executable can't be linked into place if bin is a file.
+		writeTestFile(t, installation.binDir, "")
+		if err := installation.install(); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := os.Stat(installation.kubeconfig); err != nil {
+			t.Errorf("the kubeconfig should not have been removed: %v", err)
+		}
+	})
+}
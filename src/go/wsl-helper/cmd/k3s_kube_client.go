@@ -0,0 +1,162 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kubeRequestTimeout is how long a single request to the API server may take.
+const kubeRequestTimeout = 5 * time.Second
+
+// kubeClient makes requests to the API server, using the credentials of the
+// selected context in a kubeconfig.
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// readKubeConfig reads and parses the kubeconfig at the given path.
+func readKubeConfig(configPath string) (*kubeConfig, error) {
+	configFile, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer configFile.Close()
+	var config kubeConfig
+	if err = yaml.NewDecoder(configFile).Decode(&config); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", configPath, err)
+	}
+	return &config, nil
+}
+
+// newKubeClient creates a client for the selected context of the kubeconfig
+// read from configPath; relative certificate paths are relative to it.
+func newKubeClient(config *kubeConfig, configPath string) (*kubeClient, error) {
+	contextName, err := config.selectedContext()
+	if err != nil {
+		return nil, err
+	}
+	context := config.context(contextName)
+	var cluster *kubeCluster
+	for i := range config.Clusters {
+		if config.Clusters[i].Name == context.Cluster {
+			cluster = &config.Clusters[i].Cluster
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster %q does not exist", context.Cluster)
+	}
+	user := &kubeUser{}
+	for i := range config.Users {
+		if config.Users[i].Name == context.User {
+			user = &config.Users[i].User
+		}
+	}
+
+	baseDir := filepath.Dir(configPath)
+	tlsConfig := &tls.Config{
+		ServerName:         cluster.TLSServerName,
+		InsecureSkipVerify: cluster.InsecureSkipTLSVerify,
+	}
+	caData, err := kubeconfigData(cluster.CertificateAuthorityData, cluster.CertificateAuthority, baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificate authority: %w", err)
+	}
+	if caData != nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, errors.New("could not parse certificate authority")
+		}
+	}
+	certData, err := kubeconfigData(user.ClientCertificateData, user.ClientCertificate, baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client certificate: %w", err)
+	}
+	keyData, err := kubeconfigData(user.ClientKeyData, user.ClientKey, baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client key: %w", err)
+	}
+	if certData != nil || keyData != nil {
+		cert, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &kubeClient{
+		server: strings.TrimSuffix(cluster.Server, "/"),
+		token:  user.Token,
+		client: &http.Client{
+			Timeout:   kubeRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// kubeconfigData returns the embedded (base64) data if given, or else the
+// contents of the file; if neither is given, it returns nil.
+func kubeconfigData(data, filePath, baseDir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if filePath == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(baseDir, filePath)
+	}
+	return os.ReadFile(filePath)
+}
+
+// get fetches the given path from the API server, returning the status code
+// and the body.
+func (c *kubeClient) get(ctx context.Context, path string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, body, err
+}
+
+// close releases any idle connections.
+func (c *kubeClient) close() {
+	c.client.CloseIdleConnections()
+}
@@ -16,11 +16,15 @@ limitations under the License.
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
-	"net/url"
+	"io"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -28,16 +32,14 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-type kubeConfig struct {
-	Clusters []struct {
-		Cluster struct {
-			Server string
-			Extras map[string]interface{} `yaml:",inline"`
-		}
-		Extras map[string]interface{} `yaml:",inline"`
-	} `yaml:",omitempty"`
-	Extras map[string]interface{} `yaml:",inline"`
-}
+const (
+	// kubeconfigPollInterval is how often to check for the k3s kubeconfig
+	// while waiting for it to exist.
+	kubeconfigPollInterval = time.Second
+	// kubeconfigSettleDelay is how long to wait after a change before reading
+	// the kubeconfig, so that a burst of changes only emits one document.
+	kubeconfigSettleDelay = 250 * time.Millisecond
+)
 
 var k3sKubeconfigViper = viper.New()
 
@@ -45,103 +47,260 @@ var k3sKubeconfigViper = viper.New()
 var k3sKubeconfigCmd = &cobra.Command{
 	Use:   "kubeconfig",
 	Short: "Fetch kubeconfig from the WSL VM",
+	Long: `This command prints the k3s kubeconfig, with the server address changed to
+one that can be reached from outside the VM; clusters whose server is at
+127.0.0.1, localhost, ::1 or 0.0.0.0 are rewritten.  With --watch, a new
+document is printed whenever the kubeconfig or the network addresses change.
+
+As k3s names its context, cluster and user "default", --context-name can be used
+to rename them.  With --write, the kubeconfig is written to the given file
+(atomically, with mode 0600) instead of being printed.`,
+	Args: cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Read the existing kubeconfig.  Wait up to 10 seconds for it to exist.
-		ch := make(chan *os.File)
-		abort := false
-		go func() {
-			configPath := k3sKubeconfigViper.GetString("k3sconfig")
-			for {
-				if abort {
-					return
-				}
-				f, err := os.Open(configPath)
-				if err == nil {
-					ch <- f
-					return
-				}
-				time.Sleep(time.Second)
-			}
-		}()
-		var err error
-		timeout := time.After(10 * time.Second)
-		var configFile *os.File
-		select {
-		case <-timeout:
-			return fmt.Errorf("Timed out waiting for k3s kubeconfig to exist")
-		case configFile = <-ch:
-			break
+		configPath := k3sKubeconfigViper.GetString("k3sconfig")
+		output := k3sKubeconfigViper.GetString("output")
+		if output != "yaml" && output != "json" {
+			return fmt.Errorf("unsupported output format %q", output)
 		}
-
-		var config kubeConfig
-		defer configFile.Close()
-		err = yaml.NewDecoder(configFile).Decode(&config)
+		policy, err := newAddressPolicy(
+			k3sKubeconfigViper.GetString("server-host"),
+			k3sKubeconfigViper.GetString("interface"),
+			k3sKubeconfigViper.GetString("address-family"),
+			k3sKubeconfigViper.GetStringSlice("cidr"))
 		if err != nil {
 			return err
 		}
-
-		// Find the IP address of eth0.
-		iface, err := net.InterfaceByName("eth0")
+		sanCheck, err := newSANChecker(
+			k3sKubeconfigViper.GetString("san-check"),
+			k3sKubeconfigViper.GetString("serving-cert"),
+			os.Stderr)
 		if err != nil {
-			// Use a random interface, assuming we're testing on Windows.
-			ifaces, err := net.Interfaces()
-			if err != nil {
-				return err
+			return err
+		}
+		rewrite := &kubeconfigRewrite{
+			policy:   policy,
+			clusters: k3sKubeconfigViper.GetStringSlice("cluster"),
+			sanCheck: sanCheck,
+		}
+		transform := &kubeconfigTransform{
+			contextName: k3sKubeconfigViper.GetString("context-name"),
+			minify:      k3sKubeconfigViper.GetBool("minify"),
+			setCurrent:  k3sKubeconfigViper.GetBool("set-current"),
+			embedCerts:  k3sKubeconfigViper.GetBool("embed-certs"),
+			certDir:     k3sKubeconfigViper.GetString("cert-dir"),
+		}
+		if transform.embedCerts && transform.certDir != "" {
+			return errors.New("--embed-certs and --cert-dir can not be used together")
+		}
+		write := func(doc []byte) error {
+			_, err := os.Stdout.Write(doc)
+			return err
+		}
+		if writePath := k3sKubeconfigViper.GetString("write"); writePath != "" {
+			write = func(doc []byte) error {
+				return writeFileAtomic(writePath, doc, 0o600, false)
 			}
-			iface = &ifaces[0]
-			fmt.Fprintf(os.Stderr, "Could not find eth0, using fallback interface %s\n", iface.Name)
 		}
-		addrs, err := iface.Addrs()
+		cmd.SilenceUsage = true
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = waitForKubeconfig(ctx, configPath, k3sKubeconfigViper.GetDuration("timeout"))
 		if err != nil {
 			return err
 		}
-		var ip net.IP
-		for _, addr := range addrs {
-			// addr.String() gives "192.2.3.4/16", so we need to chop off the netmask
-			ip = net.ParseIP(strings.SplitN(addr.String(), "/", 2)[0])
-			if ip == nil {
-				continue
-			}
-			ip = ip.To4()
-			if ip != nil {
-				break
+		if k3sKubeconfigViper.GetBool("watch") {
+			if k3sKubeconfigViper.GetString("write") == "" {
+				write = func(doc []byte) error {
+					return writeKubeconfigFrame(os.Stdout, output, doc)
+				}
 			}
+			return watchKubeconfig(ctx, configPath, output, rewrite, transform, write)
 		}
-		if ip == nil {
-			return fmt.Errorf("could not find IPv4 address on interface %s", iface.Name)
+		doc, err := renderKubeconfig(configPath, output, rewrite, transform)
+		if err != nil {
+			return err
 		}
+		return write(doc)
+	},
+}
 
-		// Fix up any clusters at 127.0.0.1, using the IP address we found.
-		for clusterIdx, cluster := range config.Clusters {
-			server, err := url.Parse(cluster.Cluster.Server)
-			if err != nil {
-				// Ignore any clusters with invalid servers
-				continue
-			}
-			if server.Hostname() != "127.0.0.1" {
-				continue
-			}
-			if server.Port() != "" {
-				server.Host = net.JoinHostPort(ip.String(), server.Port())
-			} else {
-				server.Host = ip.String()
+// waitForKubeconfig waits for the k3s kubeconfig to exist; a timeout of zero
+// waits until the context is cancelled.
+func waitForKubeconfig(ctx context.Context, configPath string, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ticker := time.NewTicker(kubeconfigPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(configPath); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out waiting for k3s kubeconfig %s to exist", configPath)
 			}
-			config.Clusters[clusterIdx].Cluster.Server = server.String()
+			return ctx.Err()
+		case <-ticker.C:
 		}
+	}
+}
+
+// renderKubeconfig reads the k3s kubeconfig and returns the rewritten document
+// in the given format ("yaml" or "json").  JSON documents are a single line.
+func renderKubeconfig(configPath, output string, rewrite *kubeconfigRewrite, transform *kubeconfigTransform) ([]byte, error) {
+	config, err := readKubeConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = rewrite.apply(config); err != nil {
+		return nil, err
+	}
+	if err = transform.apply(config, configPath); err != nil {
+		return nil, err
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil || output != "json" {
+		return data, err
+	}
+	// Round-trip through a generic value, as the kubeconfig type only has
+	// YAML annotations.
+	var generic interface{}
+	if err = yaml.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(generic)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// writeKubeconfigFrame writes one document in watch mode.  YAML documents are
+// framed with start (---) and end (...) markers; JSON documents are one per
+// line.
+func writeKubeconfigFrame(output io.Writer, format string, doc []byte) error {
+	if format == "json" {
+		_, err := output.Write(doc)
+		return err
+	}
+	_, err := fmt.Fprintf(output, "---\n%s...\n", doc)
+	return err
+}
+
+// changeSource reports changes to the kubeconfig (or anything else that might
+// change its rendering) on the given channel, until the context is cancelled
+// or an error occurs.
+type changeSource interface {
+	run(ctx context.Context, changes chan<- struct{}) error
+}
 
-		// Emit the result
-		err = yaml.NewEncoder(os.Stdout).Encode(config)
+// watchKubeconfig writes the rewritten kubeconfig, and then writes it again
+// whenever it changes (because either the file or the network addresses
+// changed), until the context is cancelled.
+func watchKubeconfig(ctx context.Context, configPath, format string, rewrite *kubeconfigRewrite, transform *kubeconfigTransform, write func([]byte) error) error {
+	watcher, err := newKubeconfigWatcher(configPath)
+	if err != nil {
+		return err
+	}
+	render := func() ([]byte, error) {
+		return renderKubeconfig(configPath, format, rewrite, transform)
+	}
+	return watchChanges(ctx, watcher, kubeconfigSettleDelay, render, write)
+}
+
+// watchChanges writes the rendered document, and then renders it again after
+// each change reported by the source, waiting for the given delay so that a
+// burst of changes only renders once.  Documents are only written if they
+// differ from the previous one.
+func watchChanges(ctx context.Context, source changeSource, settleDelay time.Duration, render func() ([]byte, error), write func([]byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changes := make(chan struct{}, 1)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- source.run(ctx, changes)
+	}()
+
+	var last []byte
+	emit := func() error {
+		doc, err := render()
 		if err != nil {
+			// The file may be in the middle of being replaced; a further
+			// change is expected.
+			fmt.Fprintf(os.Stderr, "Could not read kubeconfig: %s\n", err)
+			return nil
+		}
+		if bytes.Equal(doc, last) {
+			return nil
+		}
+		last = doc
+		return write(doc)
+	}
+
+	if err := emit(); err != nil {
+		cancel()
+		<-watchErr
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// Wait for the watcher to finish cleaning up.
+			return <-watchErr
+		case err := <-watchErr:
 			return err
+		case <-changes:
+			// Let any further changes settle before reading the file.
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(settleDelay):
+			}
+			if err := emit(); err != nil {
+				cancel()
+				<-watchErr
+				return err
+			}
 		}
+	}
+}
 
-		return nil
-	},
+// notifyChange signals a change without blocking; changes are coalesced.
+func notifyChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
 }
 
 func init() {
 	k3sKubeconfigCmd.Flags().String("k3sconfig", "/etc/rancher/k3s/k3s.yaml", "Path to k3s kubeconfig")
-	k3sKubeconfigViper.AutomaticEnv()
+	k3sKubeconfigCmd.Flags().Bool("watch", false, "Print the kubeconfig again whenever it changes")
+	k3sKubeconfigCmd.Flags().Duration("timeout", 10*time.Second, "How long to wait for the k3s kubeconfig to exist (0 to wait forever)")
+	k3sKubeconfigCmd.Flags().String("output", "yaml", "Output format (yaml or json)")
+	k3sKubeconfigCmd.Flags().String("interface", "eth0", "Interface to take the server address from; may be a glob pattern")
+	k3sKubeconfigCmd.Flags().String("address-family", addressFamilyIPv4, "Address family to use: ipv4, ipv6, prefer-ipv4 or prefer-ipv6")
+	k3sKubeconfigCmd.Flags().StringSlice("cidr", nil, "Only use server addresses in these networks")
+	k3sKubeconfigCmd.Flags().String("server-host", "", "Use this host name instead of an interface address, e.g. rancher-desktop.localhost")
+	k3sKubeconfigCmd.Flags().StringSlice("cluster", nil, "Names of the clusters to rewrite (default all)")
+	k3sKubeconfigCmd.Flags().String("serving-cert", defaultServingCertPath, "Path to the API server certificate; if unreadable, it is fetched from the server")
+	k3sKubeconfigCmd.Flags().String("san-check", sanCheckReport, "If the certificate does not cover the address: none (do not check), report, pick (another address), or tls-server-name")
+	k3sKubeconfigCmd.Flags().String("context-name", "", "Rename the context, and its cluster and user, to this (e.g. rancher-desktop)")
+	k3sKubeconfigCmd.Flags().Bool("minify", false, "Remove everything not used by the current context")
+	k3sKubeconfigCmd.Flags().Bool("set-current", false, "Make the (renamed) context the current context")
+	k3sKubeconfigCmd.Flags().Bool("embed-certs", false, "Embed the contents of certificate and key files")
+	k3sKubeconfigCmd.Flags().String("cert-dir", "", "Extract embedded certificates and keys into files in this directory")
+	k3sKubeconfigCmd.Flags().String("write", "", "Write the kubeconfig to this file instead of printing it")
+	// Only the path of the k3s kubeconfig is taken from the environment (as
+	// it was before the other flags were added); names such as OUTPUT and
+	// WRITE are too likely to be set for other reasons.
 	k3sKubeconfigViper.BindPFlags(k3sKubeconfigCmd.Flags())
+	k3sKubeconfigViper.BindEnv("k3sconfig", "K3SCONFIG")
 	k3sCmd.AddCommand(k3sKubeconfigCmd)
 }
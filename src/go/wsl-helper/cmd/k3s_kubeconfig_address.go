@@ -0,0 +1,242 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Address families for selecting the server address.
+const (
+	addressFamilyIPv4       = "ipv4"
+	addressFamilyIPv6       = "ipv6"
+	addressFamilyPreferIPv4 = "prefer-ipv4"
+	addressFamilyPreferIPv6 = "prefer-ipv6"
+)
+
+// localServerHosts are the server hosts that can only be reached from inside
+// the VM, and are therefore rewritten.
+var localServerHosts = map[string]struct{}{
+	"127.0.0.1": {},
+	"localhost": {},
+	"::1":       {},
+	"0.0.0.0":   {},
+}
+
+// addressPolicy describes how to pick the address that clients outside the VM
+// should use to reach the API server.
+type addressPolicy struct {
+	// host is a fixed host name (or address) to use; if set, the other fields
+	// are ignored.
+	host string
+	// iface is the name of the interface to use; it may be a glob pattern.
+	iface string
+	// family is the address family to use (or prefer).
+	family string
+	// networks restricts the addresses to those in any of the networks.
+	networks []*net.IPNet
+}
+
+// newAddressPolicy creates an address policy from the command line options.
+func newAddressPolicy(host, iface, family string, cidrs []string) (*addressPolicy, error) {
+	policy := &addressPolicy{host: host, iface: iface, family: family}
+	switch family {
+	case addressFamilyIPv4, addressFamilyIPv6, addressFamilyPreferIPv4, addressFamilyPreferIPv6:
+	default:
+		return nil, fmt.Errorf("invalid address family %q", family)
+	}
+	if _, err := path.Match(iface, ""); err != nil {
+		return nil, fmt.Errorf("invalid interface pattern %q: %w", iface, err)
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		policy.networks = append(policy.networks, network)
+	}
+	return policy, nil
+}
+
+// serverHosts returns the hosts that may be used for the API server, most
+// preferred first.
+func (p *addressPolicy) serverHosts() ([]string, error) {
+	if p.host != "" {
+		return []string{p.host}, nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []net.IP
+	matched := false
+	for _, iface := range ifaces {
+		if ok, _ := path.Match(p.iface, iface.Name); !ok {
+			continue
+		}
+		matched = true
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range ifaceAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				addrs = append(addrs, ipNet.IP)
+			}
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("could not find any interface matching %q", p.iface)
+	}
+	hosts := p.orderAddresses(addrs)
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("could not find a suitable %s address on interfaces matching %q", p.family, p.iface)
+	}
+	return hosts, nil
+}
+
+// orderAddresses returns the acceptable addresses of the configured family,
+// most preferred first.
+func (p *addressPolicy) orderAddresses(addrs []net.IP) []string {
+	var ipv4, ipv6 []net.IP
+	for _, addr := range addrs {
+		if !p.acceptable(addr) {
+			continue
+		}
+		if ip := addr.To4(); ip != nil {
+			ipv4 = append(ipv4, ip)
+		} else {
+			ipv6 = append(ipv6, addr)
+		}
+	}
+
+	var candidates []net.IP
+	switch p.family {
+	case addressFamilyIPv4:
+		candidates = ipv4
+	case addressFamilyIPv6:
+		candidates = ipv6
+	case addressFamilyPreferIPv4:
+		candidates = append(ipv4, ipv6...)
+	case addressFamilyPreferIPv6:
+		candidates = append(ipv6, ipv4...)
+	}
+	hosts := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		hosts = append(hosts, candidate.String())
+	}
+	return hosts
+}
+
+// acceptable checks whether the address can be used from outside the VM and
+// matches the configured networks.
+func (p *addressPolicy) acceptable(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	if len(p.networks) == 0 {
+		return true
+	}
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// kubeconfigRewrite describes how to rewrite the server addresses in the k3s
+// kubeconfig.
+type kubeconfigRewrite struct {
+	policy *addressPolicy
+	// clusters are the names of the clusters to rewrite; if empty, all
+	// clusters are rewritten.
+	clusters []string
+	// sanCheck checks the chosen address against the API server certificate.
+	sanCheck *sanChecker
+}
+
+// apply rewrites any selected clusters with a local server address to use the
+// address chosen by the policy instead.
+func (r *kubeconfigRewrite) apply(config *kubeConfig) error {
+	var hosts []string
+	for clusterIdx, cluster := range config.Clusters {
+		if !r.selected(cluster.Name) {
+			continue
+		}
+		server, err := url.Parse(cluster.Cluster.Server)
+		if err != nil {
+			// Ignore any clusters with invalid servers
+			continue
+		}
+		if !isLocalServer(server) {
+			continue
+		}
+		if hosts == nil {
+			if hosts, err = r.policy.serverHosts(); err != nil {
+				return err
+			}
+		}
+		host, tlsServerName := hosts[0], ""
+		if r.sanCheck != nil {
+			host, tlsServerName = r.sanCheck.check(server, hosts)
+		}
+		if tlsServerName != "" {
+			config.Clusters[clusterIdx].Cluster.TLSServerName = tlsServerName
+		}
+		setServerHost(server, host)
+		config.Clusters[clusterIdx].Cluster.Server = server.String()
+	}
+	return nil
+}
+
+// isLocalServer checks whether the server can only be reached from inside the
+// VM.
+func isLocalServer(server *url.URL) bool {
+	_, ok := localServerHosts[strings.ToLower(server.Hostname())]
+	return ok
+}
+
+// setServerHost replaces the host of the server, keeping the port.
+func setServerHost(server *url.URL, host string) {
+	if server.Port() != "" {
+		server.Host = net.JoinHostPort(host, server.Port())
+	} else if strings.Contains(host, ":") {
+		server.Host = "[" + host + "]"
+	} else {
+		server.Host = host
+	}
+}
+
+// selected checks whether the cluster with the given name should be rewritten.
+func (r *kubeconfigRewrite) selected(name string) bool {
+	if len(r.clusters) == 0 {
+		return true
+	}
+	for _, cluster := range r.clusters {
+		if cluster == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestNewAddressPolicy(t *testing.T) {
+	cases := []struct {
+		name   string
+		iface  string
+		family string
+		cidrs  []string
+		valid  bool
+	}{
+		{"defaults", "eth0", addressFamilyIPv4, nil, true},
+		{"glob", "eth*", addressFamilyPreferIPv6, nil, true},
+		{"cidrs", "eth0", addressFamilyIPv6, []string{"192.168.0.0/16", "fd00::/8"}, true},
+		{"invalid family", "eth0", "ipv5", nil, false},
+		{"invalid pattern", "eth[", addressFamilyIPv4, nil, false},
+		{"invalid cidr", "eth0", addressFamilyIPv4, []string{"192.168.0.0"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, err := newAddressPolicy("", c.iface, c.family, c.cidrs)
+			if !c.valid {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(policy.networks) != len(c.cidrs) {
+				t.Errorf("expected %d networks, got %d", len(c.cidrs), len(policy.networks))
+			}
+		})
+	}
+}
+
+func TestAddressPolicyOrderAddresses(t *testing.T) {
+	addrs := []net.IP{
+		net.ParseIP("127.0.0.1"),
+		net.ParseIP("172.20.1.2"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("fd00::2"),
+		net.ParseIP("192.168.1.3"),
+		net.ParseIP("::1"),
+		net.ParseIP("2001:db8::4"),
+	}
+	cases := []struct {
+		name     string
+		family   string
+		cidrs    []string
+		expected []string
+	}{
+		{"ipv4", addressFamilyIPv4, nil, []string{"172.20.1.2", "192.168.1.3"}},
+		{"ipv6", addressFamilyIPv6, nil, []string{"fd00::2", "2001:db8::4"}},
+		{"prefer ipv4", addressFamilyPreferIPv4, nil, []string{"172.20.1.2", "192.168.1.3", "fd00::2", "2001:db8::4"}},
+		{"prefer ipv6", addressFamilyPreferIPv6, nil, []string{"fd00::2", "2001:db8::4", "172.20.1.2", "192.168.1.3"}},
+		{"cidr", addressFamilyPreferIPv4, []string{"192.168.0.0/16", "2001:db8::/32"}, []string{"192.168.1.3", "2001:db8::4"}},
+		{"cidr excludes family", addressFamilyIPv4, []string{"fd00::/8"}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, err := newAddressPolicy("", "eth0", c.family, c.cidrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := policy.orderAddresses(addrs)
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAddressPolicyAcceptable(t *testing.T) {
+	policy, err := newAddressPolicy("", "eth0", addressFamilyPreferIPv4, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.1.2.3":    true,
+		"10.0.0.1":    true,
+		"192.168.1.1": false,
+		"127.0.0.1":   false,
+		"169.254.1.1": false,
+		"0.0.0.0":     false,
+		"224.0.0.1":   false,
+	}
+	for addr, expected := range cases {
+		if actual := policy.acceptable(net.ParseIP(addr)); actual != expected {
+			t.Errorf("%s: expected %v, got %v", addr, expected, actual)
+		}
+	}
+}
+
+func TestSetServerHost(t *testing.T) {
+	cases := []struct {
+		server   string
+		host     string
+		expected string
+	}{
+		{"https://127.0.0.1:6443", "172.20.1.2", "https://172.20.1.2:6443"},
+		{"https://127.0.0.1", "172.20.1.2", "https://172.20.1.2"},
+		{"https://127.0.0.1:6443", "fd00::2", "https://[fd00::2]:6443"},
+		{"https://[::1]:6443", "fd00::2", "https://[fd00::2]:6443"},
+		{"https://localhost", "fd00::2", "https://[fd00::2]"},
+		{"https://[::1]:6443/prefix", "rancher-desktop.localhost", "https://rancher-desktop.localhost:6443/prefix"},
+	}
+	for _, c := range cases {
+		t.Run(c.server+" "+c.host, func(t *testing.T) {
+			server, err := url.Parse(c.server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setServerHost(server, c.host)
+			if server.String() != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, server)
+			}
+		})
+	}
+}
+
+func TestKubeconfigRewriteApply(t *testing.T) {
+	newConfig := func() *kubeConfig {
+		return &kubeConfig{Clusters: []kubeNamedCluster{
+			{Name: "default", Cluster: kubeCluster{Server: "https://127.0.0.1:6443"}},
+			{Name: "other", Cluster: kubeCluster{Server: "https://localhost:6444"}},
+			{Name: "remote", Cluster: kubeCluster{Server: "https://example.com:6443"}},
+			{Name: "invalid", Cluster: kubeCluster{Server: "https://127.0.0.1:port"}},
+		}}
+	}
+	cases := []struct {
+		name     string
+		host     string
+		clusters []string
+		expected []string
+	}{
+		{
+			name: "all clusters",
+			host: "172.20.1.2",
+			expected: []string{
+				"https://172.20.1.2:6443",
+				"https://172.20.1.2:6444",
+				"https://example.com:6443",
+				"https://127.0.0.1:port",
+			},
+		},
+		{
+			name:     "selected cluster",
+			host:     "172.20.1.2",
+			clusters: []string{"other"},
+			expected: []string{
+				"https://127.0.0.1:6443",
+				"https://172.20.1.2:6444",
+				"https://example.com:6443",
+				"https://127.0.0.1:port",
+			},
+		},
+		{
+			name:     "remote cluster is never rewritten",
+			host:     "fd00::2",
+			clusters: []string{"default", "remote"},
+			expected: []string{
+				"https://[fd00::2]:6443",
+				"https://localhost:6444",
+				"https://example.com:6443",
+				"https://127.0.0.1:port",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, err := newAddressPolicy(c.host, "eth0", addressFamilyIPv4, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := newConfig()
+			rewrite := &kubeconfigRewrite{policy: policy, clusters: c.clusters}
+			if err = rewrite.apply(config); err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, cluster := range config.Clusters {
+				actual = append(actual, cluster.Cluster.Server)
+				if cluster.Cluster.TLSServerName != "" {
+					t.Errorf("unexpected TLS server name for %s", cluster.Name)
+				}
+			}
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// kubeConfig is a kubeconfig file.  Any fields not described here are kept
+// as-is in the Extras maps.
+type kubeConfig struct {
+	APIVersion     string                 `yaml:"apiVersion,omitempty"`
+	Clusters       []kubeNamedCluster     `yaml:"clusters"`
+	Contexts       []kubeNamedContext     `yaml:"contexts"`
+	CurrentContext string                 `yaml:"current-context"`
+	Kind           string                 `yaml:"kind,omitempty"`
+	Preferences    kubePreferences        `yaml:"preferences"`
+	Users          []kubeNamedUser        `yaml:"users"`
+	Extras         map[string]interface{} `yaml:",inline"`
+}
+
+type kubeNamedCluster struct {
+	Name    string                 `yaml:"name"`
+	Cluster kubeCluster            `yaml:"cluster"`
+	Extras  map[string]interface{} `yaml:",inline"`
+}
+
+type kubeCluster struct {
+	Server                   string                 `yaml:"server"`
+	TLSServerName            string                 `yaml:"tls-server-name,omitempty"`
+	InsecureSkipTLSVerify    bool                   `yaml:"insecure-skip-tls-verify,omitempty"`
+	CertificateAuthority     string                 `yaml:"certificate-authority,omitempty"`
+	CertificateAuthorityData string                 `yaml:"certificate-authority-data,omitempty"`
+	ProxyURL                 string                 `yaml:"proxy-url,omitempty"`
+	Extras                   map[string]interface{} `yaml:",inline"`
+}
+
+type kubeNamedContext struct {
+	Name    string                 `yaml:"name"`
+	Context kubeContext            `yaml:"context"`
+	Extras  map[string]interface{} `yaml:",inline"`
+}
+
+type kubeContext struct {
+	Cluster   string                 `yaml:"cluster"`
+	User      string                 `yaml:"user"`
+	Namespace string                 `yaml:"namespace,omitempty"`
+	Extras    map[string]interface{} `yaml:",inline"`
+}
+
+type kubeNamedUser struct {
+	Name   string                 `yaml:"name"`
+	User   kubeUser               `yaml:"user"`
+	Extras map[string]interface{} `yaml:",inline"`
+}
+
+type kubeUser struct {
+	ClientCertificate     string                 `yaml:"client-certificate,omitempty"`
+	ClientCertificateData string                 `yaml:"client-certificate-data,omitempty"`
+	ClientKey             string                 `yaml:"client-key,omitempty"`
+	ClientKeyData         string                 `yaml:"client-key-data,omitempty"`
+	Token                 string                 `yaml:"token,omitempty"`
+	Username              string                 `yaml:"username,omitempty"`
+	Password              string                 `yaml:"password,omitempty"`
+	Extras                map[string]interface{} `yaml:",inline"`
+}
+
+type kubePreferences struct {
+	Colors bool                   `yaml:"colors,omitempty"`
+	Extras map[string]interface{} `yaml:",inline"`
+}
+
+// unsafeFileNameChars matches characters that should not be used in the names
+// of extracted certificate files.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// kubeconfigTransform describes changes to the k3s kubeconfig other than the
+// server address.
+type kubeconfigTransform struct {
+	// contextName is the new name for the context, and its cluster and user.
+	contextName string
+	// minify removes everything not used by the context.
+	minify bool
+	// setCurrent makes the context the current context.
+	setCurrent bool
+	// embedCerts replaces certificate file references with their contents.
+	embedCerts bool
+	// certDir is where to extract embedded certificates to, if not empty.
+	certDir string
+}
+
+// apply transforms the kubeconfig read from configPath.
+func (t *kubeconfigTransform) apply(config *kubeConfig, configPath string) error {
+	if t.contextName != "" || t.minify || t.setCurrent {
+		name, err := config.selectedContext()
+		if err != nil {
+			return err
+		}
+		if t.contextName != "" {
+			if err = config.renameContext(name, t.contextName); err != nil {
+				return err
+			}
+			name = t.contextName
+		}
+		if t.setCurrent {
+			config.CurrentContext = name
+		}
+		if t.minify {
+			config.minify(name)
+		}
+	}
+	if t.embedCerts {
+		if err := config.embedCerts(filepath.Dir(configPath)); err != nil {
+			return err
+		}
+	}
+	if t.certDir != "" {
+		if err := config.extractCerts(t.certDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectedContext returns the name of the current context, or of the only
+// context if there is no current context.
+func (c *kubeConfig) selectedContext() (string, error) {
+	if c.CurrentContext != "" {
+		if c.context(c.CurrentContext) == nil {
+			return "", fmt.Errorf("current context %q does not exist", c.CurrentContext)
+		}
+		return c.CurrentContext, nil
+	}
+	if len(c.Contexts) != 1 {
+		return "", fmt.Errorf("kubeconfig has %d contexts and no current context", len(c.Contexts))
+	}
+	return c.Contexts[0].Name, nil
+}
+
+// context returns the context with the given name, or nil.
+func (c *kubeConfig) context(name string) *kubeContext {
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == name {
+			return &c.Contexts[i].Context
+		}
+	}
+	return nil
+}
+
+// renameContext renames the context, as well as the cluster and user it uses
+// (along with any references to them).
+func (c *kubeConfig) renameContext(oldName, newName string) error {
+	context := c.context(oldName)
+	if context == nil {
+		return fmt.Errorf("context %q does not exist", oldName)
+	}
+	clusterName, userName := context.Cluster, context.User
+	for _, entry := range c.Contexts {
+		if entry.Name == newName && entry.Name != oldName {
+			return fmt.Errorf("context %q already exists", newName)
+		}
+	}
+	for _, entry := range c.Clusters {
+		if entry.Name == newName && entry.Name != clusterName {
+			return fmt.Errorf("cluster %q already exists", newName)
+		}
+	}
+	for _, entry := range c.Users {
+		if entry.Name == newName && entry.Name != userName {
+			return fmt.Errorf("user %q already exists", newName)
+		}
+	}
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == oldName {
+			c.Contexts[i].Name = newName
+		}
+		if c.Contexts[i].Context.Cluster == clusterName {
+			c.Contexts[i].Context.Cluster = newName
+		}
+		if c.Contexts[i].Context.User == userName {
+			c.Contexts[i].Context.User = newName
+		}
+	}
+	for i := range c.Clusters {
+		if c.Clusters[i].Name == clusterName {
+			c.Clusters[i].Name = newName
+		}
+	}
+	for i := range c.Users {
+		if c.Users[i].Name == userName {
+			c.Users[i].Name = newName
+		}
+	}
+	if c.CurrentContext == oldName {
+		c.CurrentContext = newName
+	}
+	return nil
+}
+
+// minify removes all contexts other than the given one, along with any
+// clusters and users it does not use, and makes it the current context.
+func (c *kubeConfig) minify(name string) {
+	context := *c.context(name)
+	var contexts []kubeNamedContext
+	for _, entry := range c.Contexts {
+		if entry.Name == name {
+			contexts = append(contexts, entry)
+		}
+	}
+	var clusters []kubeNamedCluster
+	for _, entry := range c.Clusters {
+		if entry.Name == context.Cluster {
+			clusters = append(clusters, entry)
+		}
+	}
+	var users []kubeNamedUser
+	for _, entry := range c.Users {
+		if entry.Name == context.User {
+			users = append(users, entry)
+		}
+	}
+	c.Contexts, c.Clusters, c.Users = contexts, clusters, users
+	c.CurrentContext = name
+}
+
+// embedCerts replaces references to certificate and key files with their
+// contents; relative paths are relative to baseDir.
+func (c *kubeConfig) embedCerts(baseDir string) error {
+	embed := func(filePath, data *string) error {
+		if *filePath == "" {
+			return nil
+		}
+		if !filepath.IsAbs(*filePath) {
+			*filePath = filepath.Join(baseDir, *filePath)
+		}
+		contents, err := os.ReadFile(*filePath)
+		if err != nil {
+			return err
+		}
+		*filePath, *data = "", base64.StdEncoding.EncodeToString(contents)
+		return nil
+	}
+	for i := range c.Clusters {
+		cluster := &c.Clusters[i].Cluster
+		if err := embed(&cluster.CertificateAuthority, &cluster.CertificateAuthorityData); err != nil {
+			return err
+		}
+	}
+	for i := range c.Users {
+		user := &c.Users[i].User
+		if err := embed(&user.ClientCertificate, &user.ClientCertificateData); err != nil {
+			return err
+		}
+		if err := embed(&user.ClientKey, &user.ClientKeyData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractCerts writes embedded certificates and keys to files in the given
+// directory, and replaces them with references to those files.
+func (c *kubeConfig) extractCerts(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	extract := func(data, filePath *string, name, suffix string) error {
+		if *data == "" {
+			return nil
+		}
+		contents, err := base64.StdEncoding.DecodeString(*data)
+		if err != nil {
+			return fmt.Errorf("could not decode certificate data for %s: %w", name, err)
+		}
+		target := filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(name, "_")+suffix)
+		if err = writeFileAtomic(target, contents, 0o600, false); err != nil {
+			return err
+		}
+		*data, *filePath = "", target
+		return nil
+	}
+	for i := range c.Clusters {
+		cluster := &c.Clusters[i].Cluster
+		err = extract(&cluster.CertificateAuthorityData, &cluster.CertificateAuthority, c.Clusters[i].Name, "-ca.crt")
+		if err != nil {
+			return err
+		}
+	}
+	for i := range c.Users {
+		user := &c.Users[i].User
+		err = extract(&user.ClientCertificateData, &user.ClientCertificate, c.Users[i].Name, "-client.crt")
+		if err != nil {
+			return err
+		}
+		err = extract(&user.ClientKeyData, &user.ClientKey, c.Users[i].Name, "-client.key")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
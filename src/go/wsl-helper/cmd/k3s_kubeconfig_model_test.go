@@ -0,0 +1,280 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testK3sKubeconfig resembles the kubeconfig k3s writes, plus another context
+// and some fields the model does not describe.
+const testK3sKubeconfig = `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: Y2EtZGF0YQ==
+    server: https://127.0.0.1:6443
+    extensions:
+    - name: example
+      extension: {}
+  name: default
+- cluster:
+    server: https://other.example.com
+  name: other
+contexts:
+- context:
+    cluster: default
+    user: default
+  name: default
+- context:
+    cluster: other
+    user: default
+  name: other
+current-context: default
+kind: Config
+preferences: {}
+users:
+- name: default
+  user:
+    client-certificate-data: Y2VydC1kYXRh
+    client-key-data: a2V5LWRhdGE=
+    exec-timeout: 10s
+`
+
+// parseTestKubeconfig parses a kubeconfig for tests.
+func parseTestKubeconfig(t *testing.T, contents string) *kubeConfig {
+	var config kubeConfig
+	if err := yaml.Unmarshal([]byte(contents), &config); err != nil {
+		t.Fatal(err)
+	}
+	return &config
+}
+
+// roundTrip encodes and decodes the kubeconfig again.
+func roundTrip(t *testing.T, config *kubeConfig) *kubeConfig {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseTestKubeconfig(t, string(data))
+}
+
+func TestKubeConfigRoundTrip(t *testing.T) {
+	config := roundTrip(t, parseTestKubeconfig(t, testK3sKubeconfig))
+	var expected, actual interface{}
+	if err := yaml.Unmarshal([]byte(testK3sKubeconfig), &expected); err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = yaml.Unmarshal(data, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unknown fields were not preserved:\n%s", data)
+	}
+}
+
+func TestKubeConfigRenameContext(t *testing.T) {
+	t.Run("rename", func(t *testing.T) {
+		config := parseTestKubeconfig(t, testK3sKubeconfig)
+		if err := config.renameContext("default", "rancher-desktop"); err != nil {
+			t.Fatal(err)
+		}
+		config = roundTrip(t, config)
+		if config.CurrentContext != "rancher-desktop" {
+			t.Errorf("unexpected current context %q", config.CurrentContext)
+		}
+		expected := []kubeContext{
+			{Cluster: "rancher-desktop", User: "rancher-desktop"},
+			// The other context shares the user, which is renamed too.
+			{Cluster: "other", User: "rancher-desktop"},
+		}
+		for i, entry := range config.Contexts {
+			if entry.Context.Cluster != expected[i].Cluster || entry.Context.User != expected[i].User {
+				t.Errorf("unexpected context %s: %+v", entry.Name, entry.Context)
+			}
+		}
+		if name := config.Contexts[0].Name; name != "rancher-desktop" {
+			t.Errorf("unexpected context name %q", name)
+		}
+		if name := config.Clusters[0].Name; name != "rancher-desktop" {
+			t.Errorf("unexpected cluster name %q", name)
+		}
+		if name := config.Clusters[1].Name; name != "other" {
+			t.Errorf("unexpected cluster name %q", name)
+		}
+		if name := config.Users[0].Name; name != "rancher-desktop" {
+			t.Errorf("unexpected user name %q", name)
+		}
+		if config.Clusters[0].Cluster.Extras["extensions"] == nil {
+			t.Errorf("cluster extensions were lost")
+		}
+	})
+
+	t.Run("same name", func(t *testing.T) {
+		config := parseTestKubeconfig(t, testK3sKubeconfig)
+		if err := config.renameContext("default", "default"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		config := parseTestKubeconfig(t, testK3sKubeconfig)
+		if err := config.renameContext("missing", "rancher-desktop"); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		config := parseTestKubeconfig(t, testK3sKubeconfig)
+		err := config.renameContext("default", "other")
+		if err == nil || !strings.Contains(err.Error(), `context "other" already exists`) {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestKubeConfigMinify(t *testing.T) {
+	config := parseTestKubeconfig(t, testK3sKubeconfig)
+	config.minify("other")
+	config = roundTrip(t, config)
+	if config.CurrentContext != "other" {
+		t.Errorf("unexpected current context %q", config.CurrentContext)
+	}
+	if len(config.Contexts) != 1 || config.Contexts[0].Name != "other" {
+		t.Errorf("unexpected contexts %+v", config.Contexts)
+	}
+	if len(config.Clusters) != 1 || config.Clusters[0].Name != "other" {
+		t.Errorf("unexpected clusters %+v", config.Clusters)
+	}
+	if len(config.Users) != 1 || config.Users[0].Name != "default" {
+		t.Errorf("unexpected users %+v", config.Users)
+	}
+}
+
+func TestKubeConfigExtractEmbedCerts(t *testing.T) {
+	certDir := filepath.Join(t.TempDir(), "certs")
+	original := parseTestKubeconfig(t, testK3sKubeconfig)
+	config := parseTestKubeconfig(t, testK3sKubeconfig)
+	if err := config.extractCerts(certDir); err != nil {
+		t.Fatal(err)
+	}
+	config = roundTrip(t, config)
+
+	cluster, user := config.Clusters[0].Cluster, config.Users[0].User
+	if cluster.CertificateAuthorityData != "" || user.ClientCertificateData != "" || user.ClientKeyData != "" {
+		t.Errorf("embedded data was not removed: %+v %+v", cluster, user)
+	}
+	files := map[string]string{
+		cluster.CertificateAuthority: "ca-data",
+		user.ClientCertificate:       "cert-data",
+		user.ClientKey:               "key-data",
+	}
+	for filePath, expected := range files {
+		if filepath.Dir(filePath) != certDir {
+			t.Errorf("%s was not written to %s", filePath, certDir)
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", filePath, expected, data)
+		}
+		if info, err := os.Stat(filePath); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("%s: unexpected mode %v (%v)", filePath, info.Mode(), err)
+		}
+	}
+	if name := filepath.Base(cluster.CertificateAuthority); name != "default-ca.crt" {
+		t.Errorf("unexpected file name %q", name)
+	}
+
+	// Embedding the extracted files again gives back the original.
+	if err := config.embedCerts(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	config = roundTrip(t, config)
+	if !reflect.DeepEqual(config, original) {
+		t.Errorf("embedding did not restore the original:\nexpected %+v\ngot %+v", original, config)
+	}
+}
+
+func TestKubeConfigEmbedRelativeCerts(t *testing.T) {
+	baseDir := t.TempDir()
+	writeTestFile(t, filepath.Join(baseDir, "ca.crt"), "ca-data")
+	config := parseTestKubeconfig(t, `clusters:
+- name: default
+  cluster:
+    server: https://127.0.0.1:6443
+    certificate-authority: ca.crt
+users:
+- name: default
+  user:
+    client-certificate: missing.crt
+`)
+	if err := config.embedCerts(baseDir); err == nil {
+		t.Errorf("expected an error for the missing certificate")
+	}
+	cluster := config.Clusters[0].Cluster
+	if cluster.CertificateAuthority != "" || cluster.CertificateAuthorityData != base64.StdEncoding.EncodeToString([]byte("ca-data")) {
+		t.Errorf("the relative certificate was not embedded: %+v", cluster)
+	}
+}
+
+func TestKubeconfigTransformApply(t *testing.T) {
+	t.Run("rename, minify and set current", func(t *testing.T) {
+		config := parseTestKubeconfig(t, testK3sKubeconfig)
+		config.CurrentContext = ""
+		config.Contexts = config.Contexts[:1]
+		transform := &kubeconfigTransform{contextName: "rancher-desktop", minify: true, setCurrent: true}
+		if err := transform.apply(config, "/etc/rancher/k3s/k3s.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		if config.CurrentContext != "rancher-desktop" {
+			t.Errorf("unexpected current context %q", config.CurrentContext)
+		}
+		if len(config.Clusters) != 1 || config.Clusters[0].Name != "rancher-desktop" {
+			t.Errorf("unexpected clusters %+v", config.Clusters)
+		}
+	})
+
+	t.Run("ambiguous context", func(t *testing.T) {
+		config := parseTestKubeconfig(t, testK3sKubeconfig)
+		config.CurrentContext = ""
+		transform := &kubeconfigTransform{contextName: "rancher-desktop"}
+		if err := transform.apply(config, "/etc/rancher/k3s/k3s.yaml"); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+
+	t.Run("missing current context", func(t *testing.T) {
+		config := parseTestKubeconfig(t, testK3sKubeconfig)
+		config.CurrentContext = "missing"
+		transform := &kubeconfigTransform{minify: true}
+		if err := transform.apply(config, "/etc/rancher/k3s/k3s.yaml"); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Ways of handling a server address that is not covered by the API server
+// certificate.
+const (
+	// sanCheckNone skips checking the certificate.
+	sanCheckNone = "none"
+	// sanCheckReport reports the --tls-san option k3s needs.
+	sanCheckReport = "report"
+	// sanCheckPick picks another address that is covered, if any.
+	sanCheckPick = "pick"
+	// sanCheckTLSServerName sets tls-server-name in the kubeconfig to a name
+	// that is covered.
+	sanCheckTLSServerName = "tls-server-name"
+)
+
+const (
+	// defaultServingCertPath is where k3s stores the API server certificate.
+	defaultServingCertPath = "/var/lib/rancher/k3s/server/tls/serving-kube-apiserver.crt"
+	// servingCertTimeout limits fetching the certificate from the server.
+	servingCertTimeout = 5 * time.Second
+)
+
+// sanChecker checks server addresses against the subject alternative names of
+// the API server certificate.
+type sanChecker struct {
+	mode string
+	// certPath is the certificate file; if it can't be read, the certificate
+	// is fetched from the server instead.
+	certPath string
+	// output receives any problems found.
+	output io.Writer
+}
+
+// newSANChecker returns a checker for the given mode, or nil if no checks
+// should be done.
+func newSANChecker(mode, certPath string, output io.Writer) (*sanChecker, error) {
+	switch mode {
+	case sanCheckNone:
+		return nil, nil
+	case sanCheckReport, sanCheckPick, sanCheckTLSServerName:
+		return &sanChecker{mode: mode, certPath: certPath, output: output}, nil
+	}
+	return nil, fmt.Errorf("invalid certificate check mode %q", mode)
+}
+
+// certificate returns the API server certificate, reading it from the file if
+// possible, or from a TLS handshake with the (original) server otherwise.
+func (c *sanChecker) certificate(server *url.URL) (*x509.Certificate, error) {
+	cert, fileErr := readCertificateFile(c.certPath)
+	if fileErr == nil {
+		return cert, nil
+	}
+	address := server.Host
+	if server.Port() == "" {
+		address = net.JoinHostPort(server.Hostname(), "443")
+	}
+	dialer := &net.Dialer{Timeout: servingCertTimeout}
+	// The certificate is only inspected, so it does not need to be verified.
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, fmt.Errorf("%v; could not fetch certificate from %s: %w", fileErr, address, err)
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%v; %s did not present a certificate", fileErr, address)
+	}
+	return certs[0], nil
+}
+
+// readCertificateFile reads the first certificate in a PEM file.
+func readCertificateFile(certPath string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificatePEM(data, certPath)
+}
+
+// parseCertificatePEM parses the first certificate in the PEM data; source
+// describes where the data came from, for errors.
+func parseCertificatePEM(data []byte, source string) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("could not find a certificate in %s", source)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// check the preferred host against the certificate for the given (original)
+// server.  It returns the host to use, plus any tls-server-name to set.
+func (c *sanChecker) check(server *url.URL, hosts []string) (string, string) {
+	cert, err := c.certificate(server)
+	if err != nil {
+		fmt.Fprintf(c.output, "Could not check the API server certificate: %s\n", err)
+		return hosts[0], ""
+	}
+	if cert.VerifyHostname(hosts[0]) == nil {
+		return hosts[0], ""
+	}
+	switch c.mode {
+	case sanCheckPick:
+		for _, host := range hosts[1:] {
+			if cert.VerifyHostname(host) == nil {
+				fmt.Fprintf(c.output, "Using %s, as the API server certificate is not valid for %s\n", host, hosts[0])
+				return host, ""
+			}
+		}
+	case sanCheckTLSServerName:
+		if name := coveredServerName(cert, server.Hostname()); name != "" {
+			return hosts[0], name
+		}
+	}
+	fmt.Fprintf(c.output, "The API server certificate is not valid for %s (only for %s); start k3s with --tls-san=%s\n",
+		hosts[0], strings.Join(certificateNames(cert), ", "), hosts[0])
+	return hosts[0], ""
+}
+
+// coveredServerName returns a name the certificate is valid for, preferring
+// the original host.
+func coveredServerName(cert *x509.Certificate, original string) string {
+	if cert.VerifyHostname(original) == nil {
+		return original
+	}
+	for _, name := range cert.DNSNames {
+		if !strings.Contains(name, "*") {
+			return name
+		}
+	}
+	if len(cert.IPAddresses) > 0 {
+		return cert.IPAddresses[0].String()
+	}
+	return ""
+}
+
+// certificateNames returns the subject alternative names in the certificate.
+func certificateNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		return []string{"no names"}
+	}
+	return names
+}
+
@@ -0,0 +1,201 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newServingCertificate creates a self-signed certificate for the given names
+// and addresses, returning it along with its PEM encoding.
+func newServingCertificate(t *testing.T, dnsNames []string, ips []string) (*x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "k3s"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+	}
+	for _, ip := range ips {
+		template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestNewSANChecker(t *testing.T) {
+	checker, err := newSANChecker(sanCheckNone, defaultServingCertPath, os.Stderr)
+	if err != nil || checker != nil {
+		t.Errorf("expected no checker for %q, got %v (%v)", sanCheckNone, checker, err)
+	}
+	for _, mode := range []string{sanCheckReport, sanCheckPick, sanCheckTLSServerName} {
+		if checker, err = newSANChecker(mode, defaultServingCertPath, os.Stderr); err != nil || checker == nil {
+			t.Errorf("expected a checker for %q, got %v (%v)", mode, checker, err)
+		}
+	}
+	if _, err = newSANChecker("strict", defaultServingCertPath, os.Stderr); err == nil {
+		t.Errorf("expected an error for an invalid mode")
+	}
+	// Rancher Desktop runs `k3s kubeconfig` without --san-check, so the
+	// default must check the certificate.
+	if flag := k3sKubeconfigCmd.Flags().Lookup("san-check"); flag.DefValue != sanCheckReport {
+		t.Errorf("expected --san-check to default to %q, got %q", sanCheckReport, flag.DefValue)
+	}
+}
+
+func TestSANCheckerCheck(t *testing.T) {
+	_, certPEM := newServingCertificate(t, []string{"localhost", "*.example.com"}, []string{"127.0.0.1", "172.20.1.2", "fd00::2"})
+	certPath := filepath.Join(t.TempDir(), "serving-kube-apiserver.crt")
+	if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server, err := url.Parse("https://127.0.0.1:6443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name          string
+		mode          string
+		hosts         []string
+		host          string
+		tlsServerName string
+		message       string
+	}{
+		{"covered", sanCheckReport, []string{"172.20.1.2", "10.0.0.1"}, "172.20.1.2", "", ""},
+		{"covered IPv6", sanCheckReport, []string{"fd00::2"}, "fd00::2", "", ""},
+		{"report", sanCheckReport, []string{"10.0.0.1", "172.20.1.2"}, "10.0.0.1", "", "--tls-san=10.0.0.1"},
+		{"pick", sanCheckPick, []string{"10.0.0.1", "fd00::2"}, "fd00::2", "", "Using fd00::2"},
+		{"pick nothing covered", sanCheckPick, []string{"10.0.0.1", "10.0.0.2"}, "10.0.0.1", "", "--tls-san=10.0.0.1"},
+		{"tls-server-name", sanCheckTLSServerName, []string{"10.0.0.1"}, "10.0.0.1", "127.0.0.1", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var output bytes.Buffer
+			checker, err := newSANChecker(c.mode, certPath, &output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			host, tlsServerName := checker.check(server, c.hosts)
+			if host != c.host || tlsServerName != c.tlsServerName {
+				t.Errorf("expected (%q, %q), got (%q, %q)", c.host, c.tlsServerName, host, tlsServerName)
+			}
+			if c.message == "" && output.Len() > 0 {
+				t.Errorf("unexpected output %q", output.String())
+			}
+			if !strings.Contains(output.String(), c.message) {
+				t.Errorf("expected output containing %q, got %q", c.message, output.String())
+			}
+		})
+	}
+
+	t.Run("fetched from server", func(t *testing.T) {
+		// The test server certificate is valid for 127.0.0.1 and example.com.
+		apiServer := httptest.NewTLSServer(http.NotFoundHandler())
+		defer apiServer.Close()
+		server, err := url.Parse(apiServer.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		missing := filepath.Join(t.TempDir(), "missing.crt")
+		checker, err := newSANChecker(sanCheckTLSServerName, missing, &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, tlsServerName := checker.check(server, []string{"10.0.0.1"})
+		if host != "10.0.0.1" || tlsServerName != "127.0.0.1" {
+			t.Errorf("unexpected result (%q, %q)", host, tlsServerName)
+		}
+		if output.Len() > 0 {
+			t.Errorf("unexpected output %q", output.String())
+		}
+	})
+
+	t.Run("certificate unavailable", func(t *testing.T) {
+		// Nothing should be listening on the discard port.
+		server, err := url.Parse("https://127.0.0.1:9")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		missing := filepath.Join(t.TempDir(), "missing.crt")
+		checker, err := newSANChecker(sanCheckPick, missing, &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, tlsServerName := checker.check(server, []string{"10.0.0.1", "10.0.0.2"})
+		if host != "10.0.0.1" || tlsServerName != "" {
+			t.Errorf("unexpected result (%q, %q)", host, tlsServerName)
+		}
+		if !strings.Contains(output.String(), "Could not check the API server certificate") {
+			t.Errorf("unexpected output %q", output.String())
+		}
+	})
+}
+
+func TestCoveredServerName(t *testing.T) {
+	cases := []struct {
+		name     string
+		dnsNames []string
+		ips      []string
+		original string
+		expected string
+	}{
+		{"original covered", []string{"kubernetes"}, []string{"127.0.0.1"}, "127.0.0.1", "127.0.0.1"},
+		{"original covered by wildcard", []string{"*.localhost"}, nil, "k3s.localhost", "k3s.localhost"},
+		{"first plain name", []string{"*.example.com", "kubernetes", "k3s"}, []string{"10.43.0.1"}, "127.0.0.1", "kubernetes"},
+		{"address only", []string{"*.example.com"}, []string{"10.43.0.1", "172.20.1.2"}, "localhost", "10.43.0.1"},
+		{"nothing usable", []string{"*.example.com"}, nil, "localhost", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cert, _ := newServingCertificate(t, c.dnsNames, c.ips)
+			if actual := coveredServerName(cert, c.original); actual != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
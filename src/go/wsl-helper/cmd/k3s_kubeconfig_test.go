@@ -0,0 +1,186 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteKubeconfigFrame(t *testing.T) {
+	cases := []struct {
+		format   string
+		doc      string
+		expected string
+	}{
+		{"yaml", "apiVersion: v1\nkind: Config\n", "---\napiVersion: v1\nkind: Config\n...\n"},
+		{"json", "{\"apiVersion\":\"v1\"}\n", "{\"apiVersion\":\"v1\"}\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			var output bytes.Buffer
+			if err := writeKubeconfigFrame(&output, c.format, []byte(c.doc)); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, output.String())
+			}
+		})
+	}
+}
+
+// fakeChangeSource reports a change for each value sent on events.
+type fakeChangeSource struct {
+	events chan struct{}
+	err    error
+}
+
+func (s *fakeChangeSource) run(ctx context.Context, changes chan<- struct{}) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-s.events:
+			if !ok {
+				return s.err
+			}
+			notifyChange(changes)
+		}
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	const settleDelay = 20 * time.Millisecond
+
+	// waitForDoc waits for the next document to be written.
+	waitForDoc := func(t *testing.T, docs <-chan string) string {
+		t.Helper()
+		select {
+		case doc := <-docs:
+			return doc
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a document")
+			return ""
+		}
+	}
+
+	t.Run("debounce", func(t *testing.T) {
+		var version, renders int32 = 1, 0
+		render := func() ([]byte, error) {
+			atomic.AddInt32(&renders, 1)
+			return []byte(fmt.Sprintf("version %d\n", atomic.LoadInt32(&version))), nil
+		}
+		docs := make(chan string, 10)
+		write := func(doc []byte) error {
+			docs <- string(doc)
+			return nil
+		}
+		source := &fakeChangeSource{events: make(chan struct{})}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		result := make(chan error, 1)
+		go func() {
+			result <- watchChanges(ctx, source, settleDelay, render, write)
+		}()
+
+		if doc := waitForDoc(t, docs); doc != "version 1\n" {
+			t.Fatalf("unexpected initial document %q", doc)
+		}
+		atomic.StoreInt32(&version, 2)
+		for i := 0; i < 5; i++ {
+			source.events <- struct{}{}
+		}
+		if doc := waitForDoc(t, docs); doc != "version 2\n" {
+			t.Fatalf("unexpected document %q", doc)
+		}
+		// Changes that do not alter the document should not write anything.
+		source.events <- struct{}{}
+		time.Sleep(5 * settleDelay)
+		select {
+		case doc := <-docs:
+			t.Fatalf("unexpected duplicate document %q", doc)
+		default:
+		}
+		// The burst of five changes is coalesced; at most one further render
+		// may happen for changes that arrive while waiting to settle.
+		if n := atomic.LoadInt32(&renders); n > 4 {
+			t.Errorf("expected at most 4 renders, got %d", n)
+		}
+
+		cancel()
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the watch to stop")
+		}
+	})
+
+	t.Run("render errors are skipped", func(t *testing.T) {
+		var failing int32 = 1
+		render := func() ([]byte, error) {
+			if atomic.LoadInt32(&failing) != 0 {
+				return nil, errors.New("file is being replaced")
+			}
+			return []byte("ok\n"), nil
+		}
+		docs := make(chan string, 10)
+		write := func(doc []byte) error {
+			docs <- string(doc)
+			return nil
+		}
+		source := &fakeChangeSource{events: make(chan struct{})}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go watchChanges(ctx, source, settleDelay, render, write)
+
+		atomic.StoreInt32(&failing, 0)
+		source.events <- struct{}{}
+		if doc := waitForDoc(t, docs); doc != "ok\n" {
+			t.Errorf("unexpected document %q", doc)
+		}
+	})
+
+	t.Run("source error", func(t *testing.T) {
+		render := func() ([]byte, error) { return []byte("doc\n"), nil }
+		write := func(doc []byte) error { return nil }
+		expected := errors.New("watch failed")
+		source := &fakeChangeSource{events: make(chan struct{}), err: expected}
+		close(source.events)
+		err := watchChanges(context.Background(), source, settleDelay, render, write)
+		if !errors.Is(err, expected) {
+			t.Errorf("expected %v, got %v", expected, err)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		render := func() ([]byte, error) { return []byte("doc\n"), nil }
+		expected := errors.New("disk full")
+		write := func(doc []byte) error { return expected }
+		source := &fakeChangeSource{events: make(chan struct{})}
+		err := watchChanges(context.Background(), source, settleDelay, render, write)
+		if !errors.Is(err, expected) {
+			t.Errorf("expected %v, got %v", expected, err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Netlink multicast groups for address changes; these are missing from the
+// syscall package.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// kubeconfigWatcher reports changes to the k3s kubeconfig (via inotify) and to
+// network addresses (via netlink).
+type kubeconfigWatcher struct {
+	// name is the base name of the kubeconfig file.
+	name    string
+	inotify *os.File
+	netlink *os.File
+}
+
+func newKubeconfigWatcher(configPath string) (*kubeconfigWatcher, error) {
+	inotifyFd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("could not watch for kubeconfig changes: %w", err)
+	}
+	// As the file descriptors are non-blocking, the files use the runtime
+	// poller, and closing them interrupts any pending reads.
+	inotify := os.NewFile(uintptr(inotifyFd), "inotify")
+	// Watch the directory, as k3s may replace the file rather than write to it.
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO)
+	if _, err = syscall.InotifyAddWatch(inotifyFd, filepath.Dir(configPath), mask); err != nil {
+		inotify.Close()
+		return nil, fmt.Errorf("could not watch %s: %w", filepath.Dir(configPath), err)
+	}
+
+	netlinkFd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		inotify.Close()
+		return nil, fmt.Errorf("could not watch for address changes: %w", err)
+	}
+	netlink := os.NewFile(uintptr(netlinkFd), "netlink")
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err = syscall.Bind(netlinkFd, addr); err != nil {
+		inotify.Close()
+		netlink.Close()
+		return nil, fmt.Errorf("could not watch for address changes: %w", err)
+	}
+	return &kubeconfigWatcher{name: filepath.Base(configPath), inotify: inotify, netlink: netlink}, nil
+}
+
+// run reports changes until the context is cancelled or an error occurs; the
+// watcher is closed when this returns.
+func (w *kubeconfigWatcher) run(ctx context.Context, changes chan<- struct{}) error {
+	errs := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs <- w.readInotify(changes)
+	}()
+	go func() {
+		defer wg.Done()
+		errs <- w.readNetlink(changes)
+	}()
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	// Closing the files interrupts any pending reads.
+	w.inotify.Close()
+	w.netlink.Close()
+	wg.Wait()
+	return err
+}
+
+// readInotify reads inotify events until the file is closed.
+func (w *kubeconfigWatcher) readInotify(changes chan<- struct{}) error {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.inotify.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("error reading kubeconfig changes: %w", err)
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(event.Len)], "\x00"))
+			if name == w.name || event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				notifyChange(changes)
+			}
+			offset = nameStart + int(event.Len)
+		}
+	}
+}
+
+// readNetlink reads address change notifications until the file is closed.
+func (w *kubeconfigWatcher) readNetlink(changes chan<- struct{}) error {
+	buf := make([]byte, os.Getpagesize()*2)
+	for {
+		n, err := w.netlink.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			if errors.Is(err, syscall.ENOBUFS) {
+				// Some notifications were dropped; assume something changed.
+				notifyChange(changes)
+				continue
+			}
+			return fmt.Errorf("error reading address changes: %w", err)
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("error parsing address changes: %w", err)
+		}
+		for _, message := range messages {
+			switch message.Header.Type {
+			case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+				notifyChange(changes)
+			}
+		}
+	}
+}
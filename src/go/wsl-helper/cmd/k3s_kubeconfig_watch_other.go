@@ -0,0 +1,36 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+)
+
+// kubeconfigWatcher reports changes to the k3s kubeconfig; this is only
+// supported on Linux.
+type kubeconfigWatcher struct{}
+
+func newKubeconfigWatcher(configPath string) (*kubeconfigWatcher, error) {
+	return nil, fmt.Errorf("watching the kubeconfig is only supported on Linux")
+}
+
+func (w *kubeconfigWatcher) run(ctx context.Context, changes chan<- struct{}) error {
+	return nil
+}
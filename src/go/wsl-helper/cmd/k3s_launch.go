@@ -0,0 +1,61 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var k3sLaunchViper = viper.New()
+
+// k3sLaunchCmd represents the `k3s launch` command.  This is necessary as we
+// store the data on the WSL shared mount (/mnt/wsl/rancher-desktop/), and that
+// can have issues with lingering tmpfs mounts after we exit.  This means we need
+// to run k3s in a private mount namespace, and then we can mark various mount
+// points as shared (for kim / buildkit).  Kubelet will internally do some tmpfs
+// mounts for volumes (secrets, etc.), which will stay private and go away once
+// k3s exits, so that we can delete the data as necessary.
+var k3sLaunchCmd = &cobra.Command{
+	Use:   "launch [flags] -- [k3s server arguments]",
+	Short: "Run k3s in a private mount namespace",
+	Long: `This command creates a private mount namespace, marks / and each of the data
+directories (which must be mount points) as shared, and then runs "k3s server"
+with the given arguments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var dataDirs []string
+		for _, dir := range strings.Split(k3sLaunchViper.GetString("data-dirs"), ":") {
+			if dir != "" {
+				dataDirs = append(dataDirs, filepath.Clean(dir))
+			}
+		}
+		cmd.SilenceUsage = true
+		return launchK3s(k3sLaunchViper.GetString("k3s"), append([]string{"/"}, dataDirs...), args)
+	},
+}
+
+func init() {
+	k3sLaunchCmd.Flags().String("k3s", "/usr/local/bin/k3s", "Path to the k3s executable")
+	k3sLaunchCmd.Flags().String("data-dirs", "", "Colon-separated mount points to mark as shared")
+	k3sLaunchViper.AutomaticEnv()
+	k3sLaunchViper.BindPFlags(k3sLaunchCmd.Flags())
+	// The wsl-launch-k3s script took the data directories from the environment.
+	k3sLaunchViper.BindEnv("data-dirs", "DISTRO_DATA_DIRS")
+	k3sCmd.AddCommand(k3sLaunchCmd)
+}
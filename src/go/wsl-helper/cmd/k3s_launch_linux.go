@@ -0,0 +1,148 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// launchK3s runs `k3s server` with the given arguments, in a new private
+// mount namespace in which the given directories are shared mounts.  It only
+// returns on failure.
+func launchK3s(k3sPath string, sharedDirs, args []string) error {
+	mountPoints, err := readMountPoints()
+	if err != nil {
+		return err
+	}
+	if err = checkMountPoints(sharedDirs, mountPoints); err != nil {
+		return err
+	}
+	if _, err := os.Stat(k3sPath); err != nil {
+		return fmt.Errorf("could not find k3s: %w", err)
+	}
+
+	// Mount namespaces are per-thread; the namespace must be created on the
+	// thread that runs k3s.
+	runtime.LockOSThread()
+	if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("could not create a mount namespace: %w", mountErrorReason(err))
+	}
+	if err := syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("could not make mounts private in the new namespace: %w", mountErrorReason(err))
+	}
+	for _, dir := range sharedDirs {
+		if err := syscall.Mount("none", dir, "", syscall.MS_SHARED, ""); err != nil {
+			return fmt.Errorf("could not make %s a shared mount: %w", dir, mountErrorReason(err))
+		}
+	}
+
+	argv := append([]string{k3sPath, "server"}, args...)
+	if err := syscall.Exec(k3sPath, argv, os.Environ()); err != nil {
+		return fmt.Errorf("could not run %s: %w", k3sPath, err)
+	}
+	return nil
+}
+
+// mountErrorReason adds an explanation to the error from a mount-related
+// system call, where the error number alone is unclear.
+func mountErrorReason(err error) error {
+	switch {
+	case errors.Is(err, syscall.EPERM):
+		return fmt.Errorf("%w (this requires root, with CAP_SYS_ADMIN)", err)
+	case errors.Is(err, syscall.EINVAL):
+		return fmt.Errorf("%w (not a mount point)", err)
+	}
+	return err
+}
+
+// checkMountPoints checks that each directory exists and is one of the given
+// mount points, as only mount points can be made shared.
+func checkMountPoints(dirs []string, mountPoints map[string]struct{}) error {
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("data directory %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("data directory %s is not a directory", dir)
+		}
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return fmt.Errorf("data directory %s: %w", dir, err)
+		}
+		if _, ok := mountPoints[resolved]; !ok {
+			if resolved != dir {
+				return fmt.Errorf("data directory %s (%s) is not a mount point", dir, resolved)
+			}
+			return fmt.Errorf("data directory %s is not a mount point", dir)
+		}
+	}
+	return nil
+}
+
+// readMountPoints returns the mount points of the current mount namespace.
+func readMountPoints() (map[string]struct{}, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("could not read mount points: %w", err)
+	}
+	defer file.Close()
+	return parseMountPoints(file)
+}
+
+// parseMountPoints returns the mount points listed in mountinfo format.
+func parseMountPoints(mountInfo io.Reader) (map[string]struct{}, error) {
+	result := make(map[string]struct{})
+	scanner := bufio.NewScanner(mountInfo)
+	for scanner.Scan() {
+		// The fifth field is the mount point; see proc(5).
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		result[unescapeMountInfo(fields[4])] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read mount points: %w", err)
+	}
+	return result, nil
+}
+
+// unescapeMountInfo decodes the octal escapes (e.g. \040 for a space) used in
+// /proc/self/mountinfo.
+func unescapeMountInfo(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) {
+			if ch, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				builder.WriteByte(byte(ch))
+				i += 3
+				continue
+			}
+		}
+		builder.WriteByte(value[i])
+	}
+	return builder.String()
+}
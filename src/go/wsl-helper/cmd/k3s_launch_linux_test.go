@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnescapeMountInfo(t *testing.T) {
+	cases := map[string]string{
+		`/mnt/wsl/rancher-desktop/run/data`: "/mnt/wsl/rancher-desktop/run/data",
+		`/mnt/c/Program\040Files`:           "/mnt/c/Program Files",
+		`/tab\011and\012newline`:            "/tab\tand\nnewline",
+		`/back\134slash`:                    `/back\slash`,
+		`/trailing\040`:                     "/trailing ",
+		`/short\04`:                         `/short\04`,
+		`/not\08octal`:                      `/not\08octal`,
+		`/out\777ofrange`:                   `/out\777ofrange`,
+		`\`:                                 `\`,
+	}
+	for input, expected := range cases {
+		if actual := unescapeMountInfo(input); actual != expected {
+			t.Errorf("%s: expected %q, got %q", input, expected, actual)
+		}
+	}
+}
+
+func TestParseMountPoints(t *testing.T) {
+	mountInfo := strings.Join([]string{
+		`22 1 8:32 / / rw,relatime shared:1 - ext4 /dev/sdc rw`,
+		`50 22 0:45 / /mnt/c rw,noatime - 9p drvfs rw,dirsync,aname=drvfs;path=C:\`,
+		`60 22 8:32 /data /var/lib/rancher rw,relatime shared:1 master:2 - ext4 /dev/sdc rw`,
+		`61 22 0:50 / /mnt/c/Program\040Files rw - 9p drvfs rw`,
+		`truncated line`,
+		``,
+	}, "\n")
+	mountPoints, err := parseMountPoints(strings.NewReader(mountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]struct{}{
+		"/":                    {},
+		"/mnt/c":               {},
+		"/var/lib/rancher":     {},
+		"/mnt/c/Program Files": {},
+	}
+	if !reflect.DeepEqual(mountPoints, expected) {
+		t.Errorf("expected %v, got %v", expected, mountPoints)
+	}
+}
+
+func TestCheckMountPoints(t *testing.T) {
+	dir := t.TempDir()
+	mounted := filepath.Join(dir, "mounted")
+	unmounted := filepath.Join(dir, "unmounted")
+	for _, p := range []string{mounted, unmounted} {
+		if err := os.Mkdir(p, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(mounted, link); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	writeTestFile(t, file, "")
+	mountPoints := map[string]struct{}{mounted: {}, file: {}}
+
+	cases := []struct {
+		name string
+		dirs []string
+		err  string
+	}{
+		{"none", nil, ""},
+		{"mount point", []string{mounted}, ""},
+		{"symlink to a mount point", []string{link}, ""},
+		{"not a mount point", []string{mounted, unmounted}, unmounted + " is not a mount point"},
+		{"missing", []string{filepath.Join(dir, "missing")}, "no such file or directory"},
+		{"not a directory", []string{file}, file + " is not a directory"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkMountPoints(c.dirs, mountPoints)
+			if c.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error containing %q, got %v", c.err, err)
+			}
+		})
+	}
+
+	t.Run("symlink to elsewhere", func(t *testing.T) {
+		other := filepath.Join(dir, "other")
+		if err := os.Symlink(unmounted, other); err != nil {
+			t.Fatal(err)
+		}
+		err := checkMountPoints([]string{other}, mountPoints)
+		if err == nil || !strings.Contains(err.Error(), "("+unmounted+") is not a mount point") {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
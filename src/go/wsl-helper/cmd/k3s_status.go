@@ -0,0 +1,337 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// k3sWaitInitialBackoff is how long to wait after the first failed probe.
+	k3sWaitInitialBackoff = 250 * time.Millisecond
+	// k3sWaitMaxBackoff is the longest time to wait between probes.
+	k3sWaitMaxBackoff = 10 * time.Second
+	// k3sProbeMessageLimit is the longest response body reported for a probe.
+	k3sProbeMessageLimit = 1024
+)
+
+// k3sProbe is the result of a health check endpoint.
+type k3sProbe struct {
+	OK         bool   `json:"ok"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// k3sNodeStatus is the Ready condition of a node.
+type k3sNodeStatus struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// k3sProcessStatus describes the k3s server process.
+type k3sProcessStatus struct {
+	Running bool   `json:"running"`
+	PID     int    `json:"pid,omitempty"`
+	State   string `json:"state,omitempty"`
+}
+
+// k3sStatus is the status document printed by `k3s status`.
+type k3sStatus struct {
+	// Ready is set if the health checks pass and the node is Ready.
+	Ready      bool             `json:"ready"`
+	Kubeconfig string           `json:"kubeconfig"`
+	Server     string           `json:"server,omitempty"`
+	Version    string           `json:"version,omitempty"`
+	Readyz     k3sProbe         `json:"readyz"`
+	Livez      k3sProbe         `json:"livez"`
+	Nodes      []k3sNodeStatus  `json:"nodes"`
+	Process    k3sProcessStatus `json:"process"`
+	// Error describes any problem other than a failed health check.
+	Error string `json:"error,omitempty"`
+}
+
+// probeK3sStatus checks the health of k3s, using the kubeconfig at
+// configPath.  If nodeName is empty, all nodes must be Ready.
+func probeK3sStatus(ctx context.Context, configPath, nodeName string) *k3sStatus {
+	status := &k3sStatus{
+		Kubeconfig: configPath,
+		Nodes:      []k3sNodeStatus{},
+		Process:    findK3sProcess(),
+	}
+	config, err := readKubeConfig(configPath)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	client, err := newKubeClient(config, configPath)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	defer client.close()
+	status.Server = client.server
+
+	status.Readyz = client.probe(ctx, "/readyz")
+	status.Livez = client.probe(ctx, "/livez")
+	if !status.Readyz.OK {
+		return status
+	}
+	if status.Version, err = client.version(ctx); err != nil {
+		status.Error = fmt.Sprintf("could not get version: %s", err)
+		return status
+	}
+	if status.Nodes, err = client.nodes(ctx, nodeName); err != nil {
+		status.Error = fmt.Sprintf("could not get nodes: %s", err)
+		return status
+	}
+	status.Ready = status.Livez.OK && len(status.Nodes) > 0
+	for _, node := range status.Nodes {
+		status.Ready = status.Ready && node.Ready
+	}
+	return status
+}
+
+// reason describes why k3s is not ready.
+func (s *k3sStatus) reason() string {
+	switch {
+	case s.Ready:
+		return "ready"
+	case s.Error != "":
+		return s.Error
+	case !s.Readyz.OK:
+		return "readyz: " + s.Readyz.Message
+	case !s.Livez.OK:
+		return "livez: " + s.Livez.Message
+	case len(s.Nodes) == 0:
+		return "no nodes"
+	}
+	for _, node := range s.Nodes {
+		if !node.Ready {
+			if node.Message != "" {
+				return fmt.Sprintf("node %s is not ready: %s", node.Name, node.Message)
+			}
+			return fmt.Sprintf("node %s is not ready", node.Name)
+		}
+	}
+	return "not ready"
+}
+
+// probe checks a health check endpoint (/readyz or /livez).
+func (c *kubeClient) probe(ctx context.Context, path string) k3sProbe {
+	code, body, err := c.get(ctx, path)
+	if err != nil {
+		return k3sProbe{Message: err.Error()}
+	}
+	message := strings.TrimSpace(string(body))
+	if len(message) > k3sProbeMessageLimit {
+		message = message[:k3sProbeMessageLimit] + "..."
+	}
+	return k3sProbe{OK: code == http.StatusOK, StatusCode: code, Message: message}
+}
+
+// version returns the Kubernetes version reported by the API server.
+func (c *kubeClient) version(ctx context.Context) (string, error) {
+	var info struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := c.getJSON(ctx, "/version", &info); err != nil {
+		return "", err
+	}
+	return info.GitVersion, nil
+}
+
+// nodes returns the Ready condition of the nodes; if name is given, only
+// that node is returned (and it must exist).
+func (c *kubeClient) nodes(ctx context.Context, name string) ([]k3sNodeStatus, error) {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type    string `json:"type"`
+					Status  string `json:"status"`
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := c.getJSON(ctx, "/api/v1/nodes", &list); err != nil {
+		return nil, err
+	}
+	result := []k3sNodeStatus{}
+	for _, item := range list.Items {
+		if name != "" && item.Metadata.Name != name {
+			continue
+		}
+		node := k3sNodeStatus{Name: item.Metadata.Name}
+		for _, condition := range item.Status.Conditions {
+			if condition.Type == "Ready" {
+				node.Ready = condition.Status == "True"
+				node.Reason, node.Message = condition.Reason, condition.Message
+			}
+		}
+		result = append(result, node)
+	}
+	if name != "" && len(result) == 0 {
+		return nil, fmt.Errorf("node %s does not exist", name)
+	}
+	return result, nil
+}
+
+// getJSON fetches the given path from the API server and decodes it.
+func (c *kubeClient) getJSON(ctx context.Context, path string, result interface{}) error {
+	code, body, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("%s returned %d: %s", path, code, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, result)
+}
+
+// waitForK3sReady probes k3s until it is ready, with exponential backoff
+// starting at initial and capped at max; progress is written to progress.
+// It returns the last status.
+func waitForK3sReady(ctx context.Context, probe func(context.Context) *k3sStatus, initial, max time.Duration, progress io.Writer) (*k3sStatus, error) {
+	var last *k3sStatus
+	delay := initial
+	for {
+		status := probe(ctx)
+		if status.Ready {
+			return status, nil
+		}
+		// A probe cut short by the context ending has nothing useful to say.
+		if ctx.Err() == nil || last == nil {
+			last = status
+		}
+		if ctx.Err() == nil {
+			fmt.Fprintf(progress, "k3s is not ready (%s); retrying in %s\n", last.reason(), delay)
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return last, fmt.Errorf("timed out waiting for k3s to be ready: %s", last.reason())
+			}
+			return last, ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > max {
+			delay = max
+		}
+	}
+}
+
+// writeK3sStatus prints the status document.
+func writeK3sStatus(output io.Writer, status *k3sStatus) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(status)
+}
+
+var k3sStatusViper = viper.New()
+
+// k3sStatusCmd represents the `k3s status` command.
+var k3sStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the health of k3s",
+	Long: `This command checks the /readyz and /livez endpoints of the API server and
+whether the node is Ready, and prints them along with the k3s version and
+process state as JSON.  The exit code is non-zero if k3s is not ready.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		status := probeK3sStatus(cmd.Context(),
+			k3sStatusViper.GetString("k3sconfig"),
+			k3sStatusViper.GetString("node"))
+		if err := writeK3sStatus(os.Stdout, status); err != nil {
+			return err
+		}
+		if !status.Ready {
+			cmd.SilenceErrors = true
+			return &exitCodeError{code: 1}
+		}
+		return nil
+	},
+}
+
+var k3sWaitReadyViper = viper.New()
+
+// k3sWaitReadyCmd represents the `k3s wait-ready` command.
+var k3sWaitReadyCmd = &cobra.Command{
+	Use:   "wait-ready",
+	Short: "Wait for k3s to be ready",
+	Long: `This command waits for k3s to be ready (as reported by "k3s status"),
+checking again with exponential backoff.  Progress is reported on stderr, and
+the final status is printed as JSON.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configPath := k3sWaitReadyViper.GetString("k3sconfig")
+		nodeName := k3sWaitReadyViper.GetString("node")
+		cmd.SilenceUsage = true
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if timeout := k3sWaitReadyViper.GetDuration("timeout"); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		probe := func(ctx context.Context) *k3sStatus {
+			return probeK3sStatus(ctx, configPath, nodeName)
+		}
+		status, err := waitForK3sReady(ctx, probe, k3sWaitInitialBackoff, k3sWaitMaxBackoff, os.Stderr)
+		if writeErr := writeK3sStatus(os.Stdout, status); writeErr != nil && err == nil {
+			err = writeErr
+		}
+		return err
+	},
+}
+
+func init() {
+	k3sStatusCmd.Flags().String("k3sconfig", "/etc/rancher/k3s/k3s.yaml", "Path to k3s kubeconfig")
+	k3sStatusCmd.Flags().String("node", "", "Name of the node to check (default all nodes)")
+	// As with k3s kubeconfig, only the kubeconfig path may come from the
+	// environment.
+	k3sStatusViper.BindPFlags(k3sStatusCmd.Flags())
+	k3sStatusViper.BindEnv("k3sconfig", "K3SCONFIG")
+	k3sCmd.AddCommand(k3sStatusCmd)
+
+	k3sWaitReadyCmd.Flags().String("k3sconfig", "/etc/rancher/k3s/k3s.yaml", "Path to k3s kubeconfig")
+	k3sWaitReadyCmd.Flags().String("node", "", "Name of the node to check (default all nodes)")
+	k3sWaitReadyCmd.Flags().Duration("timeout", 5*time.Minute, "How long to wait (0 to wait forever)")
+	k3sWaitReadyViper.BindPFlags(k3sWaitReadyCmd.Flags())
+	k3sWaitReadyViper.BindEnv("k3sconfig", "K3SCONFIG")
+	k3sCmd.AddCommand(k3sWaitReadyCmd)
+}
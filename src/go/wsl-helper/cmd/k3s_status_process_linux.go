@@ -0,0 +1,91 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// k3sProcessStates maps the state letters in /proc/<pid>/stat to names.
+var k3sProcessStates = map[byte]string{
+	'R': "running",
+	'S': "sleeping",
+	'D': "disk-sleep",
+	'T': "stopped",
+	't': "tracing-stop",
+	'Z': "zombie",
+	'X': "dead",
+	'I': "idle",
+}
+
+// findK3sProcess looks for the k3s server process.
+func findK3sProcess() k3sProcessStatus {
+	procDirs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, procDir := range procDirs {
+		pid, err := strconv.Atoi(filepath.Base(procDir))
+		if err != nil || !isK3sServer(procDir) {
+			continue
+		}
+		state := k3sProcessState(procDir)
+		return k3sProcessStatus{
+			Running: state != "" && state != "zombie" && state != "dead",
+			PID:     pid,
+			State:   state,
+		}
+	}
+	return k3sProcessStatus{}
+}
+
+// isK3sServer checks whether the process is `k3s server`; k3s renames its
+// process to k3s-server.
+func isK3sServer(procDir string) bool {
+	comm, err := os.ReadFile(filepath.Join(procDir, "comm"))
+	if err != nil {
+		return false
+	}
+	switch string(bytes.TrimSpace(comm)) {
+	case "k3s-server":
+		return true
+	case "k3s":
+		cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline"))
+		if err != nil {
+			return false
+		}
+		args := bytes.Split(cmdline, []byte{0})
+		return len(args) > 1 && string(args[1]) == "server"
+	}
+	return false
+}
+
+// k3sProcessState returns the state of the process, or empty if unknown.
+func k3sProcessState(procDir string) string {
+	stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return ""
+	}
+	// The command name (in parentheses) may contain spaces.
+	index := bytes.LastIndexByte(stat, ')')
+	if index < 0 || index+2 >= len(stat) {
+		return ""
+	}
+	if state, ok := k3sProcessStates[stat[index+2]]; ok {
+		return state
+	}
+	return string(stat[index+2])
+}
@@ -0,0 +1,279 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAPIServer is a stand-in for the k3s API server.
+type fakeAPIServer struct {
+	*httptest.Server
+	// readyAfter is the number of /readyz requests that fail before it passes.
+	readyAfter int32
+	readyCalls int32
+	// nodeReady is the status of the Ready condition of the node.
+	nodeReady string
+}
+
+// newFakeAPIServer starts a TLS server that requires a client certificate
+// signed by clientCA.
+func newFakeAPIServer(t *testing.T, clientCA *x509.Certificate) *fakeAPIServer {
+	server := &fakeAPIServer{nodeReady: "True"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&server.readyCalls, 1) <= atomic.LoadInt32(&server.readyAfter) {
+			http.Error(w, "[-]poststarthook/rbac failed", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"major": "1", "minor": "21", "gitVersion": "v1.21.4+k3s1"}`)
+	})
+	mux.HandleFunc("/api/v1/nodes", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"items": [{"metadata": {"name": "machine"}, "status": {"conditions": [
+			{"type": "MemoryPressure", "status": "False"},
+			{"type": "Ready", "status": %q, "reason": "KubeletReady", "message": "kubelet is posting ready status"}
+		]}}]}`, server.nodeReady)
+	})
+	server.Server = httptest.NewUnstartedServer(mux)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newClientCertificate creates a self-signed client certificate, returning
+// the certificate and the PEM encoded certificate and key.
+func newClientCertificate(t *testing.T) (*x509.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "system:admin", Organization: []string{"system:masters"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTestKubeconfig writes a kubeconfig like the one k3s generates, for the
+// given server, and returns its path.
+func writeTestKubeconfig(t *testing.T, server *httptest.Server, certPEM, keyPEM []byte) string {
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	encode := base64.StdEncoding.EncodeToString
+	contents := fmt.Sprintf(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: %s
+  name: default
+contexts:
+- context:
+    cluster: default
+    user: default
+  name: default
+current-context: default
+kind: Config
+preferences: {}
+users:
+- name: default
+  user:
+    client-certificate-data: %s
+    client-key-data: %s
+`, encode(caPEM), server.URL, encode(certPEM), encode(keyPEM))
+	configPath := filepath.Join(t.TempDir(), "k3s.yaml")
+	if err := ioutil.WriteFile(configPath, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func TestProbeK3sStatus(t *testing.T) {
+	clientCA, certPEM, keyPEM := newClientCertificate(t)
+
+	t.Run("ready", func(t *testing.T) {
+		server := newFakeAPIServer(t, clientCA)
+		configPath := writeTestKubeconfig(t, server.Server, certPEM, keyPEM)
+		status := probeK3sStatus(context.Background(), configPath, "")
+		if !status.Ready {
+			t.Fatalf("expected k3s to be ready, got %s", status.reason())
+		}
+		if status.Version != "v1.21.4+k3s1" {
+			t.Errorf("unexpected version %q", status.Version)
+		}
+		if status.Server != server.URL {
+			t.Errorf("unexpected server %q", status.Server)
+		}
+		if len(status.Nodes) != 1 || status.Nodes[0].Name != "machine" || !status.Nodes[0].Ready {
+			t.Errorf("unexpected nodes %+v", status.Nodes)
+		}
+	})
+
+	t.Run("readyz failing", func(t *testing.T) {
+		server := newFakeAPIServer(t, clientCA)
+		server.readyAfter = 1
+		configPath := writeTestKubeconfig(t, server.Server, certPEM, keyPEM)
+		status := probeK3sStatus(context.Background(), configPath, "")
+		if status.Ready {
+			t.Fatal("expected k3s not to be ready")
+		}
+		if status.Readyz.StatusCode != http.StatusInternalServerError {
+			t.Errorf("unexpected readyz status %d", status.Readyz.StatusCode)
+		}
+		if reason := status.reason(); !strings.Contains(reason, "poststarthook/rbac") {
+			t.Errorf("unexpected reason %q", reason)
+		}
+	})
+
+	t.Run("node not ready", func(t *testing.T) {
+		server := newFakeAPIServer(t, clientCA)
+		server.nodeReady = "False"
+		configPath := writeTestKubeconfig(t, server.Server, certPEM, keyPEM)
+		status := probeK3sStatus(context.Background(), configPath, "")
+		if status.Ready {
+			t.Fatal("expected k3s not to be ready")
+		}
+		if reason := status.reason(); !strings.HasPrefix(reason, "node machine is not ready") {
+			t.Errorf("unexpected reason %q", reason)
+		}
+	})
+
+	t.Run("missing node", func(t *testing.T) {
+		server := newFakeAPIServer(t, clientCA)
+		configPath := writeTestKubeconfig(t, server.Server, certPEM, keyPEM)
+		status := probeK3sStatus(context.Background(), configPath, "other")
+		if status.Ready || !strings.Contains(status.Error, "node other does not exist") {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+
+	t.Run("wrong client certificate", func(t *testing.T) {
+		server := newFakeAPIServer(t, clientCA)
+		_, otherCertPEM, otherKeyPEM := newClientCertificate(t)
+		configPath := writeTestKubeconfig(t, server.Server, otherCertPEM, otherKeyPEM)
+		status := probeK3sStatus(context.Background(), configPath, "")
+		if status.Ready || status.Readyz.OK || status.Readyz.Message == "" {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+
+	t.Run("missing kubeconfig", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "k3s.yaml")
+		status := probeK3sStatus(context.Background(), configPath, "")
+		if status.Ready || status.Error == "" {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+}
+
+func TestWaitForK3sReady(t *testing.T) {
+	clientCA, certPEM, keyPEM := newClientCertificate(t)
+
+	t.Run("becomes ready", func(t *testing.T) {
+		server := newFakeAPIServer(t, clientCA)
+		server.readyAfter = 3
+		configPath := writeTestKubeconfig(t, server.Server, certPEM, keyPEM)
+		probe := func(ctx context.Context) *k3sStatus {
+			return probeK3sStatus(ctx, configPath, "")
+		}
+		var progress strings.Builder
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		status, err := waitForK3sReady(ctx, probe, time.Millisecond, 4*time.Millisecond, &progress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.Ready {
+			t.Errorf("expected k3s to be ready")
+		}
+		expected := []string{"retrying in 1ms", "retrying in 2ms", "retrying in 4ms"}
+		lines := strings.Split(strings.TrimSpace(progress.String()), "\n")
+		if len(lines) != len(expected) {
+			t.Fatalf("unexpected progress %q", progress.String())
+		}
+		for i, line := range lines {
+			if !strings.HasSuffix(line, expected[i]) || !strings.Contains(line, "readyz:") {
+				t.Errorf("unexpected progress line %q", line)
+			}
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		server := newFakeAPIServer(t, clientCA)
+		server.nodeReady = "False"
+		configPath := writeTestKubeconfig(t, server.Server, certPEM, keyPEM)
+		probe := func(ctx context.Context) *k3sStatus {
+			return probeK3sStatus(ctx, configPath, "")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		status, err := waitForK3sReady(ctx, probe, time.Millisecond, 10*time.Millisecond, ioutil.Discard)
+		if err == nil {
+			t.Fatal("expected a timeout")
+		}
+		if !strings.Contains(err.Error(), "node machine is not ready") {
+			t.Errorf("unexpected error %q", err)
+		}
+		if status == nil || status.Ready {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+}
@@ -16,11 +16,11 @@ limitations under the License.
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path"
-	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -41,76 +41,141 @@ var kubeconfigCmd = &cobra.Command{
 		configPath := kubeconfigViper.GetString("kubeconfig")
 		enable := kubeconfigViper.GetBool("enable")
 		show := kubeconfigViper.GetBool("show")
-
-		_, err := os.Stat(configPath)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				return fmt.Errorf("Could not open Windows kubeconfig: %w", err)
-			}
-			return err
+		merge := kubeconfigViper.GetBool("merge")
+		output := kubeconfigViper.GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("unsupported output format %q", output)
 		}
-		cmd.SilenceUsage = true
 
 		configDir := path.Join(os.Getenv("HOME"), ".kube")
 		linkPath := path.Join(configDir, "config")
+		if show && output == "json" {
+			// The status is always printed; the exit code reflects problems.
+			cmd.SilenceUsage = true
+			status := getKubeconfigStatus(configPath, linkPath)
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(status); err != nil {
+				return err
+			}
+			if code := status.exitCode(); code != 0 {
+				cmd.SilenceErrors = true
+				return &exitCodeError{code: code}
+			}
+			return nil
+		}
+
 		if show {
 			// The output is "true", "false", or an error message for UI.
 			// We will only return nil in this path.
-			target, err := os.Readlink(linkPath)
-			if err != nil {
+			if _, err := os.Stat(configPath); err != nil {
 				if errors.Is(err, os.ErrNotExist) {
-					fmt.Println("false")
-				} else if errors.Is(err, syscall.EINVAL) {
-					fmt.Printf("File %s exists and is not a symlink\n", linkPath)
-				} else {
-					fmt.Printf("%s\n", err)
+					return fmt.Errorf("Could not open Windows kubeconfig: %w", err)
 				}
-			} else if target == configPath {
+				return err
+			}
+			cmd.SilenceUsage = true
+			status := getKubeconfigStatus(configPath, linkPath)
+			switch status.State {
+			case kubeconfigStateManaged, kubeconfigStateMerged:
 				fmt.Println("true")
-			} else {
+			case kubeconfigStateForeign:
+				fmt.Printf("File %s exists and is not a symlink\n", linkPath)
+			case kubeconfigStateUnreadable:
+				fmt.Printf("%s\n", status.Error)
+			default:
 				// For a symlink pointing elsewhere, we assume we can overwrite.
 				fmt.Println("false")
 			}
 			return nil
 		}
-		if enable {
-			err = os.Mkdir(configDir, 0o750)
-			if err != nil && !errors.Is(err, os.ErrExist) {
-				// The error already contains the full path, we can't do better.
-				return err
+		if !enable {
+			// Removing the configuration does not need the Windows kubeconfig,
+			// which may well be gone already.
+			cmd.SilenceUsage = true
+			if merge {
+				return unmergeKubeconfig(configPath, linkPath)
 			}
-			err = os.Symlink(configPath, linkPath)
-			if err != nil {
-				if errors.Is(err, os.ErrExist) {
-					// If it already exists, do nothing; even if it's not a symlink.
-					return nil
+			return removeKubeconfigLink(configPath, linkPath)
+		}
+
+		var policy *addressPolicy
+		if merge {
+			serverHost := kubeconfigViper.GetString("server-host")
+			if kubeconfigViper.GetBool("rewrite-server") || serverHost != "" {
+				var err error
+				policy, err = newAddressPolicy(
+					serverHost,
+					kubeconfigViper.GetString("interface"),
+					kubeconfigViper.GetString("address-family"),
+					kubeconfigViper.GetStringSlice("cidr"))
+				if err != nil {
+					return err
 				}
-				return err
 			}
-		} else {
-			// No need to create if we want to remove it
-			target, err := os.Readlink(linkPath)
-			if err != nil {
-				if errors.Is(err, os.ErrNotExist) {
-					return nil
-				}
-				return err
+		}
+		_, err := os.Stat(configPath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("Could not open Windows kubeconfig: %w", err)
 			}
-			if target == configPath {
-				err = os.Remove(linkPath)
-				if err != nil && !errors.Is(err, os.ErrNotExist) {
-					return err
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		if merge {
+			return mergeKubeconfig(configPath, linkPath, policy)
+		}
+		err = os.Mkdir(configDir, 0o750)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			// The error already contains the full path, we can't do better.
+			return err
+		}
+		err = os.Symlink(configPath, linkPath)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				// If it already exists, do nothing; even if it's not a symlink.
+				if target, _ := os.Readlink(linkPath); target != configPath {
+					fmt.Fprintf(os.Stderr, "%s already exists; use --merge to add the %s context to it\n", linkPath, rancherDesktopContextName)
 				}
+				return nil
 			}
+			return err
 		}
 		return nil
 	},
 }
 
+// removeKubeconfigLink removes the kubeconfig if it is a symlink to the Windows
+// kubeconfig.
+func removeKubeconfigLink(windowsPath, linkPath string) error {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if target == windowsPath {
+		err = os.Remove(linkPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
 	kubeconfigCmd.PersistentFlags().Bool("enable", true, "Set up config file")
 	kubeconfigCmd.PersistentFlags().String("kubeconfig", "", "Path to Windows kubeconfig, in /mnt/... form.")
 	kubeconfigCmd.PersistentFlags().Bool("show", false, "Get the current state rather than set it")
+	kubeconfigCmd.PersistentFlags().String("output", "text", "Output format for --show: text, or json for a status document")
+	kubeconfigCmd.PersistentFlags().Bool("merge", false, "Merge the rancher-desktop entries into the config file instead of symlinking it")
+	kubeconfigCmd.PersistentFlags().Bool("rewrite-server", false, "When merging, change the server address to one chosen by --interface, --address-family and --cidr")
+	kubeconfigCmd.PersistentFlags().String("server-host", "", "When merging, change the server host to this")
+	kubeconfigCmd.PersistentFlags().String("interface", "eth0", "When rewriting the server, the interface to take the address from; may be a glob pattern")
+	kubeconfigCmd.PersistentFlags().String("address-family", addressFamilyIPv4, "When rewriting the server, the address family to use: ipv4, ipv6, prefer-ipv4 or prefer-ipv6")
+	kubeconfigCmd.PersistentFlags().StringSlice("cidr", nil, "When rewriting the server, only use addresses in these networks")
 	kubeconfigViper.AutomaticEnv()
 	kubeconfigViper.BindPFlags(kubeconfigCmd.PersistentFlags())
 	rootCmd.AddCommand(kubeconfigCmd)
@@ -0,0 +1,456 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// rancherDesktopContextName is the name of the context (and, in the
+	// Windows kubeconfig, the cluster and user) for Rancher Desktop.
+	rancherDesktopContextName = "rancher-desktop"
+	// kubeconfigBackupSuffix is appended to the kubeconfig path to get the
+	// path of the backup made before changing it.
+	kubeconfigBackupSuffix = ".rd-backup"
+	// kubeconfigPreviousContextSuffix is appended to the kubeconfig path to get
+	// the path of the file recording the current context before merging, so
+	// that it can be restored afterwards.
+	kubeconfigPreviousContextSuffix = ".rd-previous-context"
+)
+
+// Sections in a kubeconfig that hold named entries.
+const (
+	kubeconfigClusters = "clusters"
+	kubeconfigUsers    = "users"
+	kubeconfigContexts = "contexts"
+)
+
+// kubeconfigDocument is a kubeconfig file kept as a YAML node tree, so that
+// entries that are not ours are preserved as they are (including comments).
+type kubeconfigDocument struct {
+	// root is the top-level mapping.
+	root *yaml.Node
+}
+
+// newKubeconfigDocument returns an empty kubeconfig.
+func newKubeconfigDocument() *kubeconfigDocument {
+	doc := &kubeconfigDocument{root: &yaml.Node{Kind: yaml.MappingNode}}
+	doc.set("apiVersion", &yaml.Node{Kind: yaml.ScalarNode, Value: "v1"})
+	doc.set("kind", &yaml.Node{Kind: yaml.ScalarNode, Value: "Config"})
+	return doc
+}
+
+// readKubeconfigDocument reads the given kubeconfig; a missing (or empty) file
+// results in an empty kubeconfig.
+func readKubeconfigDocument(configPath string) (*kubeconfigDocument, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return newKubeconfigDocument(), nil
+		}
+		return nil, err
+	}
+	var node yaml.Node
+	if err = yaml.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", configPath, err)
+	}
+	if node.Kind == 0 {
+		return newKubeconfigDocument(), nil
+	}
+	if node.Kind != yaml.DocumentNode || len(node.Content) != 1 || node.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("could not parse %s: not a kubeconfig", configPath)
+	}
+	return &kubeconfigDocument{root: node.Content[0]}, nil
+}
+
+// mappingValue returns the value for the given key in a mapping node, or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingString returns the scalar value for the given key in a mapping node.
+func mappingString(mapping *yaml.Node, key string) string {
+	if value := mappingValue(mapping, key); value != nil && value.Kind == yaml.ScalarNode {
+		return value.Value
+	}
+	return ""
+}
+
+// set the value of a top-level key.
+func (d *kubeconfigDocument) set(key string, value *yaml.Node) {
+	for i := 0; i+1 < len(d.root.Content); i += 2 {
+		if d.root.Content[i].Value == key {
+			d.root.Content[i+1] = value
+			return
+		}
+	}
+	d.root.Content = append(d.root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// entries returns the sequence of named entries in the given section,
+// creating it if needed.
+func (d *kubeconfigDocument) entries(section string) *yaml.Node {
+	value := mappingValue(d.root, section)
+	if value == nil || value.Kind != yaml.SequenceNode {
+		value = &yaml.Node{Kind: yaml.SequenceNode}
+		d.set(section, value)
+	}
+	return value
+}
+
+// find returns the entry with the given name in the given section, or nil.
+func (d *kubeconfigDocument) find(section, name string) *yaml.Node {
+	for _, entry := range sequenceItems(mappingValue(d.root, section)) {
+		if mappingString(entry, "name") == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+// upsert replaces the entry with the same name in the given section, or adds
+// it if there is none.
+func (d *kubeconfigDocument) upsert(section string, entry *yaml.Node) {
+	name := mappingString(entry, "name")
+	entries := d.entries(section)
+	for i, existing := range entries.Content {
+		if mappingString(existing, "name") == name {
+			entries.Content[i] = entry
+			return
+		}
+	}
+	entries.Content = append(entries.Content, entry)
+}
+
+// remove the entry with the given name from the given section, returning
+// whether it existed.
+func (d *kubeconfigDocument) remove(section, name string) bool {
+	entries := mappingValue(d.root, section)
+	for i, entry := range sequenceItems(entries) {
+		if mappingString(entry, "name") == name {
+			entries.Content = append(entries.Content[:i], entries.Content[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// contextRefs returns the names of the cluster and user used by a context
+// entry.
+func contextRefs(entry *yaml.Node) (string, string) {
+	context := mappingValue(entry, "context")
+	return mappingString(context, "cluster"), mappingString(context, "user")
+}
+
+// referenced checks whether any context uses the given cluster or user.
+func (d *kubeconfigDocument) referenced(field, name string) bool {
+	for _, entry := range sequenceItems(mappingValue(d.root, kubeconfigContexts)) {
+		if mappingString(mappingValue(entry, "context"), field) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// currentContext returns the current context name.
+func (d *kubeconfigDocument) currentContext() string {
+	return mappingString(d.root, "current-context")
+}
+
+// setCurrentContext sets the current context name.
+func (d *kubeconfigDocument) setCurrentContext(name string) {
+	d.set("current-context", &yaml.Node{Kind: yaml.ScalarNode, Value: name})
+}
+
+// encode returns the kubeconfig as YAML.
+func (d *kubeconfigDocument) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(d.root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sequenceItems returns the items of a sequence node, or nil if the node is
+// missing or not a sequence.
+func sequenceItems(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+// copyNode returns a deep copy of a YAML node.
+func copyNode(node *yaml.Node) *yaml.Node {
+	result := *node
+	result.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		result.Content[i] = copyNode(child)
+	}
+	return &result
+}
+
+// rancherDesktopEntries returns copies of the Rancher Desktop context, plus the
+// cluster and user it refers to, from the given kubeconfig.
+func rancherDesktopEntries(source *kubeconfigDocument) (context, cluster, user *yaml.Node, err error) {
+	context = source.find(kubeconfigContexts, rancherDesktopContextName)
+	if context == nil {
+		return nil, nil, nil, fmt.Errorf("could not find context %q", rancherDesktopContextName)
+	}
+	clusterName, userName := contextRefs(context)
+	if cluster = source.find(kubeconfigClusters, clusterName); cluster == nil {
+		return nil, nil, nil, fmt.Errorf("could not find cluster %q", clusterName)
+	}
+	if user = source.find(kubeconfigUsers, userName); user == nil {
+		return nil, nil, nil, fmt.Errorf("could not find user %q", userName)
+	}
+	return copyNode(context), copyNode(cluster), copyNode(user), nil
+}
+
+// rewriteClusterServer replaces a local server address in a cluster entry with
+// the one chosen by the policy.
+func rewriteClusterServer(cluster *yaml.Node, policy *addressPolicy) error {
+	serverNode := mappingValue(mappingValue(cluster, "cluster"), "server")
+	if serverNode == nil {
+		return nil
+	}
+	server, err := url.Parse(serverNode.Value)
+	if err != nil || !isLocalServer(server) {
+		return nil
+	}
+	hosts, err := policy.serverHosts()
+	if err != nil {
+		return err
+	}
+	setServerHost(server, hosts[0])
+	serverNode.Value = server.String()
+	return nil
+}
+
+// mergeKubeconfig inserts (or updates) the Rancher Desktop entries from the
+// Windows kubeconfig into the given kubeconfig, keeping all other entries.  If
+// a policy is given, the server address is rewritten.
+func mergeKubeconfig(windowsPath, linkPath string, policy *addressPolicy) error {
+	source, err := readKubeconfigDocument(windowsPath)
+	if err != nil {
+		return err
+	}
+	context, cluster, user, err := rancherDesktopEntries(source)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", windowsPath, err)
+	}
+	if policy != nil {
+		if err = rewriteClusterServer(cluster, policy); err != nil {
+			return err
+		}
+	}
+
+	targetPath, target, err := readMergeTarget(windowsPath, linkPath)
+	if err != nil {
+		return err
+	}
+	if current := target.currentContext(); current != "" && current != rancherDesktopContextName {
+		err = writeFileAtomic(targetPath+kubeconfigPreviousContextSuffix, []byte(current+"\n"), 0o600, false)
+		if err != nil {
+			return fmt.Errorf("could not record the current context: %w", err)
+		}
+	}
+	target.upsert(kubeconfigClusters, cluster)
+	target.upsert(kubeconfigUsers, user)
+	target.upsert(kubeconfigContexts, context)
+	if target.currentContext() == "" {
+		target.setCurrentContext(rancherDesktopContextName)
+	}
+	data, err := target.encode()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(targetPath, data, 0o600, true)
+}
+
+// unmergeKubeconfig removes the Rancher Desktop entries from the given
+// kubeconfig; clusters and users that other contexts use are kept.  If the
+// Rancher Desktop context is current, the context that was current when it was
+// last merged is restored.  The Windows kubeconfig does not need to exist.
+func unmergeKubeconfig(windowsPath, linkPath string) error {
+	targetPath, target, err := readMergeTarget(windowsPath, linkPath)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(targetPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	context := target.find(kubeconfigContexts, rancherDesktopContextName)
+	if context == nil {
+		return nil
+	}
+	clusterName, userName := contextRefs(context)
+	target.remove(kubeconfigContexts, rancherDesktopContextName)
+	if !target.referenced("cluster", clusterName) {
+		target.remove(kubeconfigClusters, clusterName)
+	}
+	if !target.referenced("user", userName) {
+		target.remove(kubeconfigUsers, userName)
+	}
+	previousPath := targetPath + kubeconfigPreviousContextSuffix
+	if target.currentContext() == rancherDesktopContextName {
+		target.setCurrentContext(previousContext(target, previousPath))
+	}
+	data, err := target.encode()
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(targetPath, data, 0o600, true); err != nil {
+		return err
+	}
+	if err = os.Remove(previousPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// previousContext returns the context to make current when removing the
+// Rancher Desktop context: the one recorded in the given file if it still
+// exists, otherwise the first remaining context (if any).
+func previousContext(target *kubeconfigDocument, previousPath string) string {
+	if data, err := os.ReadFile(previousPath); err == nil {
+		name := strings.TrimSpace(string(data))
+		if name != "" && target.find(kubeconfigContexts, name) != nil {
+			return name
+		}
+	}
+	for _, entry := range sequenceItems(mappingValue(target.root, kubeconfigContexts)) {
+		return mappingString(entry, "name")
+	}
+	return ""
+}
+
+// readMergeTarget returns the file to merge into, and its contents.  If the
+// kubeconfig is a symlink to the Windows kubeconfig, it is replaced by a file
+// (starting out empty); other symlinks are followed.
+func readMergeTarget(windowsPath, linkPath string) (string, *kubeconfigDocument, error) {
+	target, err := os.Readlink(linkPath)
+	if err == nil {
+		if target == windowsPath {
+			return linkPath, newKubeconfigDocument(), nil
+		}
+		if linkPath, err = filepath.EvalSymlinks(linkPath); err != nil {
+			return "", nil, err
+		}
+	}
+	doc, err := readKubeconfigDocument(linkPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return linkPath, doc, nil
+}
+
+// writeFileAtomic replaces the given file, so that readers never see a partial
+// file.  If backup is set, any existing file is first copied next to it.
+func writeFileAtomic(filePath string, data []byte, mode os.FileMode, backup bool) error {
+	dir, base := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	if backup {
+		if err := backupFile(filePath, mode); err != nil {
+			return fmt.Errorf("could not back up %s: %w", filePath, err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+// backupFile copies the given file, if it exists, to the backup path next to
+// it.  A symlink (i.e. the one to the Windows kubeconfig) is copied as a
+// symlink, rather than copying the file it points to.
+func backupFile(filePath string, mode os.FileMode) error {
+	backupPath := filePath + kubeconfigBackupSuffix
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return err
+		}
+		// Create the link under a temporary name, so that any existing backup
+		// is replaced atomically.
+		tempPath := fmt.Sprintf("%s.tmp-%d", backupPath, os.Getpid())
+		if err = os.Symlink(target, tempPath); err != nil {
+			return err
+		}
+		if err = os.Rename(tempPath, backupPath); err != nil {
+			os.Remove(tempPath)
+			return err
+		}
+		return nil
+	}
+	existing, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(backupPath, existing, mode, false)
+}
@@ -0,0 +1,352 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testWindowsKubeconfig is a Windows kubeconfig with the Rancher Desktop
+// context, plus another one that should not be merged.
+const testWindowsKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: rancher-desktop
+  cluster:
+    server: https://127.0.0.1:6443
+    certificate-authority-data: Y2E=
+- name: windows-only
+  cluster:
+    server: https://windows.example.com
+contexts:
+- name: rancher-desktop
+  context:
+    cluster: rancher-desktop
+    user: rancher-desktop
+- name: windows-only
+  context:
+    cluster: windows-only
+    user: windows-only
+current-context: rancher-desktop
+users:
+- name: rancher-desktop
+  user:
+    token: secret
+- name: windows-only
+  user:
+    token: other
+`
+
+// testLinuxKubeconfig is an existing kubeconfig in the distribution.
+const testLinuxKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+# The user's own cluster.
+- name: work
+  cluster:
+    server: https://work.example.com
+contexts:
+- name: work
+  context:
+    cluster: work
+    user: work
+current-context: work
+users:
+- name: work
+  user:
+    token: work
+`
+
+// setUpMergeTest writes the Windows kubeconfig and, if contents is not empty,
+// the Linux one, returning their paths.
+func setUpMergeTest(t *testing.T, contents string) (string, string) {
+	dir := t.TempDir()
+	windowsPath := filepath.Join(dir, "windows", "config")
+	linkPath := filepath.Join(dir, ".kube", "config")
+	for _, p := range []string{windowsPath, linkPath} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(windowsPath, []byte(testWindowsKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if contents != "" {
+		if err := os.WriteFile(linkPath, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return windowsPath, linkPath
+}
+
+// readTestKubeconfig reads a kubeconfig, returning it along with the names of
+// its contexts, clusters and users.
+func readTestKubeconfig(t *testing.T, configPath string) (*kubeconfigDocument, map[string][]string) {
+	doc, err := readKubeconfigDocument(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string][]string)
+	for _, section := range []string{kubeconfigContexts, kubeconfigClusters, kubeconfigUsers} {
+		names[section] = []string{}
+		for _, entry := range sequenceItems(mappingValue(doc.root, section)) {
+			names[section] = append(names[section], mappingString(entry, "name"))
+		}
+	}
+	return doc, names
+}
+
+func TestMergeKubeconfig(t *testing.T) {
+	t.Run("no kubeconfig", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, "")
+		if err := mergeKubeconfig(windowsPath, linkPath, nil); err != nil {
+			t.Fatal(err)
+		}
+		doc, names := readTestKubeconfig(t, linkPath)
+		expected := []string{rancherDesktopContextName}
+		for section, actual := range names {
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %s %v, got %v", section, expected, actual)
+			}
+		}
+		if current := doc.currentContext(); current != rancherDesktopContextName {
+			t.Errorf("unexpected current context %q", current)
+		}
+		if info, err := os.Stat(linkPath); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("unexpected kubeconfig mode: %v (%v)", info.Mode(), err)
+		}
+		if _, err := os.Lstat(linkPath + kubeconfigBackupSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("unexpected backup: %v", err)
+		}
+	})
+
+	t.Run("existing kubeconfig", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, testLinuxKubeconfig)
+		policy, err := newAddressPolicy("172.20.1.2", "eth0", addressFamilyIPv4, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Merging twice should not add duplicate entries.
+		for i := 0; i < 2; i++ {
+			if err = mergeKubeconfig(windowsPath, linkPath, policy); err != nil {
+				t.Fatal(err)
+			}
+		}
+		doc, names := readTestKubeconfig(t, linkPath)
+		expected := []string{"work", rancherDesktopContextName}
+		for section, actual := range names {
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %s %v, got %v", section, expected, actual)
+			}
+		}
+		if current := doc.currentContext(); current != "work" {
+			t.Errorf("the current context should be kept, got %q", current)
+		}
+		cluster := doc.find(kubeconfigClusters, rancherDesktopContextName)
+		if server := mappingString(mappingValue(cluster, "cluster"), "server"); server != "https://172.20.1.2:6443" {
+			t.Errorf("unexpected server %q", server)
+		}
+		data, err := os.ReadFile(linkPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "# The user's own cluster.") {
+			t.Errorf("comments were not preserved:\n%s", data)
+		}
+		backup, err := os.ReadFile(linkPath + kubeconfigBackupSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(backup), rancherDesktopContextName) {
+			t.Errorf("the backup should be of the last version:\n%s", backup)
+		}
+	})
+
+	t.Run("managed symlink", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, "")
+		if err := os.Symlink(windowsPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := mergeKubeconfig(windowsPath, linkPath, nil); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Lstat(linkPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Mode().IsRegular() {
+			t.Errorf("the symlink should have been replaced by a file, got %v", info.Mode())
+		}
+		_, names := readTestKubeconfig(t, linkPath)
+		if expected := []string{rancherDesktopContextName}; !reflect.DeepEqual(names[kubeconfigContexts], expected) {
+			t.Errorf("expected contexts %v, got %v", expected, names[kubeconfigContexts])
+		}
+		// The backup is of the symlink, not of the Windows kubeconfig.
+		target, err := os.Readlink(linkPath + kubeconfigBackupSuffix)
+		if err != nil {
+			t.Fatalf("the backup is not a symlink: %s", err)
+		}
+		if target != windowsPath {
+			t.Errorf("unexpected backup target %q", target)
+		}
+		data, err := os.ReadFile(windowsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != testWindowsKubeconfig {
+			t.Errorf("the Windows kubeconfig was modified:\n%s", data)
+		}
+	})
+
+	t.Run("missing context", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, testLinuxKubeconfig)
+		if err := os.WriteFile(windowsPath, []byte(testLinuxKubeconfig), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := mergeKubeconfig(windowsPath, linkPath, nil); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
+
+func TestUnmergeKubeconfig(t *testing.T) {
+	t.Run("restores the previous context", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, testLinuxKubeconfig)
+		if err := mergeKubeconfig(windowsPath, linkPath, nil); err != nil {
+			t.Fatal(err)
+		}
+		// The user switches to the Rancher Desktop context.
+		doc, _ := readTestKubeconfig(t, linkPath)
+		doc.setCurrentContext(rancherDesktopContextName)
+		data, err := doc.encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(linkPath, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = unmergeKubeconfig(windowsPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		doc, names := readTestKubeconfig(t, linkPath)
+		expected := []string{"work"}
+		for section, actual := range names {
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %s %v, got %v", section, expected, actual)
+			}
+		}
+		if current := doc.currentContext(); current != "work" {
+			t.Errorf("expected the current context to be restored, got %q", current)
+		}
+		if _, err = os.Stat(linkPath + kubeconfigPreviousContextSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("the previous context record should have been removed: %v", err)
+		}
+	})
+
+	t.Run("without the Windows kubeconfig", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, "")
+		if err := mergeKubeconfig(windowsPath, linkPath, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(windowsPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := unmergeKubeconfig(windowsPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		doc, names := readTestKubeconfig(t, linkPath)
+		for section, actual := range names {
+			if len(actual) != 0 {
+				t.Errorf("expected no %s, got %v", section, actual)
+			}
+		}
+		if current := doc.currentContext(); current != "" {
+			t.Errorf("unexpected current context %q", current)
+		}
+	})
+
+	t.Run("shared entries are kept", func(t *testing.T) {
+		// Another context uses the Rancher Desktop cluster and user.
+		windowsPath, linkPath := setUpMergeTest(t, testLinuxKubeconfig+`- name: rancher-desktop
+  user:
+    token: secret
+`)
+		doc, _ := readTestKubeconfig(t, linkPath)
+		shared := copyNode(doc.find(kubeconfigContexts, "work"))
+		mappingValue(shared, "name").Value = "shared"
+		mappingValue(mappingValue(shared, "context"), "user").Value = rancherDesktopContextName
+		doc.upsert(kubeconfigContexts, shared)
+		data, err := doc.encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(linkPath, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = mergeKubeconfig(windowsPath, linkPath, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = unmergeKubeconfig(windowsPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		_, names := readTestKubeconfig(t, linkPath)
+		expected := map[string][]string{
+			kubeconfigContexts: {"work", "shared"},
+			kubeconfigClusters: {"work"},
+			kubeconfigUsers:    {"work", rancherDesktopContextName},
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+	})
+
+	t.Run("managed symlink", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, "")
+		if err := os.Symlink(windowsPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := unmergeKubeconfig(windowsPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if target, err := os.Readlink(linkPath); err != nil || target != windowsPath {
+			t.Errorf("the symlink should be left alone: %q (%v)", target, err)
+		}
+		data, err := os.ReadFile(windowsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != testWindowsKubeconfig {
+			t.Errorf("the Windows kubeconfig was modified:\n%s", data)
+		}
+	})
+
+	t.Run("no kubeconfig", func(t *testing.T) {
+		windowsPath, linkPath := setUpMergeTest(t, "")
+		if err := unmergeKubeconfig(windowsPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(linkPath); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("no kubeconfig should have been created: %v", err)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// States of the kubeconfig in the WSL distribution.
+const (
+	// kubeconfigStateManaged is a symlink to the Windows kubeconfig.
+	kubeconfigStateManaged = "managed"
+	// kubeconfigStateMerged is a file with the Rancher Desktop context merged in.
+	kubeconfigStateMerged = "merged"
+	// kubeconfigStateAbsent means there is no kubeconfig.
+	kubeconfigStateAbsent = "absent"
+	// kubeconfigStateForeign is a file without the Rancher Desktop context.
+	kubeconfigStateForeign = "foreign"
+	// kubeconfigStateSymlinkElsewhere is a symlink to some other file.
+	kubeconfigStateSymlinkElsewhere = "symlink-elsewhere"
+	// kubeconfigStateDangling is a symlink to a file that does not exist.
+	kubeconfigStateDangling = "dangling"
+	// kubeconfigStateUnreadable means the kubeconfig could not be read.
+	kubeconfigStateUnreadable = "unreadable"
+)
+
+// Recommended actions for the kubeconfig in the WSL distribution.
+const (
+	// kubeconfigActionNone means nothing needs to be done.
+	kubeconfigActionNone = "none"
+	// kubeconfigActionEnable means the symlink can be created.
+	kubeconfigActionEnable = "enable"
+	// kubeconfigActionMerge means the context should be merged into the file.
+	kubeconfigActionMerge = "merge"
+	// kubeconfigActionRemove means the (dangling) symlink should be removed.
+	kubeconfigActionRemove = "remove"
+	// kubeconfigActionFixPermissions means the file needs to be made readable.
+	kubeconfigActionFixPermissions = "fix-permissions"
+	// kubeconfigActionWaitForWindows means the Windows kubeconfig does not
+	// exist yet (e.g. Kubernetes has not been started).
+	kubeconfigActionWaitForWindows = "wait-for-windows-kubeconfig"
+)
+
+// Exit codes for `kubeconfig --show --output json`; the status document is
+// written in all cases.
+const (
+	// kubeconfigExitUnreadable means the kubeconfig could not be read.
+	kubeconfigExitUnreadable = 2
+	// kubeconfigExitNoWindowsConfig means the Windows kubeconfig is missing.
+	kubeconfigExitNoWindowsConfig = 3
+)
+
+// kubeconfigStatus describes the kubeconfig in the WSL distribution.
+type kubeconfigStatus struct {
+	// State is one of the kubeconfigState* constants.
+	State string `json:"state"`
+	// Path is the kubeconfig path, i.e. ~/.kube/config.
+	Path string `json:"path"`
+	// Target is the target of the symlink, if it is one.
+	Target string `json:"target,omitempty"`
+	// WindowsKubeconfig is the path of the Windows kubeconfig.
+	WindowsKubeconfig string `json:"windowsKubeconfig"`
+	// WindowsKubeconfigExists is whether the Windows kubeconfig exists.
+	WindowsKubeconfigExists bool `json:"windowsKubeconfigExists"`
+	// Contexts are the names of the contexts in the kubeconfig.
+	Contexts []string `json:"contexts"`
+	// CurrentContext is the current context of the kubeconfig.
+	CurrentContext string `json:"currentContext,omitempty"`
+	// Action is one of the kubeconfigAction* constants.
+	Action string `json:"action"`
+	// Error describes any problem reading the kubeconfig.
+	Error string `json:"error,omitempty"`
+}
+
+// getKubeconfigStatus inspects the kubeconfig at linkPath.
+func getKubeconfigStatus(windowsPath, linkPath string) *kubeconfigStatus {
+	status := &kubeconfigStatus{
+		Path:              linkPath,
+		WindowsKubeconfig: windowsPath,
+		Contexts:          []string{},
+	}
+	if windowsPath != "" {
+		if _, err := os.Stat(windowsPath); err == nil {
+			status.WindowsKubeconfigExists = true
+		}
+	}
+	status.inspect(windowsPath)
+	switch {
+	case status.State == kubeconfigStateUnreadable:
+		status.Action = kubeconfigActionFixPermissions
+	case status.State == kubeconfigStateDangling:
+		status.Action = kubeconfigActionRemove
+	case !status.WindowsKubeconfigExists && status.State != kubeconfigStateMerged:
+		status.Action = kubeconfigActionWaitForWindows
+	case status.State == kubeconfigStateAbsent:
+		status.Action = kubeconfigActionEnable
+	case status.State == kubeconfigStateForeign, status.State == kubeconfigStateSymlinkElsewhere:
+		status.Action = kubeconfigActionMerge
+	default:
+		status.Action = kubeconfigActionNone
+	}
+	return status
+}
+
+// inspect fills in the state of the kubeconfig, and the contexts in it.
+func (s *kubeconfigStatus) inspect(windowsPath string) {
+	info, err := os.Lstat(s.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.State = kubeconfigStateAbsent
+		} else {
+			s.State, s.Error = kubeconfigStateUnreadable, err.Error()
+		}
+		return
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if s.Target, err = os.Readlink(s.Path); err != nil {
+			s.State, s.Error = kubeconfigStateUnreadable, err.Error()
+			return
+		}
+		if _, err = os.Stat(s.Path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				s.State = kubeconfigStateDangling
+			} else {
+				s.State, s.Error = kubeconfigStateUnreadable, err.Error()
+			}
+			return
+		}
+		if s.Target == windowsPath {
+			s.State = kubeconfigStateManaged
+		} else {
+			s.State = kubeconfigStateSymlinkElsewhere
+		}
+	}
+
+	doc, err := readKubeconfigDocument(s.Path)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			s.State, s.Error = kubeconfigStateUnreadable, err.Error()
+			return
+		}
+		// The file can be read, but is not a valid kubeconfig.
+		s.Error = err.Error()
+		if s.State == "" {
+			s.State = kubeconfigStateForeign
+		}
+		return
+	}
+	for _, entry := range sequenceItems(mappingValue(doc.root, kubeconfigContexts)) {
+		s.Contexts = append(s.Contexts, mappingString(entry, "name"))
+	}
+	s.CurrentContext = doc.currentContext()
+	if s.State == "" {
+		s.State = kubeconfigStateForeign
+		if doc.find(kubeconfigContexts, rancherDesktopContextName) != nil {
+			s.State = kubeconfigStateMerged
+		}
+	}
+}
+
+// exitCode returns the exit code for the status.
+func (s *kubeconfigStatus) exitCode() int {
+	switch {
+	case s.State == kubeconfigStateUnreadable:
+		return kubeconfigExitUnreadable
+	case !s.WindowsKubeconfigExists:
+		return kubeconfigExitNoWindowsConfig
+	}
+	return 0
+}
@@ -0,0 +1,191 @@
+/*
+Copyright © 2021 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetKubeconfigStatus(t *testing.T) {
+	cases := []struct {
+		name string
+		// setUp prepares the kubeconfig at linkPath.
+		setUp           func(t *testing.T, windowsPath, linkPath string)
+		noWindowsConfig bool
+		state           string
+		action          string
+		contexts        []string
+		exitCode        int
+	}{
+		{
+			name:     "absent",
+			setUp:    func(t *testing.T, windowsPath, linkPath string) {},
+			state:    kubeconfigStateAbsent,
+			action:   kubeconfigActionEnable,
+			contexts: []string{},
+		},
+		{
+			name: "managed",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				if err := os.Symlink(windowsPath, linkPath); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state:    kubeconfigStateManaged,
+			action:   kubeconfigActionNone,
+			contexts: []string{rancherDesktopContextName, "windows-only"},
+		},
+		{
+			name: "merged",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				writeTestFile(t, linkPath, testLinuxKubeconfig)
+				if err := mergeKubeconfig(windowsPath, linkPath, nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state:    kubeconfigStateMerged,
+			action:   kubeconfigActionNone,
+			contexts: []string{"work", rancherDesktopContextName},
+		},
+		{
+			name: "merged without the Windows kubeconfig",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				if err := mergeKubeconfig(windowsPath, linkPath, nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			noWindowsConfig: true,
+			state:           kubeconfigStateMerged,
+			action:          kubeconfigActionNone,
+			contexts:        []string{rancherDesktopContextName},
+			exitCode:        kubeconfigExitNoWindowsConfig,
+		},
+		{
+			name: "foreign",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				writeTestFile(t, linkPath, testLinuxKubeconfig)
+			},
+			state:    kubeconfigStateForeign,
+			action:   kubeconfigActionMerge,
+			contexts: []string{"work"},
+		},
+		{
+			name: "invalid file",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				writeTestFile(t, linkPath, "- not a kubeconfig\n")
+			},
+			state:    kubeconfigStateForeign,
+			action:   kubeconfigActionMerge,
+			contexts: []string{},
+		},
+		{
+			name: "symlink elsewhere",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				otherPath := filepath.Join(filepath.Dir(linkPath), "other")
+				writeTestFile(t, otherPath, testLinuxKubeconfig)
+				if err := os.Symlink(otherPath, linkPath); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state:    kubeconfigStateSymlinkElsewhere,
+			action:   kubeconfigActionMerge,
+			contexts: []string{"work"},
+		},
+		{
+			name: "dangling",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				if err := os.Symlink(filepath.Join(filepath.Dir(linkPath), "missing"), linkPath); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state:    kubeconfigStateDangling,
+			action:   kubeconfigActionRemove,
+			contexts: []string{},
+		},
+		{
+			name: "unreadable",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				// Reading a directory fails regardless of permissions.
+				if err := os.Mkdir(linkPath, 0o750); err != nil {
+					t.Fatal(err)
+				}
+			},
+			state:    kubeconfigStateUnreadable,
+			action:   kubeconfigActionFixPermissions,
+			contexts: []string{},
+			exitCode: kubeconfigExitUnreadable,
+		},
+		{
+			name:            "no Windows kubeconfig",
+			setUp:           func(t *testing.T, windowsPath, linkPath string) {},
+			noWindowsConfig: true,
+			state:           kubeconfigStateAbsent,
+			action:          kubeconfigActionWaitForWindows,
+			contexts:        []string{},
+			exitCode:        kubeconfigExitNoWindowsConfig,
+		},
+		{
+			name: "unreadable without the Windows kubeconfig",
+			setUp: func(t *testing.T, windowsPath, linkPath string) {
+				if err := os.Mkdir(linkPath, 0o750); err != nil {
+					t.Fatal(err)
+				}
+			},
+			noWindowsConfig: true,
+			state:           kubeconfigStateUnreadable,
+			action:          kubeconfigActionFixPermissions,
+			contexts:        []string{},
+			exitCode:        kubeconfigExitUnreadable,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			windowsPath, linkPath := setUpMergeTest(t, "")
+			c.setUp(t, windowsPath, linkPath)
+			if c.noWindowsConfig {
+				if err := os.Remove(windowsPath); err != nil {
+					t.Fatal(err)
+				}
+			}
+			status := getKubeconfigStatus(windowsPath, linkPath)
+			if status.State != c.state {
+				t.Errorf("expected state %q, got %q (%s)", c.state, status.State, status.Error)
+			}
+			if status.Action != c.action {
+				t.Errorf("expected action %q, got %q", c.action, status.Action)
+			}
+			if !reflect.DeepEqual(status.Contexts, c.contexts) {
+				t.Errorf("expected contexts %v, got %v", c.contexts, status.Contexts)
+			}
+			if status.WindowsKubeconfigExists == c.noWindowsConfig {
+				t.Errorf("unexpected windowsKubeconfigExists %v", status.WindowsKubeconfigExists)
+			}
+			if code := status.exitCode(); code != c.exitCode {
+				t.Errorf("expected exit code %d, got %d", c.exitCode, code)
+			}
+		})
+	}
+}
+
+// writeTestFile writes a file, failing the test on errors.
+func writeTestFile(t *testing.T, filePath, contents string) {
+	if err := os.WriteFile(filePath, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -12,8 +12,6 @@ import util from 'util';
 
 import semver from 'semver';
 
-import INSTALL_K3S_SCRIPT from '@/assets/scripts/install-k3s';
-import LAUNCH_K3S_SCRIPT from '@/assets/scripts/wsl-launch-k3s';
 import INSTALL_WSL_HELPERS_SCRIPT from '@/assets/scripts/install-wsl-helpers';
 import mainEvents from '@/main/mainEvents';
 import * as childProcess from '@/utils/childProcess';
@@ -423,8 +421,8 @@ export default class WSLBackend extends events.EventEmitter implements K8s.Kuber
   protected async installK3s(version: ShortVersion) {
     const fullVersion = this.k3sHelper.fullVersion(version);
 
-    await this.runInstallScript(INSTALL_K3S_SCRIPT,
-      'install-k3s', fullVersion, await this.wslify(path.join(paths.cache, 'k3s')));
+    await this.execCommand(await this.getWSLHelperPath(), 'k3s', 'install',
+      '--version', fullVersion, '--cache-dir', await this.wslify(path.join(paths.cache, 'k3s')));
   }
 
   /**
@@ -665,23 +663,9 @@ export default class WSLBackend extends events.EventEmitter implements K8s.Kuber
         ]);
         await this.persistVersion(desiredVersion);
 
-        // Write the launch script.
-        const installScriptWorkDir = await fs.promises.mkdtemp(path.join(os.tmpdir(), 'rd-k3s-runner-'));
-
-        try {
-          const installScriptPath = path.join(installScriptWorkDir, 'launch-k3s');
-
-          await fs.promises.writeFile(installScriptPath, LAUNCH_K3S_SCRIPT, 'utf-8');
-          await this.execCommand('mv', await this.wslify(installScriptPath), '/usr/local/bin/launch-k3s');
-          await this.execCommand('chmod', 'a+x', '/usr/local/bin/launch-k3s');
-        } finally {
-          await fs.promises.rm(installScriptWorkDir, { recursive: true });
-        }
-
         // Actually run K3s
         const args = ['--distribution', INSTANCE_NAME, '--exec',
-          '/usr/bin/unshare', '--mount', '--propagation', 'private',
-          '/usr/local/bin/launch-k3s',
+          await this.getWSLHelperPath(), 'k3s', 'launch', '--',
           '--https-listen-port', this.#desiredPort.toString()];
         const options: childProcess.SpawnOptions = {
           env: {
@@ -717,6 +701,12 @@ export default class WSLBackend extends events.EventEmitter implements K8s.Kuber
           'Waiting for Kubernetes API',
           100,
           this.k3sHelper.waitForServerReady(() => this.ipAddress, this.#desiredPort));
+        // The API server is reachable, but may not be ready, and the node may
+        // not be ready to run workloads yet.
+        await this.progressTracker.action(
+          'Waiting for Kubernetes node',
+          100,
+          async() => await this.execCommand(await this.getWSLHelperPath(), 'k3s', 'wait-ready'));
         await this.progressTracker.action(
           'Updating kubeconfig',
           100,